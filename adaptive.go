@@ -0,0 +1,207 @@
+package rdns
+
+import (
+	"errors"
+	"expvar"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// AdaptiveGroup is a resolver group that sends queries to the resolver with the
+// best quality score. Scores are calculated at the end of every window from the
+// latency, error rate and SERVFAIL rate of the resolvers during the window, and
+// range from 0 to 1. The latency part is relative to the fastest resolver. To
+// notice when a resolver improves, a fraction of the queries is sent to a random
+// resolver instead. If a resolver fails or returns SERVFAIL, the query is retried
+// with the next best one.
+type AdaptiveGroup struct {
+	id        string
+	resolvers []Resolver
+	opt       AdaptiveGroupOptions
+	metrics   *FailRouterMetrics
+
+	mu          sync.Mutex
+	windowStart time.Time
+	stats       []adaptiveStats
+	scores      []*expvar.Float
+}
+
+var _ Resolver = &AdaptiveGroup{}
+
+// AdaptiveGroupOptions contain settings for the adaptive resolver group.
+type AdaptiveGroupOptions struct {
+	// Time period over which the quality of resolvers is measured. Scores are
+	// updated at the end of it. Defaults to 1 minute.
+	Window time.Duration
+
+	// Fraction of queries sent to a random resolver to update its score.
+	// Defaults to 0.05.
+	Epsilon float64
+
+	// Weights of the latency, error rate and SERVFAIL rate in the score. All
+	// default to 1.
+	LatencyWeight  float64
+	ErrorWeight    float64
+	ServfailWeight float64
+}
+
+// Counters of a resolver in the current window.
+type adaptiveStats struct {
+	queries   int
+	errors    int
+	servfails int
+	latency   time.Duration // Total latency of queries that didn't fail
+}
+
+// NewAdaptiveGroup returns a new instance of an adaptive resolver group.
+func NewAdaptiveGroup(id string, opt AdaptiveGroupOptions, resolvers ...Resolver) (*AdaptiveGroup, error) {
+	if opt.Window <= 0 {
+		opt.Window = time.Minute
+	}
+	if opt.Epsilon == 0 {
+		opt.Epsilon = 0.05
+	}
+	if opt.Epsilon < 0 || opt.Epsilon > 1 {
+		return nil, errors.New("epsilon must be between 0 and 1")
+	}
+	if opt.LatencyWeight == 0 && opt.ErrorWeight == 0 && opt.ServfailWeight == 0 {
+		opt.LatencyWeight, opt.ErrorWeight, opt.ServfailWeight = 1, 1, 1
+	}
+	if opt.LatencyWeight < 0 || opt.ErrorWeight < 0 || opt.ServfailWeight < 0 {
+		return nil, errors.New("weights can not be negative")
+	}
+	metric := getVarMap("router", id, "score")
+	scores := make([]*expvar.Float, len(resolvers))
+	for i, r := range resolvers {
+		// New resolvers are assumed to be perfect until measured
+		scores[i] = new(expvar.Float)
+		scores[i].Set(1)
+		metric.Set(r.String(), scores[i])
+	}
+	return &AdaptiveGroup{
+		id:          id,
+		resolvers:   resolvers,
+		opt:         opt,
+		metrics:     NewFailRouterMetrics(id, len(resolvers)),
+		windowStart: time.Now(),
+		stats:       make([]adaptiveStats, len(resolvers)),
+		scores:      scores,
+	}, nil
+}
+
+// Resolve a DNS query using the resolver with the best score, failing over to
+// the next best one.
+func (r *AdaptiveGroup) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	log := logger(r.id, q, ci)
+	var (
+		a   *dns.Msg
+		err error
+	)
+	for n, i := range r.order(time.Now()) {
+		resolver := r.resolvers[i]
+		if n > 0 {
+			r.metrics.failover.Add(1)
+		}
+		log.WithField("resolver", resolver.String()).Debug("forwarding query to resolver")
+		r.metrics.route.Add(resolver.String(), 1)
+		start := time.Now()
+		a, err = resolver.Resolve(q, ci)
+		r.record(i, a, err, time.Since(start))
+		if err == nil && (a == nil || a.Rcode != dns.RcodeServerFailure) {
+			return a, nil
+		}
+		log.WithField("resolver", resolver.String()).WithError(err).Debug("resolver returned failure")
+		r.metrics.failure.Add(resolver.String(), 1)
+	}
+	return a, err
+}
+
+func (r *AdaptiveGroup) String() string {
+	return r.id
+}
+
+// Returns the indexes of the resolvers in the order they should be tried, by
+// score. With a probability of epsilon, a random resolver goes first. Starts a
+// new window and updates the scores if the current one has ended.
+func (r *AdaptiveGroup) order(now time.Time) []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.windowStart) >= r.opt.Window {
+		r.rescore()
+		r.windowStart = now
+	}
+	order := make([]int, len(r.resolvers))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return r.scores[order[i]].Value() > r.scores[order[j]].Value()
+	})
+	if len(order) > 1 && rand.Float64() < r.opt.Epsilon {
+		i := rand.Intn(len(order))
+		explore := order[i]
+		copy(order[1:i+1], order[:i])
+		order[0] = explore
+	}
+	return order
+}
+
+// Records the outcome of a query.
+func (r *AdaptiveGroup) record(i int, a *dns.Msg, err error, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := &r.stats[i]
+	s.queries++
+	switch {
+	case err != nil:
+		s.errors++
+		return
+	case a != nil && a.Rcode == dns.RcodeServerFailure:
+		s.servfails++
+	}
+	s.latency += latency
+}
+
+// Calculates the scores of the resolvers from the stats of the window that
+// ended and resets them. Resolvers that didn't receive any queries keep their
+// score. Must be called with the lock held.
+func (r *AdaptiveGroup) rescore() {
+	var fastest time.Duration
+	for _, s := range r.stats {
+		if avg := s.avgLatency(); avg > 0 && (fastest == 0 || avg < fastest) {
+			fastest = avg
+		}
+	}
+	total := r.opt.LatencyWeight + r.opt.ErrorWeight + r.opt.ServfailWeight
+	for i, s := range r.stats {
+		if s.queries == 0 {
+			continue
+		}
+		var latencyScore float64
+		if avg := s.avgLatency(); avg > 0 {
+			latencyScore = float64(fastest) / float64(avg)
+		} else if s.errors < s.queries {
+			latencyScore = 1
+		}
+		errorScore := 1 - float64(s.errors)/float64(s.queries)
+		servfailScore := 1 - float64(s.servfails)/float64(s.queries)
+		score := (r.opt.LatencyWeight*latencyScore + r.opt.ErrorWeight*errorScore + r.opt.ServfailWeight*servfailScore) / total
+		r.scores[i].Set(score)
+		Log.WithFields(logrus.Fields{"id": r.id, "resolver": r.resolvers[i].String(), "score": score}).Trace("updated resolver score")
+	}
+	r.stats = make([]adaptiveStats, len(r.resolvers))
+}
+
+// Returns the average latency of queries that didn't fail.
+func (s adaptiveStats) avgLatency() time.Duration {
+	succeeded := s.queries - s.errors
+	if succeeded == 0 {
+		return 0
+	}
+	return s.latency / time.Duration(succeeded)
+}
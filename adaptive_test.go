@@ -0,0 +1,56 @@
+package rdns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveGroup(t *testing.T) {
+	r1 := new(TestResolver)
+	r2 := new(TestResolver)
+	g, err := NewAdaptiveGroup("test-adaptive", AdaptiveGroupOptions{Window: 100 * time.Millisecond, Epsilon: 1e-9}, r1, r2)
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeA)
+
+	// Without scores, the first resolver is used
+	_, err = g.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 1, r1.HitCount())
+	require.Equal(t, 0, r2.HitCount())
+
+	// Failures are retried on the next resolver
+	r1.SetFail(true)
+	_, err = g.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 2, r1.HitCount())
+	require.Equal(t, 1, r2.HitCount())
+
+	// Once the window ends, the second resolver has the better score and is
+	// used first, even after the first one recovered
+	time.Sleep(150 * time.Millisecond)
+	r1.SetFail(false)
+	_, err = g.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 2, r1.HitCount())
+	require.Equal(t, 2, r2.HitCount())
+	require.True(t, g.scores[0].Value() < g.scores[1].Value())
+}
+
+func TestAdaptiveGroupScore(t *testing.T) {
+	g, err := NewAdaptiveGroup("test-adaptive", AdaptiveGroupOptions{Epsilon: 1e-9}, new(TestResolver), new(TestResolver), new(TestResolver))
+	require.NoError(t, err)
+	g.stats = []adaptiveStats{
+		{queries: 10, latency: 100 * time.Millisecond},               // fastest, no failures
+		{queries: 10, servfails: 5, latency: 200 * time.Millisecond}, // half as fast, half SERVFAIL
+		{}, // no queries
+	}
+	g.rescore()
+	require.InDelta(t, 1.0, g.scores[0].Value(), 0.001)
+	require.InDelta(t, (0.5+1+0.5)/3, g.scores[1].Value(), 0.001)
+	require.InDelta(t, 1.0, g.scores[2].Value(), 0.001)
+	require.Equal(t, []int{0, 2, 1}, g.order(time.Now()))
+}
@@ -0,0 +1,101 @@
+package rdns
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// AddressFamilyFilter is a resolver that removes A or AAAA records from responses,
+// to test how applications behave on IPv4-only or IPv6-only networks, or to
+// prefer one address family over the other. Other records are not modified, and
+// responses that have no records left are returned as NODATA (empty NOERROR).
+type AddressFamilyFilter struct {
+	id       string
+	resolver Resolver
+	mode     string
+}
+
+var _ Resolver = &AddressFamilyFilter{}
+
+// AddressFamilyFilterOptions contain settings for the AddressFamilyFilter resolver.
+type AddressFamilyFilterOptions struct {
+	// "v4only" removes all AAAA records, "v6only" all A records. "prefer-v4"
+	// removes AAAA records only if the name has A records as well, and
+	// "prefer-v6" removes A records only if the name has AAAA records.
+	Mode string
+}
+
+// NewAddressFamilyFilter returns a new instance of an address family filter.
+func NewAddressFamilyFilter(id string, resolver Resolver, opt AddressFamilyFilterOptions) (*AddressFamilyFilter, error) {
+	switch opt.Mode {
+	case "v4only", "v6only", "prefer-v4", "prefer-v6":
+	default:
+		return nil, fmt.Errorf("unsupported address family mode '%s'", opt.Mode)
+	}
+	return &AddressFamilyFilter{id: id, resolver: resolver, mode: opt.Mode}, nil
+}
+
+// Resolve a DNS query and remove the address records of the filtered family.
+func (r *AddressFamilyFilter) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	answer, err := r.resolver.Resolve(q, ci)
+	if err != nil || answer == nil || answer.Rcode != dns.RcodeSuccess || len(q.Question) < 1 {
+		return answer, err
+	}
+	log := logger(r.id, q, ci)
+
+	var remove, keep uint16
+	switch r.mode {
+	case "v4only":
+		remove = dns.TypeAAAA
+	case "v6only":
+		remove = dns.TypeA
+	case "prefer-v4":
+		remove, keep = dns.TypeAAAA, dns.TypeA
+	case "prefer-v6":
+		remove, keep = dns.TypeA, dns.TypeAAAA
+	}
+	if !hasRecordType(answer.Answer, remove) {
+		return answer, nil
+	}
+
+	// When preferring a family, only remove the other one if the name has
+	// addresses of the preferred family. Those may need to be looked up.
+	if keep != 0 && !hasRecordType(answer.Answer, keep) {
+		pq := q.Copy()
+		pq.Question[0].Qtype = keep
+		a, err := r.resolver.Resolve(pq, ci)
+		if err != nil || a == nil || !hasRecordType(a.Answer, keep) {
+			return answer, nil
+		}
+	}
+	log.Debugf("removing %s records from response", dns.TypeToString[remove])
+	answer.Answer = removeRecordType(answer.Answer, remove)
+	answer.Extra = removeRecordType(answer.Extra, remove)
+	return answer, nil
+}
+
+func (r *AddressFamilyFilter) String() string {
+	return r.id
+}
+
+// Returns true if there's at least one record of the given type.
+func hasRecordType(rrs []dns.RR, t uint16) bool {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the records that are not of the given type.
+func removeRecordType(rrs []dns.RR, t uint16) []dns.RR {
+	var out []dns.RR
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != t {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
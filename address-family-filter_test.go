@@ -0,0 +1,70 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressFamilyFilter(t *testing.T) {
+	// Upstream with a dual-stack name, an IPv4-only name, and CNAMEs pointing to them
+	records := map[string][]string{
+		"dual.com.": {"dual.com. IN A 192.0.2.1", "dual.com. IN AAAA 2001:db8::1"},
+		"v4.com.":   {"v4.com. IN A 192.0.2.2"},
+		"cname.com.": {
+			"cname.com. IN CNAME dual.com.",
+			"dual.com. IN A 192.0.2.1",
+			"dual.com. IN AAAA 2001:db8::1",
+		},
+	}
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			for _, record := range records[q.Question[0].Name] {
+				rr, _ := dns.NewRR(record)
+				if rr.Header().Rrtype == q.Question[0].Qtype || rr.Header().Rrtype == dns.TypeCNAME || q.Question[0].Qtype == dns.TypeANY {
+					a.Answer = append(a.Answer, rr)
+				}
+			}
+			return a, nil
+		},
+	}
+
+	tests := []struct {
+		mode    string
+		name    string
+		qtype   uint16
+		answers int
+	}{
+		{"v4only", "dual.com.", dns.TypeA, 1},
+		{"v4only", "dual.com.", dns.TypeAAAA, 0},
+		{"v4only", "cname.com.", dns.TypeAAAA, 1},
+		{"v4only", "dual.com.", dns.TypeANY, 1},
+		{"v6only", "dual.com.", dns.TypeA, 0},
+		{"v6only", "dual.com.", dns.TypeAAAA, 1},
+		{"v6only", "v4.com.", dns.TypeA, 0},
+		{"prefer-v6", "dual.com.", dns.TypeA, 0},
+		{"prefer-v6", "dual.com.", dns.TypeAAAA, 1},
+		{"prefer-v6", "v4.com.", dns.TypeA, 1},
+		{"prefer-v6", "cname.com.", dns.TypeA, 1},
+		{"prefer-v6", "dual.com.", dns.TypeANY, 1},
+		{"prefer-v4", "dual.com.", dns.TypeAAAA, 0},
+		{"prefer-v4", "dual.com.", dns.TypeA, 1},
+	}
+	for _, test := range tests {
+		r, err := NewAddressFamilyFilter("test-family", upstream, AddressFamilyFilterOptions{Mode: test.mode})
+		require.NoError(t, err)
+		q := new(dns.Msg)
+		q.SetQuestion(test.name, test.qtype)
+		a, err := r.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeSuccess, a.Rcode, test.mode+" "+test.name)
+		require.Len(t, a.Answer, test.answers, test.mode+" "+test.name)
+	}
+
+	// Invalid mode
+	_, err := NewAddressFamilyFilter("test-family", upstream, AddressFamilyFilterOptions{Mode: "v5only"})
+	require.Error(t, err)
+}
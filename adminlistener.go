@@ -39,6 +39,13 @@ type AdminListenerOptions struct {
 	Transport string
 
 	TLSConfig *tls.Config
+
+	// Caches that can be inspected and flushed with the /cache/stats and
+	// /cache/flush endpoints. The endpoints are disabled if empty.
+	Caches []*Cache
+
+	// Bearer token required for the cache endpoints. Optional.
+	Token string
 }
 
 // NewAdminListener returns an instance of an admin service listener.
@@ -60,6 +67,10 @@ func NewAdminListener(id, addr string, opt AdminListenerOptions) (*AdminListener
 	}
 	// Serve metrics.
 	l.mux.Handle("/routedns/vars", expvar.Handler())
+	l.mux.Handle("/routedns/metrics", PrometheusHandler())
+	if len(opt.Caches) > 0 {
+		newCacheAdminHandler(id, opt.Caches, opt.Token).register(l.mux)
+	}
 	return l, nil
 }
 
@@ -0,0 +1,171 @@
+package rdns
+
+import (
+	"expvar"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// Bailiwick is a resolver that removes records from upstream responses that are
+// outside the bailiwick of the query, a common way to poison caches with plain
+// DNS. Answer records must belong to the query name or a name it's aliased to
+// with CNAMEs. Authority records must belong to a parent domain of those names,
+// or to a zone named by an SOA or NS record there. Additional records must be
+// addresses of names referenced by the kept records. If records are removed from
+// the answer and nothing of the queried type is left, the response is replaced
+// with SERVFAIL.
+type Bailiwick struct {
+	id       string
+	resolver Resolver
+	rejected *expvar.Map
+}
+
+var _ Resolver = &Bailiwick{}
+
+// NewBailiwick returns a new instance of a bailiwick checker.
+func NewBailiwick(id string, resolver Resolver) *Bailiwick {
+	return &Bailiwick{
+		id:       id,
+		resolver: resolver,
+		rejected: getVarMap("router", id, "rejected"),
+	}
+}
+
+// Resolve a DNS query with the upstream resolver and remove any out-of-bailiwick
+// records from the response.
+func (r *Bailiwick) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	answer, err := r.resolver.Resolve(q, ci)
+	if err != nil || answer == nil || len(q.Question) < 1 {
+		return answer, err
+	}
+	log := logger(r.id, q, ci)
+	question := q.Question[0]
+
+	// Follow the CNAME chain from the query name. The chain can't be longer
+	// than the answer, which stops it if there's a loop.
+	chain := []string{dns.CanonicalName(question.Name)}
+	for range answer.Answer {
+		cname := findCNAME(answer.Answer, chain[len(chain)-1])
+		if cname == nil {
+			break
+		}
+		chain = append(chain, dns.CanonicalName(cname.Target))
+	}
+	inChain := func(name string) bool {
+		for _, c := range chain {
+			if equalName(name, c) {
+				return true
+			}
+		}
+		return false
+	}
+	parentOfChain := func(name string) bool {
+		for _, c := range chain {
+			if dns.IsSubDomain(name, c) {
+				return true
+			}
+		}
+		return false
+	}
+	reject := func(section string, rr dns.RR) {
+		log.WithFields(logrus.Fields{"section": section, "rr": rr.Header().Name, "rrtype": dns.TypeToString[rr.Header().Rrtype]}).Debug("removing out-of-bailiwick record")
+		r.rejected.Add(section, 1)
+	}
+
+	// Answer records belong to a name in the chain, or are DNAMEs for a parent
+	var (
+		answerRRs   []dns.RR
+		answerFound bool
+		stripped    bool
+	)
+	for _, rr := range answer.Answer {
+		h := rr.Header()
+		if !inChain(h.Name) && !(h.Rrtype == dns.TypeDNAME && parentOfChain(h.Name)) {
+			reject("answer", rr)
+			stripped = true
+			continue
+		}
+		if h.Rrtype == question.Qtype || question.Qtype == dns.TypeANY {
+			answerFound = true
+		}
+		answerRRs = append(answerRRs, rr)
+	}
+	if stripped && !answerFound {
+		log.Warn("out-of-bailiwick answer, responding with servfail")
+		return servfail(q), nil
+	}
+
+	// SOA and NS records in the authority section are for a parent domain of the
+	// chain, other records (like NSEC and DS) can be anywhere in those zones
+	var (
+		nsRRs []dns.RR
+		zones []string
+	)
+	for _, rr := range answer.Ns {
+		h := rr.Header()
+		if h.Rrtype != dns.TypeSOA && h.Rrtype != dns.TypeNS {
+			continue
+		}
+		if parentOfChain(h.Name) {
+			zones = append(zones, h.Name)
+		}
+	}
+	inZone := func(name string) bool {
+		for _, z := range zones {
+			if dns.IsSubDomain(z, name) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, rr := range answer.Ns {
+		h := rr.Header()
+		switch {
+		case parentOfChain(h.Name):
+		case h.Rrtype != dns.TypeSOA && h.Rrtype != dns.TypeNS && inZone(h.Name):
+		default:
+			reject("authority", rr)
+			continue
+		}
+		nsRRs = append(nsRRs, rr)
+	}
+
+	// Additional records are for names in the chain or targets of the records
+	// that were kept, like the addresses of name servers
+	targets := make(map[string]struct{})
+	for _, rrs := range [][]dns.RR{answerRRs, nsRRs} {
+		for _, rr := range rrs {
+			var target string
+			switch rr := rr.(type) {
+			case *dns.NS:
+				target = rr.Ns
+			case *dns.MX:
+				target = rr.Mx
+			case *dns.SRV:
+				target = rr.Target
+			default:
+				continue
+			}
+			targets[dns.CanonicalName(target)] = struct{}{}
+		}
+	}
+	var extraRRs []dns.RR
+	for _, rr := range answer.Extra {
+		h := rr.Header()
+		if _, ok := targets[dns.CanonicalName(h.Name)]; !ok && !inChain(h.Name) && h.Rrtype != dns.TypeOPT && h.Rrtype != dns.TypeTSIG {
+			reject("additional", rr)
+			continue
+		}
+		extraRRs = append(extraRRs, rr)
+	}
+
+	answer.Answer = answerRRs
+	answer.Ns = nsRRs
+	answer.Extra = extraRRs
+	return answer, nil
+}
+
+func (r *Bailiwick) String() string {
+	return r.id
+}
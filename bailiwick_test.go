@@ -0,0 +1,93 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBailiwick(t *testing.T) {
+	var answer, ns, extra []string
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			for _, section := range []struct {
+				records []string
+				rrs     *[]dns.RR
+			}{{answer, &a.Answer}, {ns, &a.Ns}, {extra, &a.Extra}} {
+				for _, s := range section.records {
+					rr, err := dns.NewRR(s)
+					require.NoError(t, err)
+					*section.rrs = append(*section.rrs, rr)
+				}
+			}
+			return a, nil
+		},
+	}
+	r := NewBailiwick("test-bailiwick", upstream)
+	q := new(dns.Msg)
+	q.SetQuestion("www.bank.com.", dns.TypeA)
+
+	// Records in the CNAME chain and for the servers of the zone are kept
+	answer = []string{
+		"www.bank.com. 60 IN CNAME WWW.cdn.example.",
+		"www.cdn.example. 60 IN A 192.0.2.1",
+	}
+	ns = []string{
+		"cdn.example. 60 IN NS ns1.cdn.example.",
+	}
+	extra = []string{
+		"ns1.cdn.example. 60 IN A 192.0.2.53",
+	}
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.Len(t, a.Answer, 2)
+	require.Len(t, a.Ns, 1)
+	require.Len(t, a.Extra, 1)
+
+	// Unrelated records are removed from all sections
+	answer = []string{
+		"www.bank.com. 60 IN A 192.0.2.1",
+		"evil.com. 60 IN A 198.51.100.1",
+	}
+	ns = []string{
+		"bank.com. 60 IN NS ns1.bank.com.",
+		"evil.com. 60 IN NS ns1.evil.com.",
+	}
+	extra = []string{
+		"ns1.bank.com. 60 IN A 192.0.2.53",
+		"ns1.evil.com. 60 IN A 198.51.100.53",
+		"www.evil.com. 60 IN A 198.51.100.2",
+	}
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.Len(t, a.Answer, 1)
+	require.Len(t, a.Ns, 1)
+	require.Len(t, a.Extra, 1)
+	require.Equal(t, "ns1.bank.com.", a.Extra[0].Header().Name)
+
+	// Denial of existence records in the zone are kept
+	answer = nil
+	ns = []string{
+		"bank.com. 60 IN SOA ns1.bank.com. admin.bank.com. 1 7200 3600 1209600 3600",
+		"a.bank.com. 60 IN NSEC x.bank.com. A RRSIG NSEC",
+	}
+	extra = nil
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Ns, 2)
+
+	// The answer for the queried type is out-of-bailiwick
+	answer = []string{
+		"www.bank.com. 60 IN CNAME www.cdn.example.",
+		"www.evil.com. 60 IN A 198.51.100.1",
+	}
+	ns = nil
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeServerFailure, a.Rcode)
+}
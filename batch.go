@@ -0,0 +1,79 @@
+package rdns
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// Batch resolves many queries in one call for programs that use routedns as a
+// library. The queries are sent to a resolver concurrently, with a limit on how
+// many are in flight at the same time. It's not a resolver itself since DNS
+// messages only carry one question.
+type Batch struct {
+	resolver Resolver
+	opt      BatchOptions
+}
+
+// BatchOptions contain settings for resolving batches of queries.
+type BatchOptions struct {
+	// Maximum number of queries of a batch in flight at the same time. Defaults
+	// to 10.
+	Concurrency int
+}
+
+// BatchResult holds the response or error for one query of a batch.
+type BatchResult struct {
+	Answer *dns.Msg
+	Err    error
+}
+
+// NewBatch returns a new instance of a batch helper sending queries to the
+// given resolver.
+func NewBatch(resolver Resolver, opt BatchOptions) (*Batch, error) {
+	if opt.Concurrency < 0 {
+		return nil, errors.New("concurrency can not be negative")
+	}
+	if opt.Concurrency == 0 {
+		opt.Concurrency = 10
+	}
+	return &Batch{
+		resolver: resolver,
+		opt:      opt,
+	}, nil
+}
+
+// ResolveBatch resolves a list of queries and returns their results in the same
+// order. Queries that haven't been sent by the time the context is done fail with
+// the context's error. The context is passed on to the resolver if it supports
+// it, otherwise queries already in flight are waited for.
+func (b *Batch) ResolveBatch(ctx context.Context, qs []*dns.Msg, ci ClientInfo) []BatchResult {
+	results := make([]BatchResult, len(qs))
+	sem := make(chan struct{}, b.opt.Concurrency)
+	var wg sync.WaitGroup
+	for i, q := range qs {
+		// Check the context first, select picks randomly if both are ready
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(i int, q *dns.Msg) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i].Answer, results[i].Err = resolveContext(ctx, b.resolver, q, ci)
+		}(i, q)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,78 @@
+package rdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatch(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		inFlight int
+		maxSeen  int
+	)
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxSeen {
+				maxSeen = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			if q.Question[0].Name == "fail.example.com." {
+				return nil, errors.New("upstream failure")
+			}
+			a := new(dns.Msg)
+			a.SetReply(q)
+			return a, nil
+		},
+	}
+	b, err := NewBatch(upstream, BatchOptions{Concurrency: 3})
+	require.NoError(t, err)
+
+	var qs []*dns.Msg
+	for i := 0; i < 20; i++ {
+		q := new(dns.Msg)
+		q.SetQuestion(fmt.Sprintf("test%d.example.com.", i), dns.TypeA)
+		qs = append(qs, q)
+	}
+	qs[5].SetQuestion("fail.example.com.", dns.TypeA)
+
+	// Results are in the order of the queries, with errors for individual queries
+	results := b.ResolveBatch(context.Background(), qs, ClientInfo{})
+	require.Len(t, results, len(qs))
+	for i, res := range results {
+		if i == 5 {
+			require.Error(t, res.Err)
+			require.Nil(t, res.Answer)
+			continue
+		}
+		require.NoError(t, res.Err)
+		require.Equal(t, qs[i].Question[0].Name, res.Answer.Question[0].Name)
+	}
+	require.Equal(t, len(qs), upstream.HitCount())
+	require.LessOrEqual(t, maxSeen, 3)
+
+	// Nothing is sent once the context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results = b.ResolveBatch(ctx, qs, ClientInfo{})
+	for _, res := range results {
+		require.Equal(t, context.Canceled, res.Err)
+	}
+	require.Equal(t, len(qs), upstream.HitCount())
+
+	_, err = NewBatch(upstream, BatchOptions{Concurrency: -1})
+	require.Error(t, err)
+}
@@ -41,6 +41,15 @@ type BlocklistOptions struct {
 
 	// Refresh period for the allowlist. Disabled if 0.
 	AllowlistRefresh time.Duration
+
+	// Extended DNS Error added to blocked responses, unless they come from the
+	// BlocklistResolver. Optional.
+	EDE *ExtendedError
+
+	// Only log and count queries that match the blocklist, but forward them to
+	// the upstream resolver like any other query. Used to test new lists
+	// against real traffic before blocking.
+	DryRun bool
 }
 
 type BlocklistMetrics struct {
@@ -48,12 +57,15 @@ type BlocklistMetrics struct {
 	blocked *expvar.Int
 	// Allowed queries count.
 	allowed *expvar.Int
+	// Queries that would have been blocked in dry-run mode, by rule.
+	dryRun *expvar.Map
 }
 
 func NewBlocklistMetrics(id string) *BlocklistMetrics {
 	return &BlocklistMetrics{
 		allowed: getVarInt("router", id, "allow"),
 		blocked: getVarInt("router", id, "deny"),
+		dryRun:  getVarMap("router", id, "dry_run"),
 	}
 }
 
@@ -112,12 +124,17 @@ func (r *Blocklist) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
 		return r.resolver.Resolve(q, ci)
 	}
 	log = log.WithField("rule", rule)
+	if r.DryRun {
+		r.metrics.dryRun.Add(rule, 1)
+		log.WithField("resolver", r.resolver.String()).Info("matched blocklist in dry-run mode, forwarding")
+		return r.resolver.Resolve(q, ci)
+	}
 	r.metrics.blocked.Add(1)
 
 	// If we got a name for the PTR query, respond to it
 	if question.Qtype == dns.TypePTR && name != "" {
 		log.Debug("responding with ptr blocklist from blocklist")
-		return ptr(q, name), nil
+		return r.EDE.addTo(q, ptr(q, name)), nil
 	}
 
 	// If an optional blocklist-resolver was given, send the query to that instead of returning NXDOMAIN.
@@ -143,7 +160,7 @@ func (r *Blocklist) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
 			},
 		}
 		log.Debug("spoofing response")
-		return answer, nil
+		return r.EDE.addTo(q, answer), nil
 	} else if len(ip) == net.IPv6len && question.Qtype == dns.TypeAAAA {
 		answer.Answer = []dns.RR{
 			&dns.AAAA{
@@ -157,46 +174,83 @@ func (r *Blocklist) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
 			},
 		}
 		log.Debug("spoofing response")
-		return answer, nil
+		return r.EDE.addTo(q, answer), nil
 	}
 
 	// Block the request with NXDOMAIN if there was a match but no valid spoofed IP is given
 	log.Debug("blocking request")
 	answer.SetRcode(q, dns.RcodeNameError)
-	return answer, nil
+	return r.EDE.addTo(q, answer), nil
 }
 
 func (r *Blocklist) String() string {
 	return r.id
 }
 
+// Reload the blocklist and allowlist rules immediately, for example when
+// receiving a SIGHUP. Queries continue to be served with the old rules while
+// loading. If a list fails to load, its old rules remain active.
+func (r *Blocklist) Reload() {
+	r.reloadBlocklist()
+	r.reloadAllowlist()
+}
+
 func (r *Blocklist) refreshLoopBlocklist(refresh time.Duration) {
 	for {
 		time.Sleep(refresh)
-		log := Log.WithField("id", r.id)
-		log.Debug("reloading blocklist")
-		db, err := r.BlocklistDB.Reload()
-		if err != nil {
-			log.WithError(err).Error("failed to load rules")
-			continue
-		}
-		r.mu.Lock()
-		r.BlocklistDB = db
-		r.mu.Unlock()
+		r.reloadBlocklist()
 	}
 }
+
 func (r *Blocklist) refreshLoopAllowlist(refresh time.Duration) {
 	for {
 		time.Sleep(refresh)
-		log := Log.WithField("id", r.id)
-		log.Debug("reloading allowlist")
-		db, err := r.AllowlistDB.Reload()
-		if err != nil {
-			log.WithError(err).Error("failed to load rules")
-			continue
-		}
-		r.mu.Lock()
-		r.AllowlistDB = db
-		r.mu.Unlock()
+		r.reloadAllowlist()
+	}
+}
+
+func (r *Blocklist) reloadBlocklist() {
+	r.mu.RLock()
+	current := r.BlocklistDB
+	r.mu.RUnlock()
+	if current == nil {
+		return
+	}
+	log := Log.WithField("id", r.id)
+	log.Debug("reloading blocklist")
+	db, err := current.Reload()
+	if err != nil {
+		log.WithError(err).Warn("failed to load rules, keeping the previous ones")
+		return
+	}
+	r.mu.Lock()
+	old := r.BlocklistDB
+	r.BlocklistDB = db
+	r.mu.Unlock()
+	if err := closeBlocklistDB(old); err != nil {
+		log.WithError(err).Warn("failed to close previous rules")
+	}
+}
+
+func (r *Blocklist) reloadAllowlist() {
+	r.mu.RLock()
+	current := r.AllowlistDB
+	r.mu.RUnlock()
+	if current == nil {
+		return
+	}
+	log := Log.WithField("id", r.id)
+	log.Debug("reloading allowlist")
+	db, err := current.Reload()
+	if err != nil {
+		log.WithError(err).Warn("failed to load rules, keeping the previous ones")
+		return
+	}
+	r.mu.Lock()
+	old := r.AllowlistDB
+	r.AllowlistDB = db
+	r.mu.Unlock()
+	if err := closeBlocklistDB(old); err != nil {
+		log.WithError(err).Warn("failed to close previous rules")
 	}
 }
@@ -82,3 +82,103 @@ func TestBlocklistAllow(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 2, r.HitCount())
 }
+
+func TestBlocklistReload(t *testing.T) {
+	var ci ClientInfo
+	q := new(dns.Msg)
+	r := new(TestResolver)
+
+	loader := NewStaticLoader([]string{"0.0.0.0 Ads.Example.com"})
+	m, err := NewHostsDB(loader)
+	require.NoError(t, err)
+
+	b, err := NewBlocklist("test-bl", r, BlocklistOptions{BlocklistDB: m})
+	require.NoError(t, err)
+
+	// Names should match regardless of case
+	q.SetQuestion("ads.EXAMPLE.com.", dns.TypeA)
+	a, err := b.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 0, r.HitCount())
+	require.Equal(t, dns.RcodeNameError, a.Rcode)
+
+	// Update the rules and reload, the name should no longer be blocked
+	loader.rules = []string{"0.0.0.0 tracker.example.com"}
+	b.Reload()
+	_, err = b.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 1, r.HitCount())
+}
+
+func TestBlocklistEDE(t *testing.T) {
+	var ci ClientInfo
+	r := new(TestResolver)
+
+	m, err := NewRegexpDB(NewStaticLoader([]string{`(^|\.)evil\.test`}))
+	require.NoError(t, err)
+	opt := BlocklistOptions{
+		BlocklistDB: m,
+		EDE:         &ExtendedError{InfoCode: EDEFiltered, ExtraText: "blocked by RouteDNS"},
+	}
+	b, err := NewBlocklist("test-bl", r, opt)
+	require.NoError(t, err)
+
+	// No extended error without OPT record in the query
+	q := new(dns.Msg)
+	q.SetQuestion("x.evil.test.", dns.TypeA)
+	a, err := b.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeNameError, a.Rcode)
+	require.Nil(t, a.IsEdns0())
+
+	// With OPT record, the extended error survives a round-trip through the wire format
+	q.SetEdns0(4096, false)
+	a, err = b.Resolve(q, ci)
+	require.NoError(t, err)
+	buf, err := a.Pack()
+	require.NoError(t, err)
+	a = new(dns.Msg)
+	require.NoError(t, a.Unpack(buf))
+	edns0 := a.IsEdns0()
+	require.NotNil(t, edns0)
+	require.Len(t, edns0.Option, 1)
+	ede := edns0.Option[0].(*dns.EDNS0_LOCAL)
+	require.Equal(t, uint16(15), ede.Code)
+	require.Equal(t, append([]byte{0, 17}, "blocked by RouteDNS"...), ede.Data)
+	require.Equal(t, 0, r.HitCount())
+}
+
+func TestBlocklistDryRun(t *testing.T) {
+	var ci ClientInfo
+	q := new(dns.Msg)
+	r := new(TestResolver)
+
+	loader := NewStaticLoader([]string{
+		`(^|\.)block\.test`,
+		`(^|\.)evil\.test`,
+	})
+	m, err := NewRegexpDB(loader)
+	require.NoError(t, err)
+
+	opt := BlocklistOptions{
+		BlocklistDB: m,
+		DryRun:      true,
+	}
+	b, err := NewBlocklist("test-bl-dry-run", r, opt)
+	require.NoError(t, err)
+
+	// Matching queries are forwarded and counted by rule
+	q.SetQuestion("x.evil.test.", dns.TypeA)
+	a, err := b.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 1, r.HitCount())
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	q.SetQuestion("block.test.", dns.TypeA)
+	_, err = b.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 2, r.HitCount())
+
+	require.Equal(t, "1", b.metrics.dryRun.Get(`(^|\.)evil\.test`).String())
+	require.Equal(t, "1", b.metrics.dryRun.Get(`(^|\.)block\.test`).String())
+	require.Equal(t, int64(0), b.metrics.blocked.Value())
+}
@@ -0,0 +1,334 @@
+package rdns
+
+import (
+	"bufio"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// BloomDB holds a list of domain rules, in the same format as DomainDB, in a
+// bloom filter. It uses a fraction of the memory of DomainDB which makes it
+// suitable for lists with millions of rules, at the cost of a small rate of
+// false positives, names that are blocked even though there's no rule for them.
+// False positives can be eliminated by verifying matches against an exact copy
+// of the rules that's kept on disk.
+type BloomDB struct {
+	id      string
+	loader  BlocklistLoader
+	opt     BloomDBOptions
+	bits    []uint64
+	m       uint64 // Number of bits in the filter
+	k       uint64 // Number of hash functions
+	exact   *sortedFile
+	metrics *BloomDBMetrics
+}
+
+var _ BlocklistDB = &BloomDB{}
+
+// BloomDBOptions contain settings for the bloom filter blocklist.
+type BloomDBOptions struct {
+	// Expected rate of false positives, used to size the filter. Defaults
+	// to 0.001.
+	FalsePositiveRate float64
+
+	// Verify matches against an exact copy of the rules on disk. Without it,
+	// a fraction of names that aren't on the list are blocked.
+	Verify bool
+}
+
+// BloomDBMetrics contain the metrics of a bloom filter blocklist.
+type BloomDBMetrics struct {
+	// Configured false-positive rate and fraction of bits set in the filter.
+	filter *expvar.Map
+	// Count of false positives eliminated by verification.
+	falsePositive *expvar.Int
+}
+
+// NewBloomDB returns a new instance of a bloom filter blocklist. The ID is only
+// used for metrics.
+func NewBloomDB(id string, loader BlocklistLoader, opt BloomDBOptions) (*BloomDB, error) {
+	if opt.FalsePositiveRate == 0 {
+		opt.FalsePositiveRate = 0.001
+	}
+	if opt.FalsePositiveRate < 0 || opt.FalsePositiveRate >= 1 {
+		return nil, fmt.Errorf("invalid false-positive rate %g", opt.FalsePositiveRate)
+	}
+	rules, err := loader.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	// Normalize the rules the same way DomainDB does
+	items := make([]string, 0, len(rules))
+	for _, r := range rules {
+		r = strings.ToLower(strings.TrimSpace(r))
+		r = strings.TrimSuffix(r, ".")
+		if r == "" || strings.HasPrefix(r, "#") {
+			continue
+		}
+		if i := strings.LastIndex(r, "*"); i > 0 || (i == 0 && !strings.HasPrefix(r, "*.")) {
+			return nil, fmt.Errorf("invalid blocklist item: '%s'", r)
+		}
+		items = append(items, r)
+	}
+
+	// Size the filter for the number of rules and false-positive rate
+	n := float64(len(items))
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-n * math.Log(opt.FalsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / n * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	db := &BloomDB{
+		id:     id,
+		loader: loader,
+		opt:    opt,
+		bits:   make([]uint64, (m+63)/64),
+		m:      m,
+		k:      k,
+		metrics: &BloomDBMetrics{
+			filter:        getVarMap("router", id, "bloom"),
+			falsePositive: getVarInt("router", id, "false_positive"),
+		},
+	}
+	for _, item := range items {
+		db.add(item)
+	}
+	if opt.Verify {
+		db.exact, err = newSortedFile(items)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rate := new(expvar.Float)
+	rate.Set(opt.FalsePositiveRate)
+	db.metrics.filter.Set("false-positive-rate", rate)
+	fill := new(expvar.Float)
+	fill.Set(db.fillRatio())
+	db.metrics.filter.Set("fill-ratio", fill)
+	return db, nil
+}
+
+func (m *BloomDB) Reload() (BlocklistDB, error) {
+	return NewBloomDB(m.id, m.loader, m.opt)
+}
+
+// Close releases the exact copy of the rules on disk, if there is one. Matches
+// can't be verified anymore afterwards.
+func (m *BloomDB) Close() error {
+	if m.exact == nil {
+		return nil
+	}
+	return m.exact.close()
+}
+
+func (m *BloomDB) Match(q dns.Question) (net.IP, string, string, bool) {
+	s := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+
+	// Check the rules for every parent domain, starting with the TLD, then the
+	// name itself
+	for end := len(s); end > 0; {
+		start := strings.LastIndexByte(s[:end], '.') + 1
+		suffix := s[start:]
+		if m.match(".", suffix) { // exact and sub-domain match
+			return nil, "", "." + suffix, true
+		}
+		if start > 0 && m.match("*.", suffix) { // wildcard match on sub-domains
+			return nil, "", "*." + suffix, true
+		}
+		end = start - 1
+	}
+	if m.match("", s) { // exact match
+		return nil, "", s, true
+	}
+	return nil, "", "", false
+}
+
+func (m *BloomDB) String() string {
+	return "Bloom"
+}
+
+// Adds a rule to the filter.
+func (m *BloomDB) add(item string) {
+	h1, h2 := bloomHash("", item)
+	for i := uint64(0); i < m.k; i++ {
+		b := (h1 + i*h2) % m.m
+		m.bits[b/64] |= 1 << (b % 64)
+	}
+}
+
+// Returns true if the rule made up of prefix and name is in the filter, and
+// if enabled, in the exact copy of the rules.
+func (m *BloomDB) match(prefix, name string) bool {
+	h1, h2 := bloomHash(prefix, name)
+	for i := uint64(0); i < m.k; i++ {
+		b := (h1 + i*h2) % m.m
+		if m.bits[b/64]&(1<<(b%64)) == 0 {
+			return false
+		}
+	}
+	if m.exact == nil {
+		return true
+	}
+	ok, err := m.exact.contains(prefix + name)
+	if err != nil {
+		// Err on the side of blocking if the file can't be read
+		Log.WithError(err).WithField("id", m.id).Error("failed to verify blocklist match")
+		return true
+	}
+	if !ok {
+		m.metrics.falsePositive.Add(1)
+	}
+	return ok
+}
+
+// Returns the fraction of bits set in the filter.
+func (m *BloomDB) fillRatio() float64 {
+	var set int
+	for _, w := range m.bits {
+		set += bits.OnesCount64(w)
+	}
+	return float64(set) / float64(m.m)
+}
+
+// Returns two hashes of the concatenation of prefix and name, without
+// allocating it, for double hashing. The first is FNV-1a, the second is
+// derived from it with the splitmix64 finalizer and is always odd.
+func bloomHash(prefix, name string) (uint64, uint64) {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(prefix); i++ {
+		h ^= uint64(prefix[i])
+		h *= 1099511628211
+	}
+	for i := 0; i < len(name); i++ {
+		h ^= uint64(name[i])
+		h *= 1099511628211
+	}
+	h2 := h + 0x9e3779b97f4a7c15
+	h2 = (h2 ^ (h2 >> 30)) * 0xbf58476d1ce4e5b9
+	h2 = (h2 ^ (h2 >> 27)) * 0x94d049bb133111eb
+	h2 ^= h2 >> 31
+	return h, h2 | 1
+}
+
+// sortedFile is a set of strings stored on disk, one per line in sorted order,
+// that is searched with a binary search over the file offsets.
+type sortedFile struct {
+	mu   sync.RWMutex // Held for reading while searching, so it's not closed during a search
+	f    *os.File
+	size int64
+}
+
+// Writes the items into a temporary file. The file is removed right away and
+// only accessed through the open handle, so it's gone once that's closed.
+func newSortedFile(items []string) (*sortedFile, error) {
+	sorted := make([]string, len(items))
+	copy(sorted, items)
+	sort.Strings(sorted)
+
+	f, err := ioutil.TempFile("", "routedns-blocklist-")
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	for i, item := range sorted {
+		if i > 0 && item == sorted[i-1] {
+			continue
+		}
+		if strings.Contains(item, "\n") {
+			f.Close()
+			return nil, errors.New("blocklist items can not contain newlines")
+		}
+		_, _ = w.WriteString(item)
+		_ = w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &sortedFile{f: f, size: info.Size()}, nil
+}
+
+// Closes the file, which removes it from disk.
+func (s *sortedFile) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// Returns true if the item is in the file.
+func (s *sortedFile) contains(item string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	// If the item is in the file, its line starts in [lo, hi)
+	lo, hi := int64(0), s.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, line, err := s.lineAt(mid)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case start >= hi || item < line:
+			// No line starts between mid and the line that was read
+			hi = mid
+		case item == line:
+			return true, nil
+		default:
+			lo = start + int64(len(line)) + 1
+		}
+	}
+	return false, nil
+}
+
+// Returns the first line that starts at or after the offset and its position.
+// Returns the size of the file as position if there is no such line.
+func (s *sortedFile) lineAt(off int64) (int64, string, error) {
+	start := off
+	if off > 0 {
+		start = off - 1
+	}
+	r := bufio.NewReaderSize(io.NewSectionReader(s.f, start, s.size-start), 256)
+	if off > 0 {
+		// Skip to the end of the line the offset is in, or the one just
+		// before if the offset is at the start of a line
+		skipped, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return 0, "", err
+		}
+		start += int64(len(skipped))
+	}
+	if start >= s.size {
+		return s.size, "", nil
+	}
+	line, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, "", err
+	}
+	return start, strings.TrimSuffix(line, "\n"), nil
+}
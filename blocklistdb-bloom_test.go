@@ -0,0 +1,116 @@
+package rdns
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomDB(t *testing.T) {
+	loader := NewStaticLoader([]string{
+		"domain1.com.",    // exact match
+		".domain2.com.",   // exact match and subdomains
+		"x.domain2.com",   // above rule should take precendence
+		"*.domain3.com",   // subdomains only
+		"x.x.domain3.com", // more general wildcard above should take precedence
+		"domain4.com",     // the more general rule below wins
+		".domain4.com",
+		"Domain5.COM", // case-insensitive
+	})
+
+	for _, verify := range []bool{false, true} {
+		m, err := NewBloomDB("test-bloom", loader, BloomDBOptions{Verify: verify})
+		require.NoError(t, err)
+
+		tests := []struct {
+			q     string
+			match bool
+			rule  string
+		}{
+			{"domain1.com.", true, "domain1.com"},
+			{"x.domain1.com.", false, ""},
+			{"domain2.com.", true, ".domain2.com"},
+			{"x.domain2.com.", true, ".domain2.com"},
+			{"domain3.com.", false, ""},
+			{"x.x.domain3.com.", true, "*.domain3.com"},
+			{"sub.domain4.com.", true, ".domain4.com"},
+			{"DOMAIN5.com.", true, "domain5.com"},
+			{"unblocked.test.", false, ""},
+			{"com.", false, ""},
+		}
+		for _, test := range tests {
+			q := dns.Question{Name: test.q, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+			_, _, rule, ok := m.Match(q)
+			require.Equal(t, test.match, ok, "query: %s", test.q)
+			require.Equal(t, test.rule, rule, "query: %s", test.q)
+		}
+	}
+
+	_, err := NewBloomDB("test-bloom", NewStaticLoader([]string{"sub.*.com"}), BloomDBOptions{})
+	require.Error(t, err)
+}
+
+func TestBloomDBFalsePositives(t *testing.T) {
+	var rules []string
+	for i := 0; i < 10000; i++ {
+		rules = append(rules, fmt.Sprintf("blocked%d.com", i))
+	}
+	loader := NewStaticLoader(rules)
+
+	// Without verification, the rate of false positives is close to the configured one
+	m, err := NewBloomDB("test-bloom", loader, BloomDBOptions{FalsePositiveRate: 0.01})
+	require.NoError(t, err)
+	require.InDelta(t, 0.5, m.fillRatio(), 0.05)
+	var fp int
+	for i := 0; i < 10000; i++ {
+		if _, _, _, ok := m.Match(dns.Question{Name: fmt.Sprintf("allowed%d.com.", i)}); ok {
+			fp++
+		}
+	}
+	require.Less(t, fp, 300)
+
+	// All of them are eliminated when verifying matches
+	m, err = NewBloomDB("test-bloom", loader, BloomDBOptions{FalsePositiveRate: 0.01, Verify: true})
+	require.NoError(t, err)
+	for i := 0; i < 10000; i++ {
+		_, _, _, ok := m.Match(dns.Question{Name: fmt.Sprintf("allowed%d.com.", i)})
+		require.False(t, ok)
+		_, _, _, ok = m.Match(dns.Question{Name: fmt.Sprintf("blocked%d.com.", i)})
+		require.True(t, ok)
+	}
+}
+
+func TestSortedFile(t *testing.T) {
+	items := []string{"c", "a", "bbbbbbbbbb", "a", "dd", "e"}
+	f, err := newSortedFile(items)
+	require.NoError(t, err)
+	for _, item := range items {
+		ok, err := f.contains(item)
+		require.NoError(t, err)
+		require.True(t, ok, item)
+	}
+	for _, item := range []string{"", "0", "b", "bbbbbbbbb", "d", "f"} {
+		ok, err := f.contains(item)
+		require.NoError(t, err)
+		require.False(t, ok, item)
+	}
+}
+
+func TestBloomDBReloadClosesFile(t *testing.T) {
+	loader := NewStaticLoader([]string{"domain1.com"})
+	m, err := NewBloomDB("test-bloom", loader, BloomDBOptions{Verify: true})
+	require.NoError(t, err)
+	multi, err := NewMultiDB(m)
+	require.NoError(t, err)
+	b, err := NewBlocklist("test-bloom-reload", new(TestResolver), BlocklistOptions{BlocklistDB: multi})
+	require.NoError(t, err)
+
+	// The exact copy of the old rules is closed once the new ones are active
+	b.Reload()
+	_, err = m.exact.f.Stat()
+	require.Error(t, err)
+	_, _, _, ok := b.BlocklistDB.Match(dns.Question{Name: "domain1.com."})
+	require.True(t, ok)
+}
@@ -13,6 +13,7 @@ import (
 // domain.com: matches just domain.com and not subdomains
 // .domain.com: matches domain.com and all subdomains
 // *.domain.com: matches all subdomains but not domain.com
+// Matching is case-insensitive.
 type DomainDB struct {
 	root   node
 	loader BlocklistLoader
@@ -30,7 +31,7 @@ func NewDomainDB(loader BlocklistLoader) (*DomainDB, error) {
 	}
 	root := make(node)
 	for _, r := range rules {
-		r = strings.TrimSpace(r)
+		r = strings.ToLower(strings.TrimSpace(r))
 
 		// Strip trailing . in case the list has FQDN names with . suffixes.
 		r = strings.TrimSuffix(r, ".")
@@ -63,7 +64,7 @@ func (m *DomainDB) Reload() (BlocklistDB, error) {
 }
 
 func (m *DomainDB) Match(q dns.Question) (net.IP, string, string, bool) {
-	s := strings.TrimSuffix(q.Name, ".")
+	s := strings.ToLower(strings.TrimSuffix(q.Name, "."))
 	var matched []string
 	parts := strings.Split(s, ".")
 	n := m.root
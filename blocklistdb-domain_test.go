@@ -16,6 +16,7 @@ func TestDomainDB(t *testing.T) {
 		"x.x.domain3.com", // more general wildcard above should take precedence
 		"domain4.com",     // the more general rule below wins
 		".domain4.com",
+		"Domain5.COM", // case-insensitive
 	})
 
 	m, err := NewDomainDB(loader)
@@ -41,6 +42,10 @@ func TestDomainDB(t *testing.T) {
 		{"domain4.com.", true},
 		{"sub.domain4.com.", true},
 
+		// case-insensitive
+		{"domain5.com.", true},
+		{"DOMAIN1.com.", true},
+
 		// not matching
 		{"unblocked.test.", false},
 		{"com.", false},
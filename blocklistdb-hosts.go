@@ -54,7 +54,7 @@ func NewHostsDB(loader BlocklistLoader) (*HostsDB, error) {
 			ip = nil
 		}
 		for _, name := range names {
-			name = strings.TrimSuffix(name, ".")
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
 			ips := filters[name]
 			if isIP4 {
 				ips.ip4 = ip
@@ -81,7 +81,7 @@ func (m *HostsDB) Match(q dns.Question) (net.IP, string, string, bool) {
 		name, ok := m.ptrMap[q.Name]
 		return nil, name, "", ok
 	}
-	name := strings.TrimSuffix(q.Name, ".")
+	name := strings.ToLower(strings.TrimSuffix(q.Name, "."))
 	ips, ok := m.filters[name]
 	if q.Qtype == dns.TypeA {
 		return ips.ip4, "", ips.ip4.String() + " " + name, ok
@@ -30,6 +30,17 @@ func (m MultiDB) Reload() (BlocklistDB, error) {
 	return NewMultiDB(newDBs...)
 }
 
+// Close closes all blocklist DBs that hold resources.
+func (m MultiDB) Close() error {
+	var err error
+	for _, db := range m.dbs {
+		if cerr := closeBlocklistDB(db); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
 func (m MultiDB) Match(q dns.Question) (net.IP, string, string, bool) {
 	for _, db := range m.dbs {
 		if ip, name, rule, ok := db.Match(q); ok {
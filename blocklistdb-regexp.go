@@ -3,17 +3,28 @@ package rdns
 import (
 	"net"
 	"regexp"
+	"regexp/syntax"
 	"strings"
 
 	"github.com/miekg/dns"
 )
 
 // RegexpDB holds a list of regular expressions against which it evaluates DNS queries.
+// To avoid evaluating every expression for every query, rules are indexed by a
+// 3-character substring of a literal that must be present in any name they match.
+// Only rules with an index entry found in the query name are evaluated.
 type RegexpDB struct {
-	rules  []*regexp.Regexp
+	rules  []regexpRule            // Rules without a usable literal, always evaluated
+	index  map[string][]regexpRule // Rules indexed by trigram
 	loader BlocklistLoader
 }
 
+type regexpRule struct {
+	rule    string // The rule as written in the list, reported on matches
+	literal string // Lowercase literal required for the rule to match
+	re      *regexp.Regexp
+}
+
 var _ BlocklistDB = &RegexpDB{}
 
 // NewRegexpDB returns a new instance of a matcher for a list of regular expressions.
@@ -22,20 +33,40 @@ func NewRegexpDB(loader BlocklistLoader) (*RegexpDB, error) {
 	if err != nil {
 		return nil, err
 	}
-	var filters []*regexp.Regexp
-	for _, r := range rules {
-		r = strings.TrimSpace(r)
-		if r == "" || strings.HasPrefix(r, "#") {
+	var filters []regexpRule
+	index := make(map[string][]regexpRule)
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" || strings.HasPrefix(rule, "#") {
 			continue
 		}
-		re, err := regexp.Compile(r)
+		// Rules can optionally be enclosed in slashes, like /^ads\./
+		r := rule
+		if len(r) > 1 && strings.HasPrefix(r, "/") && strings.HasSuffix(r, "/") {
+			r = r[1 : len(r)-1]
+		}
+		// Domain names are case-insensitive
+		re, err := regexp.Compile("(?i)" + r)
 		if err != nil {
 			return nil, err
 		}
-		filters = append(filters, re)
+		literal := regexpLiteral(r)
+		if len(literal) < 3 {
+			filters = append(filters, regexpRule{rule: rule, re: re})
+			continue
+		}
+		// Pick the least used trigram of the literal to keep the index balanced
+		var key string
+		for i := 0; i+3 <= len(literal); i++ {
+			t := literal[i : i+3]
+			if key == "" || len(index[t]) < len(index[key]) {
+				key = t
+			}
+		}
+		index[key] = append(index[key], regexpRule{rule: rule, literal: literal, re: re})
 	}
 
-	return &RegexpDB{filters, loader}, nil
+	return &RegexpDB{filters, index, loader}, nil
 }
 
 func (m *RegexpDB) Reload() (BlocklistDB, error) {
@@ -44,8 +75,16 @@ func (m *RegexpDB) Reload() (BlocklistDB, error) {
 
 func (m *RegexpDB) Match(q dns.Question) (net.IP, string, string, bool) {
 	for _, rule := range m.rules {
-		if rule.MatchString(q.Name) {
-			return nil, "", rule.String(), true
+		if rule.re.MatchString(q.Name) {
+			return nil, "", rule.rule, true
+		}
+	}
+	name := strings.ToLower(q.Name)
+	for i := 0; i+3 <= len(name); i++ {
+		for _, rule := range m.index[name[i:i+3]] {
+			if strings.Contains(name, rule.literal) && rule.re.MatchString(q.Name) {
+				return nil, "", rule.rule, true
+			}
 		}
 	}
 	return nil, "", "", false
@@ -54,3 +93,48 @@ func (m *RegexpDB) Match(q dns.Question) (net.IP, string, string, bool) {
 func (m *RegexpDB) String() string {
 	return "Regexp"
 }
+
+// Returns the longest literal string, in lowercase, that must be present in
+// any string matching the expression. Returns an empty string if there is none
+// or the expression can't be parsed.
+func regexpLiteral(expr string) string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(requiredLiteral(re.Simplify()))
+}
+
+func requiredLiteral(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return string(re.Rune)
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiteral(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return requiredLiteral(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		// Adjacent literals are combined, other elements can contain literals too
+		var longest, run string
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral {
+				run += string(sub.Rune)
+				continue
+			}
+			if len(run) > len(longest) {
+				longest = run
+			}
+			run = ""
+			if s := requiredLiteral(sub); len(s) > len(longest) {
+				longest = s
+			}
+		}
+		if len(run) > len(longest) {
+			longest = run
+		}
+		return longest
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+package rdns
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegexpDB(t *testing.T) {
+	loader := NewStaticLoader([]string{
+		"# some comment",
+		`(^|\.)evil\.test\.$`,
+		`/.*\.doubleclick\.net\.$/`,
+	})
+
+	m, err := NewRegexpDB(loader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		q     string
+		match bool
+	}{
+		{"evil.test.", true},
+		{"x.evil.test.", true},
+		{"notevil.test.", false},
+		{"ad.doubleclick.net.", true},
+		{"AD.DoubleClick.NET.", true},
+		{"doubleclick.net.", false},
+	}
+	for _, test := range tests {
+		q := dns.Question{Name: test.q, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+		_, _, _, ok := m.Match(q)
+		require.Equal(t, test.match, ok, "query: %s", test.q)
+	}
+
+	// Matches report the rule as written
+	_, _, rule, _ := m.Match(dns.Question{Name: "AD.DoubleClick.NET.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	require.Equal(t, `/.*\.doubleclick\.net\.$/`, rule)
+}
+
+func BenchmarkRegexpDB(b *testing.B) {
+	rules := make([]string, 0, 100000)
+	for i := 0; i < 100000; i++ {
+		rules = append(rules, fmt.Sprintf(`(^|\.)domain%d\.test\.$`, i))
+	}
+	m, err := NewRegexpDB(NewStaticLoader(rules))
+	require.NoError(b, err)
+
+	for _, name := range []string{"www.unblocked.test.", "www.domain99999.test."} {
+		q := dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m.Match(q)
+			}
+		})
+	}
+}
+
+func TestRegexpLiteral(t *testing.T) {
+	tests := []struct {
+		expr    string
+		literal string
+	}{
+		{`(^|\.)evil\.test\.$`, "evil.test."},
+		{`.*\.DoubleClick\.net$`, ".doubleclick.net"},
+		{`^ads?\.example\.com$`, ".example.com"},
+		{`(tracker)+\.`, "tracker"},
+		{`ads|tracker`, ""},
+		{`^[a-z]+$`, ""},
+		{`(`, ""},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.literal, regexpLiteral(test.expr), test.expr)
+	}
+}
@@ -2,6 +2,7 @@ package rdns
 
 import (
 	"fmt"
+	"io"
 	"net"
 
 	"github.com/miekg/dns"
@@ -18,3 +19,12 @@ type BlocklistDB interface {
 
 	fmt.Stringer
 }
+
+// Closes a blocklist DB that was replaced, if it holds resources like files
+// that aren't released otherwise.
+func closeBlocklistDB(db BlocklistDB) error {
+	if c, ok := db.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
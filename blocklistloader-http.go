@@ -2,21 +2,30 @@ package rdns
 
 import (
 	"bufio"
+	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 )
 
-// HTTPLoader reads blocklist rules from a server via HTTP(S).
+// HTTPLoader reads blocklist rules from a server via HTTP(S). Lists can be gzip
+// compressed. If a cache-dir is used, the list is only downloaded again if it
+// changed on the server, based on its ETag or modification time.
 type HTTPLoader struct {
 	url      string
 	opt      HTTPLoaderOptions
 	fromDisk bool
+
+	mu           sync.Mutex
+	etag         string
+	lastModified string
 }
 
 // HTTPLoaderOptions holds options for HTTP blocklist loaders.
@@ -29,10 +38,16 @@ var _ BlocklistLoader = &HTTPLoader{}
 const httpTimeout = 30 * time.Minute
 
 func NewHTTPLoader(url string, opt HTTPLoaderOptions) *HTTPLoader {
-	return &HTTPLoader{url, opt, opt.CacheDir != ""}
+	return &HTTPLoader{
+		url:      url,
+		opt:      opt,
+		fromDisk: opt.CacheDir != "",
+	}
 }
 
 func (l *HTTPLoader) Load() ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	log := Log.WithField("url", l.url)
 	log.Trace("loading blocklist")
 
@@ -43,6 +58,10 @@ func (l *HTTPLoader) Load() ([]string, error) {
 		rules, err := l.loadFromDisk()
 		if err == nil {
 			log.WithField("load-time", time.Since(start)).Trace("loaded blocklist from cache-dir")
+			// Only download the list again if it changed after it was cached
+			if info, err := os.Stat(l.cacheFilename()); err == nil {
+				l.lastModified = info.ModTime().UTC().Format(http.TimeFormat)
+			}
 			return rules, err
 		}
 		log.WithError(err).Warn("unable to load cached list from disk, loading from upstream")
@@ -56,19 +75,47 @@ func (l *HTTPLoader) Load() ([]string, error) {
 		return nil, err
 	}
 
+	// Make the request conditional if there's a copy of the list in the cache-dir
+	// that can be used if it hasn't changed.
+	if l.opt.CacheDir != "" {
+		if l.etag != "" {
+			req.Header.Set("If-None-Match", l.etag)
+		}
+		if l.lastModified != "" {
+			req.Header.Set("If-Modified-Since", l.lastModified)
+		}
+	}
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && l.opt.CacheDir != "" {
+		log.Debug("blocklist not modified, loading from cache-dir")
+		return l.loadFromDisk()
+	}
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		return nil, fmt.Errorf("got unexpected status code %d from %s", resp.StatusCode, l.url)
 	}
 
+	// Lists can be gzip files, as opposed to being compressed for the transfer
+	// which is handled by the HTTP client.
+	br := bufio.NewReader(resp.Body)
+	var body io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+
 	start := time.Now()
 	var rules []string
-	scanner := bufio.NewScanner(resp.Body)
+	scanner := bufio.NewScanner(body)
 	for scanner.Scan() {
 		rules = append(rules, scanner.Text())
 	}
@@ -79,6 +126,9 @@ func (l *HTTPLoader) Load() ([]string, error) {
 		log.Trace("writing rules to cache-dir")
 		if err := l.writeToDisk(rules); err != nil {
 			log.WithError(err).Error("failed to write rules to cache")
+		} else {
+			l.etag = resp.Header.Get("ETag")
+			l.lastModified = resp.Header.Get("Last-Modified")
 		}
 	}
 	return rules, scanner.Err()
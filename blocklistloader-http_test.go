@@ -0,0 +1,62 @@
+package rdns
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPLoaderGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte("domain1.com\n.domain2.com\n"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/gzip")
+		_, _ = w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	rules, err := NewHTTPLoader(srv.URL+"/list.gz", HTTPLoaderOptions{}).Load()
+	require.NoError(t, err)
+	require.Equal(t, []string{"domain1.com", ".domain2.com"}, rules)
+}
+
+func TestHTTPLoaderNotModified(t *testing.T) {
+	var downloads int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("domain1.com\n"))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "routedns")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The first load downloads the list since there's nothing in the cache-dir
+	l := NewHTTPLoader(srv.URL, HTTPLoaderOptions{CacheDir: dir})
+	rules, err := l.Load()
+	require.NoError(t, err)
+	require.Equal(t, []string{"domain1.com"}, rules)
+	require.Equal(t, 1, downloads)
+
+	// The list hasn't changed, it's loaded from the cache-dir
+	rules, err = l.Load()
+	require.NoError(t, err)
+	require.Equal(t, []string{"domain1.com"}, rules)
+	require.Equal(t, 1, downloads)
+}
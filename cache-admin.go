@@ -0,0 +1,129 @@
+package rdns
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// Serves the cache endpoints of the admin listener. Stats are returned with
+// GET /cache/stats, answers are removed with POST /cache/flush, optionally
+// limited to one name and type with the "name" and "type" parameters. Both
+// can be restricted to one cache with the "cache" parameter.
+type cacheAdminHandler struct {
+	listenerID string
+	caches     []*Cache
+	token      string
+}
+
+// Response to a cache flush, number of removed answers by cache.
+type cacheFlushResponse struct {
+	Name    string         `json:"name,omitempty"`
+	Type    string         `json:"type,omitempty"`
+	Flushed map[string]int `json:"flushed"`
+}
+
+func newCacheAdminHandler(listenerID string, caches []*Cache, token string) *cacheAdminHandler {
+	return &cacheAdminHandler{
+		listenerID: listenerID,
+		caches:     caches,
+		token:      token,
+	}
+}
+
+func (h *cacheAdminHandler) register(mux *http.ServeMux) {
+	mux.HandleFunc("/cache/stats", h.authorize(h.stats))
+	mux.HandleFunc("/cache/flush", h.authorize(h.flush))
+}
+
+// Wraps a handler and rejects requests without the bearer token, if one is configured.
+func (h *cacheAdminHandler) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func (h *cacheAdminHandler) stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caches, err := h.selectCaches(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	resp := make(map[string]CacheStats)
+	for _, c := range caches {
+		resp[c.String()] = c.Stats()
+	}
+	h.writeJSON(w, resp)
+}
+
+func (h *cacheAdminHandler) flush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caches, err := h.selectCaches(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	typ := strings.ToUpper(r.URL.Query().Get("type"))
+	var qtype uint16
+	if typ != "" {
+		var ok bool
+		qtype, ok = dns.StringToType[typ]
+		if !ok {
+			http.Error(w, fmt.Sprintf("invalid type '%s'", typ), http.StatusBadRequest)
+			return
+		}
+	}
+	resp := cacheFlushResponse{
+		Name:    name,
+		Type:    typ,
+		Flushed: make(map[string]int),
+	}
+	for _, c := range caches {
+		resp.Flushed[c.String()] = c.Flush(name, qtype)
+	}
+	Log.WithFields(logrus.Fields{"id": h.listenerID, "name": name, "type": typ, "flushed": resp.Flushed}).Info("flushed cache")
+	h.writeJSON(w, resp)
+}
+
+// Returns the caches a request applies to, all of them unless one is selected
+// with the "cache" parameter.
+func (h *cacheAdminHandler) selectCaches(r *http.Request) ([]*Cache, error) {
+	id := r.URL.Query().Get("cache")
+	if id == "" {
+		return h.caches, nil
+	}
+	for _, c := range h.caches {
+		if c.String() == id {
+			return []*Cache{c}, nil
+		}
+	}
+	return nil, fmt.Errorf("cache '%s' not found", id)
+}
+
+func (h *cacheAdminHandler) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		Log.WithField("id", h.listenerID).WithError(err).Error("failed to write response")
+	}
+}
@@ -0,0 +1,81 @@
+package rdns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheAdmin(t *testing.T) {
+	var ci ClientInfo
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.Answer = []dns.RR{&dns.A{
+				Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600},
+			}}
+			return a, nil
+		},
+	}
+	c := NewCache("test-cache", upstream, CacheOptions{})
+	for _, query := range []struct {
+		name  string
+		qtype uint16
+	}{
+		{"example.com.", dns.TypeA},
+		{"example.com.", dns.TypeAAAA},
+		{"example.net.", dns.TypeA},
+	} {
+		q := new(dns.Msg)
+		q.SetQuestion(query.name, query.qtype)
+		_, err := c.Resolve(q, ci)
+		require.NoError(t, err)
+	}
+
+	mux := http.NewServeMux()
+	newCacheAdminHandler("test-admin", []*Cache{c}, "secret").register(mux)
+	do := func(method, url string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	// The token is required
+	require.Equal(t, http.StatusUnauthorized, do("GET", "/cache/stats", "").Code)
+	require.Equal(t, http.StatusUnauthorized, do("GET", "/cache/stats", "wrong").Code)
+
+	w := do("GET", "/cache/stats", "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var stats map[string]CacheStats
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+	require.Equal(t, CacheStats{Entries: 3, Miss: 3}, stats["test-cache"])
+
+	// Flushing requires POST
+	require.Equal(t, http.StatusMethodNotAllowed, do("GET", "/cache/flush", "secret").Code)
+	require.Equal(t, http.StatusNotFound, do("POST", "/cache/flush?cache=other", "secret").Code)
+	require.Equal(t, http.StatusBadRequest, do("POST", "/cache/flush?name=example.com&type=BAD", "secret").Code)
+
+	// Targeted flush of one name and type
+	w = do("POST", "/cache/flush?name=Example.com&type=a", "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var flush cacheFlushResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&flush))
+	require.Equal(t, 1, flush.Flushed["test-cache"])
+	require.Equal(t, 2, c.Stats().Entries)
+
+	// Flush everything
+	w = do("POST", "/cache/flush", "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&flush))
+	require.Equal(t, 2, flush.Flushed["test-cache"])
+	require.Equal(t, 0, c.Stats().Entries)
+}
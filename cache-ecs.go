@@ -0,0 +1,124 @@
+package rdns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// Default max number of ECS scopes cached per question.
+const defaultECSMaxScopes = 16
+
+// Returns the address of the client network used for ECS-aware cache lookups. That's
+// the address in the ECS option of the query if there is one, otherwise the IP of
+// the client, which is what an ECS modifier after the cache would send upstream.
+func ecsClientAddr(q *dns.Msg, ci ClientInfo) net.IP {
+	if edns0 := q.IsEdns0(); edns0 != nil {
+		for _, opt := range edns0.Option {
+			if subnet, ok := opt.(*dns.EDNS0_SUBNET); ok {
+				return subnet.Address
+			}
+		}
+	}
+	return ci.SourceIP
+}
+
+// Looks up the answer for the most specific ECS scope that contains the client
+// address, falling back to an answer that's valid for all clients. Scopes of
+// answers that are no longer in the cache are removed. Must be called with the
+// cache locked.
+func (r *Cache) ecsLookup(question dns.Question, ip net.IP) (lruKey, *cacheAnswer) {
+	key := lruKey{question: question}
+	var scopes []*net.IPNet
+	bestLen := -1
+	for _, scope := range r.ecsScopes[question] {
+		k := lruKey{question: question, net: scope.String()}
+		if !r.lru.has(k) {
+			continue
+		}
+		scopes = append(scopes, scope)
+		if ip == nil || !scope.Contains(ip) {
+			continue
+		}
+		if ones, _ := scope.Mask.Size(); ones > bestLen {
+			key, bestLen = k, ones
+		}
+	}
+	r.setECSScopes(question, scopes)
+	return key, r.lru.getKey(key)
+}
+
+// Returns the cache key for an answer based on the scope in its ECS option and
+// records the scope for lookups. Answers without ECS option or with a scope of 0
+// are valid for all clients. If there are too many scopes for the question, the
+// oldest one is removed from the cache. Must be called with the cache locked.
+func (r *Cache) ecsStoreKey(question dns.Question, answer *dns.Msg) lruKey {
+	key := lruKey{question: question}
+	scope := ecsScope(answer)
+	if scope == nil {
+		return key
+	}
+	key.net = scope.String()
+	scopes := r.ecsScopes[question]
+	for _, s := range scopes {
+		if s.String() == key.net {
+			return key
+		}
+	}
+	scopes = append(scopes, scope)
+	if len(scopes) > r.ECSMaxScopes {
+		r.lru.deleteKey(lruKey{question: question, net: scopes[0].String()})
+		scopes = scopes[1:]
+	}
+	r.setECSScopes(question, scopes)
+	return key
+}
+
+// Records the scopes for a question, in the order they were added.
+func (r *Cache) setECSScopes(question dns.Question, scopes []*net.IPNet) {
+	if len(scopes) == 0 {
+		delete(r.ecsScopes, question)
+		return
+	}
+	r.ecsScopes[question] = scopes
+}
+
+// Removes the scopes of answers that are no longer in the cache. Must be called
+// with the cache locked.
+func (r *Cache) pruneECSScopes() {
+	for question, scopes := range r.ecsScopes {
+		var keep []*net.IPNet
+		for _, scope := range scopes {
+			if r.lru.has(lruKey{question: question, net: scope.String()}) {
+				keep = append(keep, scope)
+			}
+		}
+		r.setECSScopes(question, keep)
+	}
+}
+
+// Returns the network an answer is valid for, based on the address and scope
+// prefix length in its ECS option. Returns nil if the answer is valid for all
+// clients.
+func ecsScope(answer *dns.Msg) *net.IPNet {
+	edns0 := answer.IsEdns0()
+	if edns0 == nil {
+		return nil
+	}
+	for _, opt := range edns0.Option {
+		subnet, ok := opt.(*dns.EDNS0_SUBNET)
+		if !ok || subnet.SourceScope == 0 {
+			continue
+		}
+		bits := 32
+		if subnet.Family == 2 {
+			bits = 128
+		}
+		if int(subnet.SourceScope) > bits {
+			return nil
+		}
+		mask := net.CIDRMask(int(subnet.SourceScope), bits)
+		return &net.IPNet{IP: subnet.Address.Mask(mask), Mask: mask}
+	}
+	return nil
+}
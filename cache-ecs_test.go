@@ -0,0 +1,87 @@
+package rdns
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheECSAware(t *testing.T) {
+	var scope uint8 = 24
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			// Answer with an address based on the client network, like a CDN would
+			ip := ecsClientAddr(q, ci).To4()
+			rr, err := dns.NewRR(q.Question[0].Name + " 3600 IN A 10.0.0." + strconv.Itoa(int(ip[2])))
+			require.NoError(t, err)
+			a.Answer = append(a.Answer, rr)
+			a.SetEdns0(4096, false)
+			a.IsEdns0().Option = append(a.IsEdns0().Option, &dns.EDNS0_SUBNET{
+				Code:          dns.EDNS0SUBNET,
+				Family:        1,
+				SourceNetmask: 32,
+				SourceScope:   scope,
+				Address:       ip,
+			})
+			return a, nil
+		},
+	}
+	c := NewCache("test-cache-ecs", upstream, CacheOptions{ECSAware: true, ECSMaxScopes: 2})
+
+	query := func(ecs string, ci ClientInfo) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion("cdn.example.com.", dns.TypeA)
+		if ecs != "" {
+			q.SetEdns0(4096, false)
+			q.IsEdns0().Option = append(q.IsEdns0().Option, &dns.EDNS0_SUBNET{
+				Code:          dns.EDNS0SUBNET,
+				Family:        1,
+				SourceNetmask: 32,
+				Address:       net.ParseIP(ecs).To4(),
+			})
+		}
+		a, err := c.Resolve(q, ci)
+		require.NoError(t, err)
+		return a
+	}
+
+	// First query from a network goes upstream
+	a := query("192.0.2.1", ClientInfo{})
+	require.Equal(t, 1, upstream.HitCount())
+	require.Equal(t, "10.0.0.2", a.Answer[0].(*dns.A).A.String())
+
+	// Clients in the same scope are served from the cache
+	a = query("192.0.2.99", ClientInfo{})
+	require.Equal(t, 1, upstream.HitCount())
+	require.Equal(t, "10.0.0.2", a.Answer[0].(*dns.A).A.String())
+
+	// Without ECS in the query, the client IP is used
+	a = query("", ClientInfo{SourceIP: net.ParseIP("192.0.2.50")})
+	require.Equal(t, 1, upstream.HitCount())
+	require.Equal(t, "10.0.0.2", a.Answer[0].(*dns.A).A.String())
+
+	// Clients in other networks don't get the same answer
+	a = query("192.0.3.1", ClientInfo{})
+	require.Equal(t, 2, upstream.HitCount())
+	require.Equal(t, "10.0.0.3", a.Answer[0].(*dns.A).A.String())
+
+	// Only 2 scopes are kept per name, the oldest one is removed
+	query("192.0.4.1", ClientInfo{})
+	require.Equal(t, 3, upstream.HitCount())
+	query("192.0.2.1", ClientInfo{})
+	require.Equal(t, 4, upstream.HitCount())
+
+	// Answers with a scope of 0 are valid for all clients
+	c = NewCache("test-cache-ecs", upstream, CacheOptions{ECSAware: true})
+	scope = 0
+	query("192.0.2.1", ClientInfo{})
+	require.Equal(t, 5, upstream.HitCount())
+	a = query("198.51.100.1", ClientInfo{})
+	require.Equal(t, 5, upstream.HitCount())
+	require.Equal(t, "10.0.0.2", a.Answer[0].(*dns.A).A.String())
+}
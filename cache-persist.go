@@ -0,0 +1,118 @@
+package rdns
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// Cached answer as it's written to disk. The answer is stored in DNS wire format.
+type persistedCacheAnswer struct {
+	Question  dns.Question
+	Net       string
+	Timestamp time.Time
+	Expiry    time.Time
+	Answer    []byte
+}
+
+// Persist writes the contents of the cache to the file in PersistPath. The
+// file is replaced atomically so a crash while writing doesn't corrupt it.
+// Does nothing if PersistPath isn't set.
+func (r *Cache) Persist() error {
+	if r.PersistPath == "" {
+		return nil
+	}
+	var records []persistedCacheAnswer
+	r.mu.Lock()
+	r.lru.each(func(key lruKey, a *cacheAnswer) {
+		b, err := a.Pack()
+		if err != nil {
+			return
+		}
+		records = append(records, persistedCacheAnswer{
+			Question:  key.question,
+			Net:       key.net,
+			Timestamp: a.timestamp,
+			Expiry:    a.expiry,
+			Answer:    b,
+		})
+	})
+	r.mu.Unlock()
+
+	f, err := ioutil.TempFile(filepath.Dir(r.PersistPath), filepath.Base(r.PersistPath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := gob.NewEncoder(f).Encode(records); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), r.PersistPath); err != nil {
+		return err
+	}
+	Log.WithFields(logrus.Fields{"id": r.id, "file": r.PersistPath, "entries": len(records)}).Debug("cache persisted")
+	return nil
+}
+
+// Loads the cache from the file in PersistPath, if it exists. Answers that
+// expired while the cache wasn't running are discarded.
+func (r *Cache) loadPersisted() error {
+	f, err := os.Open(r.PersistPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	var records []persistedCacheAnswer
+	if err := gob.NewDecoder(f).Decode(&records); err != nil {
+		return err
+	}
+	now := time.Now()
+	var loaded int
+	r.mu.Lock()
+	for _, rec := range records {
+		if now.After(rec.Expiry.Add(r.ServeStale)) {
+			continue
+		}
+		msg := new(dns.Msg)
+		if err := msg.Unpack(rec.Answer); err != nil {
+			continue
+		}
+		key := lruKey{question: rec.Question, net: rec.Net}
+		if r.ECSAware {
+			key = r.ecsStoreKey(rec.Question, msg)
+		}
+		r.lru.addKey(key, &cacheAnswer{
+			Msg:       msg,
+			timestamp: rec.Timestamp,
+			expiry:    rec.Expiry,
+		})
+		loaded++
+	}
+	total := r.lru.size()
+	r.mu.Unlock()
+	r.metrics.entries.Set(int64(total))
+	Log.WithFields(logrus.Fields{"id": r.id, "file": r.PersistPath, "loaded": loaded, "expired": len(records) - loaded}).Info("loaded persisted cache")
+	return nil
+}
+
+// Writes the cache to disk every period so not everything is lost on a crash.
+func (r *Cache) startPersist(period time.Duration) {
+	for {
+		time.Sleep(period)
+		if err := r.Persist(); err != nil {
+			Log.WithField("id", r.id).WithError(err).Error("failed to persist cache")
+		}
+	}
+}
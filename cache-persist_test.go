@@ -0,0 +1,70 @@
+package rdns
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachePersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routedns")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache")
+
+	var ci ClientInfo
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			ttl := uint32(3600)
+			if q.Question[0].Name == "short.example.com." {
+				ttl = 1
+			}
+			a.Answer = []dns.RR{&dns.A{
+				Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			}}
+			return a, nil
+		},
+	}
+	opt := CacheOptions{PersistPath: path, PersistInterval: time.Hour}
+	c := NewCache("test-cache", upstream, opt)
+	for _, name := range []string{"long.example.com.", "short.example.com."} {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		_, err := c.Resolve(q, ci)
+		require.NoError(t, err)
+	}
+	require.NoError(t, c.Persist())
+	require.Equal(t, 2, upstream.HitCount())
+
+	// Wait for one answer to expire, then load the file into a new cache
+	time.Sleep(1100 * time.Millisecond)
+	c = NewCache("test-cache", upstream, opt)
+	require.Equal(t, 1, c.Stats().Entries)
+
+	// The remaining answer is served from the cache with a reduced TTL
+	q := new(dns.Msg)
+	q.SetQuestion("long.example.com.", dns.TypeA)
+	a, err := c.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 2, upstream.HitCount())
+	require.Equal(t, q.Id, a.Id)
+	require.Less(t, a.Answer[0].Header().Ttl, uint32(3600))
+
+	// The expired one is fetched again
+	q.SetQuestion("short.example.com.", dns.TypeA)
+	_, err = c.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 3, upstream.HitCount())
+
+	// No temporary files are left behind
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
@@ -5,6 +5,7 @@ import (
 	"expvar"
 	"math"
 	"math/rand"
+	"net"
 	"strings"
 	"sync"
 	"time"
@@ -22,6 +23,12 @@ type Cache struct {
 	mu       sync.Mutex
 	lru      *lruCache
 	metrics  *CacheMetrics
+
+	// Items that are currently being refreshed in the background
+	refreshing map[lruKey]struct{}
+
+	// ECS scopes of cached answers by question, if ECSAware is enabled
+	ecsScopes map[dns.Question][]*net.IPNet
 }
 
 type CacheMetrics struct {
@@ -31,6 +38,12 @@ type CacheMetrics struct {
 	miss *expvar.Int
 	// Current cache entry count.
 	entries *expvar.Int
+	// Count of expired answers served from the cache.
+	staleServed *expvar.Int
+	// Count of background refreshes of expired answers.
+	staleRefresh *expvar.Int
+	// Count of background refreshes of answers before they expire.
+	prefetch *expvar.Int
 }
 
 var _ Resolver = &Cache{}
@@ -46,6 +59,26 @@ type CacheOptions struct {
 	// TTL to use for negative responses that do not have an SOA record, default 60
 	NegativeTTL uint32
 
+	// Limits applied to the TTL of records in negative responses (NXDOMAIN, or
+	// NODATA with SOA) before they are stored. A value of 0 disables the limit.
+	NegativeMinTTL uint32
+	NegativeMaxTTL uint32
+
+	// Limits applied to the TTL of records in all other responses before they
+	// are stored. A value of 0 disables the limit.
+	PositiveMinTTL uint32
+	PositiveMaxTTL uint32
+
+	// Random change applied to the TTL of records before they are stored, in
+	// percent of the TTL. Spreads out the expiry of answers that were cached at
+	// the same time and avoids bursts of queries upstream. For example 10
+	// changes TTLs by up to +/-10%. The TTL limits still apply. Default 0,
+	// disabled.
+	JitterPercent float64
+
+	// Upper limit of the TTL change by JitterPercent. Defaults to 5 minutes.
+	MaxJitter time.Duration
+
 	// Allows control over the order of answer RRs in cached responses. Default is to keep
 	// the order if nil.
 	ShuffleAnswerFunc AnswerShuffleFunc
@@ -55,8 +88,55 @@ type CacheOptions struct {
 	// NXDOMAIN, a query for www.example.com will also immediately return NXDOMAIN.
 	// See RFC8020.
 	HardenBelowNXDOMAIN bool
+
+	// Period after expiry during which an expired answer is still served from the
+	// cache, with its TTL set to 30 seconds. The expired answer is refreshed in
+	// the background. Default 0, disabled. See RFC8767.
+	ServeStale time.Duration
+
+	// Fraction of the TTL after which a frequently used answer is refreshed in
+	// the background, before it expires. For example 0.9 refreshes an answer
+	// once 90% of its TTL has elapsed. Default 0, disabled.
+	PrefetchTrigger float64
+
+	// Minimum number of times an answer has to be served from the cache before
+	// it is eligible for prefetching.
+	PrefetchEligible int
+
+	// File the cache contents are written to periodically and on shutdown, and
+	// loaded from on startup so the cache isn't cold after a restart. Optional.
+	PersistPath string
+
+	// Time period the cache is written to PersistPath. Defaults to 5 minutes if set to 0.
+	PersistInterval time.Duration
+
+	// Cache answers by the EDNS0 Client Subnet (ECS) scope in the response, and
+	// only serve them to clients in that network. Prevents serving the answer of
+	// a CDN for one region to clients in another.
+	ECSAware bool
+
+	// Max number of ECS scopes cached per query name and type. If the limit is
+	// reached, the oldest answer for the name is removed. Defaults to 16.
+	ECSMaxScopes int
 }
 
+// Type of cache hit, determines if an answer needs to be refreshed.
+type cacheHit int
+
+const (
+	cacheHitFresh    cacheHit = iota
+	cacheHitStale             // Expired, but within the ServeStale period
+	cacheHitPrefetch          // Valid, but used frequently and close to expiry
+)
+
+const (
+	// TTL of expired answers served from the cache, as recommended in RFC8767.
+	staleTTL = 30
+
+	// Max number of concurrent background refreshes of stale or prefetched answers.
+	maxCacheRefresh = 64
+)
+
 // NewCache returns a new instance of a Cache resolver.
 func NewCache(id string, resolver Resolver, opt CacheOptions) *Cache {
 	c := &Cache{
@@ -65,10 +145,15 @@ func NewCache(id string, resolver Resolver, opt CacheOptions) *Cache {
 		resolver:     resolver,
 		lru:          newLRUCache(opt.Capacity),
 		metrics: &CacheMetrics{
-			hit:     getVarInt("cache", id, "hit"),
-			miss:    getVarInt("cache", id, "miss"),
-			entries: getVarInt("cache", id, "entries"),
+			hit:          getVarInt("cache", id, "hit"),
+			miss:         getVarInt("cache", id, "miss"),
+			entries:      getVarInt("cache", id, "entries"),
+			staleServed:  getVarInt("cache", id, "stale_served"),
+			staleRefresh: getVarInt("cache", id, "stale_refresh"),
+			prefetch:     getVarInt("cache", id, "prefetch"),
 		},
+		refreshing: make(map[lruKey]struct{}),
+		ecsScopes:  make(map[dns.Question][]*net.IPNet),
 	}
 	if c.GCPeriod == 0 {
 		c.GCPeriod = time.Minute
@@ -76,6 +161,21 @@ func NewCache(id string, resolver Resolver, opt CacheOptions) *Cache {
 	if c.NegativeTTL == 0 {
 		c.NegativeTTL = 60
 	}
+	if c.ECSMaxScopes == 0 {
+		c.ECSMaxScopes = defaultECSMaxScopes
+	}
+	if c.MaxJitter == 0 {
+		c.MaxJitter = 5 * time.Minute
+	}
+	if c.PersistPath != "" {
+		if c.PersistInterval == 0 {
+			c.PersistInterval = 5 * time.Minute
+		}
+		if err := c.loadPersisted(); err != nil {
+			Log.WithField("id", id).WithError(err).Error("failed to load persisted cache")
+		}
+		go c.startPersist(c.PersistInterval)
+	}
 	go c.startGC(c.GCPeriod)
 	return c
 }
@@ -96,10 +196,25 @@ func (r *Cache) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
 	log := logger(r.id, q, ci)
 
 	// Returned an answer from the cache if one exists
-	a, ok := r.answerFromCache(q)
+	a, hit, ok := r.answerFromCache(q, ci)
 	if ok {
-		log.Debug("cache-hit")
+		switch hit {
+		case cacheHitStale:
+			log.Debug("cache-hit, stale")
+			r.metrics.staleServed.Add(1)
+			if r.refresh(q, ci) {
+				r.metrics.staleRefresh.Add(1)
+			}
+		case cacheHitPrefetch:
+			log.Debug("cache-hit, prefetching")
+			if r.refresh(q, ci) {
+				r.metrics.prefetch.Add(1)
+			}
+		default:
+			log.Debug("cache-hit")
+		}
 		r.metrics.hit.Add(1)
+		ci.trace.setCacheHit()
 		return a, nil
 	}
 	r.metrics.miss.Add(1)
@@ -122,17 +237,60 @@ func (r *Cache) String() string {
 	return r.id
 }
 
+// Refreshes a cached answer in the background by querying the upstream resolver.
+// Only one refresh per query is performed at a time. Returns false if no refresh
+// was started because one is already in progress or too many are running.
+func (r *Cache) refresh(q *dns.Msg, ci ClientInfo) bool {
+	key := lruKeyFromQuery(q)
+	r.mu.Lock()
+	if _, ok := r.refreshing[key]; ok || len(r.refreshing) >= maxCacheRefresh {
+		r.mu.Unlock()
+		return false
+	}
+	r.refreshing[key] = struct{}{}
+	r.mu.Unlock()
+
+	q = q.Copy()
+	ci = ci.detached()
+	go func() {
+		defer func() {
+			r.mu.Lock()
+			delete(r.refreshing, key)
+			r.mu.Unlock()
+		}()
+		a, err := r.resolver.Resolve(q.Copy(), ci)
+		if err != nil || a == nil {
+			logger(r.id, q, ci).WithError(err).Debug("failed to refresh cached answer")
+			return
+		}
+		r.storeInCache(q, a)
+	}()
+	return true
+}
+
 // Returns an answer from the cache with it's TTL updated or false in case of a cache-miss.
-func (r *Cache) answerFromCache(q *dns.Msg) (*dns.Msg, bool) {
+// The type of hit indicates if the answer should be refreshed in the background.
+func (r *Cache) answerFromCache(q *dns.Msg, ci ClientInfo) (*dns.Msg, cacheHit, bool) {
 	var answer *dns.Msg
-	var timestamp time.Time
+	var timestamp, expiry time.Time
+	var hits int
 	r.mu.Lock()
-	if a := r.lru.get(q); a != nil {
+	key := lruKeyFromQuery(q)
+	var a *cacheAnswer
+	if r.ECSAware {
+		key, a = r.ecsLookup(q.Question[0], ecsClientAddr(q, ci))
+	} else {
+		a = r.lru.getKey(key)
+	}
+	if a != nil {
 		if r.ShuffleAnswerFunc != nil {
 			r.ShuffleAnswerFunc(a.Msg)
 		}
+		a.hits++
 		answer = a.Copy()
 		timestamp = a.timestamp
+		expiry = a.expiry
+		hits = a.hits
 	}
 	r.mu.Unlock()
 
@@ -148,7 +306,7 @@ func (r *Cache) answerFromCache(q *dns.Msg) (*dns.Msg, bool) {
 			if a := r.lru.get(newQ); a != nil {
 				if a.Rcode == dns.RcodeNameError {
 					r.mu.Unlock()
-					return nxdomain(q), true
+					return nxdomain(q), cacheHitFresh, true
 				}
 				break
 			}
@@ -158,7 +316,7 @@ func (r *Cache) answerFromCache(q *dns.Msg) (*dns.Msg, bool) {
 
 	// Return a cache-miss if there's no answer record in the map
 	if answer == nil {
-		return nil, false
+		return nil, cacheHitFresh, false
 	}
 
 	// Make a copy of the response before returning it. Some later
@@ -170,6 +328,16 @@ func (r *Cache) answerFromCache(q *dns.Msg) (*dns.Msg, bool) {
 	// subtract that from the TTL of each answer record.
 	age := uint32(time.Since(timestamp).Seconds())
 
+	// Only successful and NXDOMAIN answers are refreshed in the background
+	refreshable := answer.Rcode == dns.RcodeSuccess || answer.Rcode == dns.RcodeNameError
+
+	// Expired answers can still be served if they're within the stale period
+	stale := r.ServeStale > 0 && time.Now().After(expiry) && refreshable
+	if stale && time.Since(expiry) > r.ServeStale {
+		r.evictFromCache(key)
+		return nil, cacheHitFresh, false
+	}
+
 	// Go through all the answers, NS, and Extra and adjust the TTL (subtract the time
 	// it's spent in the cache). If the record is too old, evict it from the cache
 	// and return a cache-miss. OPT records have a TTL of 0 and are ignored.
@@ -179,15 +347,30 @@ func (r *Cache) answerFromCache(q *dns.Msg) (*dns.Msg, bool) {
 				continue
 			}
 			h := a.Header()
+			if stale {
+				h.Ttl = staleTTL
+				continue
+			}
 			if age >= h.Ttl {
-				r.evictFromCache(q)
-				return nil, false
+				r.evictFromCache(key)
+				return nil, cacheHitFresh, false
 			}
 			h.Ttl -= age
 		}
 	}
 
-	return answer, true
+	if stale {
+		return answer, cacheHitStale, true
+	}
+
+	// Frequently used answers are refreshed once they reach the prefetch trigger
+	if r.PrefetchTrigger > 0 && refreshable && hits >= r.PrefetchEligible {
+		ttl := expiry.Sub(timestamp)
+		if time.Since(timestamp) >= time.Duration(r.PrefetchTrigger*float64(ttl)) {
+			return answer, cacheHitPrefetch, true
+		}
+	}
+	return answer, cacheHitFresh, true
 }
 
 func (r *Cache) storeInCache(query, answer *dns.Msg) {
@@ -196,6 +379,14 @@ func (r *Cache) storeInCache(query, answer *dns.Msg) {
 	// Prepare an item for the cache, without expiry for now
 	item := &cacheAnswer{Msg: answer, timestamp: now}
 
+	// Apply the jitter and TTL limits, these also determine the expiry
+	jitterTTL(answer, r.JitterPercent, r.MaxJitter)
+	if isNegativeAnswer(answer) {
+		limitTTL(answer, r.NegativeMinTTL, r.NegativeMaxTTL)
+	} else {
+		limitTTL(answer, r.PositiveMinTTL, r.PositiveMaxTTL)
+	}
+
 	// Find the lowest TTL in the response, this determines the expiry for the whole answer in the cache.
 	min, ok := minTTL(answer)
 
@@ -220,16 +411,59 @@ func (r *Cache) storeInCache(query, answer *dns.Msg) {
 
 	// Store it in the cache
 	r.mu.Lock()
-	r.lru.add(query, item)
+	key := lruKeyFromQuery(query)
+	if r.ECSAware {
+		key = r.ecsStoreKey(query.Question[0], answer)
+	}
+	r.lru.addKey(key, item)
 	r.mu.Unlock()
 }
 
-func (r *Cache) evictFromCache(queries ...*dns.Msg) {
+func (r *Cache) evictFromCache(keys ...lruKey) {
+	r.mu.Lock()
+	for _, key := range keys {
+		r.lru.deleteKey(key)
+	}
+	r.mu.Unlock()
+}
+
+// CacheStats contains the current state and counters of a cache.
+type CacheStats struct {
+	Entries int   `json:"entries"`
+	Hit     int64 `json:"hit"`
+	Miss    int64 `json:"miss"`
+}
+
+// Stats returns the number of cached answers and the hit/miss counters.
+func (r *Cache) Stats() CacheStats {
 	r.mu.Lock()
-	for _, query := range queries {
-		r.lru.delete(query)
+	entries := r.lru.size()
+	r.mu.Unlock()
+	return CacheStats{
+		Entries: entries,
+		Hit:     r.metrics.hit.Value(),
+		Miss:    r.metrics.miss.Value(),
+	}
+}
+
+// Flush removes answers from the cache. If name is empty, all answers are removed,
+// otherwise only answers for that name. A qtype other than 0 limits it further to
+// one query type. Returns the number of removed answers.
+func (r *Cache) Flush(name string, qtype uint16) int {
+	if name != "" {
+		name = dns.CanonicalName(name)
 	}
+	r.mu.Lock()
+	n := r.lru.deleteQuestionFunc(func(q dns.Question) bool {
+		if name != "" && dns.CanonicalName(q.Name) != name {
+			return false
+		}
+		return qtype == 0 || q.Qtype == qtype
+	})
+	total := r.lru.size()
 	r.mu.Unlock()
+	r.metrics.entries.Set(int64(total))
+	return n
 }
 
 // Runs every period time and evicts all items from the cache that are
@@ -244,12 +478,15 @@ func (r *Cache) startGC(period time.Duration) {
 		var total, removed int
 		r.mu.Lock()
 		r.lru.deleteFunc(func(a *cacheAnswer) bool {
-			if now.After(a.expiry) {
+			if now.After(a.expiry.Add(r.ServeStale)) {
 				removed++
 				return true
 			}
 			return false
 		})
+		if r.ECSAware {
+			r.pruneECSScopes()
+		}
 		total = r.lru.size()
 		r.mu.Unlock()
 
@@ -279,6 +516,57 @@ func minTTL(answer *dns.Msg) (uint32, bool) {
 	return min, found
 }
 
+// Returns true if the response is NXDOMAIN, or NODATA with a SOA record in
+// the authority section.
+func isNegativeAnswer(answer *dns.Msg) bool {
+	if answer.Rcode == dns.RcodeNameError {
+		return true
+	}
+	if answer.Rcode != dns.RcodeSuccess || len(answer.Answer) > 0 {
+		return false
+	}
+	for _, rr := range answer.Ns {
+		if _, ok := rr.(*dns.SOA); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Changes the TTL of all resource records (except OPT) by the same random
+// fraction, of up to percent of the TTL and limited to max. Records with the
+// same TTL, like those in an RRset, still have the same TTL afterwards. The TTL
+// isn't reduced below 1, and records with a TTL of 0 aren't changed.
+func jitterTTL(answer *dns.Msg, percent float64, max time.Duration) {
+	if percent <= 0 {
+		return
+	}
+	f := (2*rand.Float64() - 1) * percent / 100
+	for _, rr := range [][]dns.RR{answer.Answer, answer.Ns, answer.Extra} {
+		for _, a := range rr {
+			if _, ok := a.(*dns.OPT); ok {
+				continue
+			}
+			h := a.Header()
+			if h.Ttl == 0 {
+				continue
+			}
+			delta := f * float64(h.Ttl)
+			if limit := max.Seconds(); math.Abs(delta) > limit {
+				delta = math.Copysign(limit, delta)
+			}
+			ttl := math.Round(float64(h.Ttl) + delta)
+			switch {
+			case ttl < 1:
+				ttl = 1
+			case ttl > math.MaxUint32:
+				ttl = math.MaxUint32
+			}
+			h.Ttl = uint32(ttl)
+		}
+	}
+}
+
 // Shuffles the order of answer A/AAAA RRs. Used to allow for some control
 // over the records in the cache.
 type AnswerShuffleFunc func(*dns.Msg)
@@ -1,7 +1,9 @@
 package rdns
 
 import (
+	"context"
 	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -173,3 +175,284 @@ func TestRoundRobinShuffle(t *testing.T) {
 	require.Equal(t, net.IP{0, 0, 0, 2}, a1.A)
 	require.Equal(t, net.IP{0, 0, 0, 1}, a2.A)
 }
+
+func TestCacheServeStale(t *testing.T) {
+	var ci ClientInfo
+	q := new(dns.Msg)
+	r := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.Answer = []dns.RR{
+				&dns.A{
+					Hdr: dns.RR_Header{
+						Name:   q.Question[0].Name,
+						Rrtype: dns.TypeA,
+						Class:  dns.ClassINET,
+						Ttl:    1,
+					},
+					A: net.IP{127, 0, 0, 1},
+				},
+			}
+			return a, nil
+		},
+	}
+
+	opt := CacheOptions{
+		GCPeriod:   time.Minute,
+		ServeStale: time.Minute,
+	}
+	c := NewCache("test-cache", r, opt)
+
+	// First query should be a cache-miss and be passed on to the upstream resolver
+	q.SetQuestion("test.com.", dns.TypeA)
+	_, err := c.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 1, r.HitCount())
+
+	time.Sleep(1100 * time.Millisecond)
+
+	// The answer has expired, it should still be served from the cache with the
+	// stale TTL and refreshed in the background
+	a, err := c.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, uint32(staleTTL), a.Answer[0].Header().Ttl)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 2, r.HitCount())
+
+	// The refreshed answer should be in the cache now
+	a, err = c.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), a.Answer[0].Header().Ttl)
+	require.Equal(t, 2, r.HitCount())
+}
+
+func TestCacheRefreshAfterQueryContext(t *testing.T) {
+	q := new(dns.Msg)
+	var refreshed int32
+	r := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			// Like context-aware upstreams, fail once the query context is done
+			if err := ci.queryContext().Err(); err != nil {
+				return nil, err
+			}
+			atomic.AddInt32(&refreshed, 1)
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.Answer = []dns.RR{
+				&dns.A{
+					Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 1},
+					A:   net.IP{127, 0, 0, 1},
+				},
+			}
+			return a, nil
+		},
+	}
+	c := NewCache("test-cache", r, CacheOptions{GCPeriod: time.Minute, ServeStale: time.Minute})
+
+	// Resolve the way listeners do, cancelling the context once answered
+	resolve := func() *dns.Msg {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		a, err := resolveContext(ctx, c, q, ClientInfo{})
+		require.NoError(t, err)
+		return a
+	}
+
+	q.SetQuestion("test.com.", dns.TypeA)
+	resolve()
+	time.Sleep(1100 * time.Millisecond)
+
+	// The stale answer is returned and refreshed in the background, after the
+	// context of the query was cancelled
+	a := resolve()
+	require.Equal(t, uint32(staleTTL), a.Answer[0].Header().Ttl)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, int32(2), atomic.LoadInt32(&refreshed))
+
+	a = resolve()
+	require.Equal(t, uint32(1), a.Answer[0].Header().Ttl)
+}
+
+func TestCacheTTLLimits(t *testing.T) {
+	var ci ClientInfo
+	q := new(dns.Msg)
+	r := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			if q.Question[0].Name == "nxdomain.test.com." {
+				a.Rcode = dns.RcodeNameError
+				a.Ns = []dns.RR{
+					&dns.SOA{
+						Hdr:    dns.RR_Header{Name: "test.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 86400},
+						Ns:     "ns.test.com.",
+						Mbox:   "admin.test.com.",
+						Minttl: 86400,
+					},
+				}
+				return a, nil
+			}
+			if q.Question[0].Name == "cname.test.com." {
+				a.Rcode = dns.RcodeNameError
+				a.Answer = []dns.RR{
+					&dns.CNAME{
+						Hdr:    dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 5},
+						Target: "missing.test.com.",
+					},
+				}
+				return a, nil
+			}
+			a.Answer = []dns.RR{
+				&dns.A{
+					Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 5},
+					A:   net.IP{127, 0, 0, 1},
+				},
+			}
+			return a, nil
+		},
+	}
+
+	opt := CacheOptions{
+		GCPeriod:       time.Minute,
+		PositiveMinTTL: 60,
+		NegativeMaxTTL: 300,
+	}
+	c := NewCache("test-cache", r, opt)
+
+	// Low TTL should be raised to the minimum for positive responses
+	q.SetQuestion("test.com.", dns.TypeA)
+	_, err := c.Resolve(q, ci)
+	require.NoError(t, err)
+	a, err := c.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 1, r.HitCount())
+	require.Equal(t, uint32(60), a.Answer[0].Header().Ttl)
+
+	// High TTL in the SOA should be reduced to the max for negative responses
+	q.SetQuestion("nxdomain.test.com.", dns.TypeA)
+	_, err = c.Resolve(q, ci)
+	require.NoError(t, err)
+	a, err = c.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 2, r.HitCount())
+	require.Equal(t, uint32(300), a.Ns[0].Header().Ttl)
+
+	// NXDOMAIN without SOA is still negative, the CNAME isn't raised to the
+	// positive minimum
+	q.SetQuestion("cname.test.com.", dns.TypeA)
+	_, err = c.Resolve(q, ci)
+	require.NoError(t, err)
+	a, err = c.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 3, r.HitCount())
+	require.Equal(t, dns.RcodeNameError, a.Rcode)
+	require.Equal(t, uint32(5), a.Answer[0].Header().Ttl)
+}
+
+func TestCacheTTLJitter(t *testing.T) {
+	rrs := func(ttl uint32) []dns.RR {
+		return []dns.RR{
+			&dns.A{Hdr: dns.RR_Header{Name: "test.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: net.IP{127, 0, 0, 1}},
+			&dns.A{Hdr: dns.RR_Header{Name: "test.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: net.IP{127, 0, 0, 2}},
+		}
+	}
+	seen := make(map[uint32]bool)
+	for i := 0; i < 100; i++ {
+		a := new(dns.Msg)
+		a.Answer = rrs(1000)
+		a.Ns = rrs(0)
+		a.Extra = rrs(10000)
+		a.SetEdns0(4096, false)
+		jitterTTL(a, 10, time.Minute)
+
+		// Records of the same RRset have the same TTL, within the limits
+		ttl := a.Answer[0].Header().Ttl
+		require.Equal(t, ttl, a.Answer[1].Header().Ttl)
+		require.True(t, ttl >= 900 && ttl <= 1100, "ttl %d out of range", ttl)
+		seen[ttl] = true
+
+		// The change is capped, and records without TTL keep it
+		extra := a.Extra[0].Header().Ttl
+		require.True(t, extra >= 9940 && extra <= 10060, "ttl %d out of range", extra)
+		require.Equal(t, uint32(0), a.Ns[0].Header().Ttl)
+	}
+	require.True(t, len(seen) > 1)
+
+	// TTLs aren't reduced to 0
+	for i := 0; i < 100; i++ {
+		a := new(dns.Msg)
+		a.Answer = rrs(1)
+		jitterTTL(a, 100, time.Minute)
+		require.True(t, a.Answer[0].Header().Ttl >= 1)
+	}
+
+	// The TTL limits of the cache apply after the jitter
+	var ci ClientInfo
+	r := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.Answer = rrs(100)
+			return a, nil
+		},
+	}
+	c := NewCache("test-cache-jitter", r, CacheOptions{JitterPercent: 50, PositiveMaxTTL: 100})
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeA)
+	_, err := c.Resolve(q, ci)
+	require.NoError(t, err)
+	a, err := c.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 1, r.HitCount())
+	require.True(t, a.Answer[0].Header().Ttl <= 100)
+}
+
+func TestCachePrefetch(t *testing.T) {
+	var ci ClientInfo
+	q := new(dns.Msg)
+	r := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.Answer = []dns.RR{
+				&dns.A{
+					Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 2},
+					A:   net.IP{127, 0, 0, 1},
+				},
+			}
+			return a, nil
+		},
+	}
+
+	opt := CacheOptions{
+		GCPeriod:         time.Minute,
+		PrefetchTrigger:  0.5,
+		PrefetchEligible: 2,
+	}
+	c := NewCache("test-cache", r, opt)
+
+	// Cache-miss, then a hit that's too early for prefetching
+	q.SetQuestion("test.com.", dns.TypeA)
+	_, err := c.Resolve(q, ci)
+	require.NoError(t, err)
+	_, err = c.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 1, r.HitCount())
+
+	time.Sleep(1100 * time.Millisecond)
+
+	// Half the TTL has elapsed and the answer was used often enough, it should
+	// be served from the cache and refreshed in the background
+	a, err := c.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), a.Answer[0].Header().Ttl)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 2, r.HitCount())
+
+	// The refreshed answer should be in the cache now
+	a, err = c.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), a.Answer[0].Header().Ttl)
+	require.Equal(t, 2, r.HitCount())
+}
@@ -0,0 +1,100 @@
+package rdns
+
+import (
+	"crypto/rand"
+	"errors"
+	"expvar"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// Case0x20 is a resolver that randomizes the case of the letters in the query
+// name before sending it upstream, and checks that the response has the same
+// case in the question section (DNS 0x20 encoding). This makes it harder for
+// off-path attackers to spoof responses over unencrypted transports. Responses
+// that don't match are rejected, or the query is retried with an alternative
+// resolver, typically one using TCP.
+type Case0x20 struct {
+	id       string
+	resolver Resolver
+	Case0x20Options
+	mismatch *expvar.Int
+}
+
+var _ Resolver = &Case0x20{}
+
+// Case0x20Options contain settings for the 0x20 encoding resolver.
+type Case0x20Options struct {
+	// Optional resolver to send the query to if the case in the response doesn't
+	// match. If nil, the response is rejected with an error.
+	MismatchResolver Resolver
+}
+
+// NewCase0x20 returns a new instance of a 0x20 encoding resolver.
+func NewCase0x20(id string, resolver Resolver, opt Case0x20Options) *Case0x20 {
+	return &Case0x20{
+		id:              id,
+		resolver:        resolver,
+		Case0x20Options: opt,
+		mismatch:        getVarInt("router", id, "case_mismatch"),
+	}
+}
+
+// Resolve a DNS query with a randomized case in the query name.
+func (r *Case0x20) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return nil, errors.New("no question in query")
+	}
+	name := q.Question[0].Name
+	encoded, ok := randomizeCase(name)
+	if !ok { // No letters in the name
+		return r.resolver.Resolve(q, ci)
+	}
+	log := logger(r.id, q, ci)
+	eq := q.Copy()
+	eq.Question[0].Name = encoded
+	log.WithField("encoded", encoded).Debug("forwarding query with randomized case")
+	a, err := r.resolver.Resolve(eq, ci)
+	if err != nil || a == nil {
+		return a, err
+	}
+	if len(a.Question) < 1 || a.Question[0].Name != encoded {
+		r.mismatch.Add(1)
+		if r.MismatchResolver != nil {
+			log.WithField("resolver", r.MismatchResolver).Debug("case mismatch in response, forwarding to mismatch-resolver")
+			return r.MismatchResolver.Resolve(q, ci)
+		}
+		log.Debug("case mismatch in response, rejecting")
+		return nil, fmt.Errorf("case mismatch in response for '%s'", encoded)
+	}
+
+	// Restore the original case in the response
+	a.Question[0].Name = name
+	for _, rr := range a.Answer {
+		if h := rr.Header(); h.Name == encoded {
+			h.Name = name
+		}
+	}
+	return a, nil
+}
+
+func (r *Case0x20) String() string {
+	return r.id
+}
+
+// Returns the name with the case of all letters set randomly. The boolean is
+// false if the name doesn't contain any letters.
+func randomizeCase(name string) (string, bool) {
+	random := make([]byte, len(name))
+	_, _ = rand.Read(random)
+	b := []byte(name)
+	var letters bool
+	for i, c := range b {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			b[i] = c&^0x20 | random[i]&0x20
+			letters = true
+		}
+	}
+	return string(b), letters
+}
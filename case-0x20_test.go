@@ -0,0 +1,69 @@
+package rdns
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCase0x20(t *testing.T) {
+	var received string
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			received = q.Question[0].Name
+			a := new(dns.Msg)
+			a.SetReply(q)
+			rr, _ := dns.NewRR(q.Question[0].Name + " IN A 1.2.3.4")
+			a.Answer = []dns.RR{rr}
+			return a, nil
+		},
+	}
+	r := NewCase0x20("test-0x20", upstream, Case0x20Options{})
+	q := new(dns.Msg)
+	q.SetQuestion("www.some-long-domain-name.com.", dns.TypeA)
+
+	// The case is randomized upstream, and restored in the response
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.True(t, strings.EqualFold(received, "www.some-long-domain-name.com."))
+	require.NotEqual(t, "www.some-long-domain-name.com.", received)
+	require.Equal(t, "www.some-long-domain-name.com.", a.Question[0].Name)
+	require.Equal(t, "www.some-long-domain-name.com.", a.Answer[0].Header().Name)
+	require.Equal(t, "www.some-long-domain-name.com.", q.Question[0].Name)
+
+	// Names without letters are not changed
+	q.SetQuestion("1.2.3.4.", dns.TypeA)
+	_, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, "1.2.3.4.", received)
+}
+
+func TestCase0x20Mismatch(t *testing.T) {
+	// Upstream that doesn't preserve the case
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.Question[0].Name = strings.ToLower(q.Question[0].Name)
+			return a, nil
+		},
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("www.some-long-domain-name.com.", dns.TypeA)
+
+	// Responses are rejected without mismatch-resolver
+	r := NewCase0x20("test-0x20-mismatch", upstream, Case0x20Options{})
+	_, err := r.Resolve(q, ClientInfo{})
+	require.Error(t, err)
+	require.Equal(t, int64(1), r.mismatch.Value())
+
+	// Otherwise the query is sent to the alternative
+	alternative := new(TestResolver)
+	r = NewCase0x20("test-0x20-mismatch", upstream, Case0x20Options{MismatchResolver: alternative})
+	_, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 1, alternative.HitCount())
+	require.Equal(t, int64(2), r.mismatch.Value())
+}
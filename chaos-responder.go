@@ -0,0 +1,86 @@
+package rdns
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// CHAOSResponder is a resolver that answers the CHAOS class TXT queries used to
+// identify a DNS server, like "version.bind." and "id.server.", with configured
+// strings. Alternatively it can refuse them to hide the identity of the server.
+// All other queries are forwarded to the upstream resolver.
+type CHAOSResponder struct {
+	id       string
+	resolver Resolver
+	opt      CHAOSResponderOptions
+}
+
+var _ Resolver = &CHAOSResponder{}
+
+// CHAOSResponderOptions contain settings for the CHAOSResponder resolver.
+type CHAOSResponderOptions struct {
+	// Returned for "version.bind." and "version.server.".
+	Version string
+
+	// Returned for "hostname.bind." and "id.server.".
+	Hostname string
+
+	// Refuse all identification queries. Queries for names that don't have a
+	// string configured are refused as well.
+	Hide bool
+}
+
+// NewCHAOSResponder returns a new instance of a CHAOS responder.
+func NewCHAOSResponder(id string, resolver Resolver, opt CHAOSResponderOptions) *CHAOSResponder {
+	return &CHAOSResponder{id: id, resolver: resolver, opt: opt}
+}
+
+// Resolve a DNS query by answering identification queries and forwarding
+// everything else.
+func (r *CHAOSResponder) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return nil, errors.New("no question in query")
+	}
+	log := logger(r.id, q, ci)
+	question := q.Question[0]
+	if question.Qclass != dns.ClassCHAOS || question.Qtype != dns.TypeTXT {
+		log.WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+		return r.resolver.Resolve(q, ci)
+	}
+
+	var value string
+	switch strings.ToLower(question.Name) {
+	case "version.bind.", "version.server.":
+		value = r.opt.Version
+	case "hostname.bind.", "id.server.":
+		value = r.opt.Hostname
+	default:
+		log.WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+		return r.resolver.Resolve(q, ci)
+	}
+	if r.opt.Hide || value == "" {
+		log.Debug("refusing identification query")
+		return refused(q), nil
+	}
+	log.Debug("responding to identification query")
+	answer := new(dns.Msg)
+	answer.SetReply(q)
+	answer.Authoritative = true
+	answer.Answer = []dns.RR{
+		&dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   question.Name,
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassCHAOS,
+			},
+			Txt: []string{value},
+		},
+	}
+	return answer, nil
+}
+
+func (r *CHAOSResponder) String() string {
+	return r.id
+}
@@ -0,0 +1,46 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCHAOSResponder(t *testing.T) {
+	upstream := new(TestResolver)
+	r := NewCHAOSResponder("test-chaos", upstream, CHAOSResponderOptions{
+		Version:  "routedns",
+		Hostname: "dns1.example.com",
+	})
+
+	query := func(name string, class uint16) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeTXT)
+		q.Question[0].Qclass = class
+		a, err := r.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+		return a
+	}
+
+	a := query("version.bind.", dns.ClassCHAOS)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.Equal(t, []string{"routedns"}, a.Answer[0].(*dns.TXT).Txt)
+	require.Equal(t, uint16(dns.ClassCHAOS), a.Answer[0].Header().Class)
+
+	a = query("ID.Server.", dns.ClassCHAOS)
+	require.Equal(t, []string{"dns1.example.com"}, a.Answer[0].(*dns.TXT).Txt)
+	require.Equal(t, 0, upstream.HitCount())
+
+	// Other names and classes are forwarded
+	query("authors.bind.", dns.ClassCHAOS)
+	query("version.bind.", dns.ClassINET)
+	require.Equal(t, 2, upstream.HitCount())
+
+	// Everything is refused when hidden
+	r = NewCHAOSResponder("test-chaos", upstream, CHAOSResponderOptions{Version: "routedns", Hide: true})
+	a = query("version.bind.", dns.ClassCHAOS)
+	require.Equal(t, dns.RcodeRefused, a.Rcode)
+	a = query("hostname.bind.", dns.ClassCHAOS)
+	require.Equal(t, dns.RcodeRefused, a.Rcode)
+}
@@ -0,0 +1,157 @@
+package rdns
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// CircuitBreakerOptions contain settings for a circuit breaker that stops sending
+// queries to an upstream after repeated failures.
+type CircuitBreakerOptions struct {
+	// Number of consecutive failures after which the circuit is opened and
+	// queries fail immediately. Disabled if 0.
+	FailureThreshold int
+
+	// Time the circuit stays open after the first trip. Doubles with every
+	// consecutive trip. Default 5 seconds.
+	Cooldown time.Duration
+
+	// Upper limit for the cooldown. Default 5 minutes.
+	MaxCooldown time.Duration
+}
+
+// States of a circuit breaker, as reported in the metrics.
+const (
+	circuitClosed   = 0
+	circuitOpen     = 1
+	circuitHalfOpen = 2
+)
+
+// circuitBreaker tracks consecutive failures of an upstream. Once the threshold
+// is reached, the circuit opens and no queries are allowed until the cooldown has
+// passed. After that, a single probe query is let through (half-open). If that
+// succeeds the circuit is closed, otherwise it opens again with a longer cooldown.
+// A nil circuitBreaker allows all queries.
+type circuitBreaker struct {
+	opt CircuitBreakerOptions
+
+	mu        sync.Mutex
+	failures  int
+	trips     int
+	openUntil time.Time
+	probing   bool
+
+	state *expvar.Int
+	trip  *expvar.Int
+}
+
+// Returns a new circuit breaker, or nil if it's disabled in the options.
+func newCircuitBreaker(base, id string, opt CircuitBreakerOptions) *circuitBreaker {
+	if opt.FailureThreshold <= 0 {
+		return nil
+	}
+	if opt.Cooldown <= 0 {
+		opt.Cooldown = 5 * time.Second
+	}
+	if opt.MaxCooldown <= 0 {
+		opt.MaxCooldown = 5 * time.Minute
+	}
+	state := getVarInt(base, id, "breaker_state")
+	state.Set(circuitClosed)
+	return &circuitBreaker{
+		opt:   opt,
+		state: state,
+		trip:  getVarInt(base, id, "breaker_trips"),
+	}
+}
+
+// Returns true if a query can be sent to the upstream.
+func (c *circuitBreaker) allow() bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.openUntil.IsZero() {
+		return true
+	}
+	if c.probing || time.Now().Before(c.openUntil) {
+		return false
+	}
+	// Cooldown has passed, let one probe through
+	c.probing = true
+	c.state.Set(circuitHalfOpen)
+	return true
+}
+
+// Record a successful query, closing the circuit.
+func (c *circuitBreaker) success() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = 0
+	c.trips = 0
+	c.probing = false
+	c.openUntil = time.Time{}
+	c.state.Set(circuitClosed)
+}
+
+// Record a failed query, opening the circuit if the threshold is reached or
+// the probe in half-open state failed.
+func (c *circuitBreaker) failure() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures++
+	if !c.probing && c.failures < c.opt.FailureThreshold {
+		return
+	}
+	cooldown := c.opt.Cooldown << uint(c.trips)
+	if cooldown <= 0 || cooldown > c.opt.MaxCooldown { // Check for overflow as well
+		cooldown = c.opt.MaxCooldown
+	}
+	c.trips++
+	c.probing = false
+	c.openUntil = time.Now().Add(cooldown)
+	c.state.Set(circuitOpen)
+	c.trip.Add(1)
+}
+
+// Record a query that ended without a result, like one cancelled by the caller.
+// It counts as neither success nor failure, but if it was the probe, another
+// one is let through.
+func (c *circuitBreaker) cancel() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.probing {
+		c.probing = false
+		c.state.Set(circuitOpen)
+	}
+}
+
+// Open the circuit for a fixed time, independent of the number of failures. Used
+// when the upstream asked us to back off, with a Retry-After header for example.
+// The duration is limited to the max cooldown.
+func (c *circuitBreaker) openFor(d time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d > c.opt.MaxCooldown {
+		d = c.opt.MaxCooldown
+	}
+	c.failures++
+	c.probing = false
+	c.openUntil = time.Now().Add(d)
+	c.state.Set(circuitOpen)
+	c.trip.Add(1)
+}
@@ -0,0 +1,77 @@
+package rdns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	c := newCircuitBreaker("client", "test-breaker", CircuitBreakerOptions{
+		FailureThreshold: 2,
+		Cooldown:         50 * time.Millisecond,
+		MaxCooldown:      80 * time.Millisecond,
+	})
+
+	// Closed until the threshold is reached
+	require.True(t, c.allow())
+	c.failure()
+	require.True(t, c.allow())
+	c.failure()
+	require.False(t, c.allow())
+	require.Equal(t, int64(circuitOpen), c.state.Value())
+
+	// After the cooldown, a single probe is allowed
+	time.Sleep(60 * time.Millisecond)
+	require.True(t, c.allow())
+	require.False(t, c.allow())
+	require.Equal(t, int64(circuitHalfOpen), c.state.Value())
+
+	// A failed probe opens the circuit again, with a longer (capped) cooldown
+	c.failure()
+	require.False(t, c.allow())
+	time.Sleep(60 * time.Millisecond)
+	require.False(t, c.allow())
+	time.Sleep(30 * time.Millisecond)
+	require.True(t, c.allow())
+
+	// A successful probe closes it
+	c.success()
+	require.True(t, c.allow())
+	require.True(t, c.allow())
+	require.Equal(t, int64(circuitClosed), c.state.Value())
+	require.Equal(t, int64(2), c.trip.Value())
+
+	// Disabled breakers allow everything
+	var disabled *circuitBreaker
+	disabled.failure()
+	disabled.cancel()
+	require.True(t, disabled.allow())
+	require.Nil(t, newCircuitBreaker("client", "test-breaker", CircuitBreakerOptions{}))
+}
+
+func TestCircuitBreakerCancelledProbe(t *testing.T) {
+	c := newCircuitBreaker("client", "test-breaker-cancel", CircuitBreakerOptions{
+		FailureThreshold: 1,
+		Cooldown:         20 * time.Millisecond,
+	})
+	c.failure()
+	time.Sleep(30 * time.Millisecond)
+
+	// A cancelled probe lets the next query probe instead of blocking all
+	require.True(t, c.allow())
+	require.False(t, c.allow())
+	c.cancel()
+	require.Equal(t, int64(circuitOpen), c.state.Value())
+	require.True(t, c.allow())
+
+	// The cancelled probe wasn't counted as another trip
+	c.success()
+	require.Equal(t, int64(1), c.trip.Value())
+
+	// Cancelled queries while closed don't change anything
+	c.cancel()
+	require.True(t, c.allow())
+	require.Equal(t, int64(circuitClosed), c.state.Value())
+}
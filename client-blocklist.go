@@ -27,6 +27,10 @@ type ClientBlocklistOptions struct {
 
 	// Refresh period for the blocklist. Disabled if 0.
 	BlocklistRefresh time.Duration
+
+	// Extended DNS Error added to blocked responses, unless they come from the
+	// BlocklistResolver. Optional.
+	EDE *ExtendedError
 }
 
 // NewClientBlocklistIP returns a new instance of a client blocklist resolver.
@@ -57,7 +61,7 @@ func (r *ClientBlocklist) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
 			return r.BlocklistResolver.Resolve(q, ci)
 		}
 		log.Debug("blocking client")
-		return refused(q), nil
+		return r.EDE.addTo(q, refused(q)), nil
 	}
 
 	r.metrics.allowed.Add(1)
@@ -75,7 +79,7 @@ func (r *ClientBlocklist) refreshLoopBlocklist(refresh time.Duration) {
 		log.Debug("reloading blocklist")
 		db, err := r.BlocklistDB.Reload()
 		if err != nil {
-			Log.WithError(err).Error("failed to load rules")
+			log.WithError(err).Warn("failed to load rules, keeping the previous ones")
 			continue
 		}
 		r.mu.Lock()
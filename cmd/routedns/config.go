@@ -30,6 +30,15 @@ type listener struct {
 	MutualTLS  bool     `toml:"mutual-tls"`
 	AllowedNet []string `toml:"allowed-net"`
 	Frontend   dohFrontend
+
+	QueryTimeout int `toml:"query-timeout"` // Time (in seconds) after which queries are answered with SERVFAIL, no limit if 0
+
+	// TCP and DoT listener options
+	IdleTimeout int `toml:"idle-timeout"` // Time (in seconds) after which idle connections are closed, default 8
+
+	// Admin listener options
+	Caches []string // Caches that can be inspected and flushed via the admin API
+	Token  string   // Bearer token required for the cache endpoints, optional
 }
 
 // DoH listener frontend options
@@ -43,21 +52,68 @@ type resolver struct {
 	Transport     string
 	DoH           doh
 	CA            string
-	ClientKey     string `toml:"client-key"`
-	ClientCrt     string `toml:"client-crt"`
-	BootstrapAddr string `toml:"bootstrap-address"`
-	LocalAddr     string `toml:"local-address"`
+	ClientKey     string   `toml:"client-key"`
+	ClientCrt     string   `toml:"client-crt"`
+	BootstrapAddr string   `toml:"bootstrap-address"`
+	LocalAddr     string   `toml:"local-address"`
+	PinnedSPKI    []string `toml:"pinned-spki"`    // Base64 encoded SHA-256 hashes of accepted server public keys, DoT only
+	PoolSize      int      `toml:"pool-size"`      // Max number of connections for TCP, UDP and DoT resolvers, default 1
+	IdleTimeout   int      `toml:"idle-timeout"`   // Time (in seconds) after which idle TCP, UDP and DoT connections are closed, default 10
+	EnableCookies bool     `toml:"enable-cookies"` // Send EDNS0 cookies with queries, TCP and UDP only
+	DSCP          int      // DSCP value (0-63) to mark outgoing packets with, not supported for DTLS
 }
 
 // DoH-specific resolver options
 type doh struct {
-	Method string
+	Method          string
+	AutoUpgrade     bool              `toml:"auto-upgrade"` // Switch to QUIC if the server advertises HTTP/3 via Alt-Svc
+	ECS             string            // EDNS0 Client Subnet handling, "passthrough" (default), "off", or "client-ip"
+	PinnedSPKI      []string          `toml:"pinned-spki"`       // Base64 encoded SHA-256 hashes of accepted server public keys
+	MaxResponseSize int               `toml:"max-response-size"` // Max size (in bytes) of a response, default 65535
+	Headers         map[string]string // Additional HTTP headers to send with every query
+	KeepAlive       int               `toml:"keep-alive"`  // Interval (in seconds) to send QUIC keepalive packets, default 0 (disabled)
+	Enable0RTT      bool              `toml:"enable-0rtt"` // Send GET queries as 0-RTT early data over QUIC
+	CircuitBreaker  circuitBreaker    `toml:"circuit-breaker"`
+	Padding         padding           // Query padding, enabled with a block size of 128 by default
+	ODoH            *odoh             // Oblivious DoH, disabled if not set
+	Proxy           string            // Proxy URL, "http://", "https://" or "socks5://", taken from the environment if not set
+	HappyEyeballs   bool              `toml:"happy-eyeballs"` // Race IPv4 and IPv6 connections to the server (RFC8305), QUIC only
+	Format          string            // Query format, "wire" (default) or "json"
+	Compression     bool              // Ask the server for gzip compressed responses
+	ForceHTTP1      bool              `toml:"force-http1"` // Use HTTP/1.1 instead of HTTP/2, only with the "tcp" transport
+
+	// Connection pool options, only used with the "tcp" transport
+	MaxIdleConns          int `toml:"max-idle-conns"`          // Max number of idle connections across all hosts, default 0 (unlimited)
+	MaxIdleConnsPerHost   int `toml:"max-idle-conns-per-host"` // Max number of idle connections per host, default 2
+	IdleConnTimeout       int `toml:"idle-conn-timeout"`       // Time (in seconds) to keep idle connections open, default 30
+	ResponseHeaderTimeout int `toml:"response-header-timeout"` // Time (in seconds) to wait for response headers, default 10
+}
+
+// Circuit breaker options for DoH resolvers
+type circuitBreaker struct {
+	FailureThreshold int `toml:"failure-threshold"` // Consecutive failures after which the circuit opens, default 0 (disabled)
+	Cooldown         int // Time (in seconds) the circuit stays open after the first failure, default 5
+	MaxCooldown      int `toml:"max-cooldown"` // Max time (in seconds) the circuit stays open after repeated failures, default 300
+}
+
+// Oblivious DoH options
+type odoh struct {
+	Relay    string // URL of the relay that forwards queries to the target
+	Config   string // Base64 encoded ObliviousDoHConfigs of the target, fetched from the target if not set
+	Fallback bool   // Send queries as regular DoH to the target if ODoH isn't available
+}
+
+// Query padding options for DoH resolvers
+type padding struct {
+	Disabled  bool // Don't pad queries
+	BlockSize int  `toml:"block-size"` // Pad queries to a multiple of this size, default 128
 }
 
 type group struct {
 	Resolvers  []string
 	Type       string
 	Replace    []rdns.ReplaceOperation // only used by "replace" type
+	ReplaceIP  []rdns.ReplaceIPRule    `toml:"replace-ip"`  // only used by "replace-ip" type
 	GCPeriod   int                     `toml:"gc-period"`   // Time-period (seconds) used to expire cached items in the "cache" type
 	ECSOp      string                  `toml:"ecs-op"`      // ECS modifier operation, "add", "delete", "privacy"
 	ECSAddress net.IP                  `toml:"ecs-address"` // ECS address. If empty for "add", uses the client IP. Ignored for "privacy" and "delete"
@@ -69,11 +125,28 @@ type group struct {
 	EDNS0Code  uint16                  `toml:"edns0-code"`  // EDNS0 modifier option code
 	EDNS0Data  []byte                  `toml:"edns0-data"`  // EDNS0 modifier option data
 
+	// EDNS buffer size options
+	MaxUDPSize uint16 `toml:"max-udp-size"` // Largest UDP payload size advertised in queries, default 1232
+	AddOPT     bool   `toml:"add-opt"`      // Add an OPT record to queries that don't have one
+
 	// Cache options
-	CacheSize                int    `toml:"cache-size"`                  // Max number of items to keep in the cache. Default 0 == unlimited
-	CacheNegativeTTL         uint32 `toml:"cache-negative-ttl"`          // TTL to apply to negative responses, default 60.
-	CacheAnswerShuffle       string `toml:"cache-answer-shuffle"`        // Algorithm to use for modifying the response order of cached items
-	CacheHardenBelowNXDOMAIN bool   `toml:"cache-harden-below-nxdomain"` // Return NXDOMAIN if an NXDOMAIN is cached for a parent domain
+	CacheSize                int     `toml:"cache-size"`                  // Max number of items to keep in the cache. Default 0 == unlimited
+	CacheNegativeTTL         uint32  `toml:"cache-negative-ttl"`          // TTL to apply to negative responses, default 60.
+	CacheAnswerShuffle       string  `toml:"cache-answer-shuffle"`        // Algorithm to use for modifying the response order of cached items
+	CacheHardenBelowNXDOMAIN bool    `toml:"cache-harden-below-nxdomain"` // Return NXDOMAIN if an NXDOMAIN is cached for a parent domain
+	CacheServeStale          int     `toml:"cache-serve-stale"`           // Time (in seconds) expired answers are served while being refreshed, default 0 (disabled)
+	CacheNegativeMinTTL      uint32  `toml:"cache-negative-min-ttl"`      // Minimum TTL of cached negative responses, default 0 (no limit)
+	CacheNegativeMaxTTL      uint32  `toml:"cache-negative-max-ttl"`      // Maximum TTL of cached negative responses, default 0 (no limit)
+	CachePositiveMinTTL      uint32  `toml:"cache-positive-min-ttl"`      // Minimum TTL of cached positive responses, default 0 (no limit)
+	CachePositiveMaxTTL      uint32  `toml:"cache-positive-max-ttl"`      // Maximum TTL of cached positive responses, default 0 (no limit)
+	CacheTTLJitter           float64 `toml:"cache-ttl-jitter"`            // Random change of TTLs in percent when stored, default 0 (disabled)
+	CacheTTLMaxJitter        int     `toml:"cache-ttl-max-jitter"`        // Max change of TTLs (in seconds) by the jitter, default 300
+	CachePrefetchTrigger     float64 `toml:"cache-prefetch-trigger"`      // Fraction of the TTL after which frequently used answers are refreshed, default 0 (disabled)
+	CachePrefetchEligible    int     `toml:"cache-prefetch-eligible"`     // Min number of cache hits before an answer is prefetched
+	CachePersistPath         string  `toml:"cache-persist-path"`          // File to save the cache to, loaded on startup
+	CachePersistInterval     int     `toml:"cache-persist-interval"`      // Time (in seconds) between saving the cache to disk, default 300
+	CacheECSAware            bool    `toml:"cache-ecs-aware"`             // Cache answers by the ECS scope in the response
+	CacheECSMaxScopes        int     `toml:"cache-ecs-max-scopes"`        // Max number of ECS scopes cached per name and type, default 16
 
 	// Blocklist options
 	Blocklist []string // Blocklist rules, only used by "blocklist" type
@@ -94,12 +167,24 @@ type group struct {
 	AllowlistRefresh  int      `toml:"allowlist-refresh"`
 	LocationDB        string   `toml:"location-db"` // GeoIP database file for response blocklist. Default "/usr/share/GeoIP/GeoLite2-City.mmdb"
 
+	// Extended DNS Error options for blocklists
+	BlocklistEDE     bool   `toml:"blocklist-ede"`      // Add an Extended DNS Error to blocked responses
+	BlocklistEDECode uint16 `toml:"blocklist-ede-code"` // Info code of the extended error, default 17 (Filtered)
+	BlocklistEDEText string `toml:"blocklist-ede-text"` // Extra text of the extended error, optional
+
+	// Only log and count queries that match a blocklist rather than block them
+	BlocklistDryRun bool `toml:"blocklist-dry-run"`
+
 	// Static responder options
 	Answer []string
 	NS     []string
 	Extra  []string
 	RCode  int
 
+	// Local zone options
+	Records  []string // Records in zone-file format
+	ZoneFile string   `toml:"zone-file"` // Zone file in RFC1035 format, reloaded on SIGHUP
+
 	// Rate-limiting options
 	Requests      uint   // Number of requests allowed
 	Window        uint   // Time period in seconds for the requests
@@ -107,8 +192,216 @@ type group struct {
 	Prefix6       uint8  // Prefix bits to identify IPv6 client
 	LimitResolver string `toml:"limit-resolver"` // Resolver to use when rate-limit exceeded
 
+	// Response rate limiting options, also uses Window, Prefix4 and Prefix6
+	ResponsesPerSecond uint `toml:"responses-per-second"` // Identical responses per second allowed for a client network
+	SlipRatio          int  `toml:"slip-ratio"`           // Every Nth limited response is truncated rather than dropped, default 0 (drop all)
+
+	// QPS-limiter options
+	PerClientQPS   float64 `toml:"per-client-qps"`   // Queries per second allowed per client IP, default 0 (no limit)
+	PerClientBurst int     `toml:"per-client-burst"` // Max burst of queries per client, defaults to the per-client QPS
+	GlobalQPS      float64 `toml:"global-qps"`       // Queries per second allowed for all clients, default 0 (no limit)
+	GlobalBurst    int     `toml:"global-burst"`     // Max burst of queries for all clients, defaults to the global QPS
+	LimitAction    string  `toml:"limit-action"`     // Action for queries over the limit, "refuse" (default) or "drop"
+
+	// Tunnel-guard options, also uses Window
+	TunnelMaxNameLength    int     `toml:"tunnel-max-name-length"`   // Max length of query names, default 100
+	TunnelEntropyThreshold float64 `toml:"tunnel-entropy-threshold"` // Max entropy of labels in bits per character, default 4.0
+	TunnelTXTBudget        uint    `toml:"tunnel-txt-budget"`        // TXT and NULL queries allowed per client and window, default 0 (no limit)
+	TunnelDomainBudget     uint    `toml:"tunnel-domain-budget"`     // Queries allowed per client, registrable domain and window, default 0 (no limit)
+	TunnelAction           string  `toml:"tunnel-action"`            // Action for flagged queries, "refuse" (default), "rate-limit", or "log"
+	TunnelRateLimit        uint    `toml:"tunnel-rate-limit"`        // Flagged queries allowed per client and window with the "rate-limit" action, default 10
+
+	// QType-filter options
+	QTypes      []string `toml:"qtypes"`       // Query types to block, like "ANY"
+	QTypeAction string   `toml:"qtype-action"` // Action for blocked queries, "refuse" (default), "empty", or "drop"
+
+	// Rebind-protect options
+	RebindCIDRs  []string `toml:"rebind-cidrs"`  // Networks not allowed in responses, defaults to private and loopback networks
+	RebindAction string   `toml:"rebind-action"` // Action for blocked responses, "strip" (default), "nxdomain", or "refuse"
+	RebindAllow  []string `toml:"rebind-allow"`  // Names allowed to resolve to addresses in the networks, like ".lan"
+
+	// NX-replace options
+	HijackIPs    []string `toml:"hijack-ips"`    // Addresses returned by resolvers that hijack NXDOMAIN responses
+	HijackAction string   `toml:"hijack-action"` // Action for hijacked responses, "nxdomain" (default) or "next"
+
+	// Case-0x20 options
+	MismatchResolver string `toml:"mismatch-resolver"` // Resolver to use if the case in the response doesn't match, like a TCP resolver
+
+	// Inspector options
+	InspectorSize int `toml:"inspector-size"` // Number of recent queries to record, default 100
+
+	// Response Minimize options
+	KeepExtraTypes []string `toml:"keep-extra-types"` // Query types for which Extra records are not removed, like "MX"
+
 	// Response Collapse options
 	NullRCode int `toml:"null-rcode"` // Response code if after collapsing, no answers are left
+
+	// Loop-guard options
+	MaxCNAMEChain int `toml:"max-cname-chain"` // Max number of CNAME records in a response chain, default 16
+
+	// Address family filter options
+	AddressFamily string `toml:"address-family"` // Address records to keep, "v4only", "v6only", "prefer-v4", or "prefer-v6"
+
+	// Query log options
+	QueryLogFile       string   `toml:"query-log-file"`        // File to append the query log to, default STDOUT
+	QueryLogSampleRate float64  `toml:"query-log-sample-rate"` // Fraction of queries to log, between 0 and 1, default 1
+	QueryLogFields     []string `toml:"query-log-fields"`      // Fields to include in the log, default all
+	QueryLogBufferSize int      `toml:"query-log-buffer-size"` // Max number of entries waiting to be written, default 1000
+
+	// Slow-log options
+	SlowThreshold int `toml:"slow-threshold"` // Time (in milliseconds) after which a query is logged as slow, default 1000
+
+	// DNSSEC validator options
+	TrustAnchors []string `toml:"trust-anchors"` // DS records of the trust anchors, default is the root KSK
+	BogusAction  string   `toml:"bogus-action"`  // What to do with responses that fail validation, "servfail" (default) or "pass"
+
+	// Query name minimizer options
+	WalkLabels bool `toml:"walk-labels"` // Probe every label below the registrable domain, not just the domain itself
+
+	// CHAOS responder options
+	ChaosVersion  string `toml:"chaos-version"`  // Response to "version.bind" and "version.server" queries
+	ChaosHostname string `toml:"chaos-hostname"` // Response to "hostname.bind" and "id.server" queries
+	ChaosHide     bool   `toml:"chaos-hide"`     // Refuse all identification queries
+
+	// Response limiter options
+	MaxAnswers int  `toml:"max-answers"` // Max number of answer records in a response, default 0 (no limit)
+	MaxSize    int  `toml:"max-size"`    // Max size of a response in bytes, default 0 (no limit)
+	SetTC      bool `toml:"set-tc"`      // Set the TC bit in responses that were truncated
+
+	// Sticky group options, also uses Prefix4 and Prefix6
+	StickyMode string `toml:"sticky-mode"` // How clients are reassigned if resolvers fail, "consistent" (default) or "rebalance"
+
+	// Replace-name options
+	ReplaceName []rdns.ReplaceNameRule `toml:"replace-name"` // Regexp rules applied to names in responses
+
+	// Truncate-retry options
+	TCPResolver string `toml:"tcp-resolver"` // Resolver for truncated responses, derived from a "udp" resolver if not set
+	KeepTC      bool   `toml:"keep-tc"`      // Return truncated responses instead of retrying
+
+	// Consensus group options
+	Quorum         int    // Number of resolvers that have to agree on the answer, default is a majority
+	DisagreeAction string `toml:"disagree-action"` // Action if no quorum is reached, "servfail" (default) or "majority"
+
+	// Override options
+	Override         map[string]map[string][]string // Record values by name and type, like {"router.local" = {A = ["192.168.1.1"]}}
+	OverrideNXDomain []string                       `toml:"override-nxdomain"` // Names to answer with NXDOMAIN
+	OverrideTTL      uint32                         `toml:"override-ttl"`      // TTL of override records, default 3600
+
+	// Safe-search options
+	SafeSearchMappings   map[string]string `toml:"safe-search-mappings"`    // Additional hostnames and their safe search host, an empty host removes a built-in one
+	SafeSearchNoDefaults bool              `toml:"safe-search-no-defaults"` // Only use the configured mappings, not the built-in ones
+	SafeSearchTTL        uint32            `toml:"safe-search-ttl"`         // TTL of the CNAME records, default 300
+
+	// Concurrency-limiter options
+	MaxInFlight int `toml:"max-in-flight"` // Max number of queries in flight to the upstream resolver
+	MaxWait     int `toml:"max-wait"`      // Time (in milliseconds) queries wait for a free slot, default 0 (reject immediately)
+
+	// Single-flight options
+	SingleFlightECS bool `toml:"single-flight-ecs"` // Only combine queries with the same ECS subnet
+
+	// Geo-router options, also uses LocationDB
+	GeoField   string            `toml:"geo-field"`   // Location to route by, "country" (default) or "continent"
+	GeoMapping map[string]string `toml:"geo-mapping"` // Resolvers by country or continent code
+
+	// Geo-steer options, also uses LocationDB and GeoField
+	GeoSteerMapping map[string][]string `toml:"geo-steer-mapping"` // Preferred networks by country or continent code
+	GeoSteerMode    string              `toml:"geo-steer-mode"`    // "reorder" (default) or "filter"
+
+	// Schedule options
+	Schedule         []scheduleRule // Names to block during time windows
+	ScheduleTimezone string         `toml:"schedule-timezone"` // Timezone of the time windows, like "Europe/Berlin", default is the local timezone
+
+	// Replay options
+	ReplayPath       string `toml:"replay-path"`        // File to record responses to, or replay them from
+	ReplayMode       string `toml:"replay-mode"`        // "replay" (default), "record" or "passthrough"
+	ReplayMissAction string `toml:"replay-miss-action"` // "error" (default) or "forward" for queries without recorded response
+
+	// Reverse-PTR options
+	ReversePTR    map[string][]string `toml:"reverse-ptr"`     // Addresses by name, answered as PTR records for the reverse names
+	ReversePTRTTL uint32              `toml:"reverse-ptr-ttl"` // TTL of PTR records, default 3600
+
+	// Compression options
+	Compress bool // Compress names in responses, or send them fully expanded if false
+
+	// Fastest-TCP probe options
+	ProbeNetwork string `toml:"probe-network"` // Network to use for probes, "tcp" (default), "udp", or "icmp"
+	Port         int    // Port number to use for TCP and UDP probes, default 443
+	ProbeTimeout int    `toml:"probe-timeout"`    // Time (in milliseconds) to wait for probes to complete, default 2000
+	ProbeCount   int    `toml:"probe-count"`      // Max number of IPs in a response to probe, default 0 (all)
+	ProbeMode    string `toml:"probe-mode"`       // What to do with probe results, "first" (default) or "reorder"
+	ProbeTTL     int    `toml:"probe-ttl"`        // Time (in seconds) to keep probe results for a set of IPs, default 0 (disabled)
+	OnProbeFail  string `toml:"on-probe-failure"` // What to do if all probes fail, "original" (default), "first-answer", or "shuffle"
+
+	// SRV-steer options, also uses ProbeNetwork and ProbeTimeout
+	SRVSteerMode string `toml:"srv-steer-mode"` // How to adjust SRV records, "priority" (default), "weight", or "filter"
+
+	// Retry options
+	RetryAttempts int     `toml:"retry-attempts"` // Max number of attempts including the first query, default 3
+	RetryDelay    int     `toml:"retry-delay"`    // Time (in milliseconds) to wait before the first retry, default 100
+	RetryBackoff  float64 `toml:"retry-backoff"`  // Factor applied to the delay after every retry, default 1.0
+	RetryRcodes   []int   `toml:"retry-rcodes"`   // Response codes that trigger a retry, default [2] (SERVFAIL)
+	RetryTimeout  int     `toml:"retry-timeout"`  // Overall time limit (in milliseconds) for all attempts, default 0 (none)
+
+	// Split-horizon options
+	Horizons []horizon
+
+	// Domain-router options
+	DomainRoutes []domainRoute `toml:"domain-routes"`
+
+	// Fail-back options
+	ResetAfter          int    `toml:"reset-after"`           // Time (in seconds) without failures, or with successful health-checks, before switching back to the first resolver, default 60
+	HealthCheckName     string `toml:"health-check-name"`     // Query name to check if the first resolver is healthy again, default "" (disabled)
+	HealthCheckType     string `toml:"health-check-type"`     // Query type of health-check queries, default "A"
+	HealthCheckInterval int    `toml:"health-check-interval"` // Time (in seconds) between health-check queries, default 5
+
+	// Fail-memo options
+	FailMemoThreshold int  `toml:"fail-memo-threshold"` // Number of consecutive failures of a resolver for a name before it's skipped for the name, default 2
+	FailMemoCooldown  int  `toml:"fail-memo-cooldown"`  // Time (in seconds) a resolver is skipped for a name, default 300
+	FailMemoNXDomain  bool `toml:"fail-memo-nxdomain"`  // Treat NXDOMAIN responses as failures
+
+	// Weighted group options
+	Weights  []int // Weight of each resolver, in the same order as "resolvers"
+	FailFast bool  `toml:"fail-fast"` // Return errors rather than retrying with the other resolvers
+
+	// Weight decay options of the weighted group
+	DecayFactor    float64 `toml:"decay-factor"`    // Factor applied to the weight of a resolver after every failure, between 0 and 1, default 0 (disabled)
+	RecoveryFactor float64 `toml:"recovery-factor"` // Fraction of the lost weight restored after every success, default 0.1
+	MinWeight      float64 `toml:"min-weight"`      // Lowest weight a resolver can decay to, default 0.1
+
+	// Adaptive group options, also uses Window
+	Epsilon        float64 // Fraction of queries sent to a random resolver to measure it, default 0.05
+	LatencyWeight  float64 `toml:"latency-weight"`  // Weight of the latency in the score, default 1.0
+	ErrorWeight    float64 `toml:"error-weight"`    // Weight of the error rate in the score, default 1.0
+	ServfailWeight float64 `toml:"servfail-weight"` // Weight of the SERVFAIL rate in the score, default 1.0
+
+	// DNS64 options
+	DNS64Prefix string `toml:"dns64-prefix"` // IPv6 prefix for synthesized AAAA records, default "64:ff9b::/96"
+
+	// HTTPS-hint options
+	HTTPSHintTypes      []string `toml:"https-hint-types"`       // Query types to add HTTPS records to, default ["A", "AAAA"]
+	HTTPSHintMaxLookups int      `toml:"https-hint-max-lookups"` // Max number of HTTPS lookups in flight, default 32
+	HTTPSHintTimeout    int      `toml:"https-hint-timeout"`     // Time (in milliseconds) to wait for the HTTPS lookup after the response, default 200
+}
+
+// Client networks and the resolver to use for them in a split-horizon group
+type horizon struct {
+	Networks []string
+	Resolver string
+}
+
+// Domain suffix and the resolver to use for it in a domain-router group
+type domainRoute struct {
+	Suffix   string
+	Resolver string
+}
+
+// Names blocked during a time window in a schedule group
+type scheduleRule struct {
+	Names     []string
+	Days      []string // Days the time window starts on, like "mon", default is every day
+	StartTime string   `toml:"start-time"` // Start of the time window as "hh:mm", default "00:00"
+	EndTime   string   `toml:"end-time"`   // End of the time window as "hh:mm", the next day if before the start
+	Action    string   // "nxdomain" (default), "refuse" or "drop"
 }
 
 // Block/Allowlist items for blocklist-v2
@@ -116,6 +409,11 @@ type list struct {
 	Format   string
 	Source   string
 	CacheDir string `toml:"cache-dir"` // Where to store copies of remote blocklists for faster startup
+
+	// Storage of the rules, "map" (default) or "bloom" for a bloom filter. Only lists in "domain" format can use "bloom".
+	Backend           string
+	FalsePositiveRate float64 `toml:"false-positive-rate"` // False-positive rate of the bloom filter, default 0.001
+	Verify            bool    // Verify bloom filter matches against an exact copy of the rules on disk
 }
 
 type router struct {
@@ -128,7 +426,8 @@ type route struct {
 	Class    string
 	Name     string
 	Source   string
-	Invert   bool // Invert the result of the match
+	Identity string // Regexp matching the identity in the client's TLS certificate
+	Invert   bool   // Invert the result of the match
 	Resolver string
 }
 
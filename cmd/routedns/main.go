@@ -1,14 +1,20 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	rdns "github.com/folbricht/routedns"
 	"github.com/heimdalr/dag"
+	"github.com/miekg/dns"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -104,7 +110,31 @@ func start(opt options, args []string) error {
 		if err != nil {
 			return err
 		}
-		edges[id] = append(v.Resolvers, v.AllowListResolver, v.BlockListResolver, v.LimitResolver)
+		edges[id] = append(v.Resolvers, v.AllowListResolver, v.BlockListResolver, v.LimitResolver, v.MismatchResolver, v.TCPResolver)
+
+		// Multiple horizons can reference the same resolver, dedup them
+		dep := make(map[string]struct{})
+		for _, r := range edges[id] {
+			dep[r] = struct{}{}
+		}
+		for _, h := range v.Horizons {
+			if _, ok := dep[h.Resolver]; !ok {
+				dep[h.Resolver] = struct{}{}
+				edges[id] = append(edges[id], h.Resolver)
+			}
+		}
+		for _, r := range v.DomainRoutes {
+			if _, ok := dep[r.Resolver]; !ok {
+				dep[r.Resolver] = struct{}{}
+				edges[id] = append(edges[id], r.Resolver)
+			}
+		}
+		for _, r := range v.GeoMapping {
+			if _, ok := dep[r]; !ok {
+				dep[r] = struct{}{}
+				edges[id] = append(edges[id], r)
+			}
+		}
 	}
 	for id, v := range config.Routers {
 		node := &Node{id, v}
@@ -164,8 +194,8 @@ func start(opt options, args []string) error {
 	var listeners []rdns.Listener
 	for id, l := range config.Listeners {
 		resolver, ok := resolvers[l.Resolver]
-		// All Listeners should route queries (except the admin service).
-		if !ok && l.Protocol != "admin" {
+		// All Listeners should route queries (except the admin and metrics services).
+		if !ok && l.Protocol != "admin" && l.Protocol != "prometheus" {
 			return fmt.Errorf("listener '%s' references non-existant resolver, group or router '%s'", id, l.Resolver)
 		}
 
@@ -174,7 +204,11 @@ func start(opt options, args []string) error {
 			return err
 		}
 
-		opt := rdns.ListenOptions{AllowedNet: allowedNet}
+		opt := rdns.ListenOptions{
+			AllowedNet:   allowedNet,
+			IdleTimeout:  time.Duration(l.IdleTimeout) * time.Second,
+			QueryTimeout: time.Duration(l.QueryTimeout) * time.Second,
+		}
 
 		switch l.Protocol {
 		case "tcp":
@@ -186,16 +220,39 @@ func start(opt options, args []string) error {
 			if err != nil {
 				return err
 			}
+			var caches []*rdns.Cache
+			for _, cacheID := range l.Caches {
+				c, ok := resolvers[cacheID].(*rdns.Cache)
+				if !ok {
+					return fmt.Errorf("listener '%s' references '%s' which is not a cache", id, cacheID)
+				}
+				caches = append(caches, c)
+			}
 			opt := rdns.AdminListenerOptions{
 				TLSConfig:     tlsConfig,
 				ListenOptions: opt,
 				Transport:     l.Transport,
+				Caches:        caches,
+				Token:         l.Token,
 			}
 			ln, err := rdns.NewAdminListener(id, l.Address, opt)
 			if err != nil {
 				return err
 			}
 			listeners = append(listeners, ln)
+		case "prometheus":
+			var tlsConfig *tls.Config
+			if l.ServerCrt != "" || l.ServerKey != "" {
+				tlsConfig, err = rdns.TLSServerConfig(l.CA, l.ServerCrt, l.ServerKey, l.MutualTLS)
+				if err != nil {
+					return err
+				}
+			}
+			opt := rdns.PrometheusListenerOptions{
+				TLSConfig:     tlsConfig,
+				ListenOptions: opt,
+			}
+			listeners = append(listeners, rdns.NewPrometheusListener(id, l.Address, opt))
 		case "dot":
 			tlsConfig, err := rdns.TLSServerConfig(l.CA, l.ServerCrt, l.ServerKey, l.MutualTLS)
 			if err != nil {
@@ -256,7 +313,64 @@ func start(opt options, args []string) error {
 		}(l)
 	}
 
-	select {}
+	// Reload blocklists on SIGHUP
+	go reloadOnSignal(resolvers)
+
+	// Save the state of persistent elements, like caches, before exiting
+	persistOnShutdown(resolvers)
+
+	// Release upstream connections, like the QUIC sessions of DoH resolvers
+	closeResolvers(resolvers)
+	return nil
+}
+
+// Elements that can reload their rules on demand, such as blocklists.
+type reloader interface {
+	Reload()
+}
+
+// Reload all elements that support it whenever a SIGHUP is received.
+func reloadOnSignal(resolvers map[string]rdns.Resolver) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		for id, r := range resolvers {
+			if rl, ok := r.(reloader); ok {
+				rdns.Log.WithField("id", id).Info("reloading")
+				rl.Reload()
+			}
+		}
+	}
+}
+
+// Elements that save their state to disk before shutdown, such as caches.
+type persister interface {
+	Persist() error
+}
+
+// Block until SIGINT or SIGTERM is received, then persist all elements that support it.
+func persistOnShutdown(resolvers map[string]rdns.Resolver) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	for id, r := range resolvers {
+		if p, ok := r.(persister); ok {
+			if err := p.Persist(); err != nil {
+				rdns.Log.WithField("id", id).WithError(err).Error("failed to persist state")
+			}
+		}
+	}
+}
+
+// Close all elements that hold connections or other resources, such as DoH resolvers.
+func closeResolvers(resolvers map[string]rdns.Resolver) {
+	for id, r := range resolvers {
+		if c, ok := r.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				rdns.Log.WithField("id", id).WithError(err).Error("failed to close")
+			}
+		}
+	}
 }
 
 // Instantiate a group object based on configuration and add to the map of resolvers by ID.
@@ -276,11 +390,83 @@ func instantiateGroup(id string, g group, resolvers map[string]rdns.Resolver) er
 	case "fail-rotate":
 		resolvers[id] = rdns.NewFailRotate(id, gr...)
 	case "fail-back":
-		resolvers[id] = rdns.NewFailBack(id, rdns.FailBackOptions{ResetAfter: time.Minute}, gr...)
+		opt := rdns.FailBackOptions{
+			ResetAfter:          time.Duration(g.ResetAfter) * time.Second,
+			HealthCheckInterval: time.Duration(g.HealthCheckInterval) * time.Second,
+		}
+		if g.HealthCheckName != "" {
+			qtype, ok := dns.StringToType[strings.ToUpper(g.HealthCheckType)]
+			if !ok && g.HealthCheckType != "" {
+				return fmt.Errorf("group '%s' has unknown health-check type '%s'", id, g.HealthCheckType)
+			}
+			if !ok {
+				qtype = dns.TypeA
+			}
+			opt.HealthCheckQuery = new(dns.Msg)
+			opt.HealthCheckQuery.SetQuestion(dns.Fqdn(g.HealthCheckName), qtype)
+		}
+		resolvers[id] = rdns.NewFailBack(id, opt, gr...)
+	case "fail-memo":
+		opt := rdns.FailMemoOptions{
+			FailureThreshold:  g.FailMemoThreshold,
+			Cooldown:          time.Duration(g.FailMemoCooldown) * time.Second,
+			NXDomainIsFailure: g.FailMemoNXDomain,
+		}
+		resolvers[id] = rdns.NewFailMemo(id, opt, gr...)
 	case "fastest":
 		resolvers[id] = rdns.NewFastest(id, gr...)
 	case "random":
 		resolvers[id] = rdns.NewRandom(id, rdns.RandomOptions{ResetAfter: time.Minute}, gr...)
+	case "sticky":
+		opt := rdns.StickyGroupOptions{
+			Prefix4:    g.Prefix4,
+			Prefix6:    g.Prefix6,
+			Mode:       g.StickyMode,
+			ResetAfter: time.Minute,
+		}
+		resolvers[id], err = rdns.NewStickyGroup(id, opt, gr...)
+		if err != nil {
+			return err
+		}
+	case "consensus":
+		opt := rdns.ConsensusOptions{
+			Quorum:         g.Quorum,
+			DisagreeAction: g.DisagreeAction,
+		}
+		resolvers[id], err = rdns.NewConsensus(id, opt, gr...)
+		if err != nil {
+			return err
+		}
+	case "weighted":
+		if len(g.Weights) != len(gr) {
+			return fmt.Errorf("group '%s' requires one weight per resolver", id)
+		}
+		var wr []rdns.WeightedResolver
+		for i, resolver := range gr {
+			wr = append(wr, rdns.WeightedResolver{Resolver: resolver, Weight: g.Weights[i]})
+		}
+		opt := rdns.WeightedGroupOptions{
+			FailFast:       g.FailFast,
+			DecayFactor:    g.DecayFactor,
+			RecoveryFactor: g.RecoveryFactor,
+			MinWeight:      g.MinWeight,
+		}
+		resolvers[id], err = rdns.NewWeightedGroup(id, opt, wr...)
+		if err != nil {
+			return err
+		}
+	case "adaptive":
+		opt := rdns.AdaptiveGroupOptions{
+			Window:         time.Duration(g.Window) * time.Second,
+			Epsilon:        g.Epsilon,
+			LatencyWeight:  g.LatencyWeight,
+			ErrorWeight:    g.ErrorWeight,
+			ServfailWeight: g.ServfailWeight,
+		}
+		resolvers[id], err = rdns.NewAdaptiveGroup(id, opt, gr...)
+		if err != nil {
+			return err
+		}
 	case "blocklist":
 		if len(gr) != 1 {
 			return fmt.Errorf("type blocklist only supports one resolver in '%s'", id)
@@ -288,13 +474,15 @@ func instantiateGroup(id string, g group, resolvers map[string]rdns.Resolver) er
 		if len(g.Blocklist) > 0 && g.Source != "" {
 			return fmt.Errorf("static blocklist can't be used with 'source' in '%s'", id)
 		}
-		blocklistDB, err := newBlocklistDB(list{Format: g.Format, Source: g.Source}, g.Blocklist)
+		blocklistDB, err := newBlocklistDB(id, list{Format: g.Format, Source: g.Source}, g.Blocklist)
 		if err != nil {
 			return err
 		}
 		opt := rdns.BlocklistOptions{
 			BlocklistDB:      blocklistDB,
 			BlocklistRefresh: time.Duration(g.Refresh) * time.Second,
+			EDE:              blocklistEDE(g),
+			DryRun:           g.BlocklistDryRun,
 		}
 		resolvers[id], err = rdns.NewBlocklist(id, gr[0], opt)
 		if err != nil {
@@ -312,14 +500,14 @@ func instantiateGroup(id string, g group, resolvers map[string]rdns.Resolver) er
 		}
 		var blocklistDB rdns.BlocklistDB
 		if len(g.Blocklist) > 0 {
-			blocklistDB, err = newBlocklistDB(list{Format: g.BlocklistFormat}, g.Blocklist)
+			blocklistDB, err = newBlocklistDB(id, list{Format: g.BlocklistFormat}, g.Blocklist)
 			if err != nil {
 				return err
 			}
 		} else {
 			var dbs []rdns.BlocklistDB
-			for _, s := range g.BlocklistSource {
-				db, err := newBlocklistDB(s, nil)
+			for i, s := range g.BlocklistSource {
+				db, err := newBlocklistDB(fmt.Sprintf("%s-blocklist-%d", id, i), s, nil)
 				if err != nil {
 					return fmt.Errorf("%s: %w", id, err)
 				}
@@ -332,14 +520,14 @@ func instantiateGroup(id string, g group, resolvers map[string]rdns.Resolver) er
 		}
 		var allowlistDB rdns.BlocklistDB
 		if len(g.Allowlist) > 0 {
-			allowlistDB, err = newBlocklistDB(list{Format: g.BlocklistFormat}, g.Allowlist)
+			allowlistDB, err = newBlocklistDB(id, list{Format: g.BlocklistFormat}, g.Allowlist)
 			if err != nil {
 				return err
 			}
 		} else {
 			var dbs []rdns.BlocklistDB
-			for _, s := range g.AllowlistSource {
-				db, err := newBlocklistDB(s, nil)
+			for i, s := range g.AllowlistSource {
+				db, err := newBlocklistDB(fmt.Sprintf("%s-allowlist-%d", id, i), s, nil)
 				if err != nil {
 					return fmt.Errorf("%s: %w", id, err)
 				}
@@ -357,6 +545,8 @@ func instantiateGroup(id string, g group, resolvers map[string]rdns.Resolver) er
 			AllowListResolver: resolvers[g.AllowListResolver],
 			AllowlistDB:       allowlistDB,
 			AllowlistRefresh:  time.Duration(g.AllowlistRefresh) * time.Second,
+			EDE:               blocklistEDE(g),
+			DryRun:            g.BlocklistDryRun,
 		}
 		resolvers[id], err = rdns.NewBlocklist(id, gr[0], opt)
 		if err != nil {
@@ -370,6 +560,22 @@ func instantiateGroup(id string, g group, resolvers map[string]rdns.Resolver) er
 		if err != nil {
 			return err
 		}
+	case "replace-ip":
+		if len(gr) != 1 {
+			return fmt.Errorf("type replace-ip only supports one resolver in '%s'", id)
+		}
+		resolvers[id], err = rdns.NewReplaceIP(id, gr[0], g.ReplaceIP...)
+		if err != nil {
+			return err
+		}
+	case "replace-name":
+		if len(gr) != 1 {
+			return fmt.Errorf("type replace-name only supports one resolver in '%s'", id)
+		}
+		resolvers[id], err = rdns.NewReplaceName(id, gr[0], g.ReplaceName...)
+		if err != nil {
+			return err
+		}
 	case "ttl-modifier":
 		if len(gr) != 1 {
 			return fmt.Errorf("type ttl-modifier only supports one resolver in '%s'", id)
@@ -417,6 +623,15 @@ func instantiateGroup(id string, g group, resolvers map[string]rdns.Resolver) er
 		if err != nil {
 			return err
 		}
+	case "edns-bufsize":
+		if len(gr) != 1 {
+			return fmt.Errorf("type edns-bufsize only supports one resolver in '%s'", id)
+		}
+		opt := rdns.EDNSBufSizeOptions{
+			MaxSize: g.MaxUDPSize,
+			AddOPT:  g.AddOPT,
+		}
+		resolvers[id] = rdns.NewEDNSBufSize(id, gr[0], opt)
 	case "cache":
 		var shuffleFunc rdns.AnswerShuffleFunc
 		switch g.CacheAnswerShuffle {
@@ -428,12 +643,31 @@ func instantiateGroup(id string, g group, resolvers map[string]rdns.Resolver) er
 		default:
 			return fmt.Errorf("unsupported shuffle function %q", g.CacheAnswerShuffle)
 		}
+		if g.CachePrefetchTrigger < 0 || g.CachePrefetchTrigger >= 1 {
+			return fmt.Errorf("cache-prefetch-trigger must be between 0 and 1 in '%s'", id)
+		}
+		if g.CacheTTLJitter < 0 || g.CacheTTLJitter > 100 {
+			return fmt.Errorf("cache-ttl-jitter must be between 0 and 100 in '%s'", id)
+		}
 		opt := rdns.CacheOptions{
 			GCPeriod:            time.Duration(g.GCPeriod) * time.Second,
 			Capacity:            g.CacheSize,
 			NegativeTTL:         g.CacheNegativeTTL,
 			ShuffleAnswerFunc:   shuffleFunc,
 			HardenBelowNXDOMAIN: g.CacheHardenBelowNXDOMAIN,
+			ServeStale:          time.Duration(g.CacheServeStale) * time.Second,
+			NegativeMinTTL:      g.CacheNegativeMinTTL,
+			NegativeMaxTTL:      g.CacheNegativeMaxTTL,
+			PositiveMinTTL:      g.CachePositiveMinTTL,
+			PositiveMaxTTL:      g.CachePositiveMaxTTL,
+			JitterPercent:       g.CacheTTLJitter,
+			MaxJitter:           time.Duration(g.CacheTTLMaxJitter) * time.Second,
+			PrefetchTrigger:     g.CachePrefetchTrigger,
+			PrefetchEligible:    g.CachePrefetchEligible,
+			PersistPath:         g.CachePersistPath,
+			PersistInterval:     time.Duration(g.CachePersistInterval) * time.Second,
+			ECSAware:            g.CacheECSAware,
+			ECSMaxScopes:        g.CacheECSMaxScopes,
 		}
 		resolvers[id] = rdns.NewCache(id, gr[0], opt)
 	case "response-blocklist-ip", "response-blocklist-cidr": // "response-blocklist-cidr" has been retired/renamed to "response-blocklist-ip"
@@ -468,6 +702,7 @@ func instantiateGroup(id string, g group, resolvers map[string]rdns.Resolver) er
 			BlocklistDB:       blocklistDB,
 			BlocklistRefresh:  time.Duration(g.BlocklistRefresh) * time.Second,
 			Filter:            g.Filter,
+			EDE:               blocklistEDE(g),
 		}
 		resolvers[id], err = rdns.NewResponseBlocklistIP(id, gr[0], opt)
 		if err != nil {
@@ -482,14 +717,14 @@ func instantiateGroup(id string, g group, resolvers map[string]rdns.Resolver) er
 		}
 		var blocklistDB rdns.BlocklistDB
 		if len(g.Blocklist) > 0 {
-			blocklistDB, err = newBlocklistDB(list{Format: g.BlocklistFormat}, g.Blocklist)
+			blocklistDB, err = newBlocklistDB(id, list{Format: g.BlocklistFormat}, g.Blocklist)
 			if err != nil {
 				return err
 			}
 		} else {
 			var dbs []rdns.BlocklistDB
-			for _, s := range g.BlocklistSource {
-				db, err := newBlocklistDB(s, nil)
+			for i, s := range g.BlocklistSource {
+				db, err := newBlocklistDB(fmt.Sprintf("%s-blocklist-%d", id, i), s, nil)
 				if err != nil {
 					return fmt.Errorf("%s: %w", id, err)
 				}
@@ -504,6 +739,7 @@ func instantiateGroup(id string, g group, resolvers map[string]rdns.Resolver) er
 			BlocklistResolver: resolvers[g.BlockListResolver],
 			BlocklistDB:       blocklistDB,
 			BlocklistRefresh:  time.Duration(g.BlocklistRefresh) * time.Second,
+			EDE:               blocklistEDE(g),
 		}
 		resolvers[id], err = rdns.NewResponseBlocklistName(id, gr[0], opt)
 		if err != nil {
@@ -540,6 +776,7 @@ func instantiateGroup(id string, g group, resolvers map[string]rdns.Resolver) er
 			BlocklistResolver: resolvers[g.BlockListResolver],
 			BlocklistDB:       blocklistDB,
 			BlocklistRefresh:  time.Duration(g.BlocklistRefresh) * time.Second,
+			EDE:               blocklistEDE(g),
 		}
 		resolvers[id], err = rdns.NewClientBlocklist(id, gr[0], opt)
 		if err != nil {
@@ -557,11 +794,76 @@ func instantiateGroup(id string, g group, resolvers map[string]rdns.Resolver) er
 		if err != nil {
 			return err
 		}
+	case "override":
+		if len(gr) != 1 {
+			return fmt.Errorf("type override only supports one resolver in '%s'", id)
+		}
+		opt := rdns.OverrideOptions{
+			Records:  g.Override,
+			NXDomain: g.OverrideNXDomain,
+			TTL:      g.OverrideTTL,
+		}
+		resolvers[id], err = rdns.NewOverride(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "safe-search":
+		if len(gr) != 1 {
+			return fmt.Errorf("type safe-search only supports one resolver in '%s'", id)
+		}
+		opt := rdns.SafeSearchOptions{
+			Mappings:   g.SafeSearchMappings,
+			NoDefaults: g.SafeSearchNoDefaults,
+			TTL:        g.SafeSearchTTL,
+		}
+		resolvers[id], err = rdns.NewSafeSearch(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "compression":
+		if len(gr) != 1 {
+			return fmt.Errorf("type compression only supports one resolver in '%s'", id)
+		}
+		resolvers[id] = rdns.NewCompression(id, gr[0], rdns.CompressionOptions{Compress: g.Compress})
+	case "reverse-ptr":
+		if len(gr) != 1 {
+			return fmt.Errorf("type reverse-ptr only supports one resolver in '%s'", id)
+		}
+		opt := rdns.ReversePTROptions{
+			Mappings: g.ReversePTR,
+			TTL:      g.ReversePTRTTL,
+		}
+		resolvers[id], err = rdns.NewReversePTR(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "local-zone":
+		if len(gr) > 1 {
+			return fmt.Errorf("type local-zone only supports one resolver in '%s'", id)
+		}
+		var resolver rdns.Resolver
+		if len(gr) == 1 {
+			resolver = gr[0]
+		}
+		opt := rdns.LocalZoneOptions{
+			Records:  g.Records,
+			ZoneFile: g.ZoneFile,
+		}
+		resolvers[id], err = rdns.NewLocalZone(id, resolver, opt)
+		if err != nil {
+			return err
+		}
 	case "response-minimize":
 		if len(gr) != 1 {
 			return fmt.Errorf("type response-minimize only supports one resolver in '%s'", id)
 		}
-		resolvers[id] = rdns.NewResponseMinimize(id, gr[0])
+		opt := rdns.ResponseMinimizeOptions{
+			KeepExtraTypes: g.KeepExtraTypes,
+		}
+		resolvers[id], err = rdns.NewResponseMinimize(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
 	case "response-collapse":
 		if len(gr) != 1 {
 			return fmt.Errorf("type response-collapse only supports one resolver in '%s'", id)
@@ -570,8 +872,378 @@ func instantiateGroup(id string, g group, resolvers map[string]rdns.Resolver) er
 			NullRCode: g.NullRCode,
 		}
 		resolvers[id] = rdns.NewResponseCollapse(id, gr[0], opt)
+	case "cname-flatten":
+		if len(gr) != 1 {
+			return fmt.Errorf("type cname-flatten only supports one resolver in '%s'", id)
+		}
+		resolvers[id] = rdns.NewCNAMEFlatten(id, gr[0])
 	case "drop":
 		resolvers[id] = rdns.NewDropResolver(id)
+	case "fastest-tcp":
+		if len(gr) != 1 {
+			return fmt.Errorf("type fastest-tcp only supports one resolver in '%s'", id)
+		}
+		opt := rdns.FastestTCPOptions{
+			Network:        g.ProbeNetwork,
+			Port:           g.Port,
+			ProbeTimeout:   time.Duration(g.ProbeTimeout) * time.Millisecond,
+			Count:          g.ProbeCount,
+			Mode:           g.ProbeMode,
+			ResultTTL:      time.Duration(g.ProbeTTL) * time.Second,
+			OnProbeFailure: g.OnProbeFail,
+		}
+		resolvers[id], err = rdns.NewFastestTCP(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "srv-steer":
+		if len(gr) != 1 {
+			return fmt.Errorf("type srv-steer only supports one resolver in '%s'", id)
+		}
+		opt := rdns.SRVSteerOptions{
+			Network:      g.ProbeNetwork,
+			ProbeTimeout: time.Duration(g.ProbeTimeout) * time.Millisecond,
+			Mode:         g.SRVSteerMode,
+		}
+		resolvers[id], err = rdns.NewSRVSteer(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "split-horizon":
+		if len(gr) != 1 {
+			return fmt.Errorf("type split-horizon only supports one resolver in '%s'", id)
+		}
+		var horizons []rdns.Horizon
+		for _, h := range g.Horizons {
+			networks, err := parseCIDRList(h.Networks)
+			if err != nil {
+				return fmt.Errorf("%s: %w", id, err)
+			}
+			resolver, ok := resolvers[h.Resolver]
+			if !ok {
+				return fmt.Errorf("group '%s' references non-existant resolver or group '%s'", id, h.Resolver)
+			}
+			horizons = append(horizons, rdns.Horizon{Networks: networks, Resolver: resolver})
+		}
+		resolvers[id] = rdns.NewSplitHorizon(id, gr[0], horizons...)
+	case "domain-router":
+		if len(gr) != 1 {
+			return fmt.Errorf("type domain-router only supports one resolver in '%s'", id)
+		}
+		var routes []rdns.DomainRoute
+		for _, r := range g.DomainRoutes {
+			resolver, ok := resolvers[r.Resolver]
+			if !ok {
+				return fmt.Errorf("group '%s' references non-existant resolver or group '%s'", id, r.Resolver)
+			}
+			routes = append(routes, rdns.DomainRoute{Suffix: r.Suffix, Resolver: resolver})
+		}
+		resolvers[id], err = rdns.NewDomainRouter(id, gr[0], routes...)
+		if err != nil {
+			return err
+		}
+	case "geo-router":
+		if len(gr) != 1 {
+			return fmt.Errorf("type geo-router only supports one resolver in '%s'", id)
+		}
+		mapping := make(map[string]rdns.Resolver)
+		for code, rid := range g.GeoMapping {
+			resolver, ok := resolvers[rid]
+			if !ok {
+				return fmt.Errorf("group '%s' references non-existant resolver or group '%s'", id, rid)
+			}
+			mapping[code] = resolver
+		}
+		opt := rdns.GeoRouterOptions{
+			DatabasePath: g.LocationDB,
+			ByField:      g.GeoField,
+			Mapping:      mapping,
+		}
+		resolvers[id], err = rdns.NewGeoRouter(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "geo-steer":
+		if len(gr) != 1 {
+			return fmt.Errorf("type geo-steer only supports one resolver in '%s'", id)
+		}
+		opt := rdns.GeoSteerOptions{
+			DatabasePath: g.LocationDB,
+			ByField:      g.GeoField,
+			Mapping:      g.GeoSteerMapping,
+			Mode:         g.GeoSteerMode,
+		}
+		resolvers[id], err = rdns.NewGeoSteer(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "schedule":
+		if len(gr) != 1 {
+			return fmt.Errorf("type schedule only supports one resolver in '%s'", id)
+		}
+		opt := rdns.ScheduleOptions{}
+		if g.ScheduleTimezone != "" {
+			opt.Location, err = time.LoadLocation(g.ScheduleTimezone)
+			if err != nil {
+				return err
+			}
+		}
+		for _, rule := range g.Schedule {
+			opt.Rules = append(opt.Rules, rdns.ScheduleRule{
+				Names:     rule.Names,
+				Days:      rule.Days,
+				StartTime: rule.StartTime,
+				EndTime:   rule.EndTime,
+				Action:    rule.Action,
+			})
+		}
+		resolvers[id], err = rdns.NewSchedule(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "replay":
+		if len(gr) != 1 {
+			return fmt.Errorf("type replay only supports one resolver in '%s'", id)
+		}
+		opt := rdns.ReplayOptions{
+			Path:       g.ReplayPath,
+			Mode:       g.ReplayMode,
+			MissAction: g.ReplayMissAction,
+		}
+		resolvers[id], err = rdns.NewReplay(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "retry":
+		if len(gr) != 1 {
+			return fmt.Errorf("type retry only supports one resolver in '%s'", id)
+		}
+		opt := rdns.RetryOptions{
+			Attempts:    g.RetryAttempts,
+			Delay:       time.Duration(g.RetryDelay) * time.Millisecond,
+			Backoff:     g.RetryBackoff,
+			RetryRcodes: g.RetryRcodes,
+			Timeout:     time.Duration(g.RetryTimeout) * time.Millisecond,
+		}
+		resolvers[id] = rdns.NewRetry(id, gr[0], opt)
+	case "truncate-retry":
+		if len(gr) != 1 {
+			return fmt.Errorf("type truncate-retry only supports one resolver in '%s'", id)
+		}
+		opt := rdns.TruncateRetryOptions{
+			TCPResolver: resolvers[g.TCPResolver],
+			KeepTC:      g.KeepTC,
+		}
+		resolvers[id], err = rdns.NewTruncateRetry(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "qps-limiter":
+		if len(gr) != 1 {
+			return fmt.Errorf("type qps-limiter only supports one resolver in '%s'", id)
+		}
+		opt := rdns.QPSLimiterOptions{
+			PerClientQPS:   g.PerClientQPS,
+			PerClientBurst: g.PerClientBurst,
+			GlobalQPS:      g.GlobalQPS,
+			GlobalBurst:    g.GlobalBurst,
+			Action:         g.LimitAction,
+		}
+		resolvers[id], err = rdns.NewQPSLimiter(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "single-flight":
+		if len(gr) != 1 {
+			return fmt.Errorf("type single-flight only supports one resolver in '%s'", id)
+		}
+		opt := rdns.SingleFlightOptions{
+			ECS: g.SingleFlightECS,
+		}
+		resolvers[id] = rdns.NewSingleFlight(id, gr[0], opt)
+	case "concurrency-limiter":
+		if len(gr) != 1 {
+			return fmt.Errorf("type concurrency-limiter only supports one resolver in '%s'", id)
+		}
+		opt := rdns.ConcurrencyLimiterOptions{
+			MaxInFlight: g.MaxInFlight,
+			MaxWait:     time.Duration(g.MaxWait) * time.Millisecond,
+		}
+		resolvers[id], err = rdns.NewConcurrencyLimiter(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "qtype-filter":
+		if len(gr) != 1 {
+			return fmt.Errorf("type qtype-filter only supports one resolver in '%s'", id)
+		}
+		opt := rdns.QTypeFilterOptions{
+			Types:  g.QTypes,
+			Action: g.QTypeAction,
+		}
+		resolvers[id], err = rdns.NewQTypeFilter(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "nx-replace":
+		opt := rdns.NXReplaceOptions{
+			HijackIPs: g.HijackIPs,
+			Action:    g.HijackAction,
+		}
+		resolvers[id], err = rdns.NewNXReplace(id, opt, gr...)
+		if err != nil {
+			return err
+		}
+	case "rebind-protect":
+		if len(gr) != 1 {
+			return fmt.Errorf("type rebind-protect only supports one resolver in '%s'", id)
+		}
+		opt := rdns.RebindProtectOptions{
+			CIDRs:      g.RebindCIDRs,
+			Action:     g.RebindAction,
+			AllowNames: g.RebindAllow,
+		}
+		resolvers[id], err = rdns.NewRebindProtect(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "loop-guard":
+		if len(gr) != 1 {
+			return fmt.Errorf("type loop-guard only supports one resolver in '%s'", id)
+		}
+		opt := rdns.LoopGuardOptions{
+			MaxChain: g.MaxCNAMEChain,
+		}
+		resolvers[id] = rdns.NewLoopGuard(id, gr[0], opt)
+	case "bailiwick":
+		if len(gr) != 1 {
+			return fmt.Errorf("type bailiwick only supports one resolver in '%s'", id)
+		}
+		resolvers[id] = rdns.NewBailiwick(id, gr[0])
+	case "case-0x20":
+		if len(gr) != 1 {
+			return fmt.Errorf("type case-0x20 only supports one resolver in '%s'", id)
+		}
+		opt := rdns.Case0x20Options{
+			MismatchResolver: resolvers[g.MismatchResolver],
+		}
+		resolvers[id] = rdns.NewCase0x20(id, gr[0], opt)
+	case "address-family-filter":
+		if len(gr) != 1 {
+			return fmt.Errorf("type address-family-filter only supports one resolver in '%s'", id)
+		}
+		opt := rdns.AddressFamilyFilterOptions{
+			Mode: g.AddressFamily,
+		}
+		resolvers[id], err = rdns.NewAddressFamilyFilter(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "slow-log":
+		if len(gr) != 1 {
+			return fmt.Errorf("type slow-log only supports one resolver in '%s'", id)
+		}
+		opt := rdns.SlowLogOptions{
+			Threshold: time.Duration(g.SlowThreshold) * time.Millisecond,
+		}
+		resolvers[id] = rdns.NewSlowLog(id, gr[0], opt)
+	case "query-log":
+		if len(gr) != 1 {
+			return fmt.Errorf("type query-log only supports one resolver in '%s'", id)
+		}
+		opt := rdns.QueryLogOptions{
+			SampleRate: g.QueryLogSampleRate,
+			Fields:     g.QueryLogFields,
+			BufferSize: g.QueryLogBufferSize,
+		}
+		if g.QueryLogFile != "" {
+			f, err := os.OpenFile(g.QueryLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+			opt.Output = f
+		}
+		resolvers[id], err = rdns.NewQueryLog(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "dnssec-strip":
+		if len(gr) != 1 {
+			return fmt.Errorf("type dnssec-strip only supports one resolver in '%s'", id)
+		}
+		resolvers[id] = rdns.NewDNSSECStrip(id, gr[0])
+	case "dnssec-validator":
+		if len(gr) != 1 {
+			return fmt.Errorf("type dnssec-validator only supports one resolver in '%s'", id)
+		}
+		opt := rdns.DNSSECValidatorOptions{
+			TrustAnchors: g.TrustAnchors,
+			BogusAction:  g.BogusAction,
+		}
+		resolvers[id], err = rdns.NewDNSSECValidator(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "qname-minimizer":
+		if len(gr) != 1 {
+			return fmt.Errorf("type qname-minimizer only supports one resolver in '%s'", id)
+		}
+		opt := rdns.QNameMinimizerOptions{
+			WalkLabels: g.WalkLabels,
+		}
+		resolvers[id] = rdns.NewQNameMinimizer(id, gr[0], opt)
+	case "chaos-responder":
+		if len(gr) != 1 {
+			return fmt.Errorf("type chaos-responder only supports one resolver in '%s'", id)
+		}
+		opt := rdns.CHAOSResponderOptions{
+			Version:  g.ChaosVersion,
+			Hostname: g.ChaosHostname,
+			Hide:     g.ChaosHide,
+		}
+		resolvers[id] = rdns.NewCHAOSResponder(id, gr[0], opt)
+	case "response-limiter":
+		if len(gr) != 1 {
+			return fmt.Errorf("type response-limiter only supports one resolver in '%s'", id)
+		}
+		opt := rdns.ResponseLimiterOptions{
+			MaxAnswers: g.MaxAnswers,
+			MaxSize:    g.MaxSize,
+			SetTC:      g.SetTC,
+		}
+		resolvers[id] = rdns.NewResponseLimiter(id, gr[0], opt)
+	case "inspector":
+		if len(gr) != 1 {
+			return fmt.Errorf("type inspector only supports one resolver in '%s'", id)
+		}
+		opt := rdns.InspectorOptions{
+			Size: g.InspectorSize,
+		}
+		resolvers[id] = rdns.NewInspector(id, gr[0], opt)
+	case "dns64":
+		if len(gr) != 1 {
+			return fmt.Errorf("type dns64 only supports one resolver in '%s'", id)
+		}
+		opt := rdns.DNS64Options{
+			Prefix: g.DNS64Prefix,
+		}
+		resolvers[id], err = rdns.NewDNS64(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "https-hint":
+		if len(gr) != 1 {
+			return fmt.Errorf("type https-hint only supports one resolver in '%s'", id)
+		}
+		opt := rdns.HTTPSHintOptions{
+			Types:      g.HTTPSHintTypes,
+			MaxLookups: g.HTTPSHintMaxLookups,
+			Timeout:    time.Duration(g.HTTPSHintTimeout) * time.Millisecond,
+		}
+		resolvers[id], err = rdns.NewHTTPSHint(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
 	case "rate-limiter":
 		if len(gr) != 1 {
 			return fmt.Errorf("type rate-limiter only supports one resolver in '%s'", id)
@@ -584,6 +1256,38 @@ func instantiateGroup(id string, g group, resolvers map[string]rdns.Resolver) er
 			LimitResolver: resolvers[g.LimitResolver],
 		}
 		resolvers[id] = rdns.NewRateLimiter(id, gr[0], opt)
+	case "response-rate-limiter":
+		if len(gr) != 1 {
+			return fmt.Errorf("type response-rate-limiter only supports one resolver in '%s'", id)
+		}
+		opt := rdns.ResponseRateLimiterOptions{
+			ResponsesPerSecond: g.ResponsesPerSecond,
+			Window:             time.Duration(g.Window) * time.Second,
+			SlipRatio:          g.SlipRatio,
+			IPv4PrefixLen:      g.Prefix4,
+			IPv6PrefixLen:      g.Prefix6,
+		}
+		resolvers[id], err = rdns.NewResponseRateLimiter(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
+	case "tunnel-guard":
+		if len(gr) != 1 {
+			return fmt.Errorf("type tunnel-guard only supports one resolver in '%s'", id)
+		}
+		opt := rdns.TunnelGuardOptions{
+			MaxNameLength:    g.TunnelMaxNameLength,
+			EntropyThreshold: g.TunnelEntropyThreshold,
+			TXTBudget:        g.TunnelTXTBudget,
+			DomainBudget:     g.TunnelDomainBudget,
+			Window:           time.Duration(g.Window) * time.Second,
+			Action:           g.TunnelAction,
+			RateLimit:        g.TunnelRateLimit,
+		}
+		resolvers[id], err = rdns.NewTunnelGuard(id, gr[0], opt)
+		if err != nil {
+			return err
+		}
 
 	default:
 		return fmt.Errorf("unsupported group type '%s' for group '%s'", g.Type, id)
@@ -607,6 +1311,9 @@ func instantiateRouter(id string, r router, resolvers map[string]rdns.Resolver)
 		if err != nil {
 			return fmt.Errorf("failure parsing routes for router '%s' : %s", id, err.Error())
 		}
+		if err := r.ClientIdentity(route.Identity); err != nil {
+			return fmt.Errorf("failure parsing routes for router '%s' : %s", id, err.Error())
+		}
 		r.Invert(route.Invert)
 		router.Add(r)
 	}
@@ -614,7 +1321,19 @@ func instantiateRouter(id string, r router, resolvers map[string]rdns.Resolver)
 	return nil
 }
 
-func newBlocklistDB(l list, rules []string) (rdns.BlocklistDB, error) {
+// Returns the Extended DNS Error for blocked responses, or nil if not enabled.
+func blocklistEDE(g group) *rdns.ExtendedError {
+	if !g.BlocklistEDE {
+		return nil
+	}
+	code := g.BlocklistEDECode
+	if code == 0 {
+		code = rdns.EDEFiltered
+	}
+	return &rdns.ExtendedError{InfoCode: code, ExtraText: g.BlocklistEDEText}
+}
+
+func newBlocklistDB(id string, l list, rules []string) (rdns.BlocklistDB, error) {
 	loc, err := url.Parse(l.Source)
 	if err != nil {
 		return nil, err
@@ -635,10 +1354,24 @@ func newBlocklistDB(l list, rules []string) (rdns.BlocklistDB, error) {
 			return nil, fmt.Errorf("unsupported scheme '%s' in '%s'", loc.Scheme, l.Source)
 		}
 	}
+	switch l.Backend {
+	case "", "map":
+	case "bloom":
+		if l.Format != "domain" && l.Format != "wildcard" {
+			return nil, fmt.Errorf("backend 'bloom' only supports the 'domain' format")
+		}
+		opt := rdns.BloomDBOptions{
+			FalsePositiveRate: l.FalsePositiveRate,
+			Verify:            l.Verify,
+		}
+		return rdns.NewBloomDB(id, loader, opt)
+	default:
+		return nil, fmt.Errorf("unsupported backend '%s'", l.Backend)
+	}
 	switch l.Format {
 	case "regexp", "":
 		return rdns.NewRegexpDB(loader)
-	case "domain":
+	case "domain", "wildcard":
 		return rdns.NewDomainDB(loader)
 	case "hosts":
 		return rdns.NewHostsDB(loader)
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net"
+	"time"
 
 	rdns "github.com/folbricht/routedns"
 )
@@ -19,6 +20,7 @@ func instantiateResolver(id string, r resolver, resolvers map[string]rdns.Resolv
 		opt := rdns.DoQClientOptions{
 			BootstrapAddr: r.BootstrapAddr,
 			LocalAddr:     net.ParseIP(r.LocalAddr),
+			DSCP:          r.DSCP,
 			TLSConfig:     tlsConfig,
 		}
 		resolvers[id], err = rdns.NewDoQClient(id, r.Address, opt)
@@ -33,7 +35,11 @@ func instantiateResolver(id string, r resolver, resolvers map[string]rdns.Resolv
 		opt := rdns.DoTClientOptions{
 			BootstrapAddr: r.BootstrapAddr,
 			LocalAddr:     net.ParseIP(r.LocalAddr),
+			DSCP:          r.DSCP,
 			TLSConfig:     tlsConfig,
+			PinnedSPKI:    r.PinnedSPKI,
+			PoolSize:      r.PoolSize,
+			IdleTimeout:   time.Duration(r.IdleTimeout) * time.Second,
 		}
 		resolvers[id], err = rdns.NewDoTClient(id, r.Address, opt)
 		if err != nil {
@@ -59,11 +65,46 @@ func instantiateResolver(id string, r resolver, resolvers map[string]rdns.Resolv
 			return err
 		}
 		opt := rdns.DoHClientOptions{
-			Method:        r.DoH.Method,
-			TLSConfig:     tlsConfig,
-			BootstrapAddr: r.BootstrapAddr,
-			Transport:     r.Transport,
-			LocalAddr:     net.ParseIP(r.LocalAddr),
+			Method:          r.DoH.Method,
+			Format:          r.DoH.Format,
+			TLSConfig:       tlsConfig,
+			BootstrapAddr:   r.BootstrapAddr,
+			Transport:       r.Transport,
+			LocalAddr:       net.ParseIP(r.LocalAddr),
+			DSCP:            r.DSCP,
+			AutoUpgrade:     r.DoH.AutoUpgrade,
+			ECS:             r.DoH.ECS,
+			PinnedSPKI:      r.DoH.PinnedSPKI,
+			MaxResponseSize: r.DoH.MaxResponseSize,
+			Headers:         r.DoH.Headers,
+			KeepAlive:       time.Duration(r.DoH.KeepAlive) * time.Second,
+			Enable0RTT:      r.DoH.Enable0RTT,
+			Proxy:           r.DoH.Proxy,
+			HappyEyeballs:   r.DoH.HappyEyeballs,
+			Compression:     r.DoH.Compression,
+			ForceHTTP1:      r.DoH.ForceHTTP1,
+
+			MaxIdleConns:          r.DoH.MaxIdleConns,
+			MaxIdleConnsPerHost:   r.DoH.MaxIdleConnsPerHost,
+			IdleConnTimeout:       time.Duration(r.DoH.IdleConnTimeout) * time.Second,
+			ResponseHeaderTimeout: time.Duration(r.DoH.ResponseHeaderTimeout) * time.Second,
+
+			CircuitBreaker: rdns.CircuitBreakerOptions{
+				FailureThreshold: r.DoH.CircuitBreaker.FailureThreshold,
+				Cooldown:         time.Duration(r.DoH.CircuitBreaker.Cooldown) * time.Second,
+				MaxCooldown:      time.Duration(r.DoH.CircuitBreaker.MaxCooldown) * time.Second,
+			},
+			QueryPadding: &rdns.PaddingPolicy{
+				Enabled:   !r.DoH.Padding.Disabled,
+				BlockSize: r.DoH.Padding.BlockSize,
+			},
+		}
+		if r.DoH.ODoH != nil {
+			opt.ODoH = &rdns.ODoHOptions{
+				Relay:    r.DoH.ODoH.Relay,
+				Config:   r.DoH.ODoH.Config,
+				Fallback: r.DoH.ODoH.Fallback,
+			}
 		}
 		resolvers[id], err = rdns.NewDoHClient(id, r.Address, opt)
 		if err != nil {
@@ -71,7 +112,11 @@ func instantiateResolver(id string, r resolver, resolvers map[string]rdns.Resolv
 		}
 	case "tcp", "udp":
 		opt := rdns.DNSClientOptions{
-			LocalAddr: net.ParseIP(r.LocalAddr),
+			LocalAddr:     net.ParseIP(r.LocalAddr),
+			DSCP:          r.DSCP,
+			PoolSize:      r.PoolSize,
+			IdleTimeout:   time.Duration(r.IdleTimeout) * time.Second,
+			EnableCookies: r.EnableCookies,
 		}
 		resolvers[id], err = rdns.NewDNSClient(id, r.Address, r.Protocol, opt)
 		if err != nil {
@@ -0,0 +1,82 @@
+package rdns
+
+import (
+	"github.com/miekg/dns"
+)
+
+// CNAMEFlatten is a resolver that flattens CNAME chains in responses, similar
+// to ALIAS records. The records at the end of the chain are returned under the
+// query name and the CNAME records are removed. The TTL of the records is the
+// lowest TTL along the chain. Only the chain within the response is followed,
+// no further queries are sent.
+type CNAMEFlatten struct {
+	id       string
+	resolver Resolver
+}
+
+var _ Resolver = &CNAMEFlatten{}
+
+// NewCNAMEFlatten returns a new instance of a CNAME flattener.
+func NewCNAMEFlatten(id string, resolver Resolver) *CNAMEFlatten {
+	return &CNAMEFlatten{id: id, resolver: resolver}
+}
+
+// Resolve a DNS query, then replace the CNAME chain in the answer with the
+// records it points to.
+func (r *CNAMEFlatten) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	answer, err := r.resolver.Resolve(q, ci)
+	if err != nil || answer == nil || answer.Rcode != dns.RcodeSuccess || len(q.Question) < 1 {
+		return answer, err
+	}
+	question := q.Question[0]
+	if question.Qtype == dns.TypeCNAME || question.Qtype == dns.TypeANY {
+		return answer, nil
+	}
+
+	// Follow the CNAME chain starting at the query name, keeping track of the lowest TTL
+	target := question.Name
+	minTTL := ^uint32(0)
+	var chain int
+	for ; chain < len(answer.Answer); chain++ {
+		cname := findCNAME(answer.Answer, target)
+		if cname == nil {
+			break
+		}
+		if cname.Hdr.Ttl < minTTL {
+			minTTL = cname.Hdr.Ttl
+		}
+		target = cname.Target
+	}
+	if chain == 0 {
+		return answer, nil
+	}
+
+	// Build the answer from the records at the end of the chain. Signatures are
+	// dropped since they don't cover the records under the query name.
+	var aRR []dns.RR
+	for _, rr := range answer.Answer {
+		h := rr.Header()
+		if h.Rrtype != question.Qtype || h.Class != question.Qclass || !equalName(h.Name, target) {
+			continue
+		}
+		rr = dns.Copy(rr)
+		h = rr.Header()
+		h.Name = question.Name
+		if h.Ttl > minTTL {
+			h.Ttl = minTTL
+		}
+		aRR = append(aRR, rr)
+	}
+
+	// Leave the response alone if the chain doesn't end in records of the type
+	if len(aRR) == 0 {
+		return answer, nil
+	}
+	logger(r.id, q, ci).Debug("flattening cname chain")
+	answer.Answer = aRR
+	return answer, nil
+}
+
+func (r *CNAMEFlatten) String() string {
+	return r.id
+}
@@ -0,0 +1,62 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCNAMEFlatten(t *testing.T) {
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			for _, s := range []string{
+				"www.example.com. 2964 IN CNAME www.glb.example.com.",
+				"www.glb.example.com. 251 IN CNAME e1.example.net.",
+				"e1.example.net. 7199 IN A 95.100.196.60",
+				"e1.example.net. 7199 IN A 95.100.196.61",
+				"other.example.net. 10 IN A 10.0.0.1",
+			} {
+				rr, err := dns.NewRR(s)
+				require.NoError(t, err)
+				a.Answer = append(a.Answer, rr)
+			}
+			return a, nil
+		},
+	}
+	r := NewCNAMEFlatten("test-flatten", upstream)
+	q := new(dns.Msg)
+
+	// Only the records at the end of the chain are kept, under the query name
+	// and with the lowest TTL
+	q.SetQuestion("www.example.com.", dns.TypeA)
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 2)
+	for _, rr := range a.Answer {
+		require.Equal(t, "www.example.com.", rr.Header().Name)
+		require.Equal(t, dns.TypeA, rr.Header().Rrtype)
+		require.Equal(t, uint32(251), rr.Header().Ttl)
+	}
+
+	// The chain is matched regardless of case
+	q.SetQuestion("WWW.Example.com.", dns.TypeA)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 2)
+	require.Equal(t, "WWW.Example.com.", a.Answer[0].Header().Name)
+
+	// Responses are left alone if the chain doesn't end in the type
+	q.SetQuestion("www.example.com.", dns.TypeAAAA)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 5)
+
+	// CNAME queries are not flattened
+	q.SetQuestion("www.example.com.", dns.TypeCNAME)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 5)
+}
@@ -0,0 +1,46 @@
+package rdns
+
+import (
+	"github.com/miekg/dns"
+)
+
+// Compression is a resolver that controls whether names in responses are
+// compressed with pointers when they're packed by the listener. Some legacy or
+// embedded clients mishandle compressed names and need fully expanded responses.
+type Compression struct {
+	id       string
+	resolver Resolver
+	opt      CompressionOptions
+}
+
+var _ Resolver = &Compression{}
+
+// CompressionOptions contain settings for the Compression resolver.
+type CompressionOptions struct {
+	// Compress names in responses if true, send fully expanded names otherwise.
+	Compress bool
+}
+
+// NewCompression returns a new instance of a Compression resolver.
+func NewCompression(id string, resolver Resolver, opt CompressionOptions) *Compression {
+	return &Compression{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+	}
+}
+
+// Resolve a DNS query and set the compression of the response.
+func (r *Compression) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	logger(r.id, q, ci).WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+	a, err := r.resolver.Resolve(q, ci)
+	if err != nil || a == nil {
+		return a, err
+	}
+	a.Compress = r.opt.Compress
+	return a, nil
+}
+
+func (r *Compression) String() string {
+	return r.id
+}
@@ -0,0 +1,49 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+	var ci ClientInfo
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			for _, s := range []string{
+				"www.example.com. 300 IN CNAME web.example.com.",
+				"web.example.com. 300 IN A 192.0.2.1",
+				"web.example.com. 300 IN A 192.0.2.2",
+			} {
+				rr, err := dns.NewRR(s)
+				require.NoError(t, err)
+				a.Answer = append(a.Answer, rr)
+			}
+			return a, nil
+		},
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.com.", dns.TypeA)
+
+	var packed [][]byte
+	for _, compress := range []bool{true, false} {
+		r := NewCompression("test-compression", upstream, CompressionOptions{Compress: compress})
+		a, err := r.Resolve(q, ci)
+		require.NoError(t, err)
+		b, err := a.Pack()
+		require.NoError(t, err)
+		packed = append(packed, b)
+	}
+	compressed, expanded := packed[0], packed[1]
+	require.Less(t, len(compressed), len(expanded))
+
+	// Both unpack to the same message
+	a1, a2 := new(dns.Msg), new(dns.Msg)
+	require.NoError(t, a1.Unpack(compressed))
+	require.NoError(t, a2.Unpack(expanded))
+	require.Equal(t, a1.String(), a2.String())
+	require.Len(t, a2.Answer, 3)
+}
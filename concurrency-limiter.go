@@ -0,0 +1,121 @@
+package rdns
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ConcurrencyLimiter is a resolver that limits the number of queries that are in
+// flight to the upstream resolver at the same time, to protect fragile upstreams.
+// Unlike the rate limiters, it doesn't limit how many queries are sent over time.
+// Queries beyond the limit wait for a slot to become free, up to a maximum time,
+// and are answered with SERVFAIL if none does.
+type ConcurrencyLimiter struct {
+	id       string
+	resolver Resolver
+	opt      ConcurrencyLimiterOptions
+	sem      chan struct{}
+	metrics  *ConcurrencyLimiterMetrics
+}
+
+var _ ContextResolver = &ConcurrencyLimiter{}
+
+// ConcurrencyLimiterOptions contain settings for the ConcurrencyLimiter resolver.
+type ConcurrencyLimiterOptions struct {
+	// Maximum number of queries in flight to the upstream resolver.
+	MaxInFlight int
+
+	// How long queries wait for a free slot before they're rejected. If 0,
+	// queries are rejected immediately when the limit is reached.
+	MaxWait time.Duration
+}
+
+type ConcurrencyLimiterMetrics struct {
+	// Number of queries currently in flight.
+	inFlight *expvar.Int
+	// Count of queries rejected because the limit was reached.
+	reject *expvar.Int
+}
+
+// NewConcurrencyLimiter returns a new instance of a concurrency limiter.
+func NewConcurrencyLimiter(id string, resolver Resolver, opt ConcurrencyLimiterOptions) (*ConcurrencyLimiter, error) {
+	if opt.MaxInFlight < 1 {
+		return nil, errors.New("max-in-flight needs to be at least 1")
+	}
+	if opt.MaxWait < 0 {
+		return nil, errors.New("max-wait can not be negative")
+	}
+	return &ConcurrencyLimiter{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		sem:      make(chan struct{}, opt.MaxInFlight),
+		metrics: &ConcurrencyLimiterMetrics{
+			inFlight: getVarInt("router", id, "in_flight"),
+			reject:   getVarInt("router", id, "reject"),
+		},
+	}, nil
+}
+
+// Resolve a DNS query once a slot is available.
+func (r *ConcurrencyLimiter) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	return r.ResolveContext(ci.queryContext(), q, ci)
+}
+
+// ResolveContext resolves a DNS query once a slot is available. Waiting for the
+// slot stops when the context is done. The context is passed on to the upstream
+// resolver if it supports it.
+func (r *ConcurrencyLimiter) ResolveContext(ctx context.Context, q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	log := logger(r.id, q, ci)
+	if !r.acquire(ctx) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		log.Debug("too many queries in flight, rejecting")
+		r.metrics.reject.Add(1)
+		return servfail(q), nil
+	}
+	// Release the slot even if the upstream resolver panics
+	defer r.release()
+
+	log.WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+	return resolveContext(ctx, r.resolver, q, ci)
+}
+
+func (r *ConcurrencyLimiter) String() string {
+	return r.id
+}
+
+// Takes a slot, waiting for up to MaxWait for one to become free. Returns false
+// if there was no free slot in time or the context is done.
+func (r *ConcurrencyLimiter) acquire(ctx context.Context) bool {
+	select {
+	case r.sem <- struct{}{}:
+		r.metrics.inFlight.Add(1)
+		return true
+	default:
+	}
+	if r.opt.MaxWait == 0 {
+		return false
+	}
+	timer := time.NewTimer(r.opt.MaxWait)
+	defer timer.Stop()
+	select {
+	case r.sem <- struct{}{}:
+		r.metrics.inFlight.Add(1)
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (r *ConcurrencyLimiter) release() {
+	r.metrics.inFlight.Add(-1)
+	<-r.sem
+}
@@ -0,0 +1,79 @@
+package rdns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	block := make(chan struct{})
+	started := make(chan struct{})
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			if q.Question[0].Name == "block.example.com." {
+				started <- struct{}{}
+				<-block
+			}
+			if q.Question[0].Name == "panic.example.com." {
+				panic("upstream failure")
+			}
+			a := new(dns.Msg)
+			a.SetReply(q)
+			return a, nil
+		},
+	}
+	r, err := NewConcurrencyLimiter("test-concurrency", upstream, ConcurrencyLimiterOptions{MaxInFlight: 1})
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	bq := new(dns.Msg)
+	bq.SetQuestion("block.example.com.", dns.TypeA)
+
+	// Take the only slot with a query that blocks
+	done := make(chan struct{})
+	go func() {
+		_, _ = r.Resolve(bq, ClientInfo{})
+		close(done)
+	}()
+	<-started
+
+	// Rejected immediately without wait time
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeServerFailure, a.Rcode)
+	require.Equal(t, int64(1), r.metrics.reject.Value())
+	require.Equal(t, int64(1), r.metrics.inFlight.Value())
+
+	// Waiting stops when the context is cancelled
+	r.opt.MaxWait = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = r.ResolveContext(ctx, q, ClientInfo{})
+	require.Error(t, err)
+
+	// Succeeds once the slot is released while waiting
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(block)
+	}()
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	<-done
+
+	// The slot is released when the upstream panics
+	pq := new(dns.Msg)
+	pq.SetQuestion("panic.example.com.", dns.TypeA)
+	require.Panics(t, func() { _, _ = r.Resolve(pq, ClientInfo{}) })
+	require.Equal(t, int64(0), r.metrics.inFlight.Value())
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+
+	_, err = NewConcurrencyLimiter("test-concurrency", upstream, ConcurrencyLimiterOptions{})
+	require.Error(t, err)
+}
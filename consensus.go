@@ -0,0 +1,151 @@
+package rdns
+
+import (
+	"expvar"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Consensus is a resolver group that sends queries to all its resolvers
+// concurrently and only returns a response if a quorum of them agree on the
+// answer. Responses are compared by their response code and set of A and AAAA
+// addresses, ignoring order and TTLs. This is used to detect responses that were
+// tampered with by a single upstream.
+type Consensus struct {
+	id        string
+	resolvers []Resolver
+	opt       ConsensusOptions
+	result    *expvar.Map
+}
+
+var _ Resolver = &Consensus{}
+
+// ConsensusOptions contain settings for the consensus resolver group.
+type ConsensusOptions struct {
+	// Number of resolvers that have to return the same answer. Default is a
+	// majority of the resolvers in the group.
+	Quorum int
+
+	// What to do if no quorum is reached. "servfail" (default) responds with
+	// SERVFAIL, "majority" logs the disagreement and returns the answer that
+	// most resolvers agree on, if there is a single one.
+	DisagreeAction string
+}
+
+// NewConsensus returns a new instance of a consensus resolver group.
+func NewConsensus(id string, opt ConsensusOptions, resolvers ...Resolver) (*Consensus, error) {
+	switch opt.DisagreeAction {
+	case "":
+		opt.DisagreeAction = "servfail"
+	case "servfail", "majority":
+	default:
+		return nil, fmt.Errorf("unsupported disagree action '%s'", opt.DisagreeAction)
+	}
+	if opt.Quorum == 0 {
+		opt.Quorum = len(resolvers)/2 + 1
+	}
+	if opt.Quorum < 0 || opt.Quorum > len(resolvers) {
+		return nil, fmt.Errorf("invalid quorum %d for %d resolvers", opt.Quorum, len(resolvers))
+	}
+	return &Consensus{
+		id:        id,
+		resolvers: resolvers,
+		opt:       opt,
+		result:    getVarMap("router", id, "result"),
+	}, nil
+}
+
+// Resolve a DNS query by sending it to all resolvers and returning the answer
+// once a quorum agrees on it.
+func (r *Consensus) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	log := logger(r.id, q, ci)
+
+	type response struct {
+		r   Resolver
+		a   *dns.Msg
+		err error
+	}
+	responseCh := make(chan response, len(r.resolvers))
+	for _, resolver := range r.resolvers {
+		resolver := resolver
+		go func() {
+			a, err := resolver.Resolve(q.Copy(), ci)
+			responseCh <- response{resolver, a, err}
+		}()
+	}
+
+	// Group the responses by answer. Return as soon as one reaches the quorum,
+	// the remaining queries are abandoned.
+	votes := make(map[string]int)
+	answers := make(map[string]*dns.Msg)
+	for i := 0; i < len(r.resolvers); i++ {
+		res := <-responseCh
+		if res.err != nil || res.a == nil {
+			log.WithField("resolver", res.r).WithError(res.err).Debug("resolver returned failure, not counted")
+			continue
+		}
+		key := consensusKey(res.a)
+		log.WithField("resolver", res.r).WithField("answer", key).Trace("received response")
+		votes[key]++
+		if _, ok := answers[key]; !ok {
+			answers[key] = res.a
+		}
+		if votes[key] >= r.opt.Quorum {
+			r.result.Add("agree", 1)
+			return answers[key], nil
+		}
+	}
+
+	r.result.Add("disagree", 1)
+	log.WithField("votes", votes).Warn("resolvers did not reach a quorum")
+	if r.opt.DisagreeAction == "majority" {
+		var (
+			best string
+			max  int
+			tie  bool
+		)
+		for key, n := range votes {
+			switch {
+			case n > max:
+				best, max, tie = key, n, false
+			case n == max:
+				tie = true
+			}
+		}
+		if max > 0 && !tie {
+			r.result.Add("majority", 1)
+			return answers[best], nil
+		}
+	}
+	return servfail(q), nil
+}
+
+func (r *Consensus) String() string {
+	return r.id
+}
+
+// Returns a string representing the response code and the set of addresses in
+// a response, used to compare responses from different resolvers.
+func consensusKey(a *dns.Msg) string {
+	var addrs []string
+	for _, rr := range a.Answer {
+		switch record := rr.(type) {
+		case *dns.A:
+			addrs = append(addrs, record.A.String())
+		case *dns.AAAA:
+			addrs = append(addrs, record.AAAA.String())
+		}
+	}
+	sort.Strings(addrs)
+	// Compare sets, duplicates don't count
+	var set []string
+	for i, addr := range addrs {
+		if i == 0 || addr != addrs[i-1] {
+			set = append(set, addr)
+		}
+	}
+	return dns.RcodeToString[a.Rcode] + " " + strings.Join(set, ",")
+}
@@ -0,0 +1,78 @@
+package rdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// Returns a resolver that responds with A records for the given IPs and TTL.
+func testConsensusResolver(ttl uint32, ips ...string) *TestResolver {
+	return &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			for _, ip := range ips {
+				a.Answer = append(a.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+					A:   net.ParseIP(ip),
+				})
+			}
+			return a, nil
+		},
+	}
+}
+
+func TestConsensus(t *testing.T) {
+	r1 := testConsensusResolver(300, "192.0.2.1", "192.0.2.2")
+	r2 := testConsensusResolver(60, "192.0.2.2", "192.0.2.1")
+	r3 := testConsensusResolver(300, "203.0.113.1")
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	// Default quorum of 2 out of 3, order and TTL don't matter
+	g, err := NewConsensus("test-consensus", ConsensusOptions{}, r1, r2, r3)
+	require.NoError(t, err)
+	a, err := g.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.Len(t, a.Answer, 2)
+
+	// All need to agree
+	g, err = NewConsensus("test-consensus", ConsensusOptions{Quorum: 3}, r1, r2, r3)
+	require.NoError(t, err)
+	a, err = g.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeServerFailure, a.Rcode)
+
+	// Pick the majority answer if no quorum is reached
+	g, err = NewConsensus("test-consensus", ConsensusOptions{Quorum: 3, DisagreeAction: "majority"}, r1, r2, r3)
+	require.NoError(t, err)
+	a, err = g.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.Len(t, a.Answer, 2)
+
+	// No majority with a tie
+	g, err = NewConsensus("test-consensus", ConsensusOptions{DisagreeAction: "majority"}, r1, r3)
+	require.NoError(t, err)
+	a, err = g.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeServerFailure, a.Rcode)
+
+	// Failed resolvers don't count towards the quorum
+	r2.SetFail(true)
+	g, err = NewConsensus("test-consensus", ConsensusOptions{}, r1, r2, r3)
+	require.NoError(t, err)
+	a, err = g.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeServerFailure, a.Rcode)
+
+	// Invalid options
+	_, err = NewConsensus("test-consensus", ConsensusOptions{Quorum: 4}, r1, r2, r3)
+	require.Error(t, err)
+	_, err = NewConsensus("test-consensus", ConsensusOptions{DisagreeAction: "invalid"}, r1, r2, r3)
+	require.Error(t, err)
+}
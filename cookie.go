@@ -0,0 +1,109 @@
+package rdns
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// Length (in hex characters) of the client part of an EDNS0 cookie.
+const clientCookieLen = 16
+
+// cookieCache holds EDNS0 cookies as per RFC7873 by upstream server address. The
+// client cookie is generated once per upstream, the server cookie is learned from
+// responses and sent with subsequent queries to the same upstream.
+type cookieCache struct {
+	mu      sync.Mutex
+	cookies map[string]*upstreamCookie
+}
+
+type upstreamCookie struct {
+	client string // hex-encoded
+	server string // hex-encoded, empty until the server sent one
+}
+
+// Cookies shared by all clients that have them enabled.
+var upstreamCookies = &cookieCache{cookies: make(map[string]*upstreamCookie)}
+
+// Returns the hex-encoded cookie to send to an upstream, consisting of the client
+// cookie followed by the server cookie if one is known.
+func (c *cookieCache) get(addr string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cookie, ok := c.cookies[addr]
+	if !ok {
+		b := make([]byte, clientCookieLen/2)
+		_, _ = rand.Read(b)
+		cookie = &upstreamCookie{client: hex.EncodeToString(b)}
+		c.cookies[addr] = cookie
+	}
+	return cookie.client + cookie.server
+}
+
+// Records the server cookie from a response. Returns false if the client
+// cookie in the response doesn't match the one that was sent.
+func (c *cookieCache) update(addr, value string) bool {
+	value = strings.ToLower(value)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cookie, ok := c.cookies[addr]
+	if !ok || len(value) < clientCookieLen || value[:clientCookieLen] != cookie.client {
+		return false
+	}
+	cookie.server = value[clientCookieLen:]
+	return true
+}
+
+// Adds a cookie to the query, replacing any existing one. An OPT record is
+// added if the query doesn't have one.
+func setCookie(q *dns.Msg, cookie string) {
+	edns0 := q.IsEdns0()
+	if edns0 == nil {
+		q.SetEdns0(4096, false)
+		edns0 = q.IsEdns0()
+	}
+	removeCookie(edns0)
+	edns0.Option = append(edns0.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+}
+
+// Returns the cookie option in a message, or nil if there is none.
+func getCookie(m *dns.Msg) *dns.EDNS0_COOKIE {
+	edns0 := m.IsEdns0()
+	if edns0 == nil {
+		return nil
+	}
+	for _, opt := range edns0.Option {
+		if cookie, ok := opt.(*dns.EDNS0_COOKIE); ok {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// Removes all cookie options from an OPT record.
+func removeCookie(edns0 *dns.OPT) {
+	if edns0 == nil {
+		return
+	}
+	var options []dns.EDNS0
+	for _, opt := range edns0.Option {
+		if opt.Option() != dns.EDNS0COOKIE {
+			options = append(options, opt)
+		}
+	}
+	edns0.Option = options
+}
+
+// Removes the OPT record from a message.
+func removeEdns0(m *dns.Msg) {
+	var extra []dns.RR
+	for _, rr := range m.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	m.Extra = extra
+}
@@ -0,0 +1,133 @@
+package rdns
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// DNS64 is a resolver that synthesizes AAAA records from A records for clients in
+// IPv6-only networks that reach IPv4 hosts via a NAT64 gateway (RFC6147). If an
+// AAAA query returns no AAAA records, an A query is sent to the same resolver and
+// the IPv4 addresses are embedded into the configured IPv6 prefix.
+type DNS64 struct {
+	id       string
+	resolver Resolver
+	prefix   *net.IPNet
+}
+
+var _ Resolver = &DNS64{}
+
+// DNS64Options contain settings for the DNS64 resolver.
+type DNS64Options struct {
+	// IPv6 prefix used to synthesize AAAA records. Supported prefix lengths
+	// are 32, 40, 48, 56, 64 and 96. Default "64:ff9b::/96".
+	Prefix string
+}
+
+// NewDNS64 returns a new instance of a DNS64 resolver.
+func NewDNS64(id string, resolver Resolver, opt DNS64Options) (*DNS64, error) {
+	if opt.Prefix == "" {
+		opt.Prefix = "64:ff9b::/96"
+	}
+	ip, prefix, err := net.ParseCIDR(opt.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() != nil {
+		return nil, fmt.Errorf("dns64 prefix '%s' is not an IPv6 network", opt.Prefix)
+	}
+	switch ones, _ := prefix.Mask.Size(); ones {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return nil, fmt.Errorf("unsupported dns64 prefix length in '%s'", opt.Prefix)
+	}
+	return &DNS64{
+		id:       id,
+		resolver: resolver,
+		prefix:   prefix,
+	}, nil
+}
+
+// Resolve a DNS query and synthesize AAAA records if the upstream resolver
+// doesn't return any.
+func (r *DNS64) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	answer, err := r.resolver.Resolve(q, ci)
+	if err != nil || answer == nil || answer.Rcode != dns.RcodeSuccess {
+		return answer, err
+	}
+	if len(q.Question) < 1 || q.Question[0].Qtype != dns.TypeAAAA || q.Question[0].Qclass != dns.ClassINET {
+		return answer, nil
+	}
+	for _, rr := range answer.Answer {
+		if rr.Header().Rrtype == dns.TypeAAAA {
+			return answer, nil
+		}
+	}
+
+	// No AAAA records in the answer, query the A records instead
+	log := logger(r.id, q, ci)
+	aq := q.Copy()
+	aq.Question[0].Qtype = dns.TypeA
+	aAnswer, err := r.resolver.Resolve(aq, ci)
+	if err != nil || aAnswer == nil || aAnswer.Rcode != dns.RcodeSuccess {
+		// Return the original response if the A query failed
+		return answer, nil
+	}
+
+	// Keep any CNAMEs leading up to the A records and replace the A records
+	// with synthesized AAAA records using the same TTL.
+	var (
+		records     []dns.RR
+		synthesized bool
+	)
+	for _, rr := range aAnswer.Answer {
+		switch record := rr.(type) {
+		case *dns.A:
+			ip := r.synthesize(record.A)
+			if ip == nil {
+				continue
+			}
+			hdr := record.Hdr
+			hdr.Rrtype = dns.TypeAAAA
+			hdr.Rdlength = 0
+			records = append(records, &dns.AAAA{Hdr: hdr, AAAA: ip})
+			synthesized = true
+		case *dns.CNAME:
+			records = append(records, record)
+		}
+	}
+	if !synthesized {
+		return answer, nil
+	}
+	log.Debug("synthesizing AAAA records")
+	answer.Answer = records
+	answer.Ns = nil
+	return answer, nil
+}
+
+func (r *DNS64) String() string {
+	return r.id
+}
+
+// Embeds an IPv4 address in the IPv6 prefix as per RFC6052, section 2.2. Bits
+// 64 to 71 of the address are reserved and must be 0.
+func (r *DNS64) synthesize(ip net.IP) net.IP {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil
+	}
+	out := make(net.IP, net.IPv6len)
+	copy(out, r.prefix.IP.To16())
+	ones, _ := r.prefix.Mask.Size()
+	pos := ones / 8
+	for _, b := range ip4 {
+		if pos == 8 { // Skip the reserved octet
+			pos++
+		}
+		out[pos] = b
+		pos++
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNS64(t *testing.T) {
+	var aaaa []dns.RR
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			switch q.Question[0].Qtype {
+			case dns.TypeA:
+				cname, _ := dns.NewRR("example.com. 300 IN CNAME target.example.com.")
+				rr, _ := dns.NewRR("target.example.com. 60 IN A 192.0.2.33")
+				a.Answer = []dns.RR{cname, rr}
+			case dns.TypeAAAA:
+				a.Answer = aaaa
+			}
+			return a, nil
+		},
+	}
+	r, err := NewDNS64("test-dns64", upstream, DNS64Options{})
+	require.NoError(t, err)
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeAAAA)
+
+	// No AAAA records upstream, should be synthesized from the A record
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 2, upstream.HitCount())
+	require.Len(t, a.Answer, 2)
+	require.Equal(t, dns.TypeCNAME, a.Answer[0].Header().Rrtype)
+	synth := a.Answer[1].(*dns.AAAA)
+	require.Equal(t, "target.example.com.", synth.Hdr.Name)
+	require.Equal(t, "64:ff9b::c000:221", synth.AAAA.String())
+	require.Equal(t, uint32(60), synth.Hdr.Ttl)
+	require.Equal(t, dns.TypeAAAA, a.Question[0].Qtype)
+
+	// Real AAAA records should be returned as is
+	rr, _ := dns.NewRR("example.com. 300 IN AAAA 2001:db8::1")
+	aaaa = []dns.RR{rr}
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 3, upstream.HitCount())
+	require.Len(t, a.Answer, 1)
+	require.Equal(t, "2001:db8::1", a.Answer[0].(*dns.AAAA).AAAA.String())
+
+	// Other query types are passed through
+	q.SetQuestion("example.com.", dns.TypeA)
+	_, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 4, upstream.HitCount())
+}
+
+func TestDNS64Prefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		out    string
+	}{
+		{"2001:db8::/32", "2001:db8:c000:221::"},
+		{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+		{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+		{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+		{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+		{"2001:db8:122:344::/96", "2001:db8:122:344::c000:221"},
+	}
+	for _, test := range tests {
+		r, err := NewDNS64("test-dns64", new(TestResolver), DNS64Options{Prefix: test.prefix})
+		require.NoError(t, err)
+		ip := r.synthesize([]byte{192, 0, 2, 33})
+		require.Equal(t, test.out, ip.String(), test.prefix)
+	}
+
+	_, err := NewDNS64("test-dns64", new(TestResolver), DNS64Options{Prefix: "2001:db8::/80"})
+	require.Error(t, err)
+	_, err = NewDNS64("test-dns64", new(TestResolver), DNS64Options{Prefix: "192.0.2.0/24"})
+	require.Error(t, err)
+}
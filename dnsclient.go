@@ -2,7 +2,9 @@ package rdns
 
 import (
 	"crypto/tls"
+	"fmt"
 	"net"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/sirupsen/logrus"
@@ -15,29 +17,49 @@ type DNSClient struct {
 	net      string
 	pipeline *Pipeline
 	// Pipeline also provides operation metrics.
+	cookies bool
+	opt     DNSClientOptions
 }
 
 type DNSClientOptions struct {
 	// Local IP to use for outbound connections. If nil, a local address is chosen.
 	LocalAddr net.IP
+
+	// DSCP value (0-63) to mark outgoing packets with, for QoS. Not set if 0.
+	DSCP int
+
+	// Max number of connections to the upstream, queries are pipelined over
+	// them. Default 1.
+	PoolSize int
+
+	// Time after which an idle connection is closed. Default 10 seconds.
+	IdleTimeout time.Duration
+
+	// Send EDNS0 cookies (RFC7873) with queries and include the server cookie
+	// learned from previous responses from the same upstream.
+	EnableCookies bool
 }
 
 var _ Resolver = &DNSClient{}
 
 // NewDNSClient returns a new instance of DNSClient which is a plain DNS resolver
-// that supports pipelining over a single connection.
+// that supports pipelining over a pool of connections.
 func NewDNSClient(id, endpoint, network string, opt DNSClientOptions) (*DNSClient, error) {
 	if err := validEndpoint(endpoint); err != nil {
 		return nil, err
 	}
-	// Use a custom dialer if a local address was provided
+	if err := validDSCP(opt.DSCP); err != nil {
+		return nil, err
+	}
+	// Use a custom dialer if a local address or DSCP was provided
 	var dialer *net.Dialer
-	if opt.LocalAddr != nil {
+	if opt.LocalAddr != nil || opt.DSCP != 0 {
+		dialer = &net.Dialer{Control: dscpControl(opt.DSCP)}
 		switch network {
 		case "tcp":
-			dialer = &net.Dialer{LocalAddr: &net.TCPAddr{IP: opt.LocalAddr}}
+			dialer.LocalAddr = &net.TCPAddr{IP: opt.LocalAddr}
 		case "udp":
-			dialer = &net.Dialer{LocalAddr: &net.UDPAddr{IP: opt.LocalAddr}}
+			dialer.LocalAddr = &net.UDPAddr{IP: opt.LocalAddr}
 		}
 	}
 
@@ -51,7 +73,12 @@ func NewDNSClient(id, endpoint, network string, opt DNSClientOptions) (*DNSClien
 		id:       id,
 		net:      network,
 		endpoint: endpoint,
-		pipeline: NewPipeline(id, endpoint, client),
+		cookies:  opt.EnableCookies,
+		opt:      opt,
+		pipeline: NewPipeline(id, endpoint, client, PipelineOptions{
+			PoolSize:    opt.PoolSize,
+			IdleTimeout: opt.IdleTimeout,
+		}),
 	}, nil
 }
 
@@ -61,12 +88,46 @@ func (d *DNSClient) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
 		"resolver": d.endpoint,
 		"protocol": d.net,
 	}).Debug("querying upstream resolver")
+	ci.trace.setUpstream(d.id)
 
 	// Remove padding before sending over the wire in plain
 	stripPadding(q)
+	if d.cookies {
+		return d.resolveWithCookie(q, ci)
+	}
 	return d.pipeline.Resolve(q)
 }
 
 func (d *DNSClient) String() string {
 	return d.id
 }
+
+// Send the query with an EDNS0 cookie and record the server cookie from the
+// response. If the server responds with BADCOOKIE, the query is retried once
+// with the new server cookie. Cookies are removed from the response before
+// returning it.
+func (d *DNSClient) resolveWithCookie(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	q = q.Copy()
+	hadEdns0 := q.IsEdns0() != nil
+	for i := 0; i < 2; i++ {
+		setCookie(q, upstreamCookies.get(d.endpoint))
+		a, err := d.pipeline.Resolve(q)
+		if err != nil {
+			return nil, err
+		}
+		if cookie := getCookie(a); cookie != nil && !upstreamCookies.update(d.endpoint, cookie.Cookie) {
+			return nil, fmt.Errorf("client cookie mismatch in response from %s", d.endpoint)
+		}
+		if a.Rcode == dns.RcodeBadCookie {
+			logger(d.id, q, ci).Debug("received bad cookie response, retrying")
+			continue
+		}
+		if hadEdns0 {
+			removeCookie(a.IsEdns0())
+		} else {
+			removeEdns0(a)
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("bad cookie response from %s", d.endpoint)
+}
@@ -1,7 +1,9 @@
 package rdns
 
 import (
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
@@ -24,3 +26,74 @@ func TestDNSClientSimpleUDP(t *testing.T) {
 	require.NoError(t, err)
 	require.NotEmpty(t, r.Answer)
 }
+
+func TestDNSClientCookies(t *testing.T) {
+	addr, err := getUDPLnAddress()
+	require.NoError(t, err)
+
+	// Upstream that supports cookies, recording what was received
+	var (
+		mu           sync.Mutex
+		received     []string
+		serverCookie = "0102030405060708"
+	)
+	s := &dns.Server{Addr: addr, Net: "udp", Handler: dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+		mu.Lock()
+		defer mu.Unlock()
+		a := new(dns.Msg)
+		a.SetReply(q)
+		a.SetEdns0(4096, false)
+		cookie := getCookie(q)
+		if cookie == nil {
+			received = append(received, "")
+			_ = w.WriteMsg(a)
+			return
+		}
+		received = append(received, cookie.Cookie)
+		opt := a.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie.Cookie[:16] + serverCookie})
+		if len(cookie.Cookie) > 16 && cookie.Cookie[16:] != serverCookie {
+			a.Rcode = dns.RcodeBadCookie
+		}
+		_ = w.WriteMsg(a)
+	})}
+	go s.ListenAndServe()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	c, err := NewDNSClient("test-dns", addr, "udp", DNSClientOptions{EnableCookies: true})
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	// The first query only has a client cookie, the OPT record that was added for
+	// it is removed from the response
+	a, err := c.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Nil(t, a.IsEdns0())
+	require.Nil(t, q.IsEdns0())
+	mu.Lock()
+	require.Len(t, received[0], 16)
+	clientCookie := received[0]
+	mu.Unlock()
+
+	// The second query includes the server cookie
+	q.SetEdns0(1232, false)
+	a, err = c.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.NotNil(t, a.IsEdns0())
+	require.Nil(t, getCookie(a))
+	mu.Lock()
+	require.Equal(t, clientCookie+serverCookie, received[1])
+
+	// The server changed its cookie, the query is retried with the new one
+	serverCookie = "1112131415161718"
+	mu.Unlock()
+	a, err = c.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, received, 4)
+	require.Equal(t, clientCookie+serverCookie, received[3])
+}
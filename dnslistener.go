@@ -1,7 +1,9 @@
 package rdns
 
 import (
+	"context"
 	"net"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/sirupsen/logrus"
@@ -18,16 +20,66 @@ var _ Listener = &DNSListener{}
 type ListenOptions struct {
 	// Network allowed to query this listener.
 	AllowedNet []*net.IPNet
+
+	// Time after which idle TCP and DoT connections are closed. It's advertised
+	// to clients that use the EDNS0 TCP keepalive option. Defaults to
+	// DefaultTCPIdleTimeout.
+	IdleTimeout time.Duration
+
+	// Time after which a query is abandoned and answered with SERVFAIL. It's
+	// passed on as deadline to upstream resolvers that support cancellation,
+	// others carry on in the background until their own timeout. No limit if 0.
+	QueryTimeout time.Duration
+}
+
+// Returns the context for resolving a query received by a listener, with the
+// query timeout applied.
+func listenerContext(parent context.Context, opt ListenOptions) (context.Context, context.CancelFunc) {
+	if opt.QueryTimeout > 0 {
+		return context.WithTimeout(parent, opt.QueryTimeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// Resolves a query received by a listener. With a query timeout, the listener
+// stops waiting for the resolver once it passed, even if the resolver doesn't
+// support cancellation, and returns the error of the context.
+func listenerResolve(ctx context.Context, opt ListenOptions, r Resolver, q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if opt.QueryTimeout <= 0 {
+		return resolveContext(ctx, r, q, ci)
+	}
+	type result struct {
+		a   *dns.Msg
+		err error
+	}
+	// The resolver may still be working on the query after we stopped waiting,
+	// so it gets a copy
+	ch := make(chan result, 1)
+	rq := q.Copy()
+	go func() {
+		a, err := resolveContext(ctx, r, rq, ci)
+		ch <- result{a, err}
+	}()
+	select {
+	case res := <-ch:
+		return res.a, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
 // NewDNSListener returns an instance of either a UDP or TCP DNS listener.
 func NewDNSListener(id, addr, net string, opt ListenOptions, resolver Resolver) *DNSListener {
+	if opt.IdleTimeout == 0 {
+		opt.IdleTimeout = DefaultTCPIdleTimeout
+	}
 	return &DNSListener{
 		id: id,
 		Server: &dns.Server{
-			Addr:    addr,
-			Net:     net,
-			Handler: listenHandler(id, net, addr, resolver, opt.AllowedNet),
+			Addr:        addr,
+			Net:         net,
+			Handler:     listenHandler(id, net, addr, resolver, opt),
+			IdleTimeout: func() time.Duration { return opt.IdleTimeout },
 		},
 	}
 }
@@ -46,7 +98,7 @@ func (s DNSListener) String() string {
 }
 
 // DNS handler to forward all incoming requests to a given resolver.
-func listenHandler(id, protocol, addr string, r Resolver, allowedNet []*net.IPNet) dns.HandlerFunc {
+func listenHandler(id, protocol, addr string, r Resolver, opt ListenOptions) dns.HandlerFunc {
 	metrics := NewListenerMetrics("listener", id)
 	return func(w dns.ResponseWriter, req *dns.Msg) {
 		var (
@@ -60,19 +112,30 @@ func listenHandler(id, protocol, addr string, r Resolver, allowedNet []*net.IPNe
 		case *net.UDPAddr:
 			ci.SourceIP = addr.IP
 		}
+		if cs, ok := w.(dns.ConnectionStater); ok {
+			ci.TLSIdentity = tlsClientIdentity(cs.ConnectionState())
+		}
 
 		log := Log.WithFields(logrus.Fields{"id": id, "client": ci.SourceIP, "qname": qName(req), "protocol": protocol, "addr": addr})
+		if ci.TLSIdentity != "" {
+			log = log.WithField("identity", ci.TLSIdentity)
+		}
 		log.Debug("received query")
 		metrics.query.Add(1)
 
 		a := new(dns.Msg)
-		if isAllowed(allowedNet, ci.SourceIP) {
+		if isAllowed(opt.AllowedNet, ci.SourceIP) {
 			log.WithField("resolver", r.String()).Trace("forwarding query to resolver")
-			a, err = r.Resolve(req, ci)
+			start := time.Now()
+			ctx, cancel := listenerContext(context.Background(), opt)
+			a, err = listenerResolve(ctx, opt, r, req, ci)
+			cancel()
 			if err != nil {
 				metrics.err.Add("resolve", 1)
 				log.WithError(err).Error("failed to resolve")
 				a = servfail(req)
+			} else {
+				metrics.observeLatency(start)
 			}
 		} else {
 			metrics.err.Add("acl", 1)
@@ -87,6 +150,14 @@ func listenHandler(id, protocol, addr string, r Resolver, allowedNet []*net.IPNe
 			return
 		}
 
+		// Advertise the idle timeout to clients that use EDNS0 TCP keepalive, see rfc7828.
+		// The option must not be sent over UDP.
+		if protocol == "tcp" || protocol == "dot" {
+			setKeepalive(req, a, opt.IdleTimeout)
+		} else {
+			stripKeepalive(a)
+		}
+
 		// If the client asked via DoT and EDNS0 is enabled, the response should be padded for extra security.
 		// See rfc7830 and rfc8467.
 		if protocol == "dot" || protocol == "dtls" {
@@ -0,0 +1,40 @@
+package rdns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSListenerQueryTimeout(t *testing.T) {
+	// Upstream that takes longer than the timeout and ignores cancellation
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			time.Sleep(2 * time.Second)
+			a := new(dns.Msg)
+			a.SetReply(q)
+			return a, nil
+		},
+	}
+
+	addr, err := getUDPLnAddress()
+	require.NoError(t, err)
+	s := NewDNSListener("test-ln", addr, "udp", ListenOptions{QueryTimeout: 100 * time.Millisecond}, upstream)
+	go func() {
+		err := s.Start()
+		require.NoError(t, err)
+	}()
+	defer s.Shutdown()
+	time.Sleep(time.Second)
+
+	// The listener should answer with SERVFAIL once the timeout passed
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	start := time.Now()
+	a, err := dns.Exchange(q, addr)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeServerFailure, a.Rcode)
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+}
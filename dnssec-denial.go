@@ -0,0 +1,279 @@
+package rdns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Checks the proofs of non-existence in a response after its signatures were
+// validated. NXDOMAIN responses need to prove that the name and the wildcard
+// that could have matched it don't exist, NODATA responses that the type
+// doesn't exist, and answers expanded from a wildcard that the name they were
+// expanded for doesn't exist. See RFC4035 section 5.4 and RFC5155 section 8.
+// Returns dnssecInsecure if the proof relies on an opt-out NSEC3 record.
+func checkDenial(q, a *dns.Msg) (string, error) {
+	question := q.Question[0]
+	var (
+		nsecs  []*dns.NSEC
+		nsec3s []*dns.NSEC3
+	)
+	for _, rr := range a.Ns {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			nsecs = append(nsecs, rr)
+		case *dns.NSEC3:
+			nsec3s = append(nsec3s, rr)
+		}
+	}
+	d := &dnssecDenial{nsecs: nsecs, nsec3s: nsec3s}
+
+	// Answers synthesized from a wildcard have signatures with fewer labels than
+	// the owner name
+	for _, rr := range a.Answer {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok || strings.HasPrefix(sig.Hdr.Name, "*.") || int(sig.Labels) >= dns.CountLabel(sig.Hdr.Name) {
+			continue
+		}
+		if err := d.wildcardExpansion(sig.Hdr.Name, int(sig.Labels)); err != nil {
+			return dnssecBogus, err
+		}
+	}
+
+	// Follow the CNAME chain to the name the denial applies to
+	name := question.Name
+	if question.Qtype != dns.TypeCNAME {
+		for i := 0; i < len(a.Answer); i++ {
+			cname := findCNAME(a.Answer, name)
+			if cname == nil {
+				break
+			}
+			name = cname.Target
+		}
+	}
+
+	switch {
+	case a.Rcode == dns.RcodeNameError:
+		return d.nxdomain(name)
+	case question.Qtype == dns.TypeANY || hasRecordType(a.Answer, dns.TypeDNAME):
+		return dnssecSecure, nil
+	}
+	for _, rr := range a.Answer {
+		if equalName(rr.Header().Name, name) && rr.Header().Rrtype == question.Qtype {
+			return dnssecSecure, nil
+		}
+	}
+	return d.nodata(name, question.Qtype)
+}
+
+// NSEC and NSEC3 records from the authority section of a response.
+type dnssecDenial struct {
+	nsecs  []*dns.NSEC
+	nsec3s []*dns.NSEC3
+}
+
+// Proves that a name doesn't exist, and neither does the wildcard at its
+// closest encloser.
+func (d *dnssecDenial) nxdomain(name string) (string, error) {
+	if len(d.nsec3s) > 0 {
+		ce, optOut, err := d.closestEncloser(name)
+		if err != nil {
+			return dnssecBogus, err
+		}
+		if !d.nsec3Covered("*." + ce) {
+			return dnssecBogus, fmt.Errorf("missing proof of non-existence for wildcard *.%s", ce)
+		}
+		if optOut {
+			return dnssecInsecure, nil
+		}
+		return dnssecSecure, nil
+	}
+	nsec := d.nsecCovering(name)
+	if nsec == nil {
+		return dnssecBogus, fmt.Errorf("missing proof of non-existence for %s", name)
+	}
+	ce := nsecClosestEncloser(name, nsec)
+	if d.nsecCovering("*."+ce) == nil {
+		return dnssecBogus, fmt.Errorf("missing proof of non-existence for wildcard *.%s", ce)
+	}
+	return dnssecSecure, nil
+}
+
+// Proves that a name exists without records of the type, either directly or
+// through a wildcard.
+func (d *dnssecDenial) nodata(name string, qtype uint16) (string, error) {
+	if len(d.nsec3s) > 0 {
+		for _, rr := range d.nsec3s {
+			if rr.Match(name) {
+				return bitmapDenies(rr.TypeBitMap, name, qtype)
+			}
+		}
+		ce, optOut, err := d.closestEncloser(name)
+		if err != nil {
+			return dnssecBogus, err
+		}
+		// Insecure delegations covered by an opt-out record have no NSEC3 of
+		// their own, see RFC5155 section 8.6
+		if optOut && qtype == dns.TypeDS {
+			return dnssecInsecure, nil
+		}
+		for _, rr := range d.nsec3s {
+			if rr.Match("*." + ce) {
+				return bitmapDenies(rr.TypeBitMap, name, qtype)
+			}
+		}
+		return dnssecBogus, fmt.Errorf("missing proof of non-existence for %s %s", name, dns.TypeToString[qtype])
+	}
+	for _, rr := range d.nsecs {
+		if equalName(rr.Hdr.Name, name) {
+			return bitmapDenies(rr.TypeBitMap, name, qtype)
+		}
+	}
+	// Wildcard NODATA, the name doesn't exist but a matching wildcard without
+	// the type does
+	if nsec := d.nsecCovering(name); nsec != nil {
+		wildcard := "*." + nsecClosestEncloser(name, nsec)
+		for _, rr := range d.nsecs {
+			if equalName(rr.Hdr.Name, wildcard) {
+				return bitmapDenies(rr.TypeBitMap, name, qtype)
+			}
+		}
+	}
+	return dnssecBogus, fmt.Errorf("missing proof of non-existence for %s %s", name, dns.TypeToString[qtype])
+}
+
+// Proves that the name an answer was expanded for doesn't exist. Labels is
+// the label count of the signature, which identifies the closest encloser.
+func (d *dnssecDenial) wildcardExpansion(name string, labels int) error {
+	if len(d.nsec3s) > 0 {
+		if !d.nsec3Covered(dnssecSuffix(name, labels+1)) {
+			return fmt.Errorf("missing proof of non-existence for wildcard expansion of %s", name)
+		}
+		return nil
+	}
+	if d.nsecCovering(name) == nil {
+		return fmt.Errorf("missing proof of non-existence for wildcard expansion of %s", name)
+	}
+	return nil
+}
+
+// Finds the closest encloser of a name that doesn't exist with NSEC3 records.
+// That's the closest ancestor with a matching record whose next closer name
+// is covered. Returns true if the covering record has the opt-out flag.
+func (d *dnssecDenial) closestEncloser(name string) (string, bool, error) {
+	for labels := dns.CountLabel(name) - 1; labels >= 0; labels-- {
+		ce := dnssecSuffix(name, labels)
+		var match *dns.NSEC3
+		for _, rr := range d.nsec3s {
+			if rr.Match(ce) {
+				match = rr
+				break
+			}
+		}
+		if match == nil {
+			continue
+		}
+		// Names below a delegation or DNAME are not part of the zone
+		if isDelegation(match.TypeBitMap) || hasBit(match.TypeBitMap, dns.TypeDNAME) {
+			return "", false, fmt.Errorf("closest encloser %s of %s is a delegation or DNAME", ce, name)
+		}
+		nextCloser := dnssecSuffix(name, labels+1)
+		for _, rr := range d.nsec3s {
+			if nsec3Covers(rr, nextCloser) {
+				return ce, rr.Flags&1 == 1, nil
+			}
+		}
+		return "", false, fmt.Errorf("missing proof of non-existence for %s", nextCloser)
+	}
+	return "", false, fmt.Errorf("missing closest encloser proof for %s", name)
+}
+
+func (d *dnssecDenial) nsec3Covered(name string) bool {
+	for _, rr := range d.nsec3s {
+		if nsec3Covers(rr, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the NSEC record that proves a name doesn't exist, or nil.
+func (d *dnssecDenial) nsecCovering(name string) *dns.NSEC {
+	for _, rr := range d.nsecs {
+		if nsecCovers(rr, name) {
+			return rr
+		}
+	}
+	return nil
+}
+
+// Returns an error if the type bitmap of the record for a name contains the
+// type, or a CNAME that should have been followed.
+func bitmapDenies(bitmap []uint16, name string, qtype uint16) (string, error) {
+	if hasBit(bitmap, qtype) || hasBit(bitmap, dns.TypeCNAME) {
+		return dnssecBogus, fmt.Errorf("proof of non-existence for %s %s lists the type", name, dns.TypeToString[qtype])
+	}
+	return dnssecSecure, nil
+}
+
+// Returns true if the name falls between the owner and next name of the NSEC
+// record in canonical order, meaning the name doesn't exist. The record of a
+// delegation in the parent zone can't prove anything about names below it.
+func nsecCovers(rr *dns.NSEC, name string) bool {
+	owner, next := rr.Hdr.Name, rr.NextDomain
+	if isDelegation(rr.TypeBitMap) && dns.IsSubDomain(owner, name) {
+		return false
+	}
+	if dnssecCompare(owner, next) < 0 {
+		return dnssecCompare(owner, name) < 0 && dnssecCompare(name, next) < 0
+	}
+	// Last record in the zone, the next name is the apex
+	return dns.IsSubDomain(next, name) && dnssecCompare(owner, name) < 0
+}
+
+// Returns true if the type bitmap is that of a delegation to a child zone.
+func isDelegation(bitmap []uint16) bool {
+	return hasBit(bitmap, dns.TypeNS) && !hasBit(bitmap, dns.TypeSOA)
+}
+
+// Returns true if the hash of the name falls between the owner and next hash
+// of the NSEC3 record. Unlike NSEC3.Cover, a matching hash isn't covered.
+func nsec3Covers(rr *dns.NSEC3, name string) bool {
+	return rr.Cover(name) && !rr.Match(name)
+}
+
+// Returns the closest encloser of a name that's covered by an NSEC record,
+// the longest ancestor it shares with the owner or next name of the record.
+func nsecClosestEncloser(name string, rr *dns.NSEC) string {
+	n := dns.CompareDomainName(name, rr.Hdr.Name)
+	if m := dns.CompareDomainName(name, rr.NextDomain); m > n {
+		n = m
+	}
+	return dnssecSuffix(name, n)
+}
+
+// Returns the last n labels of a name.
+func dnssecSuffix(name string, n int) string {
+	name = dns.Fqdn(name)
+	idx := dns.Split(name)
+	if n <= 0 || len(idx) == 0 {
+		return "."
+	}
+	if n >= len(idx) {
+		return name
+	}
+	return name[idx[len(idx)-n]:]
+}
+
+// Compares two names in canonical DNS order, see RFC4034 section 6.1.
+func dnssecCompare(a, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
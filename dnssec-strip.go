@@ -0,0 +1,61 @@
+package rdns
+
+import (
+	"expvar"
+
+	"github.com/miekg/dns"
+)
+
+// DNSSECStrip is a resolver that removes DNSSEC records from responses if the
+// client didn't set the DO bit in its query. Validating upstream resolvers can
+// return RRSIG or NSEC records regardless, which only waste bytes for clients
+// that can't use them. Records of the queried type are kept, so explicit queries
+// for DNSKEY for example still get an answer. The AD bit is left unchanged.
+type DNSSECStrip struct {
+	id       string
+	resolver Resolver
+	saved    *expvar.Int
+}
+
+var _ Resolver = &DNSSECStrip{}
+
+// NewDNSSECStrip returns a new instance of a DNSSEC record stripper.
+func NewDNSSECStrip(id string, resolver Resolver) *DNSSECStrip {
+	return &DNSSECStrip{
+		id:       id,
+		resolver: resolver,
+		saved:    getVarInt("router", id, "size_saved"),
+	}
+}
+
+// Resolve a DNS query with the upstream resolver and strip DNSSEC records from
+// the response unless the client asked for them.
+func (r *DNSSECStrip) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	answer, err := r.resolver.Resolve(q, ci)
+	if err != nil || answer == nil || len(q.Question) < 1 {
+		return answer, err
+	}
+	if edns0 := q.IsEdns0(); edns0 != nil && edns0.Do() {
+		return answer, nil
+	}
+	qtype := q.Question[0].Qtype
+	before := answer.Len()
+	answer.Answer = stripDNSSEC(answer.Answer, qtype)
+	answer.Ns = stripDNSSEC(answer.Ns, qtype)
+	answer.Extra = stripDNSSEC(answer.Extra, qtype)
+
+	// The OPT record in the response shouldn't claim DNSSEC support the client
+	// didn't ask for
+	if opt := answer.IsEdns0(); opt != nil {
+		opt.SetDo(false)
+	}
+	if saved := before - answer.Len(); saved > 0 {
+		logger(r.id, q, ci).WithField("bytes", saved).Debug("stripped dnssec records")
+		r.saved.Add(int64(saved))
+	}
+	return answer, nil
+}
+
+func (r *DNSSECStrip) String() string {
+	return r.id
+}
@@ -0,0 +1,63 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSSECStrip(t *testing.T) {
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.AuthenticatedData = true
+			for _, s := range []string{
+				"example.com. 60 IN A 192.0.2.1",
+				"example.com. 60 IN RRSIG A 13 2 60 20300101000000 20200101000000 12345 example.com. dGVzdA==",
+				"example.com. 60 IN DNSKEY 257 3 13 dGVzdA==",
+			} {
+				rr, err := dns.NewRR(s)
+				require.NoError(t, err)
+				a.Answer = append(a.Answer, rr)
+			}
+			nsec, err := dns.NewRR("example.com. 60 IN NSEC www.example.com. A RRSIG NSEC")
+			require.NoError(t, err)
+			a.Ns = append(a.Ns, nsec)
+			a.SetEdns0(4096, true)
+			return a, nil
+		},
+	}
+	r := NewDNSSECStrip("test-dnssec-strip", upstream)
+
+	// No DO bit in the query, DNSSEC records are removed
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	q.SetEdns0(4096, false)
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 1)
+	require.Equal(t, dns.TypeA, a.Answer[0].Header().Rrtype)
+	require.Empty(t, a.Ns)
+	require.False(t, a.IsEdns0().Do())
+	require.True(t, a.AuthenticatedData)
+	require.Greater(t, r.saved.Value(), int64(0))
+
+	// Records of the queried type are kept
+	q.SetQuestion("example.com.", dns.TypeDNSKEY)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 2)
+	require.Equal(t, dns.TypeDNSKEY, a.Answer[1].Header().Rrtype)
+
+	// DO bit is set, the response is unmodified
+	q = new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	q.SetEdns0(4096, true)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 3)
+	require.Len(t, a.Ns, 1)
+	require.True(t, a.IsEdns0().Do())
+}
@@ -0,0 +1,506 @@
+package rdns
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNSSECValidator is a resolver that validates the DNSSEC signatures of responses
+// from its upstream resolver, starting at a trust anchor. The DNSKEY and DS records
+// needed to build the chain of trust are queried through the upstream resolver
+// as well and cached. Validated responses have the AD bit set, insecure responses
+// have it cleared, and bogus responses are answered with SERVFAIL. Queries with the
+// CD bit set are passed through without validation.
+//
+// Negative responses and answers expanded from a wildcard are only secure if their
+// NSEC or NSEC3 records prove that the name or type doesn't exist.
+type DNSSECValidator struct {
+	id       string
+	resolver Resolver
+	opt      DNSSECValidatorOptions
+	anchors  []*dns.DS
+
+	mu    sync.Mutex
+	zones map[string]*dnssecZone // Validation state by name
+
+	metrics *expvar.Map
+}
+
+var _ Resolver = &DNSSECValidator{}
+
+// DNSSECValidatorOptions contain settings for the DNSSEC validator.
+type DNSSECValidatorOptions struct {
+	// Trust anchors as DS records, like ". IN DS 20326 8 2 E06D...". Defaults
+	// to DNSSECRootAnchors.
+	TrustAnchors []string
+
+	// What to do with bogus responses, "servfail" (default) or "pass". With
+	// "pass", bogus responses are logged and returned without the AD bit.
+	BogusAction string
+}
+
+// DNSSECRootAnchors are the DS records of the root zone KSKs.
+var DNSSECRootAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// Results of validating a response, as reported in the metrics.
+const (
+	dnssecSecure   = "secure"
+	dnssecInsecure = "insecure"
+	dnssecBogus    = "bogus"
+)
+
+// Max number of names to hold validation state for. The cache is reset when
+// it's full.
+const dnssecMaxZones = 10000
+
+// Max time to cache validation state, independent of the record TTLs.
+const dnssecMaxTTL = time.Hour
+
+// The validation state of a name, that is the closest enclosing zone that is
+// either secure with its validated keys, or the start of an insecure delegation.
+type dnssecZone struct {
+	zone     string
+	keys     []*dns.DNSKEY // Nil if insecure
+	insecure bool
+	expires  time.Time
+}
+
+// NewDNSSECValidator returns a new instance of a DNSSEC validator.
+func NewDNSSECValidator(id string, resolver Resolver, opt DNSSECValidatorOptions) (*DNSSECValidator, error) {
+	switch opt.BogusAction {
+	case "":
+		opt.BogusAction = "servfail"
+	case "servfail", "pass":
+	default:
+		return nil, fmt.Errorf("unsupported bogus action '%s'", opt.BogusAction)
+	}
+	if len(opt.TrustAnchors) == 0 {
+		opt.TrustAnchors = DNSSECRootAnchors
+	}
+	var anchors []*dns.DS
+	for _, s := range opt.TrustAnchors {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return nil, err
+		}
+		ds, ok := rr.(*dns.DS)
+		if !ok {
+			return nil, fmt.Errorf("trust anchor '%s' is not a DS record", s)
+		}
+		if len(anchors) > 0 && !strings.EqualFold(ds.Hdr.Name, anchors[0].Hdr.Name) {
+			return nil, errors.New("all trust anchors need to be for the same zone")
+		}
+		anchors = append(anchors, ds)
+	}
+	return &DNSSECValidator{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		anchors:  anchors,
+		zones:    make(map[string]*dnssecZone),
+		metrics:  getVarMap("router", id, "result"),
+	}, nil
+}
+
+// Resolve a DNS query with the upstream resolver and validate the response.
+func (r *DNSSECValidator) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return nil, errors.New("no question in query")
+	}
+	log := logger(r.id, q, ci)
+
+	// The client wants to do the validation itself
+	if q.CheckingDisabled {
+		log.WithField("resolver", r.resolver).Debug("checking disabled, forwarding query to resolver")
+		return r.resolver.Resolve(q, ci)
+	}
+
+	// Ask for signatures and disable validation upstream so bogus responses can
+	// be passed on if configured
+	edns0 := q.IsEdns0()
+	do := edns0 != nil && edns0.Do()
+	vq := q.Copy()
+	vq.CheckingDisabled = true
+	if opt := vq.IsEdns0(); opt != nil {
+		opt.SetDo()
+	} else {
+		vq.SetEdns0(4096, true)
+	}
+	log.WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+	a, err := r.resolver.Resolve(vq, ci)
+	if err != nil || a == nil {
+		return a, err
+	}
+	if a.Rcode != dns.RcodeSuccess && a.Rcode != dns.RcodeNameError {
+		return a, nil
+	}
+
+	result, err := r.validate(q, a, ci)
+	r.metrics.Add(result, 1)
+	a.CheckingDisabled = false
+	a.AuthenticatedData = result == dnssecSecure
+	if result == dnssecBogus {
+		log := log.WithError(err)
+		if r.opt.BogusAction == "servfail" {
+			log.Debug("validation failed, responding with servfail")
+			return servfail(q), nil
+		}
+		log.Warn("validation failed, passing on bogus response")
+	} else {
+		log.WithField("result", result).Debug("validated response")
+	}
+
+	// Remove the records the client didn't ask for
+	if !do {
+		a.Answer = stripDNSSEC(a.Answer, q.Question[0].Qtype)
+		a.Ns = stripDNSSEC(a.Ns, q.Question[0].Qtype)
+		a.Extra = stripDNSSEC(a.Extra, q.Question[0].Qtype)
+		if edns0 == nil {
+			removeEdns0(a)
+		} else if opt := a.IsEdns0(); opt != nil {
+			opt.SetDo(false)
+		}
+	}
+	return a, nil
+}
+
+func (r *DNSSECValidator) String() string {
+	return r.id
+}
+
+// Validates the records in the answer and authority sections of a response.
+// Returns the result and the reason if it's bogus.
+func (r *DNSSECValidator) validate(q, a *dns.Msg, ci ClientInfo) (string, error) {
+	rrsets, sigs := dnssecRRsets(append(append([]dns.RR{}, a.Answer...), a.Ns...))
+
+	// A response without any records needs to be from an insecure zone
+	if len(rrsets) == 0 {
+		z, err := r.zoneFor(q.Question[0].Name, ci)
+		if err != nil {
+			return dnssecBogus, err
+		}
+		if z.insecure {
+			return dnssecInsecure, nil
+		}
+		return dnssecBogus, errors.New("missing proof of non-existence")
+	}
+
+	result := dnssecSecure
+	for key, rrset := range rrsets {
+		owner := rrset[0].Header().Name
+		rsigs := sigs[key]
+		if len(rsigs) == 0 {
+			// CNAMEs synthesized from a DNAME are not signed
+			if key.t == dns.TypeCNAME && hasRecordType(a.Answer, dns.TypeDNAME) {
+				continue
+			}
+			z, err := r.zoneFor(owner, ci)
+			if err != nil {
+				return dnssecBogus, err
+			}
+			if !z.insecure {
+				return dnssecBogus, fmt.Errorf("missing signature for %s %s", owner, dns.TypeToString[key.t])
+			}
+			result = dnssecInsecure
+			continue
+		}
+		signer := rsigs[0].SignerName
+		if !dns.IsSubDomain(signer, owner) {
+			return dnssecBogus, fmt.Errorf("signer %s not authoritative for %s", signer, owner)
+		}
+		z, err := r.zoneFor(signer, ci)
+		if err != nil {
+			return dnssecBogus, err
+		}
+		if z.insecure {
+			result = dnssecInsecure
+			continue
+		}
+		if err := verifyRRset(rrset, rsigs, z); err != nil {
+			return dnssecBogus, err
+		}
+	}
+	if result == dnssecSecure {
+		return checkDenial(q, a)
+	}
+	return result, nil
+}
+
+// Returns the validation state for a name, walking down the chain of trust
+// from the trust anchor. Returns an error if the chain is bogus.
+func (r *DNSSECValidator) zoneFor(name string, ci ClientInfo) (*dnssecZone, error) {
+	name = dns.CanonicalName(name)
+	r.mu.Lock()
+	z, ok := r.zones[name]
+	r.mu.Unlock()
+	if ok && time.Now().Before(z.expires) {
+		return z, nil
+	}
+
+	var err error
+	anchor := dns.CanonicalName(r.anchors[0].Hdr.Name)
+	switch {
+	case name == anchor:
+		var keys []*dns.DNSKEY
+		var ttl uint32
+		keys, ttl, err = r.fetchKeys(name, r.anchors, ci)
+		z = &dnssecZone{zone: name, keys: keys, expires: dnssecExpiry(ttl)}
+	case !dns.IsSubDomain(anchor, name):
+		// No trust anchor for this name
+		z = &dnssecZone{zone: name, insecure: true, expires: dnssecExpiry(uint32(dnssecMaxTTL.Seconds()))}
+	default:
+		off, _ := dns.NextLabel(name, 0)
+		var parent *dnssecZone
+		parent, err = r.zoneFor(name[off:], ci)
+		if err != nil {
+			return nil, err
+		}
+		if parent.insecure {
+			z = parent
+		} else {
+			z, err = r.delegation(name, parent, ci)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	if len(r.zones) >= dnssecMaxZones {
+		r.zones = make(map[string]*dnssecZone)
+	}
+	r.zones[name] = z
+	r.mu.Unlock()
+	return z, nil
+}
+
+// Determines if a name below a secure zone is a secure delegation, an insecure
+// delegation, or part of the parent zone by querying its DS records.
+func (r *DNSSECValidator) delegation(name string, parent *dnssecZone, ci ClientInfo) (*dnssecZone, error) {
+	a, err := r.query(name, dns.TypeDS, ci)
+	if err != nil {
+		return nil, err
+	}
+	rrsets, sigs := dnssecRRsets(append(append([]dns.RR{}, a.Answer...), a.Ns...))
+
+	// Secure delegation, validate the DS records with the keys of the parent and
+	// use them to validate the keys of the child zone
+	key := dnssecRRsetKey{name: name, t: dns.TypeDS}
+	if ds := rrsets[key]; a.Rcode == dns.RcodeSuccess && len(ds) > 0 {
+		if err := verifyRRset(ds, sigs[key], parent); err != nil {
+			return nil, err
+		}
+		var anchors []*dns.DS
+		for _, rr := range ds {
+			anchors = append(anchors, rr.(*dns.DS))
+		}
+		keys, ttl, err := r.fetchKeys(name, anchors, ci)
+		if err != nil {
+			return nil, err
+		}
+		return &dnssecZone{zone: name, keys: keys, expires: dnssecExpiry(rrsetMinTTL(ttl, ds))}, nil
+	}
+
+	// No DS records, the denial needs to be signed by the parent
+	var denial []dns.RR
+	for key, rrset := range rrsets {
+		if key.t == dns.TypeCNAME { // Not a zone cut
+			return parent, nil
+		}
+		if key.t != dns.TypeNSEC && key.t != dns.TypeNSEC3 {
+			continue
+		}
+		if err := verifyRRset(rrset, sigs[key], parent); err != nil {
+			return nil, err
+		}
+		denial = append(denial, rrset...)
+	}
+	if len(denial) == 0 {
+		return nil, fmt.Errorf("missing proof of non-existence for %s DS", name)
+	}
+	if a.Rcode == dns.RcodeNameError || !insecureDelegation(name, denial) {
+		return parent, nil
+	}
+	return &dnssecZone{zone: name, insecure: true, expires: dnssecExpiry(rrsetMinTTL(uint32(dnssecMaxTTL.Seconds()), denial))}, nil
+}
+
+// Queries the DNSKEY records of a zone and validates them with the DS records.
+// Returns all keys of the zone and the TTL they can be cached for.
+func (r *DNSSECValidator) fetchKeys(zone string, anchors []*dns.DS, ci ClientInfo) ([]*dns.DNSKEY, uint32, error) {
+	a, err := r.query(zone, dns.TypeDNSKEY, ci)
+	if err != nil {
+		return nil, 0, err
+	}
+	rrsets, sigs := dnssecRRsets(a.Answer)
+	key := dnssecRRsetKey{name: zone, t: dns.TypeDNSKEY}
+	rrset := rrsets[key]
+	if len(rrset) == 0 {
+		return nil, 0, fmt.Errorf("no DNSKEY records for %s", zone)
+	}
+	var keys []*dns.DNSKEY
+	for _, rr := range rrset {
+		keys = append(keys, rr.(*dns.DNSKEY))
+	}
+
+	// The keys are valid if they're signed by one of the keys that match a DS
+	var trusted []*dns.DNSKEY
+	for _, k := range keys {
+		for _, ds := range anchors {
+			if k.KeyTag() != ds.KeyTag || k.Algorithm != ds.Algorithm {
+				continue
+			}
+			if d := k.ToDS(ds.DigestType); d != nil && strings.EqualFold(d.Digest, ds.Digest) {
+				trusted = append(trusted, k)
+			}
+		}
+	}
+	if len(trusted) == 0 {
+		return nil, 0, fmt.Errorf("no DNSKEY for %s matches the DS records", zone)
+	}
+	if err := verifyRRset(rrset, sigs[key], &dnssecZone{zone: zone, keys: trusted}); err != nil {
+		return nil, 0, err
+	}
+	return keys, rrsetMinTTL(uint32(dnssecMaxTTL.Seconds()), rrset), nil
+}
+
+// Sends a query for DNSSEC records through the upstream resolver.
+func (r *DNSSECValidator) query(name string, qtype uint16, ci ClientInfo) (*dns.Msg, error) {
+	q := new(dns.Msg)
+	q.SetQuestion(name, qtype)
+	q.CheckingDisabled = true
+	q.SetEdns0(4096, true)
+	a, err := r.resolver.Resolve(q, ci)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil {
+		return nil, fmt.Errorf("no response for %s %s", name, dns.TypeToString[qtype])
+	}
+	if a.Rcode != dns.RcodeSuccess && a.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("unexpected response code %s for %s %s", dns.RcodeToString[a.Rcode], name, dns.TypeToString[qtype])
+	}
+	return a, nil
+}
+
+// Verifies the signatures of an RRset with the keys of a zone. At least one of
+// the signatures needs to be valid.
+func verifyRRset(rrset []dns.RR, sigs []*dns.RRSIG, z *dnssecZone) error {
+	owner := rrset[0].Header().Name
+	rrtype := dns.TypeToString[rrset[0].Header().Rrtype]
+	if len(sigs) == 0 {
+		return fmt.Errorf("missing signature for %s %s", owner, rrtype)
+	}
+	now := time.Now()
+	for _, sig := range sigs {
+		if !strings.EqualFold(sig.SignerName, z.zone) || !sig.ValidityPeriod(now) {
+			continue
+		}
+		for _, k := range z.keys {
+			if k.KeyTag() == sig.KeyTag && sig.Verify(k, rrset) == nil {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no valid signature for %s %s", owner, rrtype)
+}
+
+// Returns true if the NSEC or NSEC3 records prove that a name is a delegation
+// without DS records.
+func insecureDelegation(name string, denial []dns.RR) bool {
+	for _, rr := range denial {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			if strings.EqualFold(rr.Hdr.Name, name) {
+				return hasBit(rr.TypeBitMap, dns.TypeNS) && !hasBit(rr.TypeBitMap, dns.TypeDS) && !hasBit(rr.TypeBitMap, dns.TypeSOA)
+			}
+		case *dns.NSEC3:
+			if rr.Match(name) {
+				return hasBit(rr.TypeBitMap, dns.TypeNS) && !hasBit(rr.TypeBitMap, dns.TypeDS) && !hasBit(rr.TypeBitMap, dns.TypeSOA)
+			}
+		}
+	}
+	// The name could be covered by an opt-out NSEC3 record, in which case it's an
+	// unsigned delegation as well
+	for _, rr := range denial {
+		if rr, ok := rr.(*dns.NSEC3); ok && rr.Flags&1 == 1 && rr.Cover(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasBit(bitmap []uint16, t uint16) bool {
+	for _, b := range bitmap {
+		if b == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Identifies an RRset by its owner and type.
+type dnssecRRsetKey struct {
+	name string
+	t    uint16
+}
+
+// Groups records into RRsets and their signatures.
+func dnssecRRsets(rrs []dns.RR) (map[dnssecRRsetKey][]dns.RR, map[dnssecRRsetKey][]*dns.RRSIG) {
+	rrsets := make(map[dnssecRRsetKey][]dns.RR)
+	sigs := make(map[dnssecRRsetKey][]*dns.RRSIG)
+	for _, rr := range rrs {
+		h := rr.Header()
+		switch rr := rr.(type) {
+		case *dns.RRSIG:
+			key := dnssecRRsetKey{name: dns.CanonicalName(h.Name), t: rr.TypeCovered}
+			sigs[key] = append(sigs[key], rr)
+		case *dns.OPT:
+		default:
+			key := dnssecRRsetKey{name: dns.CanonicalName(h.Name), t: h.Rrtype}
+			rrsets[key] = append(rrsets[key], rr)
+		}
+	}
+	return rrsets, sigs
+}
+
+// Removes DNSSEC records from a response, unless they were queried for.
+func stripDNSSEC(rrs []dns.RR, qtype uint16) []dns.RR {
+	var out []dns.RR
+	for _, rr := range rrs {
+		switch t := rr.Header().Rrtype; t {
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3, dns.TypeDNSKEY:
+			if t != qtype {
+				continue
+			}
+		}
+		out = append(out, rr)
+	}
+	return out
+}
+
+// Returns the lowest TTL of the records, or the given TTL if it's lower.
+func rrsetMinTTL(ttl uint32, rrs []dns.RR) uint32 {
+	for _, rr := range rrs {
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	return ttl
+}
+
+// Returns the time validation state can be cached until.
+func dnssecExpiry(ttl uint32) time.Time {
+	d := time.Duration(ttl) * time.Second
+	if d > dnssecMaxTTL {
+		d = dnssecMaxTTL
+	}
+	return time.Now().Add(d)
+}
@@ -0,0 +1,213 @@
+package rdns
+
+import (
+	"crypto"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// Signed test hierarchy with a root zone, a secure delegation to "example." and
+// an insecure delegation to "insecure.".
+type dnssecTestData struct {
+	anchor    string
+	responses map[string]*dns.Msg // By "<name> <type>"
+}
+
+func newDNSSECTestData(t *testing.T) *dnssecTestData {
+	rootKey, rootPriv := dnssecTestKey(t, ".")
+	exampleKey, examplePriv := dnssecTestKey(t, "example.")
+	d := &dnssecTestData{
+		anchor:    rootKey.ToDS(dns.SHA256).String(),
+		responses: make(map[string]*dns.Msg),
+	}
+
+	d.add(". DNSKEY", dns.RcodeSuccess, dnssecTestSign(t, rootKey, rootPriv, rootKey), nil)
+	d.add("example. DNSKEY", dns.RcodeSuccess, dnssecTestSign(t, exampleKey, examplePriv, exampleKey), nil)
+	d.add("example. DS", dns.RcodeSuccess, dnssecTestSign(t, rootKey, rootPriv, exampleKey.ToDS(dns.SHA256)), nil)
+	d.add("insecure. DS", dns.RcodeSuccess, nil,
+		dnssecTestSign(t, rootKey, rootPriv, dnssecTestRR(t, "insecure. 300 IN NSEC next. NS RRSIG NSEC")))
+	d.add("www.example. DS", dns.RcodeSuccess, nil,
+		dnssecTestSign(t, exampleKey, examplePriv, dnssecTestRR(t, "www.example. 300 IN NSEC example. A RRSIG NSEC")))
+	d.add("bad.example. DS", dns.RcodeSuccess, nil,
+		dnssecTestSign(t, exampleKey, examplePriv, dnssecTestRR(t, "bad.example. 300 IN NSEC example. A RRSIG NSEC")))
+
+	// Secure, insecure, and tampered responses
+	d.add("www.example. A", dns.RcodeSuccess,
+		dnssecTestSign(t, exampleKey, examplePriv, dnssecTestRR(t, "www.example. 300 IN A 192.0.2.1")), nil)
+	d.add("www.insecure. A", dns.RcodeSuccess, []dns.RR{dnssecTestRR(t, "www.insecure. 300 IN A 192.0.2.2")}, nil)
+	bad := dnssecTestSign(t, exampleKey, examplePriv, dnssecTestRR(t, "bad.example. 300 IN A 192.0.2.3"))
+	bad[0].(*dns.A).A[3] = 4
+	d.add("bad.example. A", dns.RcodeSuccess, bad, nil)
+	d.add("unsigned.example. A", dns.RcodeSuccess, []dns.RR{dnssecTestRR(t, "unsigned.example. 300 IN A 192.0.2.5")}, nil)
+
+	// Negative responses with and without proof of non-existence
+	soa := dnssecTestSign(t, exampleKey, examplePriv, dnssecTestRR(t, "example. 300 IN SOA ns.example. admin.example. 1 3600 600 86400 300"))
+	apexNSEC := dnssecTestSign(t, exampleKey, examplePriv, dnssecTestRR(t, "example. 300 IN NSEC www.example. NS SOA RRSIG NSEC DNSKEY"))
+	wwwNSEC := dnssecTestSign(t, exampleKey, examplePriv, dnssecTestRR(t, "www.example. 300 IN NSEC example. A RRSIG NSEC"))
+	d.add("missing.example. A", dns.RcodeNameError, nil, append(append([]dns.RR{}, soa...), apexNSEC...))
+	d.add("forged.example. A", dns.RcodeNameError, nil, soa)
+	d.add("www.example. AAAA", dns.RcodeSuccess, nil, append(append([]dns.RR{}, soa...), wwwNSEC...))
+	d.add("www.example. TXT", dns.RcodeSuccess, nil, soa)
+	d.add("www.example. MX", dns.RcodeSuccess, nil, append(append([]dns.RR{}, soa...),
+		dnssecTestSign(t, exampleKey, examplePriv, dnssecTestRR(t, "www.example. 300 IN NSEC example. A MX RRSIG NSEC"))...))
+
+	// The NSEC of the delegation in the root zone can't deny names in the child
+	d.add("sub.example. A", dns.RcodeNameError, nil, append(append([]dns.RR{}, soa...),
+		dnssecTestSign(t, rootKey, rootPriv, dnssecTestRR(t, "example. 300 IN NSEC insecure. NS DS RRSIG NSEC"))...))
+
+	// Answers expanded from a wildcard, with and without proof that the name
+	// doesn't exist
+	wild := dnssecTestSign(t, exampleKey, examplePriv, dnssecTestRR(t, "*.wild.example. 300 IN A 192.0.2.6"))
+	for _, rr := range wild {
+		rr.Header().Name = "a.wild.example."
+	}
+	d.add("a.wild.example. A", dns.RcodeSuccess, wild, apexNSEC)
+	forgedWild := make([]dns.RR, 0, len(wild))
+	for _, rr := range wild {
+		rr = dns.Copy(rr)
+		rr.Header().Name = "b.wild.example."
+		forgedWild = append(forgedWild, rr)
+	}
+	d.add("b.wild.example. A", dns.RcodeSuccess, forgedWild, nil)
+	return d
+}
+
+func (d *dnssecTestData) add(key string, rcode int, answer, ns []dns.RR) {
+	a := new(dns.Msg)
+	a.Rcode = rcode
+	a.Answer = answer
+	a.Ns = ns
+	d.responses[key] = a
+}
+
+func (d *dnssecTestData) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	question := q.Question[0]
+	a, ok := d.responses[question.Name+" "+dns.TypeToString[question.Qtype]]
+	if !ok {
+		return nxdomain(q), nil
+	}
+	a = a.Copy()
+	a.SetRcode(q, a.Rcode)
+	return a, nil
+}
+
+func (d *dnssecTestData) String() string {
+	return "dnssec-test"
+}
+
+func dnssecTestKey(t *testing.T, zone string) (*dns.DNSKEY, crypto.Signer) {
+	k := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 300},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := k.Generate(256)
+	require.NoError(t, err)
+	return k, priv.(crypto.Signer)
+}
+
+// Returns the records with a signature.
+func dnssecTestSign(t *testing.T, k *dns.DNSKEY, priv crypto.Signer, rrs ...dns.RR) []dns.RR {
+	sig := &dns.RRSIG{
+		Algorithm:  k.Algorithm,
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		KeyTag:     k.KeyTag(),
+		SignerName: k.Hdr.Name,
+	}
+	require.NoError(t, sig.Sign(priv, rrs))
+	return append(rrs, sig)
+}
+
+func dnssecTestRR(t *testing.T, s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	require.NoError(t, err)
+	return rr
+}
+
+func TestDNSSECValidator(t *testing.T) {
+	d := newDNSSECTestData(t)
+	r, err := NewDNSSECValidator("test-dnssec", d, DNSSECValidatorOptions{TrustAnchors: []string{d.anchor}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		rcode int
+		ad    bool
+	}{
+		{"www.example.", dns.RcodeSuccess, true},
+		{"www.insecure.", dns.RcodeSuccess, false},
+		{"bad.example.", dns.RcodeServerFailure, false},
+		{"unsigned.example.", dns.RcodeServerFailure, false},
+	}
+	for _, test := range tests {
+		q := new(dns.Msg)
+		q.SetQuestion(test.name, dns.TypeA)
+		a, err := r.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+		require.Equal(t, test.rcode, a.Rcode, test.name)
+		require.Equal(t, test.ad, a.AuthenticatedData, test.name)
+		if test.rcode == dns.RcodeSuccess {
+			// Signatures are removed since the client didn't ask for them
+			require.Len(t, a.Answer, 1, test.name)
+			require.Nil(t, a.IsEdns0(), test.name)
+		}
+	}
+
+	// Negative and wildcard responses need a proof of non-existence
+	denialTests := []struct {
+		name  string
+		qtype uint16
+		rcode int
+		ad    bool
+	}{
+		{"missing.example.", dns.TypeA, dns.RcodeNameError, true},
+		{"forged.example.", dns.TypeA, dns.RcodeServerFailure, false},
+		{"www.example.", dns.TypeAAAA, dns.RcodeSuccess, true},
+		{"www.example.", dns.TypeTXT, dns.RcodeServerFailure, false},
+		{"www.example.", dns.TypeMX, dns.RcodeServerFailure, false},
+		{"sub.example.", dns.TypeA, dns.RcodeServerFailure, false},
+		{"a.wild.example.", dns.TypeA, dns.RcodeSuccess, true},
+		{"b.wild.example.", dns.TypeA, dns.RcodeServerFailure, false},
+	}
+	for _, test := range denialTests {
+		q := new(dns.Msg)
+		q.SetQuestion(test.name, test.qtype)
+		a, err := r.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+		require.Equal(t, test.rcode, a.Rcode, test.name)
+		require.Equal(t, test.ad, a.AuthenticatedData, test.name)
+	}
+
+	// Signatures are returned with the DO bit
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.", dns.TypeA)
+	q.SetEdns0(4096, true)
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.True(t, a.AuthenticatedData)
+	require.Len(t, a.Answer, 2)
+
+	// Bogus responses can be passed through
+	r, err = NewDNSSECValidator("test-dnssec", d, DNSSECValidatorOptions{TrustAnchors: []string{d.anchor}, BogusAction: "pass"})
+	require.NoError(t, err)
+	q = new(dns.Msg)
+	q.SetQuestion("bad.example.", dns.TypeA)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.False(t, a.AuthenticatedData)
+
+	// Everything is bogus with the wrong trust anchor
+	r, err = NewDNSSECValidator("test-dnssec", d, DNSSECValidatorOptions{})
+	require.NoError(t, err)
+	q = new(dns.Msg)
+	q.SetQuestion("www.example.", dns.TypeA)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeServerFailure, a.Rcode)
+}
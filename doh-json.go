@@ -0,0 +1,185 @@
+package rdns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// Response of the JSON DoH API as offered by Google and Cloudflare.
+type dohJSONResponse struct {
+	Status     int
+	TC         bool
+	RD         bool
+	RA         bool
+	AD         bool
+	CD         bool
+	Answer     []dohJSONRecord
+	Authority  []dohJSONRecord
+	Additional []dohJSONRecord
+}
+
+type dohJSONRecord struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// Content types accepted in JSON DoH responses. Google uses "application/json"
+// while Cloudflare responds with "application/dns-json".
+var dohJSONContentTypes = map[string]bool{
+	"application/dns-json":     true,
+	"application/json":         true,
+	"application/x-javascript": true,
+}
+
+// ResolveJSON resolves a DNS query via the JSON DoH API, using the GET method with
+// the name and type as query parameters.
+func (d *DoHClient) ResolveJSON(ctx context.Context, q *dns.Msg) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return nil, errors.New("no question in query")
+	}
+	question := q.Question[0]
+
+	// The URL could be a template. Process it without values, the query is
+	// added as parameters.
+	u, err := d.template.Expand(map[string]interface{}{})
+	if err != nil {
+		d.metrics.err.Add("template", 1)
+		return nil, err
+	}
+	reqURL, err := url.Parse(u)
+	if err != nil {
+		d.metrics.err.Add("template", 1)
+		return nil, err
+	}
+	values := reqURL.Query()
+	values.Set("name", question.Name)
+	values.Set("type", strconv.Itoa(int(question.Qtype)))
+	if edns0 := q.IsEdns0(); edns0 != nil {
+		if edns0.Do() {
+			values.Set("do", "1")
+		}
+		for _, o := range edns0.Option {
+			if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+				values.Set("edns_client_subnet", fmt.Sprintf("%s/%d", ecs.Address, ecs.SourceNetmask))
+			}
+		}
+	}
+	if q.CheckingDisabled {
+		values.Set("cd", "1")
+	}
+	reqURL.RawQuery = values.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		d.metrics.err.Add("http", 1)
+		return nil, err
+	}
+	d.setHeaders(req)
+	req.Header.Set("accept", "application/dns-json")
+	resp, err := d.do(req)
+	if err != nil {
+		d.metrics.err.Add("get", 1)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	rb, err := d.readResponseBody(resp, checkDoHJSONContentType, d.opt.MaxResponseSize)
+	if err != nil {
+		return nil, err
+	}
+	a, err := dohJSONToMsg(logger(d.id, q, ClientInfo{}), q, rb)
+	if err != nil {
+		d.metrics.err.Add("unpack", 1)
+		return nil, err
+	}
+	d.metrics.response.Add(rCode(a), 1)
+	return a, nil
+}
+
+// Builds a DNS response to a query from a JSON DoH response body.
+func dohJSONToMsg(log *logrus.Entry, q *dns.Msg, b []byte) (*dns.Msg, error) {
+	var r dohJSONResponse
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	a := new(dns.Msg)
+	a.SetReply(q)
+	a.Rcode = r.Status
+	a.Truncated = r.TC
+	a.RecursionDesired = r.RD
+	a.RecursionAvailable = r.RA
+	a.AuthenticatedData = r.AD
+	a.CheckingDisabled = r.CD
+	a.Answer = dohJSONRecords(log, r.Answer)
+	a.Ns = dohJSONRecords(log, r.Authority)
+	a.Extra = dohJSONRecords(log, r.Additional)
+	return a, nil
+}
+
+// Converts records of a JSON DoH response to RRs by parsing them in zone-file
+// format, which the data field uses. Records that can't be parsed, like those
+// of types the dns library doesn't know unless they're in RFC3597 format, are
+// skipped.
+func dohJSONRecords(log *logrus.Entry, records []dohJSONRecord) []dns.RR {
+	var rrs []dns.RR
+	for _, r := range records {
+		typ, ok := dns.TypeToString[r.Type]
+		if !ok {
+			typ = fmt.Sprintf("TYPE%d", r.Type)
+		}
+		data := r.Data
+		if r.Type == dns.TypeTXT || r.Type == dns.TypeSPF {
+			data = dohJSONQuoteTXT(data)
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(r.Name), r.TTL, typ, data))
+		if err != nil {
+			log.WithError(err).WithFields(logrus.Fields{"name": r.Name, "type": typ}).Error("skipping invalid record in response")
+			continue
+		}
+		if rr == nil {
+			continue
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+// Quotes the text of a TXT record. Google returns it unquoted, which would
+// otherwise be split into several strings at spaces, and cut off at a semicolon
+// since that starts a comment. Text that's already quoted, like in Cloudflare's
+// responses, is left alone.
+func dohJSONQuoteTXT(data string) string {
+	if strings.HasPrefix(data, `"`) {
+		return data
+	}
+	data = strings.ReplaceAll(data, `\`, `\\`)
+	data = strings.ReplaceAll(data, `"`, `\"`)
+	return `"` + data + `"`
+}
+
+// Returns an error if the content type of a response isn't JSON. Responses
+// without content type are accepted.
+func checkDoHJSONContentType(contentType string) error {
+	if contentType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid content type '%s': %w", contentType, err)
+	}
+	if !dohJSONContentTypes[mediaType] {
+		return fmt.Errorf("unexpected content type '%s' in response", mediaType)
+	}
+	return nil
+}
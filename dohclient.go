@@ -2,14 +2,20 @@ package rdns
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,6 +25,7 @@ import (
 	"github.com/miekg/dns"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/http2"
+	"golang.org/x/net/proxy"
 )
 
 // DoHClientOptions contains options used by the DNS-over-HTTP resolver.
@@ -26,6 +33,12 @@ type DoHClientOptions struct {
 	// Query method, either GET or POST. If empty, POST is used.
 	Method string
 
+	// Format of queries and responses. "wire" (default) uses the DNS wire format
+	// of RFC8484, "json" the JSON API offered by Google and Cloudflare, which is
+	// always queried with GET. The JSON API only supports a single question and
+	// doesn't return EDNS0 options.
+	Format string
+
 	// Bootstrap address - IP to use for the service instead of looking up
 	// the service's hostname with potentially plain DNS.
 	BootstrapAddr string
@@ -36,6 +49,90 @@ type DoHClientOptions struct {
 	// Local IP to use for outbound connections. If nil, a local address is chosen.
 	LocalAddr net.IP
 
+	// DSCP value (0-63) to mark outgoing packets with, for QoS. Not set if 0.
+	DSCP int
+
+	// Handling of EDNS0 Client Subnet options in queries. "passthrough" (default)
+	// sends queries as they are, "off" removes any ECS options, and "client-ip"
+	// replaces them with the /24 (IPv4) or /56 (IPv6) network of the client.
+	ECS string
+
+	// Switch to QUIC for subsequent queries if the server advertises HTTP/3 support
+	// with an Alt-Svc header. Only applies to the "tcp" transport.
+	AutoUpgrade bool
+
+	// Connection pool settings for the "tcp" transport. MaxIdleConns and
+	// MaxIdleConnsPerHost default to the values of the HTTP library (unlimited,
+	// and 2 respectively).
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+
+	// How long idle connections are kept open, default 30 seconds. Only applies
+	// to the "tcp" transport.
+	IdleConnTimeout time.Duration
+
+	// Time to wait for the response headers after sending a query, default
+	// 10 seconds. Only applies to the "tcp" transport.
+	ResponseHeaderTimeout time.Duration
+
+	// Base64 encoded SHA-256 hashes of the SubjectPublicKeyInfo of the server
+	// certificate. If set, only servers with one of these keys are accepted.
+	PinnedSPKI []string
+
+	// Interval at which QUIC PING frames are sent to keep idle sessions open,
+	// avoiding a new handshake for the first query after an idle period. Sessions
+	// that fail are re-established in the background. Only applies to the "quic"
+	// transport and when upgrading to QUIC. Disabled if 0. The QUIC library caps
+	// the interval at 10 seconds.
+	KeepAlive time.Duration
+
+	// Send GET queries as 0-RTT early data when resuming a QUIC session, saving
+	// a round-trip. Early data can be replayed by an attacker, so it's only used
+	// for GET queries which are idempotent. POST queries wait for the handshake
+	// to complete. Only applies to the "quic" transport and when upgrading to QUIC.
+	Enable0RTT bool
+
+	// Stop sending queries to the server for a period of time after repeated
+	// failures, failing them immediately instead. Disabled by default.
+	CircuitBreaker CircuitBreakerOptions
+
+	// Additional HTTP headers to send with every query, such as API keys or a
+	// custom User-Agent. The "accept" and "content-type" headers required by
+	// DoH can not be overridden.
+	Headers map[string]string
+
+	// Padding applied to queries. Defaults to DefaultQueryPadding if nil.
+	QueryPadding *PaddingPolicy
+
+	// Send queries as Oblivious DoH (RFC9230) so that neither the relay nor the
+	// target see both the client address and the query. Disabled if nil.
+	ODoH *ODoHOptions
+
+	// Maximum size of a response body in bytes. Larger responses are rejected.
+	// Default 65535, the maximum size of a DNS message.
+	MaxResponseSize int
+
+	// Proxy to send queries through, like "http://proxy:3128" or
+	// "socks5://127.0.0.1:9050". Supported schemes are http, https, and socks5.
+	// If empty, the proxy is taken from the environment. Only applies to the
+	// "tcp" transport.
+	Proxy string
+
+	// Connect to IPv4 and IPv6 addresses of the endpoint in parallel as described
+	// in RFC8305 and use whichever connects first. Avoids stalling on a broken
+	// IPv6 path. Only used for QUIC connections, TCP connections race IPv4 and
+	// IPv6 already. Not used with a bootstrap address.
+	HappyEyeballs bool
+
+	// Ask the server to compress responses with gzip. Compressed responses are
+	// decompressed before the size limit is applied.
+	Compression bool
+
+	// Use HTTP/1.1 instead of HTTP/2, for servers that don't handle multiplexed
+	// queries correctly. Only applies to the "tcp" transport, and can't be
+	// combined with AutoUpgrade.
+	ForceHTTP1 bool
+
 	TLSConfig *tls.Config
 }
 
@@ -46,12 +143,26 @@ type DoHClient struct {
 	template *uritemplates.UriTemplate
 	client   *http.Client
 	opt      DoHClientOptions
+	ecs      ECSModifierFunc
 	metrics  *ListenerMetrics
+
+	// HTTP/3 client and advertised alternative service, only used with AutoUpgrade
+	quicClient *http.Client
+	altSvc     *altSvcCache
+
+	// Optional, nil if disabled
+	breaker *circuitBreaker
+	odoh    *odohClient
 }
 
 var _ Resolver = &DoHClient{}
+var _ io.Closer = &DoHClient{}
+var _ ContextResolver = &DoHClient{}
 
 func NewDoHClient(id, endpoint string, opt DoHClientOptions) (*DoHClient, error) {
+	if err := validDSCP(opt.DSCP); err != nil {
+		return nil, err
+	}
 	// Parse the URL template
 	template, err := uritemplates.Parse(endpoint)
 	if err != nil {
@@ -63,6 +174,9 @@ func NewDoHClient(id, endpoint string, opt DoHClientOptions) (*DoHClient, error)
 	case "tcp", "":
 		tr, err = dohTcpTransport(opt)
 	case "quic":
+		if opt.ForceHTTP1 {
+			return nil, errors.New("http/1.1 can not be forced with the quic transport")
+		}
 		tr, err = dohQuicTransport(opt)
 	default:
 		err = fmt.Errorf("unknown protocol: '%s'", opt.Transport)
@@ -81,40 +195,142 @@ func NewDoHClient(id, endpoint string, opt DoHClientOptions) (*DoHClient, error)
 	if opt.Method != "POST" && opt.Method != "GET" {
 		return nil, fmt.Errorf("unsupported method '%s'", opt.Method)
 	}
+	switch opt.Format {
+	case "":
+		opt.Format = "wire"
+	case "wire":
+	case "json":
+		if opt.ODoH != nil {
+			return nil, errors.New("json format is not supported with odoh")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format '%s'", opt.Format)
+	}
+	if opt.QueryPadding == nil {
+		padding := DefaultQueryPadding
+		opt.QueryPadding = &padding
+	}
+	if opt.MaxResponseSize <= 0 {
+		opt.MaxResponseSize = dns.MaxMsgSize
+	}
 
-	return &DoHClient{
+	var ecs ECSModifierFunc
+	switch opt.ECS {
+	case "", "passthrough":
+	case "off":
+		ecs = ECSModifierDelete
+	case "client-ip":
+		ecs = ECSModifierAdd(nil, 24, 56)
+	default:
+		return nil, fmt.Errorf("unsupported ecs option '%s'", opt.ECS)
+	}
+
+	d := &DoHClient{
 		id:       id,
 		endpoint: endpoint,
 		template: template,
 		client:   client,
 		opt:      opt,
+		ecs:      ecs,
 		metrics:  NewListenerMetrics("client", id),
-	}, nil
+		breaker:  newCircuitBreaker("client", id, opt.CircuitBreaker),
+	}
+
+	if opt.ODoH != nil {
+		d.odoh, err = newODoHClient(id, template, *opt.ODoH)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Prepare a QUIC transport to upgrade to if the server advertises HTTP/3
+	if opt.AutoUpgrade && opt.Transport != "quic" {
+		tr, err := dohQuicTransport(opt)
+		if err != nil {
+			return nil, err
+		}
+		d.quicClient = &http.Client{Transport: tr}
+		d.altSvc = new(altSvcCache)
+	}
+	return d, nil
 }
 
 // Resolve a DNS query.
 func (d *DoHClient) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	return d.ResolveContext(ci.queryContext(), q, ci)
+}
+
+// ResolveContext resolves a DNS query. The upstream request is cancelled when
+// the context is done.
+func (d *DoHClient) ResolveContext(ctx context.Context, q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
 	logger(d.id, q, ci).WithFields(logrus.Fields{
 		"resolver": d.endpoint,
 		"protocol": "doh",
 		"method":   d.opt.Method,
 	}).Debug("querying upstream resolver")
+	ci.trace.setUpstream(d.id)
+
+	// Apply the ECS option. This needs to happen before padding since it
+	// changes the size of the query.
+	if d.ecs != nil {
+		d.ecs(q, ci)
+	}
 
 	// Add padding before sending the query over HTTPS
-	padQuery(q)
+	padQuery(q, *d.opt.QueryPadding)
 
 	d.metrics.query.Add(1)
-	switch d.opt.Method {
-	case "POST":
-		return d.ResolvePOST(q)
-	case "GET":
-		return d.ResolveGET(q)
+	if !d.breaker.allow() {
+		d.metrics.err.Add("circuit-open", 1)
+		return nil, errors.New("circuit breaker open")
+	}
+	start := time.Now()
+	var (
+		a   *dns.Msg
+		err error
+	)
+	switch {
+	case d.odoh != nil:
+		a, err = d.ResolveODoHContext(ctx, q)
+	case d.opt.Format == "json":
+		a, err = d.ResolveJSON(ctx, q)
+	case d.opt.Method == "POST":
+		a, err = d.ResolvePOSTContext(ctx, q)
+	case d.opt.Method == "GET":
+		a, err = d.ResolveGETContext(ctx, q)
+	default:
+		d.breaker.cancel()
+		return nil, errors.New("unsupported method")
+	}
+	if err != nil {
+		// A query cancelled by the caller doesn't say anything about the upstream
+		if ctx.Err() != nil {
+			d.metrics.err.Add("cancelled", 1)
+			d.breaker.cancel()
+			return nil, err
+		}
+		// Respect the pacing requested by servers that rate-limit us
+		var statusErr HTTPStatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			d.breaker.openFor(statusErr.RetryAfter)
+		} else {
+			d.breaker.failure()
+		}
+		return a, err
 	}
-	return nil, errors.New("unsupported method")
+	d.breaker.success()
+	d.metrics.observeLatency(start)
+	return a, nil
 }
 
 // ResolvePOST resolves a DNS query via DNS-over-HTTP using the POST method.
 func (d *DoHClient) ResolvePOST(q *dns.Msg) (*dns.Msg, error) {
+	return d.ResolvePOSTContext(context.Background(), q)
+}
+
+// ResolvePOSTContext resolves a DNS query via DNS-over-HTTP using the POST
+// method. The request is cancelled when the context is done.
+func (d *DoHClient) ResolvePOSTContext(ctx context.Context, q *dns.Msg) (*dns.Msg, error) {
 	// Pack the DNS query into wire format
 	b, err := q.Pack()
 	if err != nil {
@@ -127,14 +343,14 @@ func (d *DoHClient) ResolvePOST(q *dns.Msg) (*dns.Msg, error) {
 		d.metrics.err.Add("template", 1)
 		return nil, err
 	}
-	req, err := http.NewRequest("POST", u, bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(b))
 	if err != nil {
 		d.metrics.err.Add("http", 1)
 		return nil, err
 	}
-	req.Header.Add("accept", "application/dns-message")
-	req.Header.Add("content-type", "application/dns-message")
-	resp, err := d.client.Do(req)
+	d.setHeaders(req)
+	req.Header.Set("content-type", "application/dns-message")
+	resp, err := d.do(req)
 	if err != nil {
 		d.metrics.err.Add("post", 1)
 		return nil, err
@@ -145,8 +361,18 @@ func (d *DoHClient) ResolvePOST(q *dns.Msg) (*dns.Msg, error) {
 
 // ResolveGET resolves a DNS query via DNS-over-HTTP using the GET method.
 func (d *DoHClient) ResolveGET(q *dns.Msg) (*dns.Msg, error) {
-	// Pack the DNS query into wire format
+	return d.ResolveGETContext(context.Background(), q)
+}
+
+// ResolveGETContext resolves a DNS query via DNS-over-HTTP using the GET
+// method. The request is cancelled when the context is done.
+func (d *DoHClient) ResolveGETContext(ctx context.Context, q *dns.Msg) (*dns.Msg, error) {
+	// Pack the DNS query into wire format. Use an ID of 0 as recommended in RFC8484
+	// so identical queries produce identical URLs that can be cached by HTTP caches.
+	id := q.Id
+	q.Id = 0
 	b, err := q.Pack()
+	q.Id = id
 	if err != nil {
 		d.metrics.err.Add("pack", 1)
 		return nil, err
@@ -160,38 +386,156 @@ func (d *DoHClient) ResolveGET(q *dns.Msg) (*dns.Msg, error) {
 		d.metrics.err.Add("template", 1)
 		return nil, err
 	}
-	req, err := http.NewRequest("GET", u, nil)
+	method := http.MethodGet
+	if d.opt.Enable0RTT && d.opt.Transport == "quic" {
+		method = http3.MethodGet0RTT
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
 	if err != nil {
 		d.metrics.err.Add("http", 1)
 		return nil, err
 	}
-	req.Header.Add("accept", "application/dns-message")
-	resp, err := d.client.Do(req)
+	d.setHeaders(req)
+	resp, err := d.do(req)
 	if err != nil {
 		d.metrics.err.Add("get", 1)
 		return nil, err
 	}
 	defer resp.Body.Close()
-	return d.responseFromHTTP(resp)
+	a, err := d.responseFromHTTP(resp)
+	if err != nil {
+		return nil, err
+	}
+	a.Id = id
+	return a, nil
 }
 
 func (d *DoHClient) String() string {
 	return d.id
 }
 
+// Close releases the connections held by the client. Idle HTTP connections are
+// closed and QUIC sessions are terminated, which also stops their keepalive.
+// The client can still be used afterwards, new connections are opened as needed.
+func (d *DoHClient) Close() error {
+	var err error
+	for _, c := range []*http.Client{d.client, d.quicClient} {
+		if c == nil {
+			continue
+		}
+		c.CloseIdleConnections()
+		if closer, ok := c.Transport.(io.Closer); ok {
+			if cerr := closer.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// Apply the user-defined headers to a request, followed by the ones needed for
+// DoH so they take precedence.
+func (d *DoHClient) setHeaders(req *http.Request) {
+	for k, v := range d.opt.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("accept", "application/dns-message")
+	req.Header.Del("content-type") // Only set for POST
+	if d.opt.Compression {
+		req.Header.Set("accept-encoding", "gzip")
+	}
+}
+
+// Send an HTTP request to the server. If the server advertised HTTP/3 support
+// earlier, the request is sent via QUIC first, falling back to TCP on failure.
+func (d *DoHClient) do(req *http.Request) (*http.Response, error) {
+	if d.altSvc == nil {
+		return d.client.Do(req)
+	}
+	if port, ok := d.altSvc.get(); ok {
+		qReq := req.Clone(req.Context())
+		qReq.URL.Host = net.JoinHostPort(req.URL.Hostname(), port)
+		if req.GetBody != nil {
+			qReq.Body, _ = req.GetBody()
+		}
+		if d.opt.Enable0RTT && req.Method == http.MethodGet {
+			qReq.Method = http3.MethodGet0RTT
+		}
+		resp, err := d.quicClient.Do(qReq)
+		if err == nil {
+			return resp, nil
+		}
+		Log.WithFields(logrus.Fields{"id": d.id, "resolver": d.endpoint}).WithError(err).Debug("http3 upgrade failed, falling back to tcp")
+		d.metrics.err.Add("upgrade", 1)
+		d.altSvc.clear()
+
+		// The HTTP/3 round-tripper remembers failed handshakes, close it to
+		// allow for a fresh attempt once the server advertises HTTP/3 again.
+		if c, ok := d.quicClient.Transport.(io.Closer); ok {
+			_ = c.Close()
+		}
+	}
+	resp, err := d.client.Do(req)
+	if err == nil {
+		d.altSvc.update(resp.Header.Get("Alt-Svc"), req.URL.Hostname())
+	}
+	return resp, err
+}
+
 // Check the HTTP response status code and parse out the response DNS message.
 func (d *DoHClient) responseFromHTTP(resp *http.Response) (*dns.Msg, error) {
+	rb, err := d.readResponseBody(resp, checkDoHContentType, d.opt.MaxResponseSize)
+	if err != nil {
+		return nil, err
+	}
+	return d.unpackResponse(rb)
+}
+
+// Check the HTTP response status code and content type, and read the body up to
+// a maximum size.
+func (d *DoHClient) readResponseBody(resp *http.Response, checkContentType func(string) error, maxSize int) ([]byte, error) {
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		d.metrics.err.Add(fmt.Sprintf("http%d", resp.StatusCode), 1)
-		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		return nil, HTTPStatusError{
+			Code:       resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("retry-after"), time.Now()),
+		}
+	}
+	if err := checkContentType(resp.Header.Get("content-type")); err != nil {
+		d.metrics.err.Add("content-type", 1)
+		return nil, err
 	}
-	rb, err := ioutil.ReadAll(resp.Body)
+	body := resp.Body
+	switch encoding := strings.ToLower(resp.Header.Get("content-encoding")); encoding {
+	case "", "identity":
+	case "gzip":
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			d.metrics.err.Add("decompress", 1)
+			return nil, err
+		}
+		defer zr.Close()
+		body = zr
+	default:
+		d.metrics.err.Add("content-encoding", 1)
+		return nil, fmt.Errorf("unsupported content encoding '%s'", encoding)
+	}
+	// Read one byte more than the limit to detect oversized responses
+	rb, err := ioutil.ReadAll(io.LimitReader(body, int64(maxSize)+1))
 	if err != nil {
 		d.metrics.err.Add("read", 1)
 		return nil, err
 	}
+	if len(rb) > maxSize {
+		d.metrics.err.Add("oversize", 1)
+		return nil, fmt.Errorf("response exceeds maximum size of %d bytes", maxSize)
+	}
+	return rb, nil
+}
+
+func (d *DoHClient) unpackResponse(rb []byte) (*dns.Msg, error) {
 	a := new(dns.Msg)
-	err = a.Unpack(rb)
+	err := a.Unpack(rb)
 	if err != nil {
 		d.metrics.err.Add("unpack", 1)
 	} else {
@@ -200,25 +544,102 @@ func (d *DoHClient) responseFromHTTP(resp *http.Response) (*dns.Msg, error) {
 	return a, err
 }
 
+// Content types accepted in DoH responses. "application/dns-udpwireformat" was
+// used by early drafts of RFC8484 and is still sent by some older servers.
+var dohContentTypes = map[string]bool{
+	"application/dns-message":       true,
+	"application/dns-udpwireformat": true,
+}
+
+// Returns an error if the content type of a response isn't a DNS message. This
+// catches HTML pages from captive portals or error pages that would otherwise
+// fail to unpack. Responses without content type are accepted.
+func checkDoHContentType(contentType string) error {
+	if contentType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid content type '%s': %w", contentType, err)
+	}
+	if !dohContentTypes[mediaType] {
+		return fmt.Errorf("unexpected content type '%s' in response", mediaType)
+	}
+	return nil
+}
+
 func dohTcpTransport(opt DoHClientOptions) (http.RoundTripper, error) {
+	tlsConfig, err := tlsConfigWithPinnedSPKI(opt.TLSConfig, opt.PinnedSPKI)
+	if err != nil {
+		return nil, err
+	}
+	if opt.ResponseHeaderTimeout == 0 {
+		opt.ResponseHeaderTimeout = 10 * time.Second
+	}
+	if opt.IdleConnTimeout == 0 {
+		opt.IdleConnTimeout = 30 * time.Second
+	}
 	tr := &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		TLSClientConfig:       opt.TLSConfig,
+		TLSClientConfig:       tlsConfig,
 		DisableCompression:    true,
-		ResponseHeaderTimeout: 10 * time.Second,
-		IdleConnTimeout:       30 * time.Second,
+		ResponseHeaderTimeout: opt.ResponseHeaderTimeout,
+		IdleConnTimeout:       opt.IdleConnTimeout,
+		MaxIdleConns:          opt.MaxIdleConns,
+		MaxIdleConnsPerHost:   opt.MaxIdleConnsPerHost,
 	}
 	// If we're using a custom tls.Config, HTTP2 isn't enabled by default in
-	// the HTTP library. Turn it on for this transport.
-	if tr.TLSClientConfig != nil {
+	// the HTTP library. Turn it on for this transport, unless HTTP/1.1 is forced
+	// which requires a non-nil but empty map of protocols.
+	if opt.ForceHTTP1 {
+		if opt.AutoUpgrade {
+			return nil, errors.New("http/1.1 can not be forced when auto-upgrading to quic")
+		}
+		tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	} else if tr.TLSClientConfig != nil {
 		if err := http2.ConfigureTransport(tr); err != nil {
 			return nil, err
 		}
 	}
 
-	// Use a custom dialer if a bootstrap address or local address was provided
-	if opt.BootstrapAddr != "" || opt.LocalAddr != nil {
-		d := net.Dialer{LocalAddr: &net.TCPAddr{IP: opt.LocalAddr}}
+	// Use the proxy from the environment unless one was configured explicitly.
+	// SOCKS5 proxies are handled in the dialer, HTTP proxies by the transport.
+	var socksDialer proxy.ContextDialer
+	d := &net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: opt.LocalAddr},
+		Control:   dscpControl(opt.DSCP),
+	}
+	if opt.Proxy == "" {
+		tr.Proxy = http.ProxyFromEnvironment
+	} else {
+		u, err := url.Parse(opt.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy '%s': %w", opt.Proxy, err)
+		}
+		switch u.Scheme {
+		case "http", "https":
+			if opt.BootstrapAddr != "" {
+				return nil, errors.New("bootstrap address can not be used with an http proxy")
+			}
+			tr.Proxy = http.ProxyURL(u)
+		case "socks5":
+			var auth *proxy.Auth
+			if u.User != nil {
+				password, _ := u.User.Password()
+				auth = &proxy.Auth{User: u.User.Username(), Password: password}
+			}
+			pd, err := proxy.SOCKS5("tcp", u.Host, auth, d)
+			if err != nil {
+				return nil, err
+			}
+			socksDialer = pd.(proxy.ContextDialer)
+		default:
+			return nil, fmt.Errorf("unsupported proxy '%s'", opt.Proxy)
+		}
+	}
+
+	// Use a custom dialer if a bootstrap address, local address, socks proxy,
+	// or DSCP was configured
+	if opt.BootstrapAddr != "" || opt.LocalAddr != nil || socksDialer != nil || opt.DSCP != 0 {
 		tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
 			if opt.BootstrapAddr != "" {
 				_, port, err := net.SplitHostPort(addr)
@@ -227,6 +648,9 @@ func dohTcpTransport(opt DoHClientOptions) (http.RoundTripper, error) {
 				}
 				addr = net.JoinHostPort(opt.BootstrapAddr, port)
 			}
+			if socksDialer != nil {
+				return socksDialer.DialContext(ctx, network, addr)
+			}
 			return d.DialContext(ctx, network, addr)
 		}
 	}
@@ -234,11 +658,35 @@ func dohTcpTransport(opt DoHClientOptions) (http.RoundTripper, error) {
 }
 
 func dohQuicTransport(opt DoHClientOptions) (http.RoundTripper, error) {
+	if opt.Proxy != "" {
+		return nil, errors.New("proxy is not supported by the quic transport")
+	}
+	tlsConfig, err := tlsConfigWithPinnedSPKI(opt.TLSConfig, opt.PinnedSPKI)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Enable0RTT {
+		// Session tickets are needed to resume sessions with early data
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		} else {
+			tlsConfig = tlsConfig.Clone()
+		}
+		if tlsConfig.ClientSessionCache == nil {
+			tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+		}
+	}
+	quicConfig := &quic.Config{
+		TokenStore: quic.NewLRUTokenStore(10, 10),
+	}
+	if opt.KeepAlive > 0 {
+		// The QUIC library sends PING frames after a quarter of the idle timeout
+		quicConfig.KeepAlive = true
+		quicConfig.MaxIdleTimeout = 4 * opt.KeepAlive
+	}
 	tr := &http3.RoundTripper{
-		TLSClientConfig: opt.TLSConfig,
-		QuicConfig: &quic.Config{
-			TokenStore: quic.NewLRUTokenStore(10, 10),
-		},
+		TLSClientConfig: tlsConfig,
+		QuicConfig:      quicConfig,
 		Dial: func(network, addr string, tlsConfig *tls.Config, config *quic.Config) (quic.EarlySession, error) {
 			hostname, port, err := net.SplitHostPort(addr)
 			if err != nil {
@@ -249,7 +697,8 @@ func dohQuicTransport(opt DoHClientOptions) (http.RoundTripper, error) {
 				tlsConfig.ServerName = hostname
 				addr = net.JoinHostPort(opt.BootstrapAddr, port)
 			}
-			return newQuicSession(hostname, addr, opt.LocalAddr, tlsConfig, config)
+			happyEyeballs := opt.HappyEyeballs && opt.BootstrapAddr == ""
+			return newQuicSession(hostname, addr, opt.LocalAddr, opt.DSCP, tlsConfig, config, opt.KeepAlive, opt.Enable0RTT, happyEyeballs)
 		},
 	}
 	return tr, nil
@@ -257,41 +706,63 @@ func dohQuicTransport(opt DoHClientOptions) (http.RoundTripper, error) {
 
 // QUIC session that automatically restarts when it's used after having timed out. Needed
 // since the quic-go RoundTripper doesn't have any session management and timed out
-// sessions aren't restarted. This one doesn't support Early sessions, and instead just
-// uses a regular session.
+// sessions aren't restarted. Early sessions (0-RTT) are only used if enabled, otherwise
+// this is a regular session that reports the handshake as complete. With keepalive
+// enabled, failed sessions are restarted in the background rather than on the next query.
 type quicSession struct {
 	quic.Session
 
 	hostname  string
 	rAddr     string
 	lAddr     net.IP
+	dscp      int
 	tlsConfig *tls.Config
 	config    *quic.Config
-	mu        sync.Mutex
+	earlyData bool
+	// Race connections to all addresses of the endpoint
+	happyEyeballs bool
+	mu            sync.Mutex
 
 	expiredContext context.Context
+
+	// Closed when the session is closed by the RoundTripper, stops the keepalive
+	closed    chan struct{}
+	closeOnce sync.Once
 }
 
-func newQuicSession(hostname, rAddr string, lAddr net.IP, tlsConfig *tls.Config, config *quic.Config) (quic.EarlySession, error) {
-	session, err := quicDial(hostname, rAddr, lAddr, tlsConfig, config)
-	if err != nil {
-		return nil, err
-	}
+func newQuicSession(hostname, rAddr string, lAddr net.IP, dscp int, tlsConfig *tls.Config, config *quic.Config, keepAlive time.Duration, earlyData, happyEyeballs bool) (quic.EarlySession, error) {
 	expired, cancel := context.WithCancel(context.Background())
 	cancel()
 
-	return &quicSession{
+	s := &quicSession{
 		hostname:       hostname,
 		rAddr:          rAddr,
 		lAddr:          lAddr,
+		dscp:           dscp,
 		tlsConfig:      tlsConfig,
 		config:         config,
-		Session:        session,
+		earlyData:      earlyData,
+		happyEyeballs:  happyEyeballs,
 		expiredContext: expired,
-	}, nil
+		closed:         make(chan struct{}),
+	}
+	session, err := s.dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	s.Session = session
+	if keepAlive > 0 {
+		go s.redialLoop(keepAlive)
+	}
+	return s, nil
 }
 
 func (s *quicSession) HandshakeComplete() context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if early, ok := s.Session.(quic.EarlySession); ok {
+		return early.HandshakeComplete()
+	}
 	return s.expiredContext
 }
 
@@ -300,9 +771,12 @@ func (s *quicSession) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
 	defer s.mu.Unlock()
 	stream, err := s.Session.OpenStreamSync(ctx)
 	if err != nil {
+		if ctx.Err() != nil { // Cancelled by the caller, the session is fine
+			return nil, err
+		}
 		_ = s.Session.CloseWithError(quic.ErrorCode(DOQNoError), "")
 		var session quic.Session
-		session, err = quicDial(s.hostname, s.rAddr, s.lAddr, s.tlsConfig, s.config)
+		session, err = s.dial(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -319,7 +793,7 @@ func (s *quicSession) OpenStream() (quic.Stream, error) {
 	if err != nil {
 		_ = s.Session.CloseWithError(quic.ErrorCode(DOQNoError), "")
 		var session quic.Session
-		session, err = quicDial(s.hostname, s.rAddr, s.lAddr, s.tlsConfig, s.config)
+		session, err = s.dial(context.Background())
 		if err != nil {
 			return nil, err
 		}
@@ -333,14 +807,233 @@ func (s *quicSession) NextSession() quic.Session {
 	return nil
 }
 
-func quicDial(hostname, rAddr string, lAddr net.IP, tlsConfig *tls.Config, config *quic.Config) (quic.Session, error) {
+func (s *quicSession) CloseWithError(code quic.ErrorCode, msg string) error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Session.CloseWithError(code, msg)
+}
+
+// Close terminates the current session and stops the keepalive.
+func (s *quicSession) Close() error {
+	return s.CloseWithError(quic.ErrorCode(DOQNoError), "")
+}
+
+// Wait for the current session to fail and re-establish it proactively so the
+// next query doesn't need to wait for the handshake. Failed attempts are retried
+// after the given interval.
+func (s *quicSession) redialLoop(interval time.Duration) {
+	for {
+		s.mu.Lock()
+		session := s.Session
+		s.mu.Unlock()
+
+		select {
+		case <-s.closed:
+			return
+		case <-session.Context().Done():
+		}
+
+		s.mu.Lock()
+		select {
+		case <-s.closed:
+			s.mu.Unlock()
+			return
+		default:
+		}
+		var err error
+		if s.Session == session { // Could have been replaced when opening a stream already
+			var newSession quic.Session
+			newSession, err = s.dial(context.Background())
+			if err == nil {
+				s.Session = newSession
+			}
+		}
+		s.mu.Unlock()
+		if err != nil {
+			Log.WithFields(logrus.Fields{"addr": s.rAddr}).WithError(err).Debug("failed to re-establish quic session")
+			select {
+			case <-s.closed:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}
+}
+
+// Dial a new session, with support for 0-RTT if enabled. The handshake is
+// aborted when the context is done.
+func (s *quicSession) dial(ctx context.Context) (quic.Session, error) {
+	if !s.happyEyeballs {
+		return s.dialAddr(ctx, s.rAddr)
+	}
+	host, port, err := net.SplitHostPort(s.rAddr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, ip := range happyEyeballsOrder(ips) {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	return happyEyeballsDial(ctx, addrs, s.dialAddr)
+}
+
+func (s *quicSession) dialAddr(ctx context.Context, rAddr string) (quic.Session, error) {
+	if s.earlyData {
+		return quicDialEarly(ctx, s.hostname, rAddr, s.lAddr, s.dscp, s.tlsConfig, s.config)
+	}
+	return quicDial(ctx, s.hostname, rAddr, s.lAddr, s.dscp, s.tlsConfig, s.config)
+}
+
+func quicDialEarly(ctx context.Context, hostname, rAddr string, lAddr net.IP, dscp int, tlsConfig *tls.Config, config *quic.Config) (quic.EarlySession, error) {
 	udpAddr, err := net.ResolveUDPAddr("udp", rAddr)
 	if err != nil {
 		return nil, err
 	}
-	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: lAddr, Port: 0})
+	udpConn, err := quicListen(ctx, lAddr, dscp)
+	if err != nil {
+		return nil, err
+	}
+	session, err := quic.DialEarlyContext(ctx, udpConn, udpAddr, hostname, tlsConfig, config)
 	if err != nil {
+		_ = udpConn.Close()
 		return nil, err
 	}
-	return quic.Dial(udpConn, udpAddr, hostname, tlsConfig, config)
+	closeWithSession(session, udpConn)
+	return session, nil
+}
+
+func quicDial(ctx context.Context, hostname, rAddr string, lAddr net.IP, dscp int, tlsConfig *tls.Config, config *quic.Config) (quic.Session, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", rAddr)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := quicListen(ctx, lAddr, dscp)
+	if err != nil {
+		return nil, err
+	}
+	session, err := quic.DialContext(ctx, udpConn, udpAddr, hostname, tlsConfig, config)
+	if err != nil {
+		_ = udpConn.Close()
+		return nil, err
+	}
+	closeWithSession(session, udpConn)
+	return session, nil
+}
+
+// Opens the local UDP socket for a QUIC session, with packets marked with the
+// DSCP value if it's not 0.
+func quicListen(ctx context.Context, lAddr net.IP, dscp int) (net.PacketConn, error) {
+	lc := net.ListenConfig{Control: dscpControl(dscp)}
+	return lc.ListenPacket(ctx, "udp", (&net.UDPAddr{IP: lAddr}).String())
+}
+
+// Closes the UDP socket of a session once the session is closed, whether by
+// the client or because it timed out. quic-go only closes sockets it opened
+// itself.
+func closeWithSession(session quic.Session, conn net.PacketConn) {
+	go func() {
+		<-session.Context().Done()
+		_ = conn.Close()
+	}()
+}
+
+// Default lifetime of an alternative service if the server didn't provide one, as per RFC7838.
+const altSvcDefaultMaxAge = 24 * time.Hour
+
+// HTTP/3 ALPN tokens that can be used in Alt-Svc headers and are supported by the QUIC transport.
+var altSvcHTTP3Protocols = map[string]struct{}{
+	"h3":    {},
+	"h3-29": {},
+	"h3-32": {},
+	"h3-34": {},
+}
+
+// altSvcCache holds the HTTP/3 alternative service advertised by a DoH server
+// in an Alt-Svc header, along with its expiry.
+type altSvcCache struct {
+	mu     sync.Mutex
+	port   string
+	expiry time.Time
+}
+
+// Returns the port of the advertised HTTP/3 service if there is one that hasn't expired yet.
+func (c *altSvcCache) get() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.port == "" || time.Now().After(c.expiry) {
+		return "", false
+	}
+	return c.port, true
+}
+
+func (c *altSvcCache) clear() {
+	c.mu.Lock()
+	c.port = ""
+	c.mu.Unlock()
+}
+
+// Update the cached service from the value of an Alt-Svc header received from host.
+func (c *altSvcCache) update(header, host string) {
+	if header == "" {
+		return
+	}
+	port, maxAge, ok := parseAltSvc(header, host)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	c.port = port
+	c.expiry = time.Now().Add(maxAge)
+	c.mu.Unlock()
+}
+
+// Parses an Alt-Svc header as per RFC7838 and returns the port and max-age of the first
+// HTTP/3 service on the same host. Alternatives on other hosts are ignored since the
+// certificate would have to be validated against the original hostname. A header value
+// of "clear" returns an empty port, meaning any previously advertised services should
+// be removed.
+func parseAltSvc(header, host string) (string, time.Duration, bool) {
+	if strings.TrimSpace(header) == "clear" {
+		return "", 0, true
+	}
+	for _, entry := range strings.Split(header, ",") {
+		params := strings.Split(entry, ";")
+		protocol, authority := splitAltSvcParam(params[0])
+		if _, ok := altSvcHTTP3Protocols[protocol]; !ok {
+			continue
+		}
+		altHost, port, err := net.SplitHostPort(authority)
+		if err != nil || port == "" {
+			continue
+		}
+		if altHost != "" && !strings.EqualFold(altHost, host) {
+			continue
+		}
+		maxAge := altSvcDefaultMaxAge
+		for _, param := range params[1:] {
+			key, value := splitAltSvcParam(param)
+			if key != "ma" {
+				continue
+			}
+			if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		}
+		return port, maxAge, true
+	}
+	return "", 0, false
+}
+
+// Split a key=value pair from an Alt-Svc header, removing quotes from the value.
+func splitAltSvcParam(s string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(s), "=", 2)
+	if len(parts) != 2 {
+		return parts[0], ""
+	}
+	return parts[0], strings.Trim(parts[1], `"`)
 }
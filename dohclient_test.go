@@ -1,8 +1,28 @@
 package rdns
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
 )
@@ -26,3 +46,753 @@ func TestDoHClientSimpleGET(t *testing.T) {
 	require.NoError(t, err)
 	require.NotEmpty(t, r.Answer)
 }
+
+func TestDoHClientGETCanonicalURL(t *testing.T) {
+	var urls []string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		urls = append(urls, r.URL.String())
+		b, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		require.NoError(t, err)
+		q := new(dns.Msg)
+		require.NoError(t, q.Unpack(b))
+		require.Equal(t, uint16(0), q.Id)
+		a := new(dns.Msg)
+		a.SetReply(q)
+		out, err := a.Pack()
+		require.NoError(t, err)
+		w.Header().Set("content-type", "application/dns-message")
+		_, _ = w.Write(out)
+	}))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	d, err := NewDoHClient("test-doh", srv.URL+"/dns-query{?dns}", DoHClientOptions{
+		Method:    "GET",
+		TLSConfig: &tls.Config{RootCAs: pool},
+	})
+	require.NoError(t, err)
+
+	// Send the same question twice with different IDs, the URLs should be identical
+	for _, id := range []uint16{1234, 4321} {
+		q := new(dns.Msg)
+		q.SetQuestion("example.com.", dns.TypeA)
+		q.Id = id
+		a, err := d.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+		require.Equal(t, id, a.Id)
+	}
+	require.Len(t, urls, 2)
+	require.Equal(t, urls[0], urls[1])
+}
+
+func TestDoHClientMaxResponseSize(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/dns-message")
+		_, _ = w.Write(make([]byte, 1024))
+	}))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	d, err := NewDoHClient("test-doh", srv.URL+"/dns-query", DoHClientOptions{
+		TLSConfig:       &tls.Config{RootCAs: pool},
+		MaxResponseSize: 512,
+	})
+	require.NoError(t, err)
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	_, err = d.Resolve(q, ClientInfo{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "maximum size")
+}
+
+func TestDoHContentType(t *testing.T) {
+	require.NoError(t, checkDoHContentType("application/dns-message"))
+	require.NoError(t, checkDoHContentType("application/dns-udpwireformat"))
+	require.NoError(t, checkDoHContentType("Application/DNS-Message; charset=binary"))
+	require.NoError(t, checkDoHContentType(""))
+	require.Error(t, checkDoHContentType("text/html; charset=utf-8"))
+	require.Error(t, checkDoHContentType("application/json"))
+}
+
+func TestDoHClientHeaders(t *testing.T) {
+	var header http.Header
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	d, err := NewDoHClient("test-doh", srv.URL+"/dns-query", DoHClientOptions{
+		TLSConfig: &tls.Config{RootCAs: pool},
+		Headers: map[string]string{
+			"User-Agent":   "routedns-test",
+			"X-Api-Key":    "secret",
+			"Content-Type": "text/plain",
+		},
+	})
+	require.NoError(t, err)
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	_, _ = d.Resolve(q, ClientInfo{})
+	require.Equal(t, "routedns-test", header.Get("User-Agent"))
+	require.Equal(t, "secret", header.Get("X-Api-Key"))
+
+	// Headers required for DoH can't be overridden
+	require.Equal(t, []string{"application/dns-message"}, header.Values("Content-Type"))
+	require.Equal(t, []string{"application/dns-message"}, header.Values("Accept"))
+}
+
+func TestDoHClientCompression(t *testing.T) {
+	var acceptEncoding string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		b, _ := ioutil.ReadAll(r.Body)
+		q := new(dns.Msg)
+		_ = q.Unpack(b)
+		a := new(dns.Msg)
+		a.SetReply(q)
+		for i := 0; i < 50; i++ {
+			rr, _ := dns.NewRR(fmt.Sprintf("%s IN TXT \"record %d\"", q.Question[0].Name, i))
+			a.Answer = append(a.Answer, rr)
+		}
+		b, _ = a.Pack()
+		w.Header().Set("content-type", "application/dns-message")
+		if acceptEncoding == "gzip" {
+			w.Header().Set("content-encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			defer zw.Close()
+			_, _ = zw.Write(b)
+			return
+		}
+		_, _ = w.Write(b)
+	}))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeTXT)
+	for _, compression := range []bool{false, true} {
+		d, err := NewDoHClient("test-doh", srv.URL+"/dns-query", DoHClientOptions{
+			TLSConfig:   &tls.Config{RootCAs: pool},
+			Compression: compression,
+		})
+		require.NoError(t, err)
+		a, err := d.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+		require.Len(t, a.Answer, 50)
+		if compression {
+			require.Equal(t, "gzip", acceptEncoding)
+		} else {
+			require.Empty(t, acceptEncoding)
+		}
+	}
+}
+
+func TestDoHClientJSON(t *testing.T) {
+	var params url.Values
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params = r.URL.Query()
+		w.Header().Set("content-type", "application/dns-json")
+		if params.Get("name") == "nxdomain.example.com." {
+			_, _ = w.Write([]byte(`{"Status":3,"RD":true,"RA":true,"Authority":[{"name":"example.com.","type":6,"TTL":1800,"data":"ns.example.com. admin.example.com. 1 7200 3600 1209600 3600"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"Status":0,"RD":true,"RA":true,"AD":true,"Question":[{"name":"www.example.com.","type":1}],` +
+			`"Answer":[{"name":"www.example.com.","type":5,"TTL":300,"data":"example.com."},{"name":"example.com","type":1,"TTL":60,"data":"192.0.2.1"}]}`))
+	}))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	d, err := NewDoHClient("test-doh", srv.URL+"/resolve", DoHClientOptions{
+		TLSConfig: &tls.Config{RootCAs: pool},
+		Format:    "json",
+	})
+	require.NoError(t, err)
+
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.com.", dns.TypeA)
+	q.SetEdns0(4096, true)
+	a, err := d.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, "www.example.com.", params.Get("name"))
+	require.Equal(t, "1", params.Get("type"))
+	require.Equal(t, "1", params.Get("do"))
+	require.Equal(t, q.Id, a.Id)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.True(t, a.AuthenticatedData)
+	require.Len(t, a.Answer, 2)
+	require.Equal(t, "example.com.", a.Answer[0].(*dns.CNAME).Target)
+	require.Equal(t, "192.0.2.1", a.Answer[1].(*dns.A).A.String())
+	require.Equal(t, uint32(60), a.Answer[1].Header().Ttl)
+
+	// Status is mapped to the response code
+	q = new(dns.Msg)
+	q.SetQuestion("nxdomain.example.com.", dns.TypeAAAA)
+	a, err = d.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, "28", params.Get("type"))
+	require.Equal(t, dns.RcodeNameError, a.Rcode)
+	require.Len(t, a.Ns, 1)
+
+	_, err = NewDoHClient("test-doh", srv.URL+"/resolve", DoHClientOptions{Format: "invalid"})
+	require.Error(t, err)
+}
+
+func TestDoHJSONRecords(t *testing.T) {
+	log := Log.WithField("id", "test-doh")
+	rrs := dohJSONRecords(log, []dohJSONRecord{
+		// Unquoted text as returned by Google, with spaces and a semicolon
+		{Name: "example.com", Type: dns.TypeTXT, TTL: 60, Data: "v=spf1 include:_spf.example.com ~all"},
+		{Name: "example.com", Type: dns.TypeTXT, TTL: 60, Data: `v=DKIM1; k=rsa; p="abc"`},
+		// Quoted text as returned by Cloudflare
+		{Name: "example.com", Type: dns.TypeTXT, TTL: 60, Data: `"first" "second"`},
+		// Records that can't be parsed are skipped
+		{Name: "example.com", Type: dns.TypeSVCB, TTL: 60, Data: "invalid"},
+		// Unknown types in RFC3597 format
+		{Name: "example.com", Type: 65534, TTL: 60, Data: `\# 2 abcd`},
+	})
+	require.Len(t, rrs, 4)
+	require.Equal(t, []string{"v=spf1 include:_spf.example.com ~all"}, rrs[0].(*dns.TXT).Txt)
+	require.Equal(t, []string{`v=DKIM1; k=rsa; p=\"abc\"`}, rrs[1].(*dns.TXT).Txt)
+	require.Equal(t, []string{"first", "second"}, rrs[2].(*dns.TXT).Txt)
+	require.Equal(t, uint16(65534), rrs[3].Header().Rrtype)
+}
+
+func TestDoHQuicTransportKeepAlive(t *testing.T) {
+	rt, err := dohQuicTransport(DoHClientOptions{})
+	require.NoError(t, err)
+	require.False(t, rt.(*http3.RoundTripper).QuicConfig.KeepAlive)
+
+	rt, err = dohQuicTransport(DoHClientOptions{KeepAlive: 5 * time.Second})
+	require.NoError(t, err)
+	cfg := rt.(*http3.RoundTripper).QuicConfig
+	require.True(t, cfg.KeepAlive)
+	require.Equal(t, 20*time.Second, cfg.MaxIdleTimeout)
+}
+
+func TestDoHQuicTransport0RTT(t *testing.T) {
+	rt, err := dohQuicTransport(DoHClientOptions{TLSConfig: &tls.Config{}})
+	require.NoError(t, err)
+	require.Nil(t, rt.(*http3.RoundTripper).TLSClientConfig.ClientSessionCache)
+
+	// Session tickets need to be cached for 0-RTT
+	rt, err = dohQuicTransport(DoHClientOptions{Enable0RTT: true})
+	require.NoError(t, err)
+	require.NotNil(t, rt.(*http3.RoundTripper).TLSClientConfig.ClientSessionCache)
+}
+
+func TestDoHClientCircuitBreaker(t *testing.T) {
+	var hits int
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	d, err := NewDoHClient("test-doh-breaker", srv.URL+"/dns-query", DoHClientOptions{
+		TLSConfig:      &tls.Config{RootCAs: pool},
+		CircuitBreaker: CircuitBreakerOptions{FailureThreshold: 2, Cooldown: time.Minute},
+	})
+	require.NoError(t, err)
+
+	// After 2 failures, queries should fail without reaching the server
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	for i := 0; i < 4; i++ {
+		_, err = d.Resolve(q, ClientInfo{})
+		require.Error(t, err)
+	}
+	require.Equal(t, 2, hits)
+}
+
+func TestDoHClientRetryAfter(t *testing.T) {
+	var hits int
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	d, err := NewDoHClient("test-doh-retry-after", srv.URL+"/dns-query", DoHClientOptions{
+		TLSConfig:      &tls.Config{RootCAs: pool},
+		CircuitBreaker: CircuitBreakerOptions{FailureThreshold: 5},
+	})
+	require.NoError(t, err)
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	_, err = d.Resolve(q, ClientInfo{})
+	var statusErr HTTPStatusError
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, http.StatusTooManyRequests, statusErr.Code)
+	require.Equal(t, 2*time.Minute, statusErr.RetryAfter)
+
+	// The breaker opens right away, below the failure threshold
+	_, err = d.Resolve(q, ClientInfo{})
+	require.Error(t, err)
+	require.Equal(t, 1, hits)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		after time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"-1", 0},
+		{"Mon, 01 Mar 2021 12:01:30 GMT", 90 * time.Second},
+		{"Mon, 01 Mar 2021 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.after, parseRetryAfter(test.value, now), test.value)
+	}
+}
+
+func TestDoHParseAltSvc(t *testing.T) {
+	tests := []struct {
+		header string
+		port   string
+		maxAge time.Duration
+		ok     bool
+	}{
+		{`h3=":443"; ma=3600`, "443", time.Hour, true},
+		{`h3-29=":8443"`, "8443", altSvcDefaultMaxAge, true},
+		{`h2=":443", h3="dns.example.com:4443"; ma=60`, "4443", time.Minute, true},
+		{`h3="other.example.com:443"; ma=60`, "", 0, false},
+		{`h2=":443"`, "", 0, false},
+		{`clear`, "", 0, true},
+	}
+	for _, test := range tests {
+		port, maxAge, ok := parseAltSvc(test.header, "dns.example.com")
+		require.Equal(t, test.ok, ok, test.header)
+		require.Equal(t, test.port, port, test.header)
+		require.Equal(t, test.maxAge, maxAge, test.header)
+	}
+}
+
+func TestDoHTcpTransportOptions(t *testing.T) {
+	// Defaults
+	rt, err := dohTcpTransport(DoHClientOptions{})
+	require.NoError(t, err)
+	tr := rt.(*http.Transport)
+	require.Equal(t, 30*time.Second, tr.IdleConnTimeout)
+	require.Equal(t, 10*time.Second, tr.ResponseHeaderTimeout)
+	require.Equal(t, 0, tr.MaxIdleConns)
+	require.Equal(t, 0, tr.MaxIdleConnsPerHost)
+
+	// Custom values
+	rt, err = dohTcpTransport(DoHClientOptions{
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   20,
+		IdleConnTimeout:       time.Minute,
+		ResponseHeaderTimeout: 5 * time.Second,
+	})
+	require.NoError(t, err)
+	tr = rt.(*http.Transport)
+	require.Equal(t, time.Minute, tr.IdleConnTimeout)
+	require.Equal(t, 5*time.Second, tr.ResponseHeaderTimeout)
+	require.Equal(t, 100, tr.MaxIdleConns)
+	require.Equal(t, 20, tr.MaxIdleConnsPerHost)
+}
+
+func TestDoHClientProxy(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		q := new(dns.Msg)
+		_ = q.Unpack(b)
+		a := new(dns.Msg)
+		a.SetReply(q)
+		b, _ = a.Pack()
+		w.Header().Set("content-type", "application/dns-message")
+		_, _ = w.Write(b)
+	}))
+	defer srv.Close()
+
+	// SOCKS5 proxy
+	proxyAddr, connections := testSOCKS5Proxy(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	d, err := NewDoHClient("test-doh", srv.URL+"/dns-query", DoHClientOptions{
+		TLSConfig: &tls.Config{RootCAs: pool},
+		Proxy:     "socks5://" + proxyAddr,
+	})
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	_, err = d.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), atomic.LoadInt64(connections))
+
+	// HTTP proxy
+	rt, err := dohTcpTransport(DoHClientOptions{Proxy: "http://proxy.example.com:3128"})
+	require.NoError(t, err)
+	req, _ := http.NewRequest("POST", "https://dns.example.com/dns-query", nil)
+	u, err := rt.(*http.Transport).Proxy(req)
+	require.NoError(t, err)
+	require.Equal(t, &url.URL{Scheme: "http", Host: "proxy.example.com:3128"}, u)
+
+	// Invalid configurations
+	_, err = NewDoHClient("test-doh", srv.URL+"/dns-query", DoHClientOptions{Proxy: "ftp://proxy.example.com"})
+	require.Error(t, err)
+	_, err = NewDoHClient("test-doh", srv.URL+"/dns-query", DoHClientOptions{Proxy: "socks5://127.0.0.1:9050", Transport: "quic"})
+	require.Error(t, err)
+	_, err = NewDoHClient("test-doh", srv.URL+"/dns-query", DoHClientOptions{Proxy: "socks5://127.0.0.1:9050", AutoUpgrade: true})
+	require.Error(t, err)
+}
+
+// Starts a minimal SOCKS5 proxy that supports the CONNECT command without
+// authentication. Returns its address and a counter of proxied connections.
+func testSOCKS5Proxy(t *testing.T) (string, *int64) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	var connections int64
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				// Greeting, accept "no authentication"
+				b := make([]byte, 262)
+				if _, err := io.ReadFull(conn, b[:2]); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(conn, b[:b[1]]); err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte{5, 0})
+
+				// Connect request
+				if _, err := io.ReadFull(conn, b[:4]); err != nil {
+					return
+				}
+				var host string
+				switch b[3] {
+				case 1:
+					_, _ = io.ReadFull(conn, b[:4])
+					host = net.IP(b[:4]).String()
+				case 3:
+					_, _ = io.ReadFull(conn, b[:1])
+					n := int(b[0])
+					_, _ = io.ReadFull(conn, b[:n])
+					host = string(b[:n])
+				default:
+					return
+				}
+				_, _ = io.ReadFull(conn, b[:2])
+				port := binary.BigEndian.Uint16(b[:2])
+				upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				atomic.AddInt64(&connections, 1)
+				_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go func() { _, _ = io.Copy(upstream, conn) }()
+				_, _ = io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return l.Addr().String(), &connections
+}
+
+func TestDoHClientClose(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		q := new(dns.Msg)
+		_ = q.Unpack(b)
+		a := new(dns.Msg)
+		a.SetReply(q)
+		b, _ = a.Pack()
+		w.Header().Set("content-type", "application/dns-message")
+		_, _ = w.Write(b)
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	d, err := NewDoHClient("test-doh", srv.URL+"/dns-query", DoHClientOptions{
+		TLSConfig: &tls.Config{RootCAs: pool},
+	})
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	_, err = d.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+
+	// Closing the client should close the idle connection to the server
+	require.NoError(t, d.Close())
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed")
+	}
+
+	// The client opens a new connection when used after being closed
+	_, err = d.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+}
+
+func TestDoHClientContext(t *testing.T) {
+	var requests int64
+	done := make(chan struct{})
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	d, err := NewDoHClient("test-doh", srv.URL+"/dns-query", DoHClientOptions{
+		TLSConfig:      &tls.Config{RootCAs: pool},
+		CircuitBreaker: CircuitBreakerOptions{FailureThreshold: 1},
+	})
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	// The request is cancelled once the deadline has passed
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = d.ResolveContext(ctx, q, ClientInfo{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// The deadline of queries from listeners reaches the client through
+	// resolvers that don't support contexts
+	g := NewFailBack("test-doh-context-group", FailBackOptions{}, d)
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = resolveContext(ctx, g, q, ClientInfo{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// Cancelled queries don't open the circuit breaker
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = d.ResolveContext(ctx, q, ClientInfo{})
+	require.ErrorIs(t, err, context.Canceled)
+	require.True(t, d.breaker.allow())
+	require.Equal(t, int64(2), atomic.LoadInt64(&requests))
+}
+
+func TestDoHClientForceHTTP1(t *testing.T) {
+	var proto int
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto = r.ProtoMajor
+		b, _ := ioutil.ReadAll(r.Body)
+		q := new(dns.Msg)
+		_ = q.Unpack(b)
+		a := new(dns.Msg)
+		a.SetReply(q)
+		out, _ := a.Pack()
+		w.Header().Set("content-type", "application/dns-message")
+		_, _ = w.Write(out)
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	// HTTP/2 is used by default
+	d, err := NewDoHClient("test-doh", srv.URL+"/dns-query", DoHClientOptions{TLSConfig: &tls.Config{RootCAs: pool}})
+	require.NoError(t, err)
+	_, err = d.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 2, proto)
+
+	d, err = NewDoHClient("test-doh", srv.URL+"/dns-query", DoHClientOptions{TLSConfig: &tls.Config{RootCAs: pool}, ForceHTTP1: true})
+	require.NoError(t, err)
+	_, err = d.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 1, proto)
+
+	// HTTP/1.1 can't be used with QUIC
+	_, err = NewDoHClient("test-doh", srv.URL+"/dns-query", DoHClientOptions{Transport: "quic", ForceHTTP1: true})
+	require.Error(t, err)
+	_, err = NewDoHClient("test-doh", srv.URL+"/dns-query", DoHClientOptions{AutoUpgrade: true, ForceHTTP1: true})
+	require.Error(t, err)
+}
+
+// Returns the number of open file descriptors of the process.
+func openFDs(t *testing.T) int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("open file descriptors can't be counted on this platform")
+	}
+	return len(fds)
+}
+
+func TestQUICSessionCloseReleasesSocket(t *testing.T) {
+	tlsServerConfig, err := TLSServerConfig("", "testdata/server.crt", "testdata/server.key", false)
+	require.NoError(t, err)
+	tlsServerConfig.NextProtos = []string{"test"}
+	ln, err := quic.ListenAddr("127.0.0.1:0", tlsServerConfig, nil)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	tlsConfig, err := TLSClientConfig("testdata/ca.crt", "", "")
+	require.NoError(t, err)
+	tlsConfig.NextProtos = []string{"test"}
+
+	before := openFDs(t)
+	s, err := newQuicSession("127.0.0.1", ln.Addr().String(), nil, 0, tlsConfig, &quic.Config{}, 0, false, false)
+	require.NoError(t, err)
+	require.Greater(t, openFDs(t), before)
+
+	// The UDP socket is closed along with the session
+	require.NoError(t, s.(*quicSession).Close())
+	require.Eventually(t, func() bool { return openFDs(t) <= before }, time.Second, 10*time.Millisecond)
+}
+
+func TestQUICDialFailureReleasesSocket(t *testing.T) {
+	// Nothing is listening on the port, the handshake times out
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := pc.LocalAddr().String()
+	pc.Close()
+
+	before := openFDs(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = quicDial(ctx, "localhost", addr, nil, 0, &tls.Config{NextProtos: []string{"test"}}, &quic.Config{})
+	require.Error(t, err)
+	require.Equal(t, before, openFDs(t))
+}
+
+func TestDoHECSClientIP(t *testing.T) {
+	var ecs *dns.EDNS0_SUBNET
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			ecs = nil
+			if edns0 := q.IsEdns0(); edns0 != nil {
+				for _, opt := range edns0.Option {
+					if o, ok := opt.(*dns.EDNS0_SUBNET); ok {
+						ecs = o
+					}
+				}
+			}
+			a := new(dns.Msg)
+			a.SetReply(q)
+			return a, nil
+		},
+	}
+
+	addr, err := getLnAddress()
+	require.NoError(t, err)
+	tlsServerConfig, err := TLSServerConfig("", "testdata/server.crt", "testdata/server.key", false)
+	require.NoError(t, err)
+	s, err := NewDoHListener("test-doh", addr, DoHListenerOptions{TLSConfig: tlsServerConfig}, upstream)
+	require.NoError(t, err)
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second)
+
+	tlsConfig, err := TLSClientConfig("testdata/ca.crt", "", "")
+	require.NoError(t, err)
+	u := "https://" + addr + "/dns-query"
+	ci := ClientInfo{SourceIP: net.ParseIP("192.168.1.55")}
+
+	// The client network should be added to the query
+	c, err := NewDoHClient("test-doh", u, DoHClientOptions{TLSConfig: tlsConfig, ECS: "client-ip"})
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	_, err = c.Resolve(q, ci)
+	require.NoError(t, err)
+	require.NotNil(t, ecs)
+	require.Equal(t, "192.168.1.0", ecs.Address.String())
+	require.Equal(t, uint8(24), ecs.SourceNetmask)
+
+	// Any ECS option in the query should be removed
+	c, err = NewDoHClient("test-doh", u, DoHClientOptions{TLSConfig: tlsConfig, ECS: "off"})
+	require.NoError(t, err)
+	_, err = c.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Nil(t, ecs)
+
+	_, err = NewDoHClient("test-doh", u, DoHClientOptions{ECS: "invalid"})
+	require.Error(t, err)
+}
+
+func TestDoHPinnedSPKI(t *testing.T) {
+	upstream := new(TestResolver)
+
+	addr, err := getLnAddress()
+	require.NoError(t, err)
+	tlsServerConfig, err := TLSServerConfig("", "testdata/server.crt", "testdata/server.key", false)
+	require.NoError(t, err)
+	s, err := NewDoHListener("test-doh", addr, DoHListenerOptions{TLSConfig: tlsServerConfig}, upstream)
+	require.NoError(t, err)
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second)
+
+	// Calculate the pin of the server certificate
+	cert, err := x509.ParseCertificate(tlsServerConfig.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+	h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(h[:])
+
+	tlsConfig, err := TLSClientConfig("testdata/ca.crt", "", "")
+	require.NoError(t, err)
+	u := "https://" + addr + "/dns-query"
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	// Matching pin
+	c, err := NewDoHClient("test-doh", u, DoHClientOptions{TLSConfig: tlsConfig, PinnedSPKI: []string{pin}})
+	require.NoError(t, err)
+	_, err = c.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 1, upstream.HitCount())
+
+	// Different pin, the connection should be rejected
+	other := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	c, err = NewDoHClient("test-doh", u, DoHClientOptions{TLSConfig: tlsConfig, PinnedSPKI: []string{other}})
+	require.NoError(t, err)
+	_, err = c.Resolve(q, ClientInfo{})
+	require.Error(t, err)
+	require.Equal(t, 1, upstream.HitCount())
+
+	// Invalid pin
+	_, err = NewDoHClient("test-doh", u, DoHClientOptions{PinnedSPKI: []string{"invalid"}})
+	require.Error(t, err)
+}
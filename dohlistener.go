@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -66,6 +67,7 @@ func NewDoHListenerMetrics(id string) *DoHListenerMetrics {
 			response: getVarMap("listener", id, "response"),
 			err:      getVarMap("listener", id, "error"),
 			drop:     getVarInt("listener", id, "drop"),
+			latency:  getVarMap("listener", id, "latency"),
 		},
 		get:  getVarInt("listener", id, "get"),
 		post: getVarInt("listener", id, "post"),
@@ -240,20 +242,29 @@ func (s *DoHListener) parseAndRespond(b []byte, w http.ResponseWriter, r *http.R
 		return
 	}
 	ci := ClientInfo{
-		SourceIP: clientIP,
+		SourceIP:    clientIP,
+		TLSIdentity: tlsClientIdentity(r.TLS),
 	}
 	log := Log.WithFields(logrus.Fields{"id": s.id, "client": ci.SourceIP, "qname": qName(q), "protocol": "doh", "addr": s.addr})
+	if ci.TLSIdentity != "" {
+		log = log.WithField("identity", ci.TLSIdentity)
+	}
 	log.Debug("received query")
 
 	var err error
 	a := new(dns.Msg)
 	if isAllowed(s.opt.AllowedNet, ci.SourceIP) {
 		log.WithField("resolver", s.r.String()).Debug("forwarding query to resolver")
-		a, err = s.r.Resolve(q, ci)
+		start := time.Now()
+		ctx, cancel := listenerContext(r.Context(), s.opt.ListenOptions)
+		a, err = listenerResolve(ctx, s.opt.ListenOptions, s.r, q, ci)
+		cancel()
 		if err != nil {
 			log.WithError(err).Error("failed to resolve")
 			a = new(dns.Msg)
 			a.SetRcode(q, dns.RcodeServerFailure)
+		} else {
+			s.metrics.observeLatency(start)
 		}
 	} else {
 		log.Debug("refusing client ip")
@@ -278,5 +289,22 @@ func (s *DoHListener) parseAndRespond(b []byte, w http.ResponseWriter, r *http.R
 		return
 	}
 	w.Header().Set("content-type", "application/dns-message")
+	w.Header().Set("cache-control", "max-age="+strconv.FormatUint(uint64(dohMaxAge(a)), 10))
 	_, _ = w.Write(out)
 }
+
+// Returns the time in seconds HTTP caches can keep a response, the lowest TTL
+// of the answer records as recommended in RFC8484. Responses without answer
+// records shouldn't be cached.
+func dohMaxAge(a *dns.Msg) uint32 {
+	if len(a.Answer) == 0 {
+		return 0
+	}
+	min := a.Answer[0].Header().Ttl
+	for _, rr := range a.Answer[1:] {
+		if ttl := rr.Header().Ttl; ttl < min {
+			min = ttl
+		}
+	}
+	return min
+}
@@ -1,8 +1,10 @@
 package rdns
 
 import (
+	"bytes"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -65,7 +67,13 @@ func TestDoHListenerSimple(t *testing.T) {
 }
 
 func TestDoHListenerMutual(t *testing.T) {
-	upstream := new(TestResolver)
+	var identity string
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			identity = ci.TLSIdentity
+			return q, nil
+		},
+	}
 
 	// Find a free port for the listener
 	addr, err := getLnAddress()
@@ -94,8 +102,9 @@ func TestDoHListenerMutual(t *testing.T) {
 	_, err = c.Resolve(q, ClientInfo{})
 	require.NoError(t, err)
 
-	// The upstream resolver should have seen the query
+	// The upstream resolver should have seen the query, with the identity from the client certificate
 	require.Equal(t, 1, upstream.HitCount())
+	require.Equal(t, "localhost", identity)
 }
 
 func TestDoHListenerMutualQUIC(t *testing.T) {
@@ -256,3 +265,44 @@ func TestIPv6Proxy(t *testing.T) {
 	client = s.extractClientAddress(r)
 	require.Equal(t, net.IPv4(10, 0, 1, 5), client)
 }
+
+func TestDoHListenerCacheControl(t *testing.T) {
+	var ttls []uint32
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			for _, ttl := range ttls {
+				a.Answer = append(a.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+					A:   net.IP{192, 0, 2, 1},
+				})
+			}
+			return a, nil
+		},
+	}
+	s, err := NewDoHListener("test-doh", "127.0.0.1:0", DoHListenerOptions{}, upstream)
+	require.NoError(t, err)
+
+	tests := []struct {
+		ttls   []uint32
+		maxAge string
+	}{
+		{[]uint32{300, 60, 3600}, "max-age=60"},
+		{[]uint32{0, 300}, "max-age=0"},
+		{nil, "max-age=0"},
+	}
+	for _, test := range tests {
+		ttls = test.ttls
+		q := new(dns.Msg)
+		q.SetQuestion("example.com.", dns.TypeA)
+		b, err := q.Pack()
+		require.NoError(t, err)
+		r := httptest.NewRequest("POST", "/dns-query", bytes.NewReader(b))
+		w := httptest.NewRecorder()
+		s.dohHandler(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/dns-message", w.Header().Get("Content-Type"))
+		require.Equal(t, test.maxAge, w.Header().Get("Cache-Control"))
+	}
+}
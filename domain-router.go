@@ -0,0 +1,114 @@
+package rdns
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// DomainRouter is a resolver that picks an upstream resolver based on the
+// query name. Routes map domain suffixes to resolvers and the route with the
+// longest matching suffix is used, so a route for "corp.example.com" takes
+// precedence over one for "example.com". Queries that don't match any route
+// are sent to the default resolver. Routes are stored in a trie of labels, so
+// the lookup time only depends on the number of labels in the query name, not
+// on the number of routes.
+type DomainRouter struct {
+	id   string
+	root *domainRouterNode
+}
+
+var _ Resolver = &DomainRouter{}
+
+// DomainRoute maps a domain suffix, like "corp.example.com", to a resolver.
+// The suffix matches the domain itself and all names below it.
+type DomainRoute struct {
+	Suffix   string
+	Resolver Resolver
+}
+
+type domainRouterNode struct {
+	resolver Resolver
+	children map[string]*domainRouterNode
+}
+
+// NewDomainRouter returns a new instance of a domain router. The default
+// resolver is used for the root, unless there's a route for ".".
+func NewDomainRouter(id string, resolver Resolver, routes ...DomainRoute) (*DomainRouter, error) {
+	root := &domainRouterNode{resolver: resolver}
+	for _, route := range routes {
+		if route.Resolver == nil {
+			return nil, fmt.Errorf("no resolver for domain suffix '%s'", route.Suffix)
+		}
+		suffix := strings.ToLower(dns.Fqdn(route.Suffix))
+		if _, ok := dns.IsDomainName(suffix); !ok {
+			return nil, fmt.Errorf("invalid domain suffix '%s'", route.Suffix)
+		}
+		labels := dns.SplitDomainName(suffix)
+		n := root
+		for i := len(labels) - 1; i >= 0; i-- {
+			child, ok := n.children[labels[i]]
+			if !ok {
+				if n.children == nil {
+					n.children = make(map[string]*domainRouterNode)
+				}
+				child = new(domainRouterNode)
+				n.children[labels[i]] = child
+			}
+			n = child
+		}
+		if n != root && n.resolver != nil {
+			return nil, fmt.Errorf("duplicate route for domain suffix '%s'", route.Suffix)
+		}
+		n.resolver = route.Resolver
+	}
+	if root.resolver == nil {
+		return nil, errors.New("no default resolver")
+	}
+	return &DomainRouter{
+		id:   id,
+		root: root,
+	}, nil
+}
+
+// Resolve a DNS query using the resolver of the most specific matching route.
+func (r *DomainRouter) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return nil, errors.New("no question in query")
+	}
+	log := logger(r.id, q, ci)
+	resolver := r.lookup(q.Question[0].Name)
+	log.WithField("resolver", resolver).Debug("forwarding query to resolver")
+	a, err := resolver.Resolve(q, ci)
+	if a != nil {
+		a.Id = q.Id
+	}
+	return a, err
+}
+
+func (r *DomainRouter) String() string {
+	return r.id
+}
+
+// Returns the resolver of the longest suffix matching the name. The labels
+// are walked from the end of the name without splitting it first.
+func (r *DomainRouter) lookup(name string) Resolver {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	n := r.root
+	resolver := n.resolver
+	for end := len(name); end > 0; {
+		start := strings.LastIndexByte(name[:end], '.') + 1
+		child, ok := n.children[name[start:end]]
+		if !ok {
+			break
+		}
+		n = child
+		if n.resolver != nil {
+			resolver = n.resolver
+		}
+		end = start - 1
+	}
+	return resolver
+}
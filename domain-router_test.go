@@ -0,0 +1,77 @@
+package rdns
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDomainRouter(t *testing.T) {
+	public := new(TestResolver)
+	corp := new(TestResolver)
+	internal := new(TestResolver)
+
+	g, err := NewDomainRouter("test-router", public,
+		DomainRoute{Suffix: "example.com", Resolver: corp},
+		DomainRoute{Suffix: "Internal.Example.COM.", Resolver: internal},
+	)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		expected *TestResolver
+	}{
+		{"example.com.", corp},
+		{"www.example.com.", corp},
+		{"internal.example.com.", internal},
+		{"HOST.INTERNAL.example.com.", internal},
+		{"notexample.com.", public},
+		{"example.org.", public},
+		{".", public},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.expected, g.lookup(test.name), test.name)
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("host.internal.example.com.", dns.TypeA)
+	_, err = g.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 1, internal.HitCount())
+	require.Equal(t, 0, corp.HitCount())
+	require.Equal(t, 0, public.HitCount())
+
+	// A route for the root replaces the default
+	g, err = NewDomainRouter("test-router", public, DomainRoute{Suffix: ".", Resolver: corp})
+	require.NoError(t, err)
+	require.Equal(t, corp, g.lookup("example.org."))
+
+	// Duplicate routes are rejected
+	_, err = NewDomainRouter("test-router", public,
+		DomainRoute{Suffix: "example.com", Resolver: corp},
+		DomainRoute{Suffix: "EXAMPLE.com.", Resolver: internal},
+	)
+	require.Error(t, err)
+}
+
+func BenchmarkDomainRouter(b *testing.B) {
+	var routes []DomainRoute
+	for i := 0; i < 10000; i++ {
+		routes = append(routes, DomainRoute{
+			Suffix:   fmt.Sprintf("domain%d.example%d.com", i, i%100),
+			Resolver: new(TestResolver),
+		})
+	}
+	g, err := NewDomainRouter("test-router", new(TestResolver), routes...)
+	require.NoError(b, err)
+
+	for _, name := range []string{"host.sub.domain5000.example0.com.", "host.sub.nomatch.example.org."} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				g.lookup(name)
+			}
+		})
+	}
+}
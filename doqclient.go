@@ -1,6 +1,7 @@
 package rdns
 
 import (
+	"context"
 	"crypto/tls"
 	"io/ioutil"
 	"net"
@@ -40,6 +41,9 @@ type DoQClientOptions struct {
 	// Local IP to use for outbound connections. If nil, a local address is chosen.
 	LocalAddr net.IP
 
+	// DSCP value (0-63) to mark outgoing packets with, for QoS. Not set if 0.
+	DSCP int
+
 	TLSConfig *tls.Config
 }
 
@@ -50,6 +54,9 @@ func NewDoQClient(id, endpoint string, opt DoQClientOptions) (*DoQClient, error)
 	if err := validEndpoint(endpoint); err != nil {
 		return nil, err
 	}
+	if err := validDSCP(opt.DSCP); err != nil {
+		return nil, err
+	}
 	if opt.TLSConfig == nil {
 		opt.TLSConfig = new(tls.Config)
 	}
@@ -78,6 +85,7 @@ func NewDoQClient(id, endpoint string, opt DoQClientOptions) (*DoQClient, error)
 			hostname:  host,
 			endpoint:  endpoint,
 			lAddr:     opt.LocalAddr,
+			dscp:      opt.DSCP,
 			tlsConfig: opt.TLSConfig,
 			config: &quic.Config{
 				TokenStore: quic.NewLRUTokenStore(10, 10),
@@ -94,8 +102,10 @@ func (d *DoQClient) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
 		"resolver": d.endpoint,
 		"protocol": "doq",
 	}).Debug("querying upstream resolver")
+	ci.trace.setUpstream(d.id)
 
 	d.metrics.query.Add(1)
+	start := time.Now()
 
 	// Sending a edns-tcp-keepalive EDNS(0) option over DoQ is an error. Filter it out.
 	edns0 := q.IsEdns0()
@@ -167,6 +177,9 @@ func (d *DoQClient) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
 		}
 	}
 	d.metrics.response.Add(rCode(a), 1)
+	if err == nil {
+		d.metrics.observeLatency(start)
+	}
 
 	return a, err
 }
@@ -179,6 +192,7 @@ type doqSession struct {
 	hostname  string
 	endpoint  string
 	lAddr     net.IP
+	dscp      int
 	tlsConfig *tls.Config
 	config    *quic.Config
 	log       *logrus.Entry
@@ -195,7 +209,7 @@ func (s *doqSession) getStream() (quic.Stream, error) {
 	// If we don't have a session yet, make one
 	if s.session == nil {
 		var err error
-		s.session, err = quicDial(s.hostname, s.endpoint, s.lAddr, s.tlsConfig, s.config)
+		s.session, err = quicDial(context.Background(), s.hostname, s.endpoint, s.lAddr, s.dscp, s.tlsConfig, s.config)
 		if err != nil {
 			s.log.WithError(err).Error("failed to open session")
 			return nil, err
@@ -206,7 +220,7 @@ func (s *doqSession) getStream() (quic.Stream, error) {
 	if err != nil {
 		// Try to open a new session
 		_ = s.session.CloseWithError(quic.ErrorCode(DOQNoError), "")
-		s.session, err = quicDial(s.hostname, s.endpoint, s.lAddr, s.tlsConfig, s.config)
+		s.session, err = quicDial(context.Background(), s.hostname, s.endpoint, s.lAddr, s.dscp, s.tlsConfig, s.config)
 		if err != nil {
 			s.log.WithError(err).Error("failed to open session")
 			return nil, err
@@ -49,6 +49,7 @@ func NewDoQListenerMetrics(id string) *DoQListenerMetrics {
 			response: getVarMap("listener", id, "response"),
 			drop:     getVarInt("listener", id, "drop"),
 			err:      getVarMap("listener", id, "error"),
+			latency:  getVarMap("listener", id, "latency"),
 		},
 		session: getVarInt("listener", id, "session"),
 		stream:  getVarInt("listener", id, "stream"),
@@ -111,7 +112,12 @@ func (s DoQListener) handleSession(session quic.Session) {
 	case *net.UDPAddr:
 		ci.SourceIP = addr.IP
 	}
+	state := session.ConnectionState().TLS.ConnectionState
+	ci.TLSIdentity = tlsClientIdentity(&state)
 	log := s.log.WithField("client", session.RemoteAddr())
+	if ci.TLSIdentity != "" {
+		log = log.WithField("identity", ci.TLSIdentity)
+	}
 
 	if !isAllowed(s.opt.AllowedNet, ci.SourceIP) {
 		log.Debug("rejecting incoming session")
@@ -175,11 +181,16 @@ func (s DoQListener) handleStream(stream quic.Stream, log *logrus.Entry, ci Clie
 	}
 
 	// Resolve the query using the next hop
-	a, err := s.r.Resolve(q, ci)
+	start := time.Now()
+	ctx, cancel := listenerContext(stream.Context(), s.opt.ListenOptions)
+	a, err := listenerResolve(ctx, s.opt.ListenOptions, s.r, q, ci)
+	cancel()
 	if err != nil {
 		log.WithError(err).Error("failed to resolve")
 		a = new(dns.Msg)
 		a.SetRcode(q, dns.RcodeServerFailure)
+	} else {
+		s.metrics.observeLatency(start)
 	}
 
 	out, err := a.Pack()
@@ -3,6 +3,7 @@ package rdns
 import (
 	"crypto/tls"
 	"net"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/pkg/errors"
@@ -26,7 +27,21 @@ type DoTClientOptions struct {
 	// Local IP to use for outbound connections. If nil, a local address is chosen.
 	LocalAddr net.IP
 
+	// DSCP value (0-63) to mark outgoing packets with, for QoS. Not set if 0.
+	DSCP int
+
 	TLSConfig *tls.Config
+
+	// Base64 encoded SHA-256 hashes of the server's SubjectPublicKeyInfo. If
+	// set, connections to servers with other keys are rejected.
+	PinnedSPKI []string
+
+	// Max number of connections to the upstream, queries are pipelined over
+	// them. Default 1.
+	PoolSize int
+
+	// Time after which an idle connection is closed. Default 10 seconds.
+	IdleTimeout time.Duration
 }
 
 var _ Resolver = &DoTClient{}
@@ -36,33 +51,34 @@ func NewDoTClient(id, endpoint string, opt DoTClientOptions) (*DoTClient, error)
 	if err := validEndpoint(endpoint); err != nil {
 		return nil, err
 	}
-
-	// Use a custom dialer if a local address was provided
-	var dialer *net.Dialer
-	if opt.LocalAddr != nil {
-		dialer = &net.Dialer{LocalAddr: &net.TCPAddr{IP: opt.LocalAddr}}
+	if err := validDSCP(opt.DSCP); err != nil {
+		return nil, err
 	}
-	client := &dns.Client{
-		Net:       "tcp-tls",
-		TLSConfig: opt.TLSConfig,
-		Dialer:    dialer,
+	tlsConfig, err := tlsConfigWithPinnedSPKI(opt.TLSConfig, opt.PinnedSPKI)
+	if err != nil {
+		return nil, err
 	}
-	// If a bootstrap address was provided, we need to use the IP for the connection but the
-	// hostname in the TLS handshake. The DNS library doesn't support custom dialers, so
-	// instead set the ServerName in the TLS config to the name in the endpoint config, and
-	// replace the name in the endpoint with the bootstrap IP.
 	if opt.BootstrapAddr != "" {
-		host, port, err := net.SplitHostPort(endpoint)
-		if err != nil {
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
 			return nil, errors.Wrapf(err, "failed to parse dot endpoint '%s'", endpoint)
 		}
-		client.TLSConfig.ServerName = host
-		endpoint = net.JoinHostPort(opt.BootstrapAddr, port)
+	}
+	client := &dotDialer{
+		bootstrapAddr: opt.BootstrapAddr,
+		tlsConfig:     tlsConfig,
+		dialer: &net.Dialer{
+			LocalAddr: &net.TCPAddr{IP: opt.LocalAddr},
+			Control:   dscpControl(opt.DSCP),
+			Timeout:   2 * time.Second,
+		},
 	}
 	return &DoTClient{
 		id:       id,
 		endpoint: endpoint,
-		pipeline: NewPipeline(id, endpoint, client),
+		pipeline: NewPipeline(id, endpoint, client, PipelineOptions{
+			PoolSize:    opt.PoolSize,
+			IdleTimeout: opt.IdleTimeout,
+		}),
 	}, nil
 }
 
@@ -72,12 +88,47 @@ func (d *DoTClient) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
 		"resolver": d.endpoint,
 		"protocol": "dot",
 	}).Debug("querying upstream resolver")
+	ci.trace.setUpstream(d.id)
 
 	// Add padding to the query before sending over TLS
-	padQuery(q)
+	padQuery(q, DefaultQueryPadding)
 	return d.pipeline.Resolve(q)
 }
 
 func (d *DoTClient) String() string {
 	return d.id
 }
+
+// dotDialer opens TLS connections to a DoT server. If a bootstrap address is
+// set, it's used for the connection instead of resolving the hostname in the
+// endpoint, while the hostname is still used to verify the server certificate.
+type dotDialer struct {
+	bootstrapAddr string
+	tlsConfig     *tls.Config
+	dialer        *net.Dialer
+}
+
+var _ DNSDialer = &dotDialer{}
+
+func (d *dotDialer) Dial(address string) (*dns.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := d.tlsConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+	if d.bootstrapAddr != "" {
+		address = net.JoinHostPort(d.bootstrapAddr, port)
+	}
+	conn, err := tls.DialWithDialer(d.dialer, "tcp", address, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &dns.Conn{Conn: conn}, nil
+}
@@ -2,6 +2,7 @@ package rdns
 
 import (
 	"crypto/tls"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/sirupsen/logrus"
@@ -24,13 +25,17 @@ type DoTListenerOptions struct {
 
 // NewDoTListener returns an instance of a DNS-over-TLS listener.
 func NewDoTListener(id, addr string, opt DoTListenerOptions, resolver Resolver) *DoTListener {
+	if opt.IdleTimeout == 0 {
+		opt.IdleTimeout = DefaultTCPIdleTimeout
+	}
 	return &DoTListener{
 		id: id,
 		Server: &dns.Server{
-			Addr:      addr,
-			Net:       "tcp-tls",
-			TLSConfig: opt.TLSConfig,
-			Handler:   listenHandler(id, "dot", addr, resolver, opt.AllowedNet),
+			Addr:        addr,
+			Net:         "tcp-tls",
+			TLSConfig:   opt.TLSConfig,
+			Handler:     listenHandler(id, "dot", addr, resolver, opt.ListenOptions),
+			IdleTimeout: func() time.Duration { return opt.IdleTimeout },
 		},
 	}
 }
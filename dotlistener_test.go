@@ -1,6 +1,9 @@
 package rdns
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"net"
 	"testing"
 	"time"
@@ -44,7 +47,13 @@ func TestDoTListenerSimple(t *testing.T) {
 }
 
 func TestDoTListenerMutual(t *testing.T) {
-	upstream := new(TestResolver)
+	var identity string
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			identity = ci.TLSIdentity
+			return q, nil
+		},
+	}
 
 	// Find a free port for the listener
 	addr, err := getLnAddress()
@@ -74,8 +83,9 @@ func TestDoTListenerMutual(t *testing.T) {
 	_, err = c.Resolve(q, ClientInfo{})
 	require.NoError(t, err)
 
-	// The upstream resolver should have seen the query
+	// The upstream resolver should have seen the query, with the identity from the client certificate
 	require.Equal(t, 1, upstream.HitCount())
+	require.Equal(t, "localhost", identity)
 }
 
 func TestDoTListenerPadding(t *testing.T) {
@@ -145,3 +155,90 @@ func getUDPLnAddress() (string, error) {
 	defer l.Close()
 	return l.LocalAddr().String(), nil
 }
+
+func TestDoTPinnedSPKI(t *testing.T) {
+	upstream := new(TestResolver)
+
+	addr, err := getLnAddress()
+	require.NoError(t, err)
+	tlsServerConfig, err := TLSServerConfig("", "testdata/server.crt", "testdata/server.key", false)
+	require.NoError(t, err)
+	s := NewDoTListener("test-ln", addr, DoTListenerOptions{TLSConfig: tlsServerConfig}, upstream)
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second)
+
+	// Calculate the pin of the server certificate
+	cert, err := x509.ParseCertificate(tlsServerConfig.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+	h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(h[:])
+
+	tlsConfig, err := TLSClientConfig("testdata/ca.crt", "", "")
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	// Matching pin
+	c, err := NewDoTClient("test-dot", addr, DoTClientOptions{TLSConfig: tlsConfig, PinnedSPKI: []string{pin}})
+	require.NoError(t, err)
+	_, err = c.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 1, upstream.HitCount())
+
+	// Different pin, the connection should be rejected
+	other := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	c, err = NewDoTClient("test-dot", addr, DoTClientOptions{TLSConfig: tlsConfig, PinnedSPKI: []string{other}})
+	require.NoError(t, err)
+	_, err = c.Resolve(q, ClientInfo{})
+	require.Error(t, err)
+	require.Equal(t, 1, upstream.HitCount())
+
+	// Invalid pin
+	_, err = NewDoTClient("test-dot", addr, DoTClientOptions{PinnedSPKI: []string{"invalid"}})
+	require.Error(t, err)
+}
+
+func TestDoTBootstrap(t *testing.T) {
+	upstream := new(TestResolver)
+
+	addr, err := getLnAddress()
+	require.NoError(t, err)
+	tlsServerConfig, err := TLSServerConfig("", "testdata/server.crt", "testdata/server.key", false)
+	require.NoError(t, err)
+	s := NewDoTListener("test-ln", addr, DoTListenerOptions{TLSConfig: tlsServerConfig}, upstream)
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second)
+
+	// Use a hostname that doesn't resolve in the endpoint and connect to the
+	// bootstrap address instead. The hostname is used to verify the certificate.
+	_, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	tlsConfig, err := TLSClientConfig("testdata/ca.crt", "", "")
+	require.NoError(t, err)
+	c, err := NewDoTClient("test-dot", "localhost.invalid:"+port, DoTClientOptions{
+		BootstrapAddr: "127.0.0.1",
+		LocalAddr:     net.ParseIP("127.0.0.1"),
+		TLSConfig:     tlsConfig,
+	})
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	_, err = c.Resolve(q, ClientInfo{})
+	require.Error(t, err) // Name doesn't match the certificate
+	require.Equal(t, 0, upstream.HitCount())
+
+	c, err = NewDoTClient("test-dot", "localhost:"+port, DoTClientOptions{
+		BootstrapAddr: "127.0.0.1",
+		LocalAddr:     net.ParseIP("127.0.0.1"),
+		TLSConfig:     tlsConfig,
+	})
+	require.NoError(t, err)
+	_, err = c.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 1, upstream.HitCount())
+
+	// The caller's TLS config is not modified
+	require.Empty(t, tlsConfig.ServerName)
+}
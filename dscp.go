@@ -0,0 +1,34 @@
+package rdns
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Returns an error if the DSCP value is out of range.
+func validDSCP(dscp int) error {
+	if dscp < 0 || dscp > 63 {
+		return fmt.Errorf("invalid dscp value %d, must be between 0 and 63", dscp)
+	}
+	return nil
+}
+
+// Returns a control function for net.Dialer and net.ListenConfig that marks all
+// outgoing packets of a socket with the DSCP value. Returns nil if the value is 0.
+// Failures to set the value, for example on unsupported platforms, are logged
+// but don't prevent the connection.
+func dscpControl(dscp int) func(network, address string, c syscall.RawConn) error {
+	if dscp == 0 {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) { err = setDSCP(fd, network, dscp) }); cerr != nil {
+			return cerr
+		}
+		if err != nil {
+			Log.WithField("addr", address).WithError(err).Warn("failed to set dscp")
+		}
+		return nil
+	}
+}
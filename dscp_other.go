@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !solaris
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!solaris
+
+package rdns
+
+import "errors"
+
+func setDSCP(fd uintptr, network string, dscp int) error {
+	return errors.New("dscp is not supported on this platform")
+}
@@ -0,0 +1,36 @@
+package rdns
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDSCPControl(t *testing.T) {
+	require.NoError(t, validDSCP(0))
+	require.NoError(t, validDSCP(46))
+	require.Error(t, validDSCP(64))
+	require.Error(t, validDSCP(-1))
+	require.Nil(t, dscpControl(0))
+
+	if runtime.GOOS != "linux" {
+		t.Skip("reading back the dscp value is only tested on linux")
+	}
+	lc := net.ListenConfig{Control: dscpControl(46)}
+	conn, err := lc.ListenPacket(context.Background(), "udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	raw, err := conn.(*net.UDPConn).SyscallConn()
+	require.NoError(t, err)
+	var tos int
+	require.NoError(t, raw.Control(func(fd uintptr) {
+		tos, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS)
+	}))
+	require.NoError(t, err)
+	require.Equal(t, 46<<2, tos)
+}
@@ -0,0 +1,28 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly || solaris
+// +build linux darwin freebsd netbsd openbsd dragonfly solaris
+
+package rdns
+
+import (
+	"strings"
+	"syscall"
+)
+
+// Sets the DSCP value on an IPv4 or IPv6 socket. The DSCP occupies the upper 6
+// bits of the TOS and traffic class fields.
+func setDSCP(fd uintptr, network string, dscp int) error {
+	tos := dscp << 2
+	switch {
+	case strings.HasSuffix(network, "4"):
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+	case strings.HasSuffix(network, "6"):
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	}
+	// Dual-stack socket, set both and only fail if neither works
+	err6 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	err4 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+	if err6 != nil && err4 != nil {
+		return err4
+	}
+	return nil
+}
@@ -79,7 +79,7 @@ func NewDTLSClient(id, endpoint string, opt DTLSClientOptions) (*DTLSClient, err
 	return &DTLSClient{
 		id:       id,
 		endpoint: endpoint,
-		pipeline: NewPipeline(id, endpoint, client),
+		pipeline: NewPipeline(id, endpoint, client, PipelineOptions{}),
 	}, nil
 }
 
@@ -89,9 +89,10 @@ func (d *DTLSClient) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
 		"resolver": d.endpoint,
 		"protocol": "dtls",
 	}).Debug("querying upstream resolver")
+	ci.trace.setUpstream(d.id)
 
 	// Add padding to the query before sending over TLS
-	padQuery(q)
+	padQuery(q, DefaultQueryPadding)
 	return d.pipeline.Resolve(q)
 }
 
@@ -34,7 +34,7 @@ func NewDTLSListener(id, addr string, opt DTLSListenerOptions, resolver Resolver
 		id: id,
 		Server: &dns.Server{
 			Addr:    addr,
-			Handler: listenHandler(id, "dtls", addr, resolver, opt.AllowedNet),
+			Handler: listenHandler(id, "dtls", addr, resolver, opt.ListenOptions),
 		},
 		opt: opt,
 	}
@@ -71,6 +71,9 @@ func ECSModifierAdd(addr net.IP, prefix4, prefix6 uint8) ECSModifierFunc {
 		if sourceIP == nil {
 			sourceIP = ci.SourceIP
 		}
+		if sourceIP == nil {
+			return
+		}
 
 		var (
 			family uint16
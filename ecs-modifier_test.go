@@ -0,0 +1,76 @@
+package rdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestECSModifier(t *testing.T) {
+	// Capture the ECS option seen by the upstream resolver
+	var ecs *dns.EDNS0_SUBNET
+	r := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			ecs = nil
+			if edns0 := q.IsEdns0(); edns0 != nil {
+				for _, opt := range edns0.Option {
+					if o, ok := opt.(*dns.EDNS0_SUBNET); ok {
+						ecs = o
+					}
+				}
+			}
+			a := new(dns.Msg)
+			a.SetReply(q)
+			return a, nil
+		},
+	}
+	ci := ClientInfo{SourceIP: net.ParseIP("192.168.1.55")}
+
+	// Add the client address, creating the OPT record
+	m, err := NewECSModifier("test-ecs", r, ECSModifierAdd(nil, 24, 56))
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeA)
+	_, err = m.Resolve(q, ci)
+	require.NoError(t, err)
+	require.NotNil(t, ecs)
+	require.Equal(t, uint16(1), ecs.Family)
+	require.Equal(t, uint8(24), ecs.SourceNetmask)
+	require.Equal(t, "192.168.1.0", ecs.Address.String())
+
+	// Add an explicit address, replacing the existing option
+	m, err = NewECSModifier("test-ecs", r, ECSModifierAdd(net.ParseIP("2001:db8::1"), 24, 48))
+	require.NoError(t, err)
+	_, err = m.Resolve(q, ci)
+	require.NoError(t, err)
+	require.NotNil(t, ecs)
+	require.Equal(t, uint16(2), ecs.Family)
+	require.Equal(t, uint8(48), ecs.SourceNetmask)
+	require.Equal(t, "2001:db8::", ecs.Address.String())
+	require.Len(t, q.IsEdns0().Option, 1)
+
+	// Reduce the prefix of the existing option
+	m, err = NewECSModifier("test-ecs", r, ECSModifierPrivacy(16, 32))
+	require.NoError(t, err)
+	_, err = m.Resolve(q, ci)
+	require.NoError(t, err)
+	require.NotNil(t, ecs)
+	require.Equal(t, uint8(32), ecs.SourceNetmask)
+	require.Equal(t, "2001:db8::", ecs.Address.String())
+
+	// Remove it
+	m, err = NewECSModifier("test-ecs", r, ECSModifierDelete)
+	require.NoError(t, err)
+	_, err = m.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Nil(t, ecs)
+
+	// Nothing is added without a client address
+	m, err = NewECSModifier("test-ecs", r, ECSModifierAdd(nil, 24, 56))
+	require.NoError(t, err)
+	_, err = m.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Nil(t, ecs)
+}
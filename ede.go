@@ -0,0 +1,44 @@
+package rdns
+
+import (
+	"encoding/binary"
+
+	"github.com/miekg/dns"
+)
+
+// EDNS0 option code of Extended DNS Errors, RFC8914.
+const edns0EDE = 15
+
+// EDEFiltered is the Extended DNS Error info code for responses that were
+// blocked by a filter configured by the operator, RFC8914.
+const EDEFiltered = 17
+
+// ExtendedError is an Extended DNS Error (RFC8914) that is added to synthesized
+// responses, to let clients know why they got that response.
+type ExtendedError struct {
+	InfoCode  uint16
+	ExtraText string
+}
+
+// Adds the extended error to a response and returns it. The error is only added
+// if the query has an OPT record, since clients without EDNS0 can't receive it.
+// Can be called on a nil error, in which case the response is unchanged.
+func (e *ExtendedError) addTo(q, a *dns.Msg) *dns.Msg {
+	if e == nil || a == nil {
+		return a
+	}
+	edns0 := q.IsEdns0()
+	if edns0 == nil {
+		return a
+	}
+	opt := a.IsEdns0()
+	if opt == nil {
+		a.SetEdns0(edns0.UDPSize(), edns0.Do())
+		opt = a.IsEdns0()
+	}
+	data := make([]byte, 2, 2+len(e.ExtraText))
+	binary.BigEndian.PutUint16(data, e.InfoCode)
+	data = append(data, e.ExtraText...)
+	opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: edns0EDE, Data: data})
+	return a
+}
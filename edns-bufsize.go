@@ -0,0 +1,72 @@
+package rdns
+
+import (
+	"github.com/miekg/dns"
+)
+
+// EDNSBufSize is a resolver that limits the UDP payload size advertised in the
+// OPT record of queries before forwarding them. Clients often advertise 4096
+// bytes, which can lead to fragmented responses and failures on networks with a
+// smaller path MTU.
+type EDNSBufSize struct {
+	id       string
+	resolver Resolver
+	opt      EDNSBufSizeOptions
+}
+
+var _ Resolver = &EDNSBufSize{}
+
+// EDNSBufSizeOptions contain settings for the EDNSBufSize resolver.
+type EDNSBufSizeOptions struct {
+	// Largest UDP payload size sent upstream. Defaults to 1232, as recommended
+	// by DNS flag day 2020.
+	MaxSize uint16
+
+	// Add an OPT record with the max size to queries that don't have one.
+	AddOPT bool
+}
+
+// NewEDNSBufSize returns a new instance of a UDP payload size limiter.
+func NewEDNSBufSize(id string, resolver Resolver, opt EDNSBufSizeOptions) *EDNSBufSize {
+	if opt.MaxSize == 0 {
+		opt.MaxSize = 1232
+	}
+	return &EDNSBufSize{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+	}
+}
+
+// Resolve a DNS query after limiting its UDP payload size.
+func (r *EDNSBufSize) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	edns0 := q.IsEdns0()
+	switch {
+	case edns0 != nil && edns0.UDPSize() > r.opt.MaxSize:
+		logger(r.id, q, ci).WithField("size", edns0.UDPSize()).Debug("limiting udp payload size")
+		q = q.Copy()
+		q.IsEdns0().SetUDPSize(r.opt.MaxSize)
+	case edns0 == nil && r.opt.AddOPT:
+		logger(r.id, q, ci).Debug("adding opt record to query")
+		eq := q.Copy()
+		eq.SetEdns0(r.opt.MaxSize, false)
+		a, err := r.resolver.Resolve(eq, ci)
+		if err != nil || a == nil {
+			return a, err
+		}
+		// The client didn't use EDNS0, so the response can't have an OPT record
+		var extra []dns.RR
+		for _, rr := range a.Extra {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				extra = append(extra, rr)
+			}
+		}
+		a.Extra = extra
+		return a, nil
+	}
+	return r.resolver.Resolve(q, ci)
+}
+
+func (r *EDNSBufSize) String() string {
+	return r.id
+}
@@ -0,0 +1,55 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEDNSBufSize(t *testing.T) {
+	var size uint16
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			size = 0
+			if edns0 := q.IsEdns0(); edns0 != nil {
+				size = edns0.UDPSize()
+			}
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.SetEdns0(1232, false)
+			return a, nil
+		},
+	}
+	r := NewEDNSBufSize("test-bufsize", upstream, EDNSBufSizeOptions{})
+
+	// Large payload sizes are limited, without changing the original query
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	q.SetEdns0(4096, false)
+	_, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, uint16(1232), size)
+	require.Equal(t, uint16(4096), q.IsEdns0().UDPSize())
+
+	// Smaller sizes are left alone
+	q.IsEdns0().SetUDPSize(512)
+	_, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, uint16(512), size)
+
+	// Queries without OPT record are passed on as they are by default
+	q = new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	_, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, uint16(0), size)
+
+	// Add an OPT record, but not in the response to the client
+	r = NewEDNSBufSize("test-bufsize", upstream, EDNSBufSizeOptions{MaxSize: 1400, AddOPT: true})
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, uint16(1400), size)
+	require.Nil(t, q.IsEdns0())
+	require.Nil(t, a.IsEdns0())
+}
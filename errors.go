@@ -2,6 +2,9 @@ package rdns
 
 import (
 	"fmt"
+	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -14,3 +17,37 @@ type QueryTimeoutError struct {
 func (e QueryTimeoutError) Error() string {
 	return fmt.Sprintf("query for '%s' timed out", qName(e.query))
 }
+
+// HTTPStatusError is returned by HTTP-based clients when the server responds
+// with a non-2xx status code. RetryAfter is set if the server included a valid
+// Retry-After header, typically with status 429 or 503.
+type HTTPStatusError struct {
+	Code       int
+	RetryAfter time.Duration
+}
+
+func (e HTTPStatusError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("unexpected status code %d, retry after %s", e.Code, e.RetryAfter)
+	}
+	return fmt.Sprintf("unexpected status code %d", e.Code)
+}
+
+// Parses the value of a Retry-After header, given either in seconds or as
+// HTTP-date. Returns 0 if the value is empty, invalid, or in the past.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	t, err := http.ParseTime(value)
+	if err != nil || !t.After(now) {
+		return 0
+	}
+	return t.Sub(now)
+}
@@ -16,21 +16,34 @@ import (
 // reset timer expired without any further failures, the first resolver becomes
 // active again. This group prefers the resolvers in the order they were added
 // but fails over as necessary with regular retry of the higher-priority ones.
+// If a health-check query is configured, the first resolver is probed in the
+// background after a failover instead and only becomes active again once it
+// answered the health-checks successfully for the reset time.
 type FailBack struct {
 	id        string
 	resolvers []Resolver
 	mu        sync.RWMutex
 	failCh    chan struct{} // signal the timer to reset on failure
+	probing   bool          // health-check of the first resolver is running
 	active    int
 	opt       FailBackOptions
-	metrics   *FailRouterMetrics
+	metrics   *FailBackMetrics
 }
 
 // FailBackOptions contain group-specific options.
 type FailBackOptions struct {
 	// Switch back to the first resolver in the group after no further failures
-	// for this amount of time. Default 1 minute.
+	// for this amount of time. Default 1 minute. If a health-check query is
+	// set, this is the time the first resolver needs to answer health-checks
+	// successfully before it becomes active again.
 	ResetAfter time.Duration
+
+	// Query sent to the first resolver to check if it's healthy again after a
+	// failover. If nil, the group switches back after ResetAfter without checking.
+	HealthCheckQuery *dns.Msg
+
+	// How often the health-check query is sent. Default 5 seconds.
+	HealthCheckInterval time.Duration
 }
 
 var _ Resolver = &FailBack{}
@@ -54,16 +67,28 @@ func NewFailRouterMetrics(id string, available int) *FailRouterMetrics {
 	}
 }
 
+type FailBackMetrics struct {
+	FailRouterMetrics
+	// Count of switches back to the first resolver
+	failback *expvar.Int
+}
+
 // NewFailBack returns a new instance of a failover resolver group.
 func NewFailBack(id string, opt FailBackOptions, resolvers ...Resolver) *FailBack {
 	if opt.ResetAfter == 0 {
 		opt.ResetAfter = time.Minute
 	}
+	if opt.HealthCheckInterval == 0 {
+		opt.HealthCheckInterval = 5 * time.Second
+	}
 	return &FailBack{
 		id:        id,
 		resolvers: resolvers,
 		opt:       opt,
-		metrics:   NewFailRouterMetrics(id, len(resolvers)),
+		metrics: &FailBackMetrics{
+			FailRouterMetrics: *NewFailRouterMetrics(id, len(resolvers)),
+			failback:          getVarInt("router", id, "failback"),
+		},
 	}
 }
 
@@ -112,9 +137,6 @@ func (r *FailBack) errorFrom(i int) {
 	if i != r.active {
 		return
 	}
-	if r.failCh == nil { // lazy start the reset timer
-		r.failCh = r.startResetTimer()
-	}
 	r.active = (r.active + 1) % len(r.resolvers)
 	Log.WithFields(logrus.Fields{
 		"id":       r.id,
@@ -122,6 +144,16 @@ func (r *FailBack) errorFrom(i int) {
 	}).Debug("failing over to resolver")
 	r.metrics.failover.Add(1)
 	r.metrics.available.Add(-1)
+	if r.opt.HealthCheckQuery != nil {
+		if !r.probing && r.active != 0 {
+			r.probing = true
+			go r.healthCheck()
+		}
+		return
+	}
+	if r.failCh == nil { // lazy start the reset timer
+		r.failCh = r.startResetTimer()
+	}
 	r.failCh <- struct{}{} // signal the timer to wait some more before switching back
 }
 
@@ -143,6 +175,7 @@ func (r *FailBack) startResetTimer() chan struct{} {
 				Log.WithField("resolver", r.resolvers[r.active].String()).Debug("failing back to resolver")
 				r.mu.Unlock()
 				r.metrics.available.Add(1)
+				r.metrics.failback.Add(1)
 				// we just reset to the first resolver, let's wait for another failure before running again
 				<-failCh
 			}
@@ -151,3 +184,43 @@ func (r *FailBack) startResetTimer() chan struct{} {
 	}()
 	return failCh
 }
+
+// Probe the first resolver with the health-check query until it has been healthy
+// for the reset time, then make it the active one again. Stops once the first
+// resolver is active.
+func (r *FailBack) healthCheck() {
+	log := Log.WithFields(logrus.Fields{"id": r.id, "resolver": r.resolvers[0].String()})
+	var healthySince time.Time
+	for {
+		time.Sleep(r.opt.HealthCheckInterval)
+		r.mu.Lock()
+		if r.active == 0 { // Already back on the first resolver after rotating through all
+			r.probing = false
+			r.mu.Unlock()
+			return
+		}
+		r.mu.Unlock()
+		q := r.opt.HealthCheckQuery.Copy()
+		q.Id = dns.Id()
+		a, err := r.resolvers[0].Resolve(q, ClientInfo{})
+		if err != nil || a == nil || a.Rcode == dns.RcodeServerFailure {
+			log.WithError(err).Debug("health-check failed")
+			healthySince = time.Time{}
+			continue
+		}
+		if healthySince.IsZero() {
+			healthySince = time.Now()
+		}
+		if time.Since(healthySince) < r.opt.ResetAfter {
+			continue
+		}
+		r.mu.Lock()
+		r.active = 0
+		r.probing = false
+		r.mu.Unlock()
+		log.Debug("health-check succeeded, failing back to resolver")
+		r.metrics.available.Add(1)
+		r.metrics.failback.Add(1)
+		return
+	}
+}
@@ -101,3 +101,43 @@ func TestFailBackSERVFAILAll(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, dns.RcodeServerFailure, a.Rcode)
 }
+
+func TestFailBackHealthCheck(t *testing.T) {
+	var ci ClientInfo
+	r1 := new(TestResolver)
+	r2 := new(TestResolver)
+
+	hc := new(dns.Msg)
+	hc.SetQuestion("health.example.com.", dns.TypeA)
+	g := NewFailBack("test-fb-hc", FailBackOptions{
+		ResetAfter:          200 * time.Millisecond,
+		HealthCheckQuery:    hc,
+		HealthCheckInterval: 50 * time.Millisecond,
+	}, r1, r2)
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeA)
+
+	// Fail the first resolver, queries go to the 2nd
+	r1.SetFail(true)
+	_, err := g.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 1, r2.HitCount())
+
+	// The first is still failing health-checks so it shouldn't become active again
+	time.Sleep(300 * time.Millisecond)
+	_, err = g.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 2, r2.HitCount())
+
+	// Fix the first, it needs to pass the health-checks for the reset time before it's used
+	r1.SetFail(false)
+	time.Sleep(100 * time.Millisecond)
+	_, err = g.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 3, r2.HitCount())
+
+	time.Sleep(300 * time.Millisecond)
+	_, err = g.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 3, r2.HitCount())
+}
@@ -0,0 +1,201 @@
+package rdns
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// FailMemo is a resolver group that remembers which resolvers failed for which
+// query names. Resolvers are tried in the order they were added, like in a
+// fail-back group. When a resolver fails for the same name repeatedly, queries
+// for that name go straight to the other resolvers for a cooldown period, rather
+// than retrying the failing one every time. Other names are not affected, unlike
+// with a circuit breaker. Resolvers are only skipped while there are others
+// left to try.
+type FailMemo struct {
+	id        string
+	resolvers []Resolver
+	opt       FailMemoOptions
+	metrics   *FailMemoMetrics
+
+	mu        sync.Mutex
+	memo      map[failMemoKey]*failMemoEntry
+	lastSweep time.Time
+}
+
+var _ Resolver = &FailMemo{}
+
+// FailMemoOptions contain settings for the FailMemo group.
+type FailMemoOptions struct {
+	// Number of consecutive failures of a resolver for a name after which it's
+	// skipped for that name. Default 2.
+	FailureThreshold int
+
+	// Time a resolver is skipped for a name once it reached the threshold.
+	// Default 5 minutes.
+	Cooldown time.Duration
+
+	// Treat NXDOMAIN responses as failures, for upstreams that block or don't
+	// know names that others resolve. By default only errors and SERVFAIL are
+	// failures.
+	NXDomainIsFailure bool
+}
+
+type FailMemoMetrics struct {
+	FailRouterMetrics
+	// Count of queries that skipped a resolver because it failed for the name.
+	skip *expvar.Map
+}
+
+type failMemoKey struct {
+	resolver int
+	name     string
+}
+
+type failMemoEntry struct {
+	failures int
+	last     time.Time // Time of the last failure
+	until    time.Time // The resolver is skipped for the name until then
+}
+
+// NewFailMemo returns a new instance of a resolver group that remembers failures
+// of its resolvers by query name.
+func NewFailMemo(id string, opt FailMemoOptions, resolvers ...Resolver) *FailMemo {
+	if opt.FailureThreshold <= 0 {
+		opt.FailureThreshold = 2
+	}
+	if opt.Cooldown <= 0 {
+		opt.Cooldown = 5 * time.Minute
+	}
+	return &FailMemo{
+		id:        id,
+		resolvers: resolvers,
+		opt:       opt,
+		memo:      make(map[failMemoKey]*failMemoEntry),
+		lastSweep: time.Now(),
+		metrics: &FailMemoMetrics{
+			FailRouterMetrics: *NewFailRouterMetrics(id, len(resolvers)),
+			skip:              getVarMap("router", id, "skip"),
+		},
+	}
+}
+
+// Resolve a DNS query with the first resolver that hasn't failed for the name
+// recently, failing over to the next one on error.
+func (r *FailMemo) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return r.resolvers[0].Resolve(q, ci)
+	}
+	log := logger(r.id, q, ci)
+	name := dns.CanonicalName(q.Question[0].Name)
+
+	var (
+		err error
+		a   *dns.Msg
+	)
+	for n, i := range r.order(name, time.Now()) {
+		resolver := r.resolvers[i]
+		if n > 0 {
+			r.metrics.failover.Add(1)
+		}
+		log.WithField("resolver", resolver.String()).Debug("forwarding query to resolver")
+		r.metrics.route.Add(resolver.String(), 1)
+		a, err = resolver.Resolve(q, ci)
+		if !r.isFailure(a, err) {
+			r.success(i, name)
+			return a, err
+		}
+		log.WithField("resolver", resolver.String()).WithError(err).Debug("resolver returned failure")
+		r.metrics.failure.Add(resolver.String(), 1)
+		r.failure(i, name, time.Now())
+	}
+	return a, err
+}
+
+func (r *FailMemo) String() string {
+	return r.id
+}
+
+func (r *FailMemo) isFailure(a *dns.Msg, err error) bool {
+	if err != nil {
+		return true
+	}
+	if a == nil {
+		return false
+	}
+	return a.Rcode == dns.RcodeServerFailure || (r.opt.NXDomainIsFailure && a.Rcode == dns.RcodeNameError)
+}
+
+// Returns the indexes of the resolvers in the order they should be tried for a
+// name. Resolvers that are in cooldown for the name are moved to the end, so
+// they're only used when all others failed too.
+func (r *FailMemo) order(name string, now time.Time) []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	order := make([]int, 0, len(r.resolvers))
+	var skipped []int
+	for i := range r.resolvers {
+		e, ok := r.memo[failMemoKey{i, name}]
+		if ok && now.Before(e.until) {
+			r.metrics.skip.Add(r.resolvers[i].String(), 1)
+			skipped = append(skipped, i)
+			continue
+		}
+		order = append(order, i)
+	}
+	return append(order, skipped...)
+}
+
+// Forgets all failures of a resolver for a name after it answered successfully.
+func (r *FailMemo) success(i int, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.memo, failMemoKey{i, name})
+}
+
+// Records a failure of a resolver for a name and starts the cooldown once it has
+// failed often enough. A resolver that fails again after the cooldown is skipped
+// again right away.
+func (r *FailMemo) failure(i int, name string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweep(now)
+	key := failMemoKey{i, name}
+	e, ok := r.memo[key]
+	if !ok {
+		e = new(failMemoEntry)
+		r.memo[key] = e
+	}
+	e.failures++
+	e.last = now
+	if e.failures < r.opt.FailureThreshold {
+		return
+	}
+	if e.until.IsZero() {
+		Log.WithFields(logrus.Fields{
+			"id":       r.id,
+			"resolver": r.resolvers[i].String(),
+			"qname":    name,
+			"cooldown": r.opt.Cooldown,
+		}).Debug("skipping resolver for name after repeated failures")
+	}
+	e.until = now.Add(r.opt.Cooldown)
+}
+
+// Removes entries with no failure for longer than the cooldown, at most once per
+// cooldown period. Must be called with the lock held.
+func (r *FailMemo) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < r.opt.Cooldown {
+		return
+	}
+	r.lastSweep = now
+	for key, e := range r.memo {
+		if now.Sub(e.last) >= r.opt.Cooldown {
+			delete(r.memo, key)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package rdns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailMemo(t *testing.T) {
+	// The first resolver doesn't know one name, the second knows all
+	r1 := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			if q.Question[0].Name == "missing.example.com." {
+				a.Rcode = dns.RcodeNameError
+			}
+			return a, nil
+		},
+	}
+	r2 := new(TestResolver)
+	g := NewFailMemo("test-fail-memo", FailMemoOptions{NXDomainIsFailure: true}, r1, r2)
+
+	q := new(dns.Msg)
+	q.SetQuestion("missing.example.com.", dns.TypeA)
+	other := new(dns.Msg)
+	other.SetQuestion("other.example.com.", dns.TypeA)
+
+	// The first resolver is tried for the name until it reached the threshold
+	for i := 0; i < 2; i++ {
+		a, err := g.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	}
+	require.Equal(t, 2, r1.HitCount())
+	require.Equal(t, 2, r2.HitCount())
+
+	// Now the name goes straight to the second resolver
+	a, err := g.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.Equal(t, 2, r1.HitCount())
+	require.Equal(t, 3, r2.HitCount())
+
+	// Other names still use the first resolver
+	_, err = g.Resolve(other, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 3, r1.HitCount())
+	require.Equal(t, 3, r2.HitCount())
+
+	// The first resolver is used as last resort if the second fails too
+	r2.SetFail(true)
+	a, err = g.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeNameError, a.Rcode)
+	require.Equal(t, 4, r1.HitCount())
+	require.Equal(t, 4, r2.HitCount())
+	r2.SetFail(false)
+
+	// After the cooldown, the first resolver is tried again, and skipped again
+	// right away when it still fails
+	past := time.Now().Add(-time.Hour)
+	g.mu.Lock()
+	g.memo[failMemoKey{0, "missing.example.com."}].until = past
+	g.mu.Unlock()
+	_, err = g.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 5, r1.HitCount())
+	_, err = g.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 5, r1.HitCount())
+
+	// A success clears the failures for the name
+	g.success(0, "missing.example.com.")
+	_, err = g.Resolve(other, ClientInfo{})
+	require.NoError(t, err)
+	g.mu.Lock()
+	require.Empty(t, g.memo)
+	g.mu.Unlock()
+}
+
+func TestFailMemoSweep(t *testing.T) {
+	g := NewFailMemo("test-fail-memo-sweep", FailMemoOptions{Cooldown: time.Minute}, new(TestResolver))
+	now := time.Now()
+	g.failure(0, "a.example.com.", now)
+	g.failure(0, "b.example.com.", now.Add(50*time.Second))
+
+	// Entries without failures for longer than the cooldown are removed
+	g.failure(0, "c.example.com.", now.Add(70*time.Second))
+	require.Len(t, g.memo, 2)
+	require.NotContains(t, g.memo, failMemoKey{0, "a.example.com."})
+}
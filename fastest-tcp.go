@@ -0,0 +1,526 @@
+package rdns
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// FastestTCP first resolves the query with the upstream resolver, then
+// performs TCP connection tests with the response IPs to determine which
+// IP responds the fastest. This IP is then returned in the response.
+// UDP and ICMP probes are supported as well. This should be used in
+// combination with a Cache to avoid the probe overhead on every query.
+type FastestTCP struct {
+	id       string
+	resolver Resolver
+	opt      FastestTCPOptions
+	port     string
+	cache    *probeCache
+	metrics  *FastestTCPMetrics
+}
+
+type FastestTCPMetrics struct {
+	// Histogram of query latencies, including the probes.
+	latency *expvar.Map
+	// Count of responses where all probes failed.
+	probeFailure *expvar.Int
+}
+
+var _ Resolver = &FastestTCP{}
+
+// FastestTCPOptions contain settings for a resolver that filters responses
+// based on TCP connection probes.
+type FastestTCPOptions struct {
+	// Network used for the probes, "tcp" (default), "udp", or "icmp". ICMP
+	// probes require raw sockets which usually means elevated privileges.
+	Network string
+
+	// Port number to use for TCP and UDP probes, default 443
+	Port int
+
+	// Maximum time to wait for the probes to complete. Default 2 seconds.
+	ProbeTimeout time.Duration
+
+	// Maximum number of IPs in a response to probe. Only the first Count
+	// address records are probed. In "first" mode, the others can't be picked
+	// and are dropped with the rest. In "reorder" mode, they're kept at the
+	// end, after the addresses that failed the probe. Defaults to 0 which
+	// probes all IPs in the response.
+	Count int
+
+	// Determines what is done with the probe results. "first" (default) only
+	// returns the fastest address record. "reorder" waits for all probes to
+	// complete and returns all address records, sorted by connect latency.
+	Mode string
+
+	// Time probe results are kept for a set of IPs. Queries that return the
+	// same IPs within that time use the earlier results instead of probing
+	// again. Default 0, no caching.
+	ResultTTL time.Duration
+
+	// What to do if all probes fail. "original" (default) returns the response
+	// unmodified, "first-answer" only returns the first address record, and
+	// "shuffle" returns all address records in random order.
+	OnProbeFailure string
+}
+
+// Max number of IP sets to keep probe results for.
+const probeCacheSize = 1024
+
+// NewFastestTCP returns a new instance of a TCP probe resolver.
+func NewFastestTCP(id string, resolver Resolver, opt FastestTCPOptions) (*FastestTCP, error) {
+	switch opt.Mode {
+	case "":
+		opt.Mode = "first"
+	case "first", "reorder":
+	default:
+		return nil, fmt.Errorf("unsupported fastest-tcp mode '%s'", opt.Mode)
+	}
+	switch opt.OnProbeFailure {
+	case "":
+		opt.OnProbeFailure = "original"
+	case "original", "first-answer", "shuffle":
+	default:
+		return nil, fmt.Errorf("unsupported fastest-tcp probe failure action '%s'", opt.OnProbeFailure)
+	}
+	switch opt.Network {
+	case "":
+		opt.Network = "tcp"
+	case "tcp", "udp":
+	case "icmp":
+		// Make sure raw ICMP sockets can be opened for both address families,
+		// rather than failing on every probe later
+		for _, network := range []struct{ name, address string }{{"ip4:icmp", "0.0.0.0"}, {"ip6:ipv6-icmp", "::"}} {
+			c, err := icmp.ListenPacket(network.name, network.address)
+			if err != nil {
+				return nil, fmt.Errorf("icmp probes unavailable on %s, elevated privileges required: %w", network.name, err)
+			}
+			c.Close()
+		}
+	default:
+		return nil, fmt.Errorf("unsupported fastest-tcp network '%s'", opt.Network)
+	}
+	if opt.Port == 0 {
+		opt.Port = 443
+	}
+	if opt.ProbeTimeout == 0 {
+		opt.ProbeTimeout = 2 * time.Second
+	}
+	return &FastestTCP{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		port:     strconv.Itoa(opt.Port),
+		cache:    newProbeCache(probeCacheSize, opt.ResultTTL),
+		metrics: &FastestTCPMetrics{
+			latency:      getVarMap("router", id, "latency"),
+			probeFailure: getVarInt("router", id, "probe_failure"),
+		},
+	}, nil
+}
+
+// Resolve a DNS query with the upstream resolver, then probe the IPs in the
+// response and either only return the fastest one, or reorder them by latency.
+func (r *FastestTCP) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	log := logger(r.id, q, ci)
+	start := time.Now()
+	a, err := r.resolver.Resolve(q, ci)
+	if err != nil || a == nil {
+		return a, err
+	}
+	defer func() { addLatency(r.metrics.latency, time.Since(start)) }()
+	qtype := q.Question[0].Qtype
+
+	// Only probe A or AAAA queries
+	if qtype != dns.TypeA && qtype != dns.TypeAAAA {
+		return a, nil
+	}
+
+	// Extract the address records from the response
+	var ipRRs []dns.RR
+	for _, rr := range a.Answer {
+		if rr.Header().Rrtype == qtype {
+			ipRRs = append(ipRRs, rr)
+		}
+	}
+
+	// Nothing to do if there's only one or no IP in the response
+	if len(ipRRs) < 2 {
+		return a, nil
+	}
+
+	// Limit the number of IPs to probe
+	if r.opt.Count > 0 && len(ipRRs) > r.opt.Count {
+		ipRRs = ipRRs[:r.opt.Count]
+	}
+
+	// Use earlier probe results for the same set of IPs if available
+	key := probeCacheKey(ipRRs)
+	latency, ok := r.cache.get(key)
+	if ok {
+		log.Debug("using cached probe results")
+	} else {
+		latency, err = r.probe(ipRRs)
+		if err != nil {
+			log.WithError(err).Debug("tcp probe failed")
+		}
+		// Don't cache failures, they're often temporary and the next query
+		// should probe again
+		if len(latency) > 0 {
+			r.cache.add(key, latency)
+		}
+	}
+
+	if len(latency) == 0 {
+		r.metrics.probeFailure.Add(1)
+		log.WithField("action", r.opt.OnProbeFailure).Debug("all probes failed")
+		return probeFailureResponse(a, qtype, r.opt.OnProbeFailure), nil
+	}
+
+	if r.opt.Mode == "reorder" {
+		a.Answer = reorderByLatency(a.Answer, latency, qtype)
+		log.Debug("tcp probe reordered response")
+		return a, nil
+	}
+
+	var first dns.RR
+	for _, rr := range ipRRs {
+		d, ok := latency[rrIP(rr).String()]
+		if ok && (first == nil || d < latency[rrIP(first).String()]) {
+			first = rr
+		}
+	}
+	log.WithField("rr", first).Debug("tcp probe selected response")
+	a.Answer = keepAddressRecord(a.Answer, first, qtype)
+	return a, nil
+}
+
+// Returns the response to use if all probes failed, depending on the action.
+func probeFailureResponse(a *dns.Msg, qtype uint16, action string) *dns.Msg {
+	switch action {
+	case "first-answer":
+		for _, rr := range a.Answer {
+			if rr.Header().Rrtype == qtype {
+				a.Answer = keepAddressRecord(a.Answer, rr, qtype)
+				break
+			}
+		}
+	case "shuffle":
+		AnswerShuffleRandon(a)
+	}
+	return a
+}
+
+// Prunes the answer down to one address record, leaving any other records
+// (like CNAMEs) in place.
+func keepAddressRecord(answer []dns.RR, keep dns.RR, qtype uint16) []dns.RR {
+	out := make([]dns.RR, 0, len(answer))
+	for _, rr := range answer {
+		if rr.Header().Rrtype == qtype && rr != keep {
+			continue
+		}
+		out = append(out, rr)
+	}
+	return out
+}
+
+func (r *FastestTCP) String() string {
+	return r.id
+}
+
+// Probes the IPs of all records and returns the latency of those that responded,
+// keyed by IP. In "first" mode, only the fastest IP is returned without waiting
+// for the other probes to complete. If no IP responded, the last error is returned.
+func (r *FastestTCP) probe(records []dns.RR) (map[string]time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.opt.ProbeTimeout)
+	defer cancel()
+
+	resultCh := make(chan probeResult, len(records))
+	for _, rr := range records {
+		go func(rr dns.RR) {
+			start := time.Now()
+			err := r.probeIP(ctx, rrIP(rr))
+			resultCh <- probeResult{rr, time.Since(start), err}
+		}(rr)
+	}
+
+	latency := make(map[string]time.Duration)
+	var err error
+	for range records {
+		res := <-resultCh
+		if res.err != nil {
+			err = res.err
+			continue
+		}
+		latency[rrIP(res.rr).String()] = res.rtt
+		if r.opt.Mode == "first" {
+			return latency, nil
+		}
+	}
+	if len(latency) > 0 {
+		err = nil
+	}
+	return latency, err
+}
+
+// Returns the answer section with all non-address records first, followed by the
+// address records in order of connect latency. Records that failed the probe, or
+// weren't probed, are placed at the end in their original order.
+func reorderByLatency(answer []dns.RR, latency map[string]time.Duration, qtype uint16) []dns.RR {
+	var (
+		other  = make([]dns.RR, 0, len(answer))
+		fast   []dns.RR
+		failed []dns.RR
+	)
+	for _, rr := range answer {
+		if rr.Header().Rrtype != qtype {
+			other = append(other, rr)
+			continue
+		}
+		if _, ok := latency[rrIP(rr).String()]; ok {
+			fast = append(fast, rr)
+		} else {
+			failed = append(failed, rr)
+		}
+	}
+	sort.SliceStable(fast, func(i, j int) bool {
+		return latency[rrIP(fast[i]).String()] < latency[rrIP(fast[j]).String()]
+	})
+	other = append(other, fast...)
+	return append(other, failed...)
+}
+
+type probeResult struct {
+	rr  dns.RR
+	rtt time.Duration
+	err error
+}
+
+// Probes a single IP with the configured network. Returns nil if the IP is
+// reachable.
+func (r *FastestTCP) probeIP(ctx context.Context, ip net.IP) error {
+	return probeAddress(ctx, r.opt.Network, ip, r.port)
+}
+
+// Probes an address with "tcp", "udp", or "icmp". The port is ignored for ICMP.
+// Returns nil if the address is reachable.
+func probeAddress(ctx context.Context, network string, ip net.IP, port string) error {
+	switch network {
+	case "udp":
+		return probeUDP(ctx, ip, port)
+	case "icmp":
+		return probeICMP(ctx, ip)
+	default:
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// Sends an empty UDP packet. The probe is successful if either a response or
+// an ICMP port-unreachable is received before the context expires.
+func probeUDP(ctx context.Context, ip net.IP, port string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer deadlineFromContext(ctx, conn)()
+
+	if _, err := conn.Write(nil); err != nil {
+		return err
+	}
+	_, err = conn.Read(make([]byte, 1))
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return nil
+	}
+	return err
+}
+
+// Used to identify ICMP echo requests sent by this process
+var icmpEchoID uint32
+
+// Sends an ICMP echo request and waits for the reply.
+func probeICMP(ctx context.Context, ip net.IP) error {
+	var (
+		network             = "ip4:icmp"
+		address             = "0.0.0.0"
+		proto               = 1
+		echoType  icmp.Type = ipv4.ICMPTypeEcho
+		replyType icmp.Type = ipv4.ICMPTypeEchoReply
+	)
+	if ip.To4() == nil {
+		network = "ip6:ipv6-icmp"
+		address = "::"
+		proto = 58
+		echoType = ipv6.ICMPTypeEchoRequest
+		replyType = ipv6.ICMPTypeEchoReply
+	}
+	conn, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer deadlineFromContext(ctx, conn)()
+
+	id := int(atomic.AddUint32(&icmpEchoID, 1) & 0xffff)
+	msg := icmp.Message{
+		Type: echoType,
+		Body: &icmp.Echo{ID: id, Seq: 1, Data: []byte("routedns")},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.WriteTo(b, &net.IPAddr{IP: ip}); err != nil {
+		return err
+	}
+
+	// Raw sockets receive all ICMP traffic, skip anything that isn't the reply
+	// to this request
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if addr, ok := peer.(*net.IPAddr); !ok || !addr.IP.Equal(ip) {
+			continue
+		}
+		reply, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || reply.Type != replyType {
+			continue
+		}
+		if echo, ok := reply.Body.(*icmp.Echo); ok && echo.ID == id {
+			return nil
+		}
+	}
+}
+
+// Applies the deadline of the context to a connection and unblocks any reads
+// if the context is cancelled early. The returned function must be called
+// once the connection is no longer used.
+func deadlineFromContext(ctx context.Context, conn interface{ SetDeadline(time.Time) error }) func() {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// Returns the IP address in an A or AAAA record, or nil for any other type.
+func rrIP(rr dns.RR) net.IP {
+	switch record := rr.(type) {
+	case *dns.A:
+		return record.A
+	case *dns.AAAA:
+		return record.AAAA
+	}
+	return nil
+}
+
+// Returns a cache key for a set of address records that is independent of the
+// order of the records.
+func probeCacheKey(records []dns.RR) string {
+	ips := make([]string, 0, len(records))
+	for _, rr := range records {
+		ips = append(ips, rrIP(rr).String())
+	}
+	sort.Strings(ips)
+	return strings.Join(ips, ",")
+}
+
+// probeCache is a LRU cache for probe results of sets of IPs. A nil cache
+// is valid and doesn't store anything.
+type probeCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	items    map[string]*list.Element
+	lru      *list.List
+}
+
+type probeCacheItem struct {
+	key     string
+	latency map[string]time.Duration
+	expiry  time.Time
+}
+
+func newProbeCache(capacity int, ttl time.Duration) *probeCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &probeCache{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (c *probeCache) get(key string) (map[string]time.Duration, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := e.Value.(*probeCacheItem)
+	if time.Now().After(item.expiry) {
+		c.lru.Remove(e)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return item.latency, true
+}
+
+func (c *probeCache) add(key string, latency map[string]time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item := &probeCacheItem{key: key, latency: latency, expiry: time.Now().Add(c.ttl)}
+	if e, ok := c.items[key]; ok {
+		e.Value = item
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.lru.PushFront(item)
+	for c.lru.Len() > c.capacity {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.items, e.Value.(*probeCacheItem).key)
+	}
+}
@@ -0,0 +1,239 @@
+package rdns
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFastestTCP(t *testing.T) {
+	var ci ClientInfo
+
+	// Only 127.0.0.2 accepts connections on the probe port
+	ln, err := net.Listen("tcp", "127.0.0.2:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	r := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.Answer = []dns.RR{
+				&dns.CNAME{
+					Hdr:    dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET},
+					Target: "alias.test.com.",
+				},
+				&dns.A{
+					Hdr: dns.RR_Header{Name: "alias.test.com.", Rrtype: dns.TypeA, Class: dns.ClassINET},
+					A:   net.IP{127, 0, 0, 1},
+				},
+				&dns.A{
+					Hdr: dns.RR_Header{Name: "alias.test.com.", Rrtype: dns.TypeA, Class: dns.ClassINET},
+					A:   net.IP{127, 0, 0, 2},
+				},
+			}
+			return a, nil
+		},
+	}
+
+	g, err := NewFastestTCP("test-tcp", r, FastestTCPOptions{Port: port, ProbeTimeout: time.Second})
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeA)
+
+	// The CNAME should be kept, plus the one A record that accepted the connection
+	a, err := g.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 2)
+	require.Equal(t, dns.TypeCNAME, a.Answer[0].Header().Rrtype)
+	require.Equal(t, "127.0.0.2", a.Answer[1].(*dns.A).A.String())
+
+	// Only probing the first IP should fail and return the original response
+	g, err = NewFastestTCP("test-tcp", r, FastestTCPOptions{Port: port, Count: 1})
+	require.NoError(t, err)
+	a, err = g.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 3)
+
+	// Or just the first address record if configured
+	g, err = NewFastestTCP("test-tcp-failure", r, FastestTCPOptions{Port: port, Count: 1, OnProbeFailure: "first-answer"})
+	require.NoError(t, err)
+	a, err = g.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 2)
+	require.Equal(t, dns.TypeCNAME, a.Answer[0].Header().Rrtype)
+	require.Equal(t, "127.0.0.1", a.Answer[1].(*dns.A).A.String())
+	require.Equal(t, int64(1), g.metrics.probeFailure.Value())
+
+	// Reorder mode keeps all records, with the failed IP moved to the end
+	g, err = NewFastestTCP("test-tcp", r, FastestTCPOptions{Port: port, Mode: "reorder"})
+	require.NoError(t, err)
+	a, err = g.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 3)
+	require.Equal(t, dns.TypeCNAME, a.Answer[0].Header().Rrtype)
+	require.Equal(t, "127.0.0.2", a.Answer[1].(*dns.A).A.String())
+	require.Equal(t, "127.0.0.1", a.Answer[2].(*dns.A).A.String())
+
+	// Invalid modes should fail
+	_, err = NewFastestTCP("test-tcp", r, FastestTCPOptions{Mode: "invalid"})
+	require.Error(t, err)
+	_, err = NewFastestTCP("test-tcp", r, FastestTCPOptions{OnProbeFailure: "invalid"})
+	require.Error(t, err)
+}
+
+func TestFastestTCPNetworks(t *testing.T) {
+	var ci ClientInfo
+
+	// UDP listener on 127.0.0.2 that echoes packets back
+	pc, err := net.ListenPacket("udp", "127.0.0.2:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	go func() {
+		b := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(b[:n], addr)
+		}
+	}()
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+
+	r := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.Answer = []dns.RR{
+				&dns.A{
+					Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET},
+					A:   net.IP{192, 0, 2, 1},
+				},
+				&dns.A{
+					Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET},
+					A:   net.IP{127, 0, 0, 2},
+				},
+			}
+			return a, nil
+		},
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeA)
+
+	// Only the address with the UDP listener should be returned
+	g, err := NewFastestTCP("test-udp", r, FastestTCPOptions{Network: "udp", Port: port, ProbeTimeout: 500 * time.Millisecond})
+	require.NoError(t, err)
+	a, err := g.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 1)
+	require.Equal(t, "127.0.0.2", a.Answer[0].(*dns.A).A.String())
+
+	// ICMP probes need raw sockets, skip if they're not available
+	g, err = NewFastestTCP("test-icmp", r, FastestTCPOptions{Network: "icmp", ProbeTimeout: 500 * time.Millisecond})
+	if err == nil {
+		a, err = g.Resolve(q, ci)
+		require.NoError(t, err)
+		require.Len(t, a.Answer, 1)
+		require.Equal(t, "127.0.0.2", a.Answer[0].(*dns.A).A.String())
+	}
+
+	// Invalid networks should fail
+	_, err = NewFastestTCP("test-invalid", r, FastestTCPOptions{Network: "sctp"})
+	require.Error(t, err)
+}
+
+func TestFastestTCPResultCache(t *testing.T) {
+	var ci ClientInfo
+
+	ln, err := net.Listen("tcp", "127.0.0.2:0")
+	require.NoError(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	r := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.Answer = []dns.RR{
+				&dns.A{
+					Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET},
+					A:   net.IP{127, 0, 0, 1},
+				},
+				&dns.A{
+					Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET},
+					A:   net.IP{127, 0, 0, 2},
+				},
+			}
+			return a, nil
+		},
+	}
+	g, err := NewFastestTCP("test-tcp", r, FastestTCPOptions{Port: port, ResultTTL: time.Minute})
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeA)
+
+	a, err := g.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 1)
+	require.Equal(t, "127.0.0.2", a.Answer[0].(*dns.A).A.String())
+
+	// Stop the listener, the probe would fail now. Since the result is cached,
+	// the same IP should be returned without probing again.
+	ln.Close()
+	a, err = g.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 1)
+	require.Equal(t, "127.0.0.2", a.Answer[0].(*dns.A).A.String())
+}
+
+func TestFastestTCPResultCacheFailure(t *testing.T) {
+	var ci ClientInfo
+
+	// Find a free port, nothing is listening on it to start with
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	r := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.Answer = []dns.RR{
+				&dns.A{
+					Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET},
+					A:   net.IP{127, 0, 0, 1},
+				},
+				&dns.A{
+					Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET},
+					A:   net.IP{127, 0, 0, 2},
+				},
+			}
+			return a, nil
+		},
+	}
+	g, err := NewFastestTCP("test-tcp", r, FastestTCPOptions{Port: port, ResultTTL: time.Minute})
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeA)
+
+	// All probes fail, the original response is returned
+	a, err := g.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 2)
+
+	// The failure wasn't cached, so the next query probes again and finds the
+	// address that is now listening
+	ln, err = net.Listen("tcp", fmt.Sprintf("127.0.0.2:%d", port))
+	require.NoError(t, err)
+	defer ln.Close()
+	a, err = g.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 1)
+	require.Equal(t, "127.0.0.2", a.Answer[0].(*dns.A).A.String())
+}
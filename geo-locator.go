@@ -0,0 +1,84 @@
+package rdns
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Looks up the country or continent code of IPs in a MaxMind GeoIP database.
+type geoLocator struct {
+	filename string
+	field    string
+
+	mu sync.RWMutex
+	db *maxminddb.Reader
+}
+
+// Opens the database. The field is either "country" (default) for ISO country
+// codes, or "continent" for continent codes.
+func newGeoLocator(filename, field string) (*geoLocator, error) {
+	switch field {
+	case "":
+		field = "country"
+	case "country", "continent":
+	default:
+		return nil, fmt.Errorf("unsupported geo field '%s'", field)
+	}
+	if filename == "" {
+		filename = "/usr/share/GeoIP/GeoLite2-City.mmdb"
+	}
+	db, err := maxminddb.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open geo location database file: %w", err)
+	}
+	return &geoLocator{
+		filename: filename,
+		field:    field,
+		db:       db,
+	}, nil
+}
+
+// Returns the country or continent code of an IP, or an empty string if it
+// can't be located.
+func (l *geoLocator) locate(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	var record struct {
+		Continent struct {
+			Code string `maxminddb:"code"`
+		} `maxminddb:"continent"`
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	l.mu.RLock()
+	err := l.db.Lookup(ip, &record)
+	l.mu.RUnlock()
+	if err != nil {
+		Log.WithField("ip", ip).WithError(err).Debug("failed to lookup ip in geo location database")
+		return ""
+	}
+	if l.field == "continent" {
+		return record.Continent.Code
+	}
+	return record.Country.ISOCode
+}
+
+// Opens the database file again. The current database remains in use if the
+// new one fails to open.
+func (l *geoLocator) reload() error {
+	db, err := maxminddb.Open(l.filename)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	old := l.db
+	l.db = db
+	l.mu.Unlock()
+	// Lookups hold the read lock, so the old database isn't in use anymore
+	return old.Close()
+}
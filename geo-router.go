@@ -0,0 +1,86 @@
+package rdns
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// GeoRouter is a resolver that picks an upstream resolver based on the location
+// of the client. The client IP is looked up in a MaxMind GeoIP database and its
+// country or continent code is mapped to a resolver. Queries from clients that
+// can't be located or whose location isn't mapped are sent to the default
+// resolver.
+type GeoRouter struct {
+	id       string
+	resolver Resolver
+	opt      GeoRouterOptions
+	mapping  map[string]Resolver
+	geo      *geoLocator
+}
+
+var _ Resolver = &GeoRouter{}
+
+// GeoRouterOptions contain settings for the GeoRouter resolver.
+type GeoRouterOptions struct {
+	// GeoIP database file. Default "/usr/share/GeoIP/GeoLite2-City.mmdb".
+	DatabasePath string
+
+	// Location used to pick the resolver, "country" (default) for ISO country
+	// codes like "DE", or "continent" for continent codes like "EU".
+	ByField string
+
+	// Resolvers by country or continent code.
+	Mapping map[string]Resolver
+}
+
+// NewGeoRouter returns a new instance of a GeoIP router.
+func NewGeoRouter(id string, resolver Resolver, opt GeoRouterOptions) (*GeoRouter, error) {
+	geo, err := newGeoLocator(opt.DatabasePath, opt.ByField)
+	if err != nil {
+		return nil, err
+	}
+	mapping := make(map[string]Resolver)
+	for code, r := range opt.Mapping {
+		mapping[strings.ToUpper(code)] = r
+	}
+	return &GeoRouter{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		mapping:  mapping,
+		geo:      geo,
+	}, nil
+}
+
+// Resolve a DNS query using the resolver mapped to the location of the client.
+func (r *GeoRouter) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return nil, errors.New("no question in query")
+	}
+	log := logger(r.id, q, ci)
+	resolver := r.resolver
+	location := r.geo.locate(ci.SourceIP)
+	if mapped, ok := r.mapping[location]; ok {
+		resolver = mapped
+	}
+	log.WithField("location", location).WithField("resolver", resolver).Debug("forwarding query to resolver")
+	a, err := resolver.Resolve(q, ci)
+	if a != nil {
+		a.Id = q.Id
+	}
+	return a, err
+}
+
+func (r *GeoRouter) String() string {
+	return r.id
+}
+
+// Reload the GeoIP database, for example when receiving a SIGHUP. The current
+// database remains in use if the new one fails to open.
+func (r *GeoRouter) Reload() {
+	if err := r.geo.reload(); err != nil {
+		Log.WithField("id", r.id).WithError(err).Error("failed to reload geo location database")
+	}
+}
@@ -0,0 +1,148 @@
+package rdns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// Writes a minimal IPv4 MaxMind database that maps networks to a country and a
+// continent code, and returns the filename.
+func testGeoDB(t *testing.T, dir string, networks map[string][2]string) string {
+	// Binary search tree with two records per node. 0 means no data, positive
+	// values point to another node, negative ones to -(n+1) data entries.
+	nodes := [][2]int{{0, 0}}
+	var data bytes.Buffer
+	var offsets []int
+	for network, location := range networks {
+		_, n, err := net.ParseCIDR(network)
+		require.NoError(t, err)
+		ones, _ := n.Mask.Size()
+		ip := n.IP.To4()
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[node][bit] = -(len(offsets) + 1)
+				break
+			}
+			if nodes[node][bit] <= 0 {
+				nodes = append(nodes, [2]int{0, 0})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+		offsets = append(offsets, data.Len())
+		testMMDBMap(&data, 2)
+		testMMDBString(&data, "country")
+		testMMDBMap(&data, 1)
+		testMMDBString(&data, "iso_code")
+		testMMDBString(&data, location[0])
+		testMMDBString(&data, "continent")
+		testMMDBMap(&data, 1)
+		testMMDBString(&data, "code")
+		testMMDBString(&data, location[1])
+	}
+
+	var b bytes.Buffer
+	nodeCount := len(nodes)
+	for _, node := range nodes {
+		for _, record := range node {
+			value := nodeCount // No data
+			switch {
+			case record > 0:
+				value = record
+			case record < 0:
+				value = nodeCount + 16 + offsets[-record-1]
+			}
+			b.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	b.Write(make([]byte, 16)) // Data section separator
+	b.Write(data.Bytes())
+	b.WriteString("\xab\xcd\xefMaxMind.com")
+	testMMDBMap(&b, 4)
+	testMMDBString(&b, "node_count")
+	b.WriteByte(6<<5 | 4)
+	_ = binary.Write(&b, binary.BigEndian, uint32(nodeCount))
+	testMMDBString(&b, "record_size")
+	b.Write([]byte{5<<5 | 2, 0, 24})
+	testMMDBString(&b, "ip_version")
+	b.Write([]byte{5<<5 | 2, 0, 4})
+	testMMDBString(&b, "binary_format_major_version")
+	b.Write([]byte{5<<5 | 2, 0, 2})
+
+	filename := filepath.Join(dir, "test.mmdb")
+	require.NoError(t, ioutil.WriteFile(filename, b.Bytes(), 0644))
+	return filename
+}
+
+func testMMDBMap(b *bytes.Buffer, size int) {
+	b.WriteByte(byte(7<<5 | size))
+}
+
+func testMMDBString(b *bytes.Buffer, s string) {
+	b.WriteByte(byte(2<<5 | len(s)))
+	b.WriteString(s)
+}
+
+func TestGeoRouter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routedns")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbFile := testGeoDB(t, dir, map[string][2]string{
+		"192.0.2.0/24":    {"DE", "EU"},
+		"198.51.100.0/24": {"US", "NA"},
+		"203.0.113.0/24":  {"FR", "EU"},
+	})
+
+	def := new(TestResolver)
+	de := new(TestResolver)
+	eu := new(TestResolver)
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	// By country
+	r, err := NewGeoRouter("test-geo", def, GeoRouterOptions{
+		DatabasePath: dbFile,
+		Mapping:      map[string]Resolver{"de": de},
+	})
+	require.NoError(t, err)
+	for _, ip := range []string{"192.0.2.1", "203.0.113.1", "198.51.100.1", "10.0.0.1"} {
+		_, err = r.Resolve(q, ClientInfo{SourceIP: net.ParseIP(ip)})
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, de.HitCount())
+	require.Equal(t, 3, def.HitCount())
+
+	// By continent
+	r, err = NewGeoRouter("test-geo", def, GeoRouterOptions{
+		DatabasePath: dbFile,
+		ByField:      "continent",
+		Mapping:      map[string]Resolver{"EU": eu},
+	})
+	require.NoError(t, err)
+	for _, ip := range []string{"192.0.2.1", "203.0.113.1", "198.51.100.1"} {
+		_, err = r.Resolve(q, ClientInfo{SourceIP: net.ParseIP(ip)})
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, eu.HitCount())
+	require.Equal(t, 4, def.HitCount())
+
+	// Reloading keeps the old database if the new one can't be opened
+	r.geo.filename = filepath.Join(dir, "missing.mmdb")
+	r.Reload()
+	_, err = r.Resolve(q, ClientInfo{SourceIP: net.ParseIP("192.0.2.1")})
+	require.NoError(t, err)
+	require.Equal(t, 3, eu.HitCount())
+
+	_, err = NewGeoRouter("test-geo", def, GeoRouterOptions{DatabasePath: dbFile, ByField: "city"})
+	require.Error(t, err)
+}
@@ -0,0 +1,163 @@
+package rdns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// GeoSteer is a resolver that prefers addresses in A and AAAA responses based on
+// the location of the client, a simple form of global server load balancing that
+// doesn't require changes to the upstream. The client IP is looked up in a
+// MaxMind GeoIP database, and addresses in the networks mapped to its country or
+// continent are moved to the front of the answer, or returned exclusively. The
+// response is returned unchanged if the client can't be located or none of the
+// addresses are in a preferred network.
+type GeoSteer struct {
+	id       string
+	resolver Resolver
+	opt      GeoSteerOptions
+	mapping  map[string][]*net.IPNet
+	geo      *geoLocator
+}
+
+var _ Resolver = &GeoSteer{}
+
+// GeoSteerOptions contain settings for the GeoSteer resolver.
+type GeoSteerOptions struct {
+	// GeoIP database file. Default "/usr/share/GeoIP/GeoLite2-City.mmdb".
+	DatabasePath string
+
+	// Location used to pick the preferred networks, "country" (default) for
+	// ISO country codes like "DE", or "continent" for continent codes like "EU".
+	ByField string
+
+	// Preferred networks in CIDR notation by country or continent code.
+	Mapping map[string][]string
+
+	// How preferred addresses are applied. "reorder" (default) moves them to the
+	// front of the answer, "filter" removes all other addresses of the same type.
+	Mode string
+}
+
+// NewGeoSteer returns a new instance of a GeoSteer resolver.
+func NewGeoSteer(id string, resolver Resolver, opt GeoSteerOptions) (*GeoSteer, error) {
+	switch opt.Mode {
+	case "":
+		opt.Mode = "reorder"
+	case "reorder", "filter":
+	default:
+		return nil, fmt.Errorf("unsupported geo-steer mode '%s'", opt.Mode)
+	}
+	mapping := make(map[string][]*net.IPNet)
+	for code, networks := range opt.Mapping {
+		n, err := parseCIDRs(networks)
+		if err != nil {
+			return nil, err
+		}
+		mapping[strings.ToUpper(code)] = n
+	}
+	geo, err := newGeoLocator(opt.DatabasePath, opt.ByField)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoSteer{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		mapping:  mapping,
+		geo:      geo,
+	}, nil
+}
+
+// Resolve a DNS query and prefer the addresses for the location of the client
+// in the response.
+func (r *GeoSteer) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	a, err := r.resolver.Resolve(q, ci)
+	if err != nil || a == nil {
+		return a, err
+	}
+	location := r.geo.locate(ci.SourceIP)
+	networks, ok := r.mapping[location]
+	if !ok {
+		return a, nil
+	}
+	logger(r.id, q, ci).WithField("location", location).Debug("preferring addresses for location")
+	a.Answer = r.steer(a.Answer, networks)
+	return a, nil
+}
+
+func (r *GeoSteer) String() string {
+	return r.id
+}
+
+// Reload the GeoIP database, for example when receiving a SIGHUP. The current
+// database remains in use if the new one fails to open.
+func (r *GeoSteer) Reload() {
+	if err := r.geo.reload(); err != nil {
+		Log.WithField("id", r.id).WithError(err).Error("failed to reload geo location database")
+	}
+}
+
+// Returns the records with preferred addresses first. Other records keep their
+// position ahead of the addresses. In filter mode, addresses that aren't
+// preferred are removed, unless none of the same type is.
+func (r *GeoSteer) steer(answer []dns.RR, networks []*net.IPNet) []dns.RR {
+	var (
+		other, preferred, rest []dns.RR
+		hasPreferred           = make(map[uint16]bool)
+	)
+	for _, rr := range answer {
+		var ip net.IP
+		switch record := rr.(type) {
+		case *dns.A:
+			ip = record.A
+		case *dns.AAAA:
+			ip = record.AAAA
+		default:
+			other = append(other, rr)
+			continue
+		}
+		if ipInNetworks(ip, networks) {
+			preferred = append(preferred, rr)
+			hasPreferred[rr.Header().Rrtype] = true
+		} else {
+			rest = append(rest, rr)
+		}
+	}
+	if len(preferred) == 0 {
+		return answer
+	}
+	out := append(other, preferred...)
+	for _, rr := range rest {
+		if r.opt.Mode == "filter" && hasPreferred[rr.Header().Rrtype] {
+			continue
+		}
+		out = append(out, rr)
+	}
+	return out
+}
+
+// Parses a list of networks in CIDR notation.
+func parseCIDRs(networks []string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, s := range networks {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func ipInNetworks(ip net.IP, networks []*net.IPNet) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package rdns
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoSteer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routedns")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbFile := testGeoDB(t, dir, map[string][2]string{
+		"192.0.2.0/24":    {"DE", "EU"},
+		"198.51.100.0/24": {"US", "NA"},
+	})
+
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			for _, s := range []string{
+				"www.example.com. 300 IN CNAME lb.example.com.",
+				"lb.example.com. 300 IN A 203.0.113.10",
+				"lb.example.com. 300 IN A 185.0.0.10",
+				"lb.example.com. 300 IN A 203.0.113.11",
+				"lb.example.com. 300 IN AAAA 2001:db8::10",
+			} {
+				rr, err := dns.NewRR(s)
+				require.NoError(t, err)
+				a.Answer = append(a.Answer, rr)
+			}
+			return a, nil
+		},
+	}
+	opt := GeoSteerOptions{
+		DatabasePath: dbFile,
+		ByField:      "continent",
+		Mapping:      map[string][]string{"EU": {"185.0.0.0/16"}},
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.com.", dns.TypeA)
+	addrs := func(a *dns.Msg) []string {
+		var out []string
+		for _, rr := range a.Answer {
+			switch record := rr.(type) {
+			case *dns.A:
+				out = append(out, record.A.String())
+			case *dns.AAAA:
+				out = append(out, record.AAAA.String())
+			}
+		}
+		return out
+	}
+
+	// Preferred addresses first for clients in Europe
+	r, err := NewGeoSteer("test-geo-steer", upstream, opt)
+	require.NoError(t, err)
+	a, err := r.Resolve(q, ClientInfo{SourceIP: net.ParseIP("192.0.2.1")})
+	require.NoError(t, err)
+	require.IsType(t, &dns.CNAME{}, a.Answer[0])
+	require.Equal(t, []string{"185.0.0.10", "203.0.113.10", "203.0.113.11", "2001:db8::10"}, addrs(a))
+
+	// Unchanged for others
+	a, err = r.Resolve(q, ClientInfo{SourceIP: net.ParseIP("198.51.100.1")})
+	require.NoError(t, err)
+	require.Equal(t, []string{"203.0.113.10", "185.0.0.10", "203.0.113.11", "2001:db8::10"}, addrs(a))
+
+	// Other IPv4 addresses are removed in filter mode, IPv6 is kept since none
+	// are preferred
+	opt.Mode = "filter"
+	r, err = NewGeoSteer("test-geo-steer", upstream, opt)
+	require.NoError(t, err)
+	a, err = r.Resolve(q, ClientInfo{SourceIP: net.ParseIP("192.0.2.1")})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 3)
+	require.Equal(t, []string{"185.0.0.10", "2001:db8::10"}, addrs(a))
+
+	opt.Mode = "invalid"
+	_, err = NewGeoSteer("test-geo-steer", upstream, opt)
+	require.Error(t, err)
+}
@@ -0,0 +1,100 @@
+package rdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// Delay between connection attempts to different addresses, as recommended in
+// RFC8305.
+const happyEyeballsDelay = 250 * time.Millisecond
+
+// Returns the addresses in the order they should be tried in, alternating
+// between IPv6 and IPv4, starting with IPv6.
+func happyEyeballsOrder(ips []net.IPAddr) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4 = append(v4, ip.IP)
+		} else {
+			v6 = append(v6, ip.IP)
+		}
+	}
+	out := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(v4) || i < len(v6); i++ {
+		if i < len(v6) {
+			out = append(out, v6[i])
+		}
+		if i < len(v4) {
+			out = append(out, v4[i])
+		}
+	}
+	return out
+}
+
+// Connects to the addresses in order, starting the next attempt if the previous
+// one failed or hasn't completed within the delay. Returns the first session
+// that is established. Sessions that complete after that are closed.
+func happyEyeballsDial(ctx context.Context, addrs []string, dial func(context.Context, string) (quic.Session, error)) (quic.Session, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses to connect to")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		session quic.Session
+		err     error
+	}
+	results := make(chan result, len(addrs))
+	var next, pending int
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			session, err := dial(ctx, addr)
+			results <- result{session, err}
+		}()
+	}
+
+	timer := time.NewTimer(happyEyeballsDelay)
+	defer timer.Stop()
+	start()
+	var err error
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				go func(n int) {
+					for ; n > 0; n-- {
+						if res := <-results; res.err == nil {
+							_ = res.session.CloseWithError(quic.ErrorCode(DOQNoError), "")
+						}
+					}
+				}(pending)
+				return res.session, nil
+			}
+			err = res.err
+			// Don't wait for the delay if the attempt failed already
+			if next < len(addrs) {
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(happyEyeballsDelay)
+				start()
+			}
+		case <-timer.C:
+			if next < len(addrs) {
+				timer.Reset(happyEyeballsDelay)
+				start()
+			}
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,94 @@
+package rdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHappyEyeballsOrder(t *testing.T) {
+	ips := []net.IPAddr{
+		{IP: net.ParseIP("192.0.2.1")},
+		{IP: net.ParseIP("192.0.2.2")},
+		{IP: net.ParseIP("192.0.2.3")},
+		{IP: net.ParseIP("2001:db8::1")},
+	}
+	require.Equal(t, []net.IP{
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("192.0.2.1"),
+		net.ParseIP("192.0.2.2"),
+		net.ParseIP("192.0.2.3"),
+	}, happyEyeballsOrder(ips))
+}
+
+// Session that records if it was closed.
+type testHappyEyeballsSession struct {
+	quic.Session
+	addr string
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (s *testHappyEyeballsSession) CloseWithError(quic.ErrorCode, string) error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return nil
+}
+
+func TestHappyEyeballsDial(t *testing.T) {
+	// The first address is black-holed, the second is slow and completes after
+	// the third, which should be used. The second session should be closed.
+	var mu sync.Mutex
+	sessions := make(map[string]*testHappyEyeballsSession)
+	dial := func(ctx context.Context, addr string) (quic.Session, error) {
+		var d time.Duration
+		switch addr {
+		case "[2001:db8::1]:443":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		case "192.0.2.1:443":
+			d = 400 * time.Millisecond
+		case "192.0.2.2:443":
+			d = 10 * time.Millisecond
+		}
+		time.Sleep(d)
+		s := &testHappyEyeballsSession{addr: addr}
+		mu.Lock()
+		sessions[addr] = s
+		mu.Unlock()
+		return s, nil
+	}
+	addrs := []string{"[2001:db8::1]:443", "192.0.2.1:443", "192.0.2.2:443"}
+	start := time.Now()
+	session, err := happyEyeballsDial(context.Background(), addrs, dial)
+	require.NoError(t, err)
+	require.Equal(t, "192.0.2.2:443", session.(*testHappyEyeballsSession).addr)
+	require.True(t, time.Since(start) < time.Second)
+
+	// The session that lost the race is closed
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		s, ok := sessions["192.0.2.1:443"]
+		if !ok {
+			return false
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.closed
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// All attempts fail
+	_, err = happyEyeballsDial(context.Background(), addrs, func(context.Context, string) (quic.Session, error) {
+		return nil, errors.New("failed")
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,172 @@
+package rdns
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// HTTPSHint is a resolver that looks up the HTTPS record (RFC 9460) of the
+// query name alongside A and AAAA queries, and adds it to the additional section
+// of the response. Clients that understand it learn about HTTP/3 support,
+// alternative ports and address hints without another round trip. The HTTPS
+// query is sent in parallel and only waited for briefly once the original
+// response arrives. The answer and authority sections of the original response
+// are never changed and any failure of the HTTPS lookup is ignored.
+type HTTPSHint struct {
+	id       string
+	resolver Resolver
+	opt      HTTPSHintOptions
+	types    map[uint16]bool
+	sem      chan struct{}
+	metrics  *HTTPSHintMetrics
+}
+
+var _ Resolver = &HTTPSHint{}
+
+// HTTPSHintOptions contain settings for the HTTPSHint resolver.
+type HTTPSHintOptions struct {
+	// Query types to add HTTPS records to, "A" and/or "AAAA". Defaults to both.
+	Types []string
+
+	// Max number of HTTPS lookups in flight. Queries above the limit don't
+	// get hints. Defaults to 32.
+	MaxLookups int
+
+	// Time to wait for the HTTPS lookup once the original response arrived.
+	// Defaults to 200ms.
+	Timeout time.Duration
+}
+
+// HTTPSHintMetrics contain the counters of an HTTPSHint resolver.
+type HTTPSHintMetrics struct {
+	// Count of responses with added HTTPS records.
+	added *expvar.Int
+	// Count of responses without hints by reason.
+	skipped *expvar.Map
+}
+
+type httpsHintResult struct {
+	answer *dns.Msg
+	err    error
+}
+
+// NewHTTPSHint returns a new instance of an HTTPS record resolver.
+func NewHTTPSHint(id string, resolver Resolver, opt HTTPSHintOptions) (*HTTPSHint, error) {
+	if len(opt.Types) == 0 {
+		opt.Types = []string{"A", "AAAA"}
+	}
+	types, err := stringToType(opt.Types)
+	if err != nil {
+		return nil, err
+	}
+	if opt.MaxLookups == 0 {
+		opt.MaxLookups = 32
+	}
+	if opt.MaxLookups < 0 {
+		return nil, errors.New("max lookups can not be negative")
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = 200 * time.Millisecond
+	}
+	r := &HTTPSHint{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		types:    make(map[uint16]bool),
+		sem:      make(chan struct{}, opt.MaxLookups),
+		metrics: &HTTPSHintMetrics{
+			added:   getVarInt("router", id, "added"),
+			skipped: getVarMap("router", id, "skipped"),
+		},
+	}
+	for _, t := range types {
+		if t != dns.TypeA && t != dns.TypeAAAA {
+			return nil, fmt.Errorf("unsupported query type '%s'", dns.TypeToString[t])
+		}
+		r.types[t] = true
+	}
+	return r, nil
+}
+
+// Resolve a DNS query and add the HTTPS records of the name to the response.
+func (r *HTTPSHint) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 || !r.types[q.Question[0].Qtype] {
+		return r.resolver.Resolve(q, ci)
+	}
+	log := logger(r.id, q, ci)
+
+	// Start the HTTPS lookup if there's a free slot
+	var result chan httpsHintResult
+	select {
+	case r.sem <- struct{}{}:
+		result = make(chan httpsHintResult, 1)
+		hq := q.Copy()
+		hq.Id = dns.Id()
+		hq.Question[0].Qtype = dns.TypeHTTPS
+		hci := ci.detached()
+		go func() {
+			defer func() { <-r.sem }()
+			a, err := r.resolver.Resolve(hq, hci)
+			result <- httpsHintResult{a, err}
+		}()
+	default:
+		r.metrics.skipped.Add("limit", 1)
+		log.Debug("too many https lookups in flight, skipping")
+	}
+
+	answer, err := r.resolver.Resolve(q, ci)
+	if err != nil || answer == nil || result == nil {
+		return answer, err
+	}
+	if answer.Rcode != dns.RcodeSuccess || answer.Truncated || len(answer.Answer) == 0 {
+		return answer, nil
+	}
+
+	var res httpsHintResult
+	select {
+	case res = <-result:
+	case <-time.After(r.opt.Timeout):
+		r.metrics.skipped.Add("timeout", 1)
+		log.Debug("timeout waiting for https lookup")
+		return answer, nil
+	}
+	if res.err != nil || res.answer == nil || res.answer.Rcode != dns.RcodeSuccess {
+		// Upstreams that don't support the type respond with errors like
+		// NOTIMP or FORMERR, that's not a failure of the original query.
+		r.metrics.skipped.Add("error", 1)
+		log.WithError(res.err).Debug("https lookup failed")
+		return answer, nil
+	}
+
+	// Only use HTTPS records for the query name, or the name it's aliased to
+	// in the original response.
+	name := q.Question[0].Name
+	for range answer.Answer {
+		cname := findCNAME(answer.Answer, name)
+		if cname == nil {
+			break
+		}
+		name = cname.Target
+	}
+	var hints []dns.RR
+	for _, rr := range res.answer.Answer {
+		if https, ok := rr.(*dns.HTTPS); ok && (equalName(https.Hdr.Name, q.Question[0].Name) || equalName(https.Hdr.Name, name)) {
+			hints = append(hints, rr)
+		}
+	}
+	if len(hints) == 0 {
+		return answer, nil
+	}
+	log.WithField("records", len(hints)).Debug("adding https records")
+	r.metrics.added.Add(1)
+	answer.Extra = append(answer.Extra, hints...)
+	return answer, nil
+}
+
+func (r *HTTPSHint) String() string {
+	return r.id
+}
@@ -0,0 +1,65 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPSHint(t *testing.T) {
+	var httpsRcode int
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			var record string
+			switch q.Question[0].Qtype {
+			case dns.TypeA:
+				record = "www.example.com. 60 IN A 192.0.2.1"
+			case dns.TypeHTTPS:
+				a.Rcode = httpsRcode
+				record = `www.example.com. 60 IN HTTPS 1 . alpn="h3,h2" port=8443 ipv4hint=192.0.2.1`
+			case dns.TypeMX:
+				record = "www.example.com. 60 IN MX 10 mail.example.com."
+			}
+			rr, err := dns.NewRR(record)
+			require.NoError(t, err)
+			a.Answer = []dns.RR{rr}
+			return a, nil
+		},
+	}
+	r, err := NewHTTPSHint("test-hint", upstream, HTTPSHintOptions{})
+	require.NoError(t, err)
+
+	// The HTTPS record is added to A responses
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.com.", dns.TypeA)
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 1)
+	require.Len(t, a.Extra, 1)
+	https, ok := a.Extra[0].(*dns.HTTPS)
+	require.True(t, ok)
+	require.Len(t, https.Value, 3)
+	require.Equal(t, 2, upstream.HitCount())
+
+	// Other query types are passed through
+	q.SetQuestion("www.example.com.", dns.TypeMX)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Empty(t, a.Extra)
+	require.Equal(t, 3, upstream.HitCount())
+
+	// Upstreams that don't support the type don't break the response
+	httpsRcode = dns.RcodeNotImplemented
+	q.SetQuestion("www.example.com.", dns.TypeA)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.Len(t, a.Answer, 1)
+	require.Empty(t, a.Extra)
+
+	_, err = NewHTTPSHint("test-hint", upstream, HTTPSHintOptions{Types: []string{"MX"}})
+	require.Error(t, err)
+}
@@ -0,0 +1,113 @@
+package rdns
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Inspector is a resolver that records the most recent queries passing through
+// it, along with the response code, latency, and errors returned by the upstream
+// resolver. The records are published as JSON with the other variables, under
+// "routedns.router.<id>.recent". It doesn't modify queries or responses and can
+// be used to diagnose intermittent failures without enabling debug logging.
+type Inspector struct {
+	id       string
+	resolver Resolver
+
+	mu      sync.Mutex
+	entries []InspectorEntry // Ring buffer of recent queries
+	next    int              // Index of the next entry to write
+	full    bool             // All entries have been written at least once
+}
+
+var _ Resolver = &Inspector{}
+
+// InspectorOptions contain settings for the Inspector resolver.
+type InspectorOptions struct {
+	// Number of recent queries to keep. Default 100.
+	Size int
+}
+
+// InspectorEntry is the record of one query.
+type InspectorEntry struct {
+	Time      time.Time `json:"time"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Client    string    `json:"client,omitempty"`
+	Rcode     string    `json:"rcode,omitempty"` // Empty if there was no response
+	Truncated bool      `json:"truncated,omitempty"`
+	Latency   string    `json:"latency"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// NewInspector returns a new instance of a resolver that records recent queries.
+func NewInspector(id string, resolver Resolver, opt InspectorOptions) *Inspector {
+	if opt.Size <= 0 {
+		opt.Size = 100
+	}
+	r := &Inspector{
+		id:       id,
+		resolver: resolver,
+		entries:  make([]InspectorEntry, opt.Size),
+	}
+	name := fmt.Sprintf("routedns.router.%s.recent", id)
+	if expvar.Get(name) == nil {
+		expvar.Publish(name, expvar.Func(func() interface{} { return r.Recent() }))
+	}
+	return r
+}
+
+// Resolve a DNS query with the upstream resolver and record the result.
+func (r *Inspector) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	logger(r.id, q, ci).WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+	start := time.Now()
+	a, err := r.resolver.Resolve(q, ci)
+
+	e := InspectorEntry{
+		Time:    start,
+		Name:    qName(q),
+		Latency: time.Since(start).String(),
+	}
+	if len(q.Question) > 0 {
+		e.Type = dns.Type(q.Question[0].Qtype).String()
+	}
+	if ci.SourceIP != nil {
+		e.Client = ci.SourceIP.String()
+	}
+	if a != nil {
+		e.Rcode = dns.RcodeToString[a.Rcode]
+		e.Truncated = a.Truncated
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	r.mu.Lock()
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+	r.mu.Unlock()
+
+	return a, err
+}
+
+// Recent returns the recorded queries, oldest first.
+func (r *Inspector) Recent() []InspectorEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]InspectorEntry{}, r.entries[:r.next]...)
+	}
+	out := make([]InspectorEntry, 0, len(r.entries))
+	out = append(out, r.entries[r.next:]...)
+	return append(out, r.entries[:r.next]...)
+}
+
+func (r *Inspector) String() string {
+	return r.id
+}
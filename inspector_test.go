@@ -0,0 +1,47 @@
+package rdns
+
+import (
+	"encoding/json"
+	"expvar"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspector(t *testing.T) {
+	upstream := new(TestResolver)
+	r := NewInspector("test-inspector", upstream, InspectorOptions{Size: 2})
+
+	q := new(dns.Msg)
+	ci := ClientInfo{SourceIP: net.ParseIP("192.168.1.1")}
+	for _, name := range []string{"a.test.", "b.test.", "c.test."} {
+		q.SetQuestion(name, dns.TypeA)
+		_, err := r.Resolve(q, ci)
+		require.NoError(t, err)
+	}
+
+	// Failures are recorded with the error
+	upstream.SetFail(true)
+	q.SetQuestion("d.test.", dns.TypeAAAA)
+	_, err := r.Resolve(q, ci)
+	require.Error(t, err)
+
+	// Only the last 2 queries are kept, oldest first
+	recent := r.Recent()
+	require.Len(t, recent, 2)
+	require.Equal(t, "c.test.", recent[0].Name)
+	require.Equal(t, "NOERROR", recent[0].Rcode)
+	require.Equal(t, "d.test.", recent[1].Name)
+	require.Equal(t, "AAAA", recent[1].Type)
+	require.Equal(t, "192.168.1.1", recent[1].Client)
+	require.Empty(t, recent[1].Rcode)
+	require.Equal(t, "failed", recent[1].Error)
+
+	// The entries are published as JSON
+	var published []InspectorEntry
+	err = json.Unmarshal([]byte(expvar.Get("routedns.router.test-inspector.recent").String()), &published)
+	require.NoError(t, err)
+	require.Len(t, published, 2)
+}
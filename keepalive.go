@@ -0,0 +1,69 @@
+package rdns
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultTCPIdleTimeout is the time TCP and DoT listeners keep idle connections
+// open unless configured otherwise. It's the same as the default of the DNS library.
+const DefaultTCPIdleTimeout = 8 * time.Second
+
+// Adds the EDNS0 TCP keepalive option (RFC7828) with the server's idle timeout to
+// a response if the client included the option in the query. Any keepalive option
+// coming from the upstream resolver is replaced since it only applies to the
+// connection between routedns and the upstream.
+func setKeepalive(q, a *dns.Msg, timeout time.Duration) {
+	edns0q := q.IsEdns0()
+	var requested bool
+	if edns0q != nil {
+		for _, opt := range edns0q.Option {
+			if opt.Option() == dns.EDNS0TCPKEEPALIVE {
+				requested = true
+				break
+			}
+		}
+	}
+	stripKeepalive(a)
+	if !requested {
+		return
+	}
+
+	// Add an OPT record to the answer if there isn't one already
+	edns0a := a.IsEdns0()
+	if edns0a == nil {
+		a.SetEdns0(edns0q.UDPSize(), edns0q.Do())
+		edns0a = a.IsEdns0()
+	}
+
+	// The timeout is in units of 100 milliseconds. The dns library doesn't pack its
+	// EDNS0_TCP_KEEPALIVE type correctly, so the option is built as a generic one.
+	units := timeout / (100 * time.Millisecond)
+	if units > 0xFFFF {
+		units = 0xFFFF
+	}
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, uint16(units))
+	edns0a.Option = append(edns0a.Option, &dns.EDNS0_LOCAL{
+		Code: dns.EDNS0TCPKEEPALIVE,
+		Data: data,
+	})
+}
+
+// Removes the EDNS0 TCP keepalive option from a message. It must not be used in
+// responses over UDP.
+func stripKeepalive(m *dns.Msg) {
+	edns0 := m.IsEdns0()
+	if edns0 == nil {
+		return
+	}
+	options := edns0.Option[:0]
+	for _, opt := range edns0.Option {
+		if opt.Option() != dns.EDNS0TCPKEEPALIVE {
+			options = append(options, opt)
+		}
+	}
+	edns0.Option = options
+}
@@ -0,0 +1,71 @@
+package rdns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTCPListenerKeepalive(t *testing.T) {
+	upstream := new(TestResolver)
+
+	addr, err := getLnAddress()
+	require.NoError(t, err)
+	s := NewDNSListener("test-ln", addr, "tcp", ListenOptions{IdleTimeout: 500 * time.Millisecond}, upstream)
+	go func() {
+		err := s.Start()
+		require.NoError(t, err)
+	}()
+	defer s.Shutdown()
+	time.Sleep(time.Second)
+
+	conn, err := dns.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The idle timeout is advertised to clients sending the keepalive option
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	q.SetEdns0(4096, false)
+	edns0 := q.IsEdns0()
+	edns0.Option = append(edns0.Option, &dns.EDNS0_LOCAL{Code: dns.EDNS0TCPKEEPALIVE})
+	require.NoError(t, conn.WriteMsg(q))
+	a, err := conn.ReadMsg()
+	require.NoError(t, err)
+	var keepalive *dns.EDNS0_LOCAL
+	for _, opt := range a.IsEdns0().Option {
+		if o, ok := opt.(*dns.EDNS0_LOCAL); ok && o.Code == dns.EDNS0TCPKEEPALIVE {
+			keepalive = o
+		}
+	}
+	require.NotNil(t, keepalive)
+	require.Equal(t, []byte{0, 5}, keepalive.Data)
+
+	// The connection is closed once it's idle for longer than the timeout
+	time.Sleep(time.Second)
+	_ = conn.WriteMsg(q)
+	_, err = conn.ReadMsg()
+	require.Error(t, err)
+}
+
+func TestStripKeepalive(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	q.SetEdns0(4096, false)
+	edns0 := q.IsEdns0()
+	edns0.Option = append(edns0.Option,
+		&dns.EDNS0_LOCAL{Code: dns.EDNS0TCPKEEPALIVE, Data: []byte{0, 10}},
+		&dns.EDNS0_PADDING{},
+	)
+	stripKeepalive(q)
+	require.Len(t, edns0.Option, 1)
+	require.Equal(t, uint16(dns.EDNS0PADDING), edns0.Option[0].Option())
+
+	// No keepalive option is added if the client didn't ask for it
+	a := new(dns.Msg)
+	a.SetReply(q)
+	setKeepalive(q, a, time.Minute)
+	require.Nil(t, a.IsEdns0())
+}
@@ -1,9 +1,11 @@
 package rdns
 
 import (
+	"context"
 	"expvar"
 	"fmt"
 	"net"
+	"time"
 )
 
 // Listener is an interface for a DNS listener.
@@ -16,6 +18,35 @@ type Listener interface {
 // can be used to route requests.
 type ClientInfo struct {
 	SourceIP net.IP
+
+	// Identity of the client from the TLS certificate it presented, only set
+	// with mutual TLS on DoT, DoH and DoQ listeners.
+	TLSIdentity string
+
+	// Details about how the query was answered, only set for queries that
+	// are recorded by a query log.
+	trace *queryTrace
+
+	// Context of the query, done when the listener's query timeout passed.
+	// Carried along with the query so it reaches resolvers that support
+	// cancellation through those that don't.
+	ctx context.Context
+}
+
+// Returns the context of the query, or an empty context if there is none.
+func (ci ClientInfo) queryContext() context.Context {
+	if ci.ctx == nil {
+		return context.Background()
+	}
+	return ci.ctx
+}
+
+// Returns a copy of the client info without the query context, for work that
+// carries on in the background after the query was answered. The context is
+// cancelled by then.
+func (ci ClientInfo) detached() ClientInfo {
+	ci.ctx = nil
+	return ci
 }
 
 // Metrics that are available from listeners and clients.
@@ -30,6 +61,8 @@ type ListenerMetrics struct {
 	err *expvar.Map
 	// Maximum number of queries queued (optional).
 	maxQueueLen *expvar.Int
+	// Histogram of query latencies.
+	latency *expvar.Map
 }
 
 func NewListenerMetrics(base string, id string) *ListenerMetrics {
@@ -39,5 +72,12 @@ func NewListenerMetrics(base string, id string) *ListenerMetrics {
 		drop:        getVarInt(base, id, "drop"),
 		err:         getVarMap(base, id, "error"),
 		maxQueueLen: getVarInt(base, id, "maxqueue"),
+		latency:     getVarMap(base, id, "latency"),
 	}
 }
+
+// Records the time it took to complete a query that was started at the
+// given time.
+func (m *ListenerMetrics) observeLatency(start time.Time) {
+	addLatency(m.latency, time.Since(start))
+}
@@ -0,0 +1,282 @@
+package rdns
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// LocalZone is a resolver that answers queries authoritatively from a set of
+// locally defined records, for example to serve internal names in a split-DNS
+// setup. Records can be given individually or loaded from a zone file. If the
+// records contain an SOA, all names under its owner are part of the zone and
+// queries for names that don't exist are answered with NXDOMAIN, with the SOA
+// in the authority section. Wildcard records like "*.example.com." are
+// supported. Queries for names outside the zone are forwarded to the upstream
+// resolver, or answered with NXDOMAIN if there is none. Names are matched
+// case-insensitively.
+type LocalZone struct {
+	id       string
+	resolver Resolver
+	opt      LocalZoneOptions
+
+	mu   sync.RWMutex
+	zone *localZoneData
+}
+
+var _ Resolver = &LocalZone{}
+
+// LocalZoneOptions contain settings for a LocalZone resolver.
+type LocalZoneOptions struct {
+	// Records in zone-file format, like "app.internal. 300 IN A 10.0.0.1".
+	Records []string
+
+	// Zone file in RFC1035 format. Reloaded on Reload().
+	ZoneFile string
+}
+
+// Records of a local zone, indexed for lookups.
+type localZoneData struct {
+	records map[string]map[uint16][]dns.RR // Records by lowercase name and type
+	names   map[string]bool                // All names, including empty non-terminals
+	soa     *dns.SOA                       // Optional, nil if the zone has no apex
+}
+
+// Max number of CNAMEs to follow within the local records.
+const localZoneMaxCNAME = 8
+
+// NewLocalZone returns a new instance of a LocalZone resolver. The resolver is
+// optional and used for queries that can't be answered from the local records.
+func NewLocalZone(id string, resolver Resolver, opt LocalZoneOptions) (*LocalZone, error) {
+	zone, err := loadLocalZone(opt)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalZone{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		zone:     zone,
+	}, nil
+}
+
+// Resolve a DNS query from the local records, or forward it if the name isn't defined.
+func (r *LocalZone) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return nil, errors.New("no question in query")
+	}
+	log := logger(r.id, q, ci)
+	question := q.Question[0]
+	r.mu.RLock()
+	zone := r.zone
+	r.mu.RUnlock()
+
+	types, exists := zone.lookup(question.Name)
+	if !exists && !zone.inZone(question.Name) {
+		if r.resolver != nil {
+			log.WithField("resolver", r.resolver).Debug("name not in local zone, forwarding query to resolver")
+			return r.resolver.Resolve(q, ci)
+		}
+		log.Debug("name not in local zone, responding with nxdomain")
+		answer := new(dns.Msg)
+		answer.SetRcode(q, dns.RcodeNameError)
+		answer.Authoritative = true
+		return answer, nil
+	}
+
+	answer := new(dns.Msg)
+	answer.SetReply(q)
+	answer.Authoritative = true
+
+	// Follow CNAMEs as long as the target is defined locally
+	name := question.Name
+	for i := 0; i < localZoneMaxCNAME && exists; i++ {
+		cname, ok := types[dns.TypeCNAME]
+		if !ok || question.Qtype == dns.TypeCNAME {
+			break
+		}
+		answer.Answer = append(answer.Answer, localZoneCopy(cname, name)...)
+		name = cname[0].(*dns.CNAME).Target
+		types, exists = zone.lookup(name)
+		if !exists && !zone.inZone(name) {
+			// The target isn't local, try to resolve it upstream
+			if r.resolver != nil {
+				log.WithField("resolver", r.resolver).Debug("cname target not in local zone, forwarding query to resolver")
+				return r.resolveTarget(q, answer, name, ci)
+			}
+			log.Debug("responding from local zone")
+			return answer, nil
+		}
+	}
+
+	if !exists {
+		log.Debug("name not in local zone, responding with nxdomain")
+		answer.Rcode = dns.RcodeNameError
+		answer.Ns = zone.negativeSOA()
+		return answer, nil
+	}
+	n := len(answer.Answer)
+	if question.Qtype == dns.TypeANY {
+		for _, rrs := range types {
+			answer.Answer = append(answer.Answer, localZoneCopy(rrs, name)...)
+		}
+	} else {
+		answer.Answer = append(answer.Answer, localZoneCopy(types[question.Qtype], name)...)
+	}
+	if len(answer.Answer) == n { // NODATA
+		answer.Ns = zone.negativeSOA()
+	}
+	log.Debug("responding from local zone")
+	return answer, nil
+}
+
+// Reload the records and the zone file. The current records are kept if the
+// zone file fails to load.
+func (r *LocalZone) Reload() {
+	if r.opt.ZoneFile == "" {
+		return
+	}
+	log := Log.WithField("id", r.id)
+	log.Debug("reloading zone")
+	zone, err := loadLocalZone(r.opt)
+	if err != nil {
+		log.WithError(err).Error("failed to load zone")
+		return
+	}
+	r.mu.Lock()
+	r.zone = zone
+	r.mu.Unlock()
+}
+
+func (r *LocalZone) String() string {
+	return r.id
+}
+
+// Query the upstream resolver for the target of a local CNAME and add the records
+// to the answer.
+func (r *LocalZone) resolveTarget(q, answer *dns.Msg, target string, ci ClientInfo) (*dns.Msg, error) {
+	tq := q.Copy()
+	tq.Question[0].Name = target
+	a, err := r.resolver.Resolve(tq, ci)
+	if err != nil || a == nil {
+		return a, err
+	}
+	answer.Answer = append(answer.Answer, a.Answer...)
+	answer.Ns = a.Ns
+	answer.Rcode = a.Rcode
+	return answer, nil
+}
+
+// Reads the records and the zone file given in the options.
+func loadLocalZone(opt LocalZoneOptions) (*localZoneData, error) {
+	z := &localZoneData{
+		records: make(map[string]map[uint16][]dns.RR),
+		names:   make(map[string]bool),
+	}
+	for _, record := range opt.Records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			return nil, err
+		}
+		if rr == nil { // Empty line or comment
+			continue
+		}
+		z.add(rr)
+	}
+	if opt.ZoneFile != "" {
+		f, err := os.Open(opt.ZoneFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		zp := dns.NewZoneParser(f, "", opt.ZoneFile)
+		for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+			z.add(rr)
+		}
+		if err := zp.Err(); err != nil {
+			return nil, err
+		}
+		if z.soa == nil {
+			return nil, fmt.Errorf("no SOA record in zone file '%s'", opt.ZoneFile)
+		}
+	}
+	// A CNAME can't coexist with other data for the same name
+	for name, types := range z.records {
+		if cname, ok := types[dns.TypeCNAME]; ok && (len(types) > 1 || len(cname) > 1) {
+			return nil, fmt.Errorf("CNAME for '%s' can not be combined with other records", name)
+		}
+	}
+	return z, nil
+}
+
+func (z *localZoneData) add(rr dns.RR) {
+	name := dns.CanonicalName(rr.Header().Name)
+	types, ok := z.records[name]
+	if !ok {
+		types = make(map[uint16][]dns.RR)
+		z.records[name] = types
+	}
+	types[rr.Header().Rrtype] = append(types[rr.Header().Rrtype], rr)
+	if soa, ok := rr.(*dns.SOA); ok && z.soa == nil {
+		z.soa = soa
+	}
+	// Record the name and all its parents, so empty non-terminals exist as well
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		z.names[name[off:]] = true
+	}
+}
+
+// Returns the records for a name, either directly or from a matching wildcard.
+// The boolean is false if the name doesn't exist.
+func (z *localZoneData) lookup(name string) (map[uint16][]dns.RR, bool) {
+	name = dns.CanonicalName(name)
+	if types, ok := z.records[name]; ok {
+		return types, true
+	}
+	if z.names[name] { // Empty non-terminal, only exists if it's in the zone
+		return nil, z.inZone(name)
+	}
+	// Find the closest existing parent and use its wildcard if there is one
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		parent := name[off:]
+		if !z.names[parent] {
+			continue
+		}
+		types, ok := z.records["*."+parent]
+		return types, ok
+	}
+	return nil, false
+}
+
+// Returns true if the name is at or below the apex of the zone.
+func (z *localZoneData) inZone(name string) bool {
+	return z.soa != nil && dns.IsSubDomain(z.soa.Hdr.Name, name)
+}
+
+// Returns the SOA for the authority section of negative responses, with the TTL
+// set to the negative caching TTL as per RFC2308.
+func (z *localZoneData) negativeSOA() []dns.RR {
+	if z.soa == nil {
+		return nil
+	}
+	soa := dns.Copy(z.soa).(*dns.SOA)
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+	return []dns.RR{soa}
+}
+
+// Returns copies of the records with the owner name set to the given name. This
+// preserves the case used in the query.
+func localZoneCopy(rrs []dns.RR, name string) []dns.RR {
+	out := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Name = name
+		out = append(out, rr)
+	}
+	return out
+}
@@ -0,0 +1,197 @@
+package rdns
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalZone(t *testing.T) {
+	opt := LocalZoneOptions{
+		Records: []string{
+			"app.internal. 300 IN A 10.0.0.1",
+			"app.internal. 300 IN A 10.0.0.2",
+			"app.internal. 300 IN AAAA fd00::1",
+			"app.internal. 300 IN TXT \"internal app\"",
+			"www.internal. 300 IN CNAME app.internal.",
+			"internal. 300 IN MX 10 mail.internal.",
+			"_sip._tcp.internal. 300 IN SRV 10 5 5060 sip.internal.",
+		},
+	}
+	r, err := NewLocalZone("test-local", nil, opt)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		qtype   uint16
+		rcode   int
+		answers int
+	}{
+		{"app.internal.", dns.TypeA, dns.RcodeSuccess, 2},
+		{"APP.Internal.", dns.TypeAAAA, dns.RcodeSuccess, 1},
+		{"app.internal.", dns.TypeTXT, dns.RcodeSuccess, 1},
+		{"app.internal.", dns.TypeMX, dns.RcodeSuccess, 0},
+		{"app.internal.", dns.TypeANY, dns.RcodeSuccess, 4},
+		{"www.internal.", dns.TypeA, dns.RcodeSuccess, 3},
+		{"www.internal.", dns.TypeCNAME, dns.RcodeSuccess, 1},
+		{"internal.", dns.TypeMX, dns.RcodeSuccess, 1},
+		{"_sip._tcp.internal.", dns.TypeSRV, dns.RcodeSuccess, 1},
+		{"other.internal.", dns.TypeA, dns.RcodeNameError, 0},
+	}
+	for _, test := range tests {
+		q := new(dns.Msg)
+		q.SetQuestion(test.name, test.qtype)
+		a, err := r.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+		require.True(t, a.Authoritative, test.name)
+		require.Equal(t, test.rcode, a.Rcode, test.name)
+		require.Len(t, a.Answer, test.answers, test.name)
+		if test.answers > 0 {
+			require.Equal(t, test.name, a.Answer[0].Header().Name)
+		}
+	}
+
+	// A CNAME can't be combined with other records
+	_, err = NewLocalZone("test-local", nil, LocalZoneOptions{
+		Records: []string{
+			"www.internal. IN CNAME app.internal.",
+			"www.internal. IN A 10.0.0.1",
+		},
+	})
+	require.Error(t, err)
+}
+
+func TestLocalZoneForward(t *testing.T) {
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			rr, _ := dns.NewRR(q.Question[0].Name + " IN A 1.2.3.4")
+			a.Answer = []dns.RR{rr}
+			return a, nil
+		},
+	}
+	r, err := NewLocalZone("test-local", upstream, LocalZoneOptions{
+		Records: []string{
+			"app.internal. IN A 10.0.0.1",
+			"cdn.internal. IN CNAME cdn.example.com.",
+		},
+	})
+	require.NoError(t, err)
+
+	// Local names are not forwarded
+	q := new(dns.Msg)
+	q.SetQuestion("app.internal.", dns.TypeAAAA)
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Empty(t, a.Answer)
+	require.Equal(t, 0, upstream.HitCount())
+
+	// Everything else is
+	q.SetQuestion("example.com.", dns.TypeA)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 1)
+	require.False(t, a.Authoritative)
+	require.Equal(t, 1, upstream.HitCount())
+
+	// CNAMEs pointing outside the local zone are resolved upstream
+	q.SetQuestion("cdn.internal.", dns.TypeA)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 2)
+	require.Equal(t, "cdn.example.com.", a.Answer[1].Header().Name)
+	require.Equal(t, 2, upstream.HitCount())
+}
+
+func TestLocalZoneFile(t *testing.T) {
+	upstream := new(TestResolver)
+	r, err := NewLocalZone("test-local", upstream, LocalZoneOptions{ZoneFile: "testdata/local-zone.db"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		qtype   uint16
+		rcode   int
+		answers int
+		soa     bool
+	}{
+		{"example.com.", dns.TypeSOA, dns.RcodeSuccess, 1, false},
+		{"example.com.", dns.TypeNS, dns.RcodeSuccess, 1, false},
+		{"www.example.com.", dns.TypeA, dns.RcodeSuccess, 1, false},
+		{"www.example.com.", dns.TypeAAAA, dns.RcodeSuccess, 0, true},    // NODATA
+		{"missing.example.com.", dns.TypeA, dns.RcodeNameError, 0, true}, // NXDOMAIN
+		{"x.apps.example.com.", dns.TypeA, dns.RcodeSuccess, 1, false},   // Wildcard
+		{"x.y.apps.example.com.", dns.TypeA, dns.RcodeSuccess, 1, false}, // Wildcard
+		{"x.apps.example.com.", dns.TypeMX, dns.RcodeSuccess, 0, true},
+		{"apps.example.com.", dns.TypeA, dns.RcodeSuccess, 0, true}, // Empty non-terminal
+		{"x.cdn.example.com.", dns.TypeA, dns.RcodeSuccess, 2, false},
+		{"b.c.example.com.", dns.TypeTXT, dns.RcodeSuccess, 0, true},
+		{"x.c.example.com.", dns.TypeTXT, dns.RcodeNameError, 0, true},
+	}
+	for _, test := range tests {
+		q := new(dns.Msg)
+		q.SetQuestion(test.name, test.qtype)
+		a, err := r.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+		require.True(t, a.Authoritative, test.name)
+		require.Equal(t, test.rcode, a.Rcode, test.name)
+		require.Len(t, a.Answer, test.answers, test.name)
+		if test.answers > 0 {
+			require.Equal(t, test.name, a.Answer[0].Header().Name)
+		}
+		if test.soa {
+			require.Len(t, a.Ns, 1, test.name)
+			require.Equal(t, uint32(300), a.Ns[0].Header().Ttl)
+		} else {
+			require.Empty(t, a.Ns, test.name)
+		}
+	}
+	require.Equal(t, 0, upstream.HitCount())
+
+	// Names outside the zone are forwarded
+	q := new(dns.Msg)
+	q.SetQuestion("example.net.", dns.TypeA)
+	_, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 1, upstream.HitCount())
+
+	// A zone file without SOA is rejected
+	_, err = NewLocalZone("test-local", nil, LocalZoneOptions{ZoneFile: "testdata/ca.crt"})
+	require.Error(t, err)
+}
+
+func TestLocalZoneReload(t *testing.T) {
+	f, err := ioutil.TempFile("", "routedns")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	zone := "example.com. IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300\n"
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte(zone), 0644))
+
+	r, err := NewLocalZone("test-local", nil, LocalZoneOptions{ZoneFile: f.Name()})
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.com.", dns.TypeA)
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeNameError, a.Rcode)
+
+	// Add a record and reload
+	zone += "www.example.com. IN A 192.0.2.10\n"
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte(zone), 0644))
+	r.Reload()
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.Len(t, a.Answer, 1)
+
+	// Invalid zone files are ignored
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("invalid"), 0644))
+	r.Reload()
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 1)
+}
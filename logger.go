@@ -10,10 +10,14 @@ import (
 var Log = logrus.New()
 
 func logger(id string, q *dns.Msg, ci ClientInfo) *logrus.Entry {
-	return Log.WithFields(logrus.Fields{
+	fields := logrus.Fields{
 		"id":     id,
 		"client": ci.SourceIP,
 		"qtype":  dns.Type(q.Question[0].Qtype).String(),
 		"qname":  qName(q),
-	})
+	}
+	if ci.TLSIdentity != "" {
+		fields["identity"] = ci.TLSIdentity
+	}
+	return Log.WithFields(fields)
 }
@@ -0,0 +1,74 @@
+package rdns
+
+import (
+	"expvar"
+
+	"github.com/miekg/dns"
+)
+
+// LoopGuard is a resolver that protects clients from malformed upstream responses
+// with CNAME chains that loop back on themselves, like A -> B -> A. It follows the
+// CNAME chain in the answer starting at the query name and responds with SERVFAIL
+// if a name is seen twice or the chain is longer than the configured maximum.
+type LoopGuard struct {
+	id       string
+	resolver Resolver
+	opt      LoopGuardOptions
+	loops    *expvar.Map
+}
+
+var _ Resolver = &LoopGuard{}
+
+// LoopGuardOptions contain settings for the LoopGuard resolver.
+type LoopGuardOptions struct {
+	// Maximum number of CNAME records in a chain. Defaults to 16.
+	MaxChain int
+}
+
+// NewLoopGuard returns a new instance of a CNAME loop guard.
+func NewLoopGuard(id string, resolver Resolver, opt LoopGuardOptions) *LoopGuard {
+	if opt.MaxChain <= 0 {
+		opt.MaxChain = 16
+	}
+	return &LoopGuard{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		loops:    getVarMap("router", id, "loop"),
+	}
+}
+
+// Resolve a DNS query with the upstream resolver and fail the response if it
+// contains a looping or overly long CNAME chain.
+func (r *LoopGuard) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	answer, err := r.resolver.Resolve(q, ci)
+	if err != nil || answer == nil || len(q.Question) < 1 {
+		return answer, err
+	}
+	log := logger(r.id, q, ci)
+
+	name := dns.CanonicalName(q.Question[0].Name)
+	visited := map[string]struct{}{name: {}}
+	for length := 1; ; length++ {
+		cname := findCNAME(answer.Answer, name)
+		if cname == nil {
+			return answer, nil
+		}
+		if length > r.opt.MaxChain {
+			log.WithField("max", r.opt.MaxChain).Warn("cname chain too long, responding with servfail")
+			r.loops.Add("max-chain", 1)
+			return servfail(q), nil
+		}
+		name = dns.CanonicalName(cname.Target)
+		if _, ok := visited[name]; ok {
+			log.WithField("target", name).Warn("cname loop in response, responding with servfail")
+			r.loops.Add("cycle", 1)
+			return servfail(q), nil
+		}
+		visited[name] = struct{}{}
+	}
+}
+
+func (r *LoopGuard) String() string {
+	return r.id
+}
@@ -0,0 +1,68 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoopGuard(t *testing.T) {
+	var records []string
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			for _, s := range records {
+				rr, err := dns.NewRR(s)
+				require.NoError(t, err)
+				a.Answer = append(a.Answer, rr)
+			}
+			return a, nil
+		},
+	}
+	r := NewLoopGuard("test-loop-guard", upstream, LoopGuardOptions{MaxChain: 2})
+	q := new(dns.Msg)
+	q.SetQuestion("a.example.com.", dns.TypeA)
+
+	// A chain without loops is passed through
+	records = []string{
+		"a.example.com. 60 IN CNAME b.example.com.",
+		"b.example.com. 60 IN CNAME c.example.com.",
+		"c.example.com. 60 IN A 192.0.2.1",
+	}
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.Len(t, a.Answer, 3)
+
+	// A loop back to the query name, with different case
+	records = []string{
+		"a.example.com. 60 IN CNAME B.example.com.",
+		"b.example.com. 60 IN CNAME A.EXAMPLE.COM.",
+	}
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeServerFailure, a.Rcode)
+	require.Empty(t, a.Answer)
+
+	// A loop further down the chain
+	records = []string{
+		"a.example.com. 60 IN CNAME b.example.com.",
+		"b.example.com. 60 IN CNAME b.example.com.",
+	}
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeServerFailure, a.Rcode)
+
+	// The chain is longer than the maximum
+	records = []string{
+		"a.example.com. 60 IN CNAME b.example.com.",
+		"b.example.com. 60 IN CNAME c.example.com.",
+		"c.example.com. 60 IN CNAME d.example.com.",
+		"d.example.com. 60 IN A 192.0.2.1",
+	}
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeServerFailure, a.Rcode)
+}
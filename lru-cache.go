@@ -26,6 +26,7 @@ type lruKey struct {
 type cacheAnswer struct {
 	timestamp time.Time // Time the record was cached. Needed to adjust TTL
 	expiry    time.Time // Time the record expires and should be removed
+	hits      int       // Number of times the record was served from the cache
 	*dns.Msg
 }
 
@@ -44,9 +45,15 @@ func newLRUCache(capacity int) *lruCache {
 }
 
 func (c *lruCache) add(query *dns.Msg, answer *cacheAnswer) {
-	key := lruKeyFromQuery(query)
+	c.addKey(lruKeyFromQuery(query), answer)
+}
+
+func (c *lruCache) addKey(key lruKey, answer *cacheAnswer) {
 	item := c.touch(key)
 	if item != nil {
+		// Replace the answer of an existing item, this happens when stale
+		// items are refreshed
+		item.cacheAnswer = answer
 		return
 	}
 	// Add new item to the top of the linked list
@@ -79,7 +86,10 @@ func (c *lruCache) touch(key lruKey) *cacheItem {
 }
 
 func (c *lruCache) delete(q *dns.Msg) {
-	key := lruKeyFromQuery(q)
+	c.deleteKey(lruKeyFromQuery(q))
+}
+
+func (c *lruCache) deleteKey(key lruKey) {
 	item := c.items[key]
 	if item == nil {
 		return
@@ -90,7 +100,10 @@ func (c *lruCache) delete(q *dns.Msg) {
 }
 
 func (c *lruCache) get(query *dns.Msg) *cacheAnswer {
-	key := lruKeyFromQuery(query)
+	return c.getKey(lruKeyFromQuery(query))
+}
+
+func (c *lruCache) getKey(key lruKey) *cacheAnswer {
 	item := c.touch(key)
 	if item != nil {
 		return item.cacheAnswer
@@ -98,6 +111,12 @@ func (c *lruCache) get(query *dns.Msg) *cacheAnswer {
 	return nil
 }
 
+// Returns true if there is an item for the key, without changing its position.
+func (c *lruCache) has(key lruKey) bool {
+	_, ok := c.items[key]
+	return ok
+}
+
 // Shrink the cache down to the maximum number of items.
 func (c *lruCache) resize() {
 	if c.maxItems <= 0 { // no size limit
@@ -126,6 +145,29 @@ func (c *lruCache) deleteFunc(f func(*cacheAnswer) bool) {
 	}
 }
 
+// Delete all items with a question the provided function returns true for.
+// Returns the number of deleted items.
+func (c *lruCache) deleteQuestionFunc(f func(dns.Question) bool) int {
+	var n int
+	for key, item := range c.items {
+		if f(key.question) {
+			item.prev.next = item.next
+			item.next.prev = item.prev
+			delete(c.items, key)
+			n++
+		}
+	}
+	return n
+}
+
+// Call the provided function for every item, starting with the least-recently
+// used one. Adding the items in that order restores the same order.
+func (c *lruCache) each(f func(lruKey, *cacheAnswer)) {
+	for item := c.tail.prev; item != c.head; item = item.prev {
+		f(item.key, item.cacheAnswer)
+	}
+}
+
 func (c *lruCache) size() int {
 	return len(c.items)
 }
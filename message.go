@@ -61,3 +61,18 @@ func ptr(q *dns.Msg, name string) *dns.Msg {
 	}
 	return a
 }
+
+// Returns the CNAME record for a name, or nil if there is none.
+func findCNAME(rrs []dns.RR, name string) *dns.CNAME {
+	for _, rr := range rrs {
+		if cname, ok := rr.(*dns.CNAME); ok && equalName(cname.Hdr.Name, name) {
+			return cname
+		}
+	}
+	return nil
+}
+
+// Returns true if the two names are equal, ignoring case.
+func equalName(a, b string) bool {
+	return dns.CanonicalName(a) == dns.CanonicalName(b)
+}
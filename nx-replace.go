@@ -0,0 +1,108 @@
+package rdns
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// NXReplace is a resolver group that restores NXDOMAIN responses on networks that
+// hijack them. Some ISPs answer queries for non-existent names with the address
+// of a search or advertising page instead. Responses containing one of the known
+// hijack addresses are either replaced with NXDOMAIN, or the query is sent to the
+// next resolver in the group.
+type NXReplace struct {
+	id        string
+	resolvers []Resolver
+	action    string
+	hijackIPs map[string]struct{}
+	hijacked  *expvar.Int
+}
+
+var _ Resolver = &NXReplace{}
+
+// NXReplaceOptions contain settings for the NXReplace resolver group.
+type NXReplaceOptions struct {
+	// IPv4 or IPv6 addresses returned by the hijacking resolver for names that
+	// don't exist.
+	HijackIPs []string
+
+	// What to do with hijacked responses. "nxdomain" (default) responds with
+	// NXDOMAIN, "next" sends the query to the next resolver in the group and
+	// only responds with NXDOMAIN if the last one is hijacked as well.
+	Action string
+}
+
+// NewNXReplace returns a new instance of an NXDOMAIN hijack replacing group.
+func NewNXReplace(id string, opt NXReplaceOptions, resolvers ...Resolver) (*NXReplace, error) {
+	switch opt.Action {
+	case "":
+		opt.Action = "nxdomain"
+	case "nxdomain", "next":
+	default:
+		return nil, fmt.Errorf("unsupported action '%s'", opt.Action)
+	}
+	hijackIPs := make(map[string]struct{})
+	for _, s := range opt.HijackIPs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid hijack address '%s'", s)
+		}
+		hijackIPs[ip.String()] = struct{}{}
+	}
+	return &NXReplace{
+		id:        id,
+		resolvers: resolvers,
+		action:    opt.Action,
+		hijackIPs: hijackIPs,
+		hijacked:  getVarInt("router", id, "hijacked"),
+	}, nil
+}
+
+// Resolve a DNS query and replace hijacked responses with NXDOMAIN.
+func (r *NXReplace) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(r.resolvers) == 0 {
+		return nil, errors.New("no resolvers in group")
+	}
+	log := logger(r.id, q, ci)
+	for _, resolver := range r.resolvers {
+		log.WithField("resolver", resolver).Debug("forwarding query to resolver")
+		a, err := resolver.Resolve(q, ci)
+		if err != nil || a == nil || !r.isHijacked(a) {
+			return a, err
+		}
+		r.hijacked.Add(1)
+		if r.action != "next" {
+			break
+		}
+		log.WithField("resolver", resolver).Debug("hijacked response, trying next resolver")
+	}
+	log.Debug("hijacked response, responding with nxdomain")
+	return nxdomain(q), nil
+}
+
+func (r *NXReplace) String() string {
+	return r.id
+}
+
+// Returns true if the response contains one of the hijack addresses.
+func (r *NXReplace) isHijacked(a *dns.Msg) bool {
+	for _, rr := range a.Answer {
+		var ip net.IP
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip = rr.A
+		case *dns.AAAA:
+			ip = rr.AAAA
+		default:
+			continue
+		}
+		if _, ok := r.hijackIPs[ip.String()]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNXReplace(t *testing.T) {
+	respond := func(record string) *TestResolver {
+		return &TestResolver{
+			ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+				a := new(dns.Msg)
+				a.SetReply(q)
+				rr, err := dns.NewRR(record)
+				require.NoError(t, err)
+				a.Answer = append(a.Answer, rr)
+				return a, nil
+			},
+		}
+	}
+	hijack4 := respond("missing.example.com. 60 IN A 198.51.100.1")
+	hijack6 := respond("missing.example.com. 60 IN AAAA 2001:db8::0:1")
+	good := respond("missing.example.com. 60 IN A 192.0.2.1")
+	opt := NXReplaceOptions{HijackIPs: []string{"198.51.100.1", "2001:db8::1"}}
+
+	q := new(dns.Msg)
+	q.SetQuestion("missing.example.com.", dns.TypeA)
+
+	// Hijacked responses are replaced with NXDOMAIN by default
+	r, err := NewNXReplace("test-nx-replace", opt, hijack4, good)
+	require.NoError(t, err)
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeNameError, a.Rcode)
+	require.Empty(t, a.Answer)
+	require.Equal(t, 0, good.HitCount())
+
+	// IPv6 addresses are matched regardless of their notation
+	r, err = NewNXReplace("test-nx-replace", opt, hijack6)
+	require.NoError(t, err)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeNameError, a.Rcode)
+
+	// Retry hijacked responses with the next resolver
+	opt.Action = "next"
+	r, err = NewNXReplace("test-nx-replace", opt, hijack4, good)
+	require.NoError(t, err)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.Len(t, a.Answer, 1)
+	require.Equal(t, 1, good.HitCount())
+
+	// All resolvers are hijacked
+	r, err = NewNXReplace("test-nx-replace", opt, hijack4, hijack6)
+	require.NoError(t, err)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeNameError, a.Rcode)
+
+	// Invalid options
+	_, err = NewNXReplace("test-nx-replace", NXReplaceOptions{HijackIPs: []string{"invalid"}}, good)
+	require.Error(t, err)
+	_, err = NewNXReplace("test-nx-replace", NXReplaceOptions{Action: "invalid"}, good)
+	require.Error(t, err)
+}
@@ -0,0 +1,526 @@
+package rdns
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/jtacoma/uritemplates"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Oblivious DoH (RFC9230) with the only HPKE (RFC9180) suite that is widely
+// deployed: DHKEM(X25519, HKDF-SHA256), HKDF-SHA256, and AES-128-GCM.
+
+// ODoHOptions contain settings for Oblivious DoH. The DoH endpoint is used as
+// the target which decrypts queries, while queries are sent via a relay which
+// only sees the client address.
+type ODoHOptions struct {
+	// URL of the relay that forwards queries to the target. The target is
+	// passed in the "targethost" and "targetpath" query parameters. If empty,
+	// queries are sent to the target directly.
+	Relay string
+
+	// Base64 encoded ObliviousDoHConfigs of the target. If empty, they are
+	// fetched from the target under /.well-known/odohconfigs.
+	Config string
+
+	// Send queries as regular DoH directly to the target if its config isn't
+	// available, rather than failing them. This reveals the client address
+	// to the target. Not allowed with a relay.
+	Fallback bool
+}
+
+// How long to wait before fetching the target config again after a failure.
+const odohConfigRetry = time.Minute
+
+// Maximum size of the target's config list.
+const odohMaxConfigSize = 4096
+
+// Overhead of an encrypted ODoH response compared to the DNS message in it:
+// type, nonce, and length fields, plus the AEAD tag.
+const odohResponseOverhead = 1 + 2 + hpkeNk + 2 + 2 + 2 + 16
+
+// odohClient holds the ODoH state of a DoH client.
+type odohClient struct {
+	relayURL  string
+	configURL string
+	static    bool // Config was provided and is never fetched
+	fallback  bool
+
+	// Count of queries sent as regular DoH because the config wasn't available
+	fallbackCount *expvar.Int
+
+	mu        sync.Mutex
+	config    *odohConfig
+	nextFetch time.Time
+}
+
+func newODoHClient(id string, target *uritemplates.UriTemplate, opt ODoHOptions) (*odohClient, error) {
+	if opt.Fallback && opt.Relay != "" {
+		return nil, errors.New("odoh fallback can not be used with a relay")
+	}
+	// The target URL could be a template, expand it without values
+	t, err := target.Expand(map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	targetURL, err := url.Parse(t)
+	if err != nil {
+		return nil, err
+	}
+	c := &odohClient{
+		relayURL: t,
+		configURL: (&url.URL{
+			Scheme: targetURL.Scheme,
+			Host:   targetURL.Host,
+			Path:   "/.well-known/odohconfigs",
+		}).String(),
+		fallback:      opt.Fallback,
+		fallbackCount: getVarInt("client", id, "odoh-fallback"),
+	}
+	if opt.Relay != "" {
+		relayURL, err := url.Parse(opt.Relay)
+		if err != nil {
+			return nil, err
+		}
+		values := relayURL.Query()
+		values.Set("targethost", targetURL.Hostname())
+		values.Set("targetpath", targetURL.EscapedPath())
+		relayURL.RawQuery = values.Encode()
+		c.relayURL = relayURL.String()
+	}
+	if opt.Config != "" {
+		b, err := base64.StdEncoding.DecodeString(opt.Config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid odoh config: %w", err)
+		}
+		config, err := parseODoHConfigs(b)
+		if err != nil {
+			return nil, err
+		}
+		c.config = &config
+		c.static = true
+	}
+	return c, nil
+}
+
+// Returns the target config, fetching it if necessary.
+func (c *odohClient) getConfig(ctx context.Context, client *http.Client) (*odohConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config != nil {
+		return c.config, nil
+	}
+	if time.Now().Before(c.nextFetch) {
+		return nil, errors.New("odoh config not available")
+	}
+	config, err := fetchODoHConfig(ctx, client, c.configURL)
+	if err != nil {
+		// Only back off if the target failed, not the query
+		if ctx.Err() == nil {
+			c.nextFetch = time.Now().Add(odohConfigRetry)
+		}
+		return nil, err
+	}
+	c.config = &config
+	return c.config, nil
+}
+
+// Discard the target config after it was rejected, to fetch it again. Does
+// nothing for configs provided by the user.
+func (c *odohClient) resetConfig() {
+	if c.static {
+		return
+	}
+	c.mu.Lock()
+	c.config = nil
+	c.mu.Unlock()
+}
+
+func fetchODoHConfig(ctx context.Context, client *http.Client, u string) (odohConfig, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return odohConfig{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return odohConfig{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return odohConfig{}, fmt.Errorf("unexpected status code %d fetching odoh config", resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, odohMaxConfigSize))
+	if err != nil {
+		return odohConfig{}, err
+	}
+	return parseODoHConfigs(b)
+}
+
+// ResolveODoH resolves a DNS query via Oblivious DoH. If the target config is
+// not available, the query fails unless fallback to regular DoH is enabled.
+func (d *DoHClient) ResolveODoH(q *dns.Msg) (*dns.Msg, error) {
+	return d.ResolveODoHContext(context.Background(), q)
+}
+
+// ResolveODoHContext resolves a DNS query via Oblivious DoH. Fetching the
+// target config and the request are cancelled when the context is done.
+func (d *DoHClient) ResolveODoHContext(ctx context.Context, q *dns.Msg) (*dns.Msg, error) {
+	config, err := d.odoh.getConfig(ctx, d.client)
+	if err != nil {
+		d.metrics.err.Add("odoh-config", 1)
+		if !d.odoh.fallback {
+			return nil, fmt.Errorf("odoh not available: %w", err)
+		}
+		Log.WithFields(logrus.Fields{"id": d.id, "resolver": d.endpoint}).WithError(err).Warn("odoh not available, falling back to doh")
+		d.odoh.fallbackCount.Add(1)
+		if d.opt.Method == "GET" {
+			return d.ResolveGETContext(ctx, q)
+		}
+		return d.ResolvePOSTContext(ctx, q)
+	}
+
+	// Pack and encrypt the DNS query
+	b, err := q.Pack()
+	if err != nil {
+		d.metrics.err.Add("pack", 1)
+		return nil, err
+	}
+	msg, qctx, err := config.encryptQuery(b)
+	if err != nil {
+		d.metrics.err.Add("encrypt", 1)
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", d.odoh.relayURL, bytes.NewReader(msg))
+	if err != nil {
+		d.metrics.err.Add("http", 1)
+		return nil, err
+	}
+	d.setHeaders(req)
+	req.Header.Set("accept", odohContentType)
+	req.Header.Set("content-type", odohContentType)
+	resp, err := d.do(req)
+	if err != nil {
+		d.metrics.err.Add("post", 1)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		// The target doesn't know the key, it was likely rotated
+		d.odoh.resetConfig()
+	}
+	rb, err := d.readResponseBody(resp, checkODoHContentType, d.opt.MaxResponseSize+odohResponseOverhead)
+	if err != nil {
+		return nil, err
+	}
+	rb, err = qctx.decryptResponse(rb)
+	if err != nil {
+		d.metrics.err.Add("decrypt", 1)
+		d.odoh.resetConfig()
+		return nil, err
+	}
+	return d.unpackResponse(rb)
+}
+
+// Returns an error if the content type of a response isn't an ODoH message.
+func checkODoHContentType(contentType string) error {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid content type '%s': %w", contentType, err)
+	}
+	if mediaType != odohContentType {
+		return fmt.Errorf("unexpected content type '%s'", contentType)
+	}
+	return nil
+}
+
+const (
+	odohVersion         = 0x0001
+	odohContentType     = "application/oblivious-dns-message"
+	odohMessageQuery    = 0x01
+	odohMessageResponse = 0x02
+
+	hpkeKEMX25519     = 0x0020
+	hpkeKDFHKDFSHA256 = 0x0001
+	hpkeAEADAES128GCM = 0x0001
+
+	hpkeNsecret = 32 // Size of the KEM shared secret
+	hpkeNk      = 16 // Size of the AEAD key
+	hpkeNn      = 12 // Size of the AEAD nonce
+	hpkeNh      = 32 // Output size of the KDF
+)
+
+// odohConfig is a target's public key configuration (ObliviousDoHConfigContents).
+type odohConfig struct {
+	kemID, kdfID, aeadID uint16
+	publicKey            []byte
+	raw                  []byte // Serialized config, used to derive the key ID
+}
+
+// Parses a list of ObliviousDoHConfigs as served by targets under
+// /.well-known/odohconfigs and returns the first one with a supported
+// version and HPKE suite.
+func parseODoHConfigs(b []byte) (odohConfig, error) {
+	list, err := readVector16(bytes.NewReader(b))
+	if err != nil {
+		return odohConfig{}, fmt.Errorf("invalid odoh config: %w", err)
+	}
+	r := bytes.NewReader(list)
+	for r.Len() > 0 {
+		var version uint16
+		if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+			return odohConfig{}, fmt.Errorf("invalid odoh config: %w", err)
+		}
+		contents, err := readVector16(r)
+		if err != nil {
+			return odohConfig{}, fmt.Errorf("invalid odoh config: %w", err)
+		}
+		if version != odohVersion {
+			continue
+		}
+		c, err := parseODoHConfigContents(contents)
+		if err != nil {
+			return odohConfig{}, err
+		}
+		if c.kemID == hpkeKEMX25519 && c.kdfID == hpkeKDFHKDFSHA256 && c.aeadID == hpkeAEADAES128GCM {
+			return c, nil
+		}
+	}
+	return odohConfig{}, errors.New("no supported odoh config found")
+}
+
+func parseODoHConfigContents(b []byte) (odohConfig, error) {
+	c := odohConfig{raw: b}
+	r := bytes.NewReader(b)
+	for _, v := range []*uint16{&c.kemID, &c.kdfID, &c.aeadID} {
+		if err := binary.Read(r, binary.BigEndian, v); err != nil {
+			return c, fmt.Errorf("invalid odoh config: %w", err)
+		}
+	}
+	var err error
+	if c.publicKey, err = readVector16(r); err != nil {
+		return c, fmt.Errorf("invalid odoh config: %w", err)
+	}
+	if c.kemID == hpkeKEMX25519 && len(c.publicKey) != curve25519.PointSize {
+		return c, errors.New("invalid odoh public key size")
+	}
+	return c, nil
+}
+
+// Returns the key ID identifying the config in queries to the target.
+func (c odohConfig) keyID() []byte {
+	prk := hkdf.Extract(sha256.New, c.raw, nil)
+	return hkdfExpand(prk, []byte("odoh key id"), hpkeNh)
+}
+
+// odohQueryContext holds the state needed to decrypt the response to a query.
+type odohQueryContext struct {
+	plaintext []byte // The serialized query plaintext
+	hpke      *hpkeContext
+}
+
+// Encrypts a DNS query for the target and returns the serialized ObliviousDoHMessage
+// as well as the context needed to decrypt the response.
+func (c odohConfig) encryptQuery(query []byte) ([]byte, *odohQueryContext, error) {
+	plaintext := appendVector16(nil, query)
+	plaintext = appendVector16(plaintext, nil) // No padding, the query is padded already
+
+	enc, ctx, err := hpkeSetupBaseS(c.publicKey, []byte("odoh query"))
+	if err != nil {
+		return nil, nil, err
+	}
+	keyID := c.keyID()
+	aad := appendVector16([]byte{odohMessageQuery}, keyID)
+	ct := ctx.seal(aad, plaintext)
+
+	msg := appendVector16([]byte{odohMessageQuery}, keyID)
+	msg = appendVector16(msg, append(enc, ct...))
+	return msg, &odohQueryContext{plaintext: plaintext, hpke: ctx}, nil
+}
+
+// Decrypts a serialized ObliviousDoHMessage response and returns the DNS message in it.
+func (q *odohQueryContext) decryptResponse(b []byte) ([]byte, error) {
+	r := bytes.NewReader(b)
+	msgType, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if msgType != odohMessageResponse {
+		return nil, fmt.Errorf("unexpected odoh message type %d", msgType)
+	}
+	nonce, err := readVector16(r)
+	if err != nil {
+		return nil, err
+	}
+	ct, err := readVector16(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Derive the response key and nonce from the query context
+	secret := q.hpke.export([]byte("odoh response"), hpkeNk)
+	salt := appendVector16(append([]byte{}, q.plaintext...), nonce)
+	prk := hkdf.Extract(sha256.New, secret, salt)
+	key := hkdfExpand(prk, []byte("odoh key"), hpkeNk)
+	aeadNonce := hkdfExpand(prk, []byte("odoh nonce"), hpkeNn)
+
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	aad := appendVector16([]byte{odohMessageResponse}, nonce)
+	plaintext, err := aead.Open(nil, aeadNonce, ct, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt odoh response: %w", err)
+	}
+	return readVector16(bytes.NewReader(plaintext))
+}
+
+// hpkeContext is an HPKE encryption context in base mode. Only a single
+// message is sealed with it, so the sequence number is always 0.
+type hpkeContext struct {
+	aead           cipher.AEAD
+	baseNonce      []byte
+	exporterSecret []byte
+}
+
+// Sets up an HPKE sender context for the recipient's public key and returns
+// the encapsulated key along with it.
+func hpkeSetupBaseS(pkR, info []byte) ([]byte, *hpkeContext, error) {
+	skE := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, skE); err != nil {
+		return nil, nil, err
+	}
+	return hpkeSetupBaseSWithKey(skE, pkR, info)
+}
+
+// Sets up an HPKE sender context with the given ephemeral private key.
+func hpkeSetupBaseSWithKey(skE, pkR, info []byte) ([]byte, *hpkeContext, error) {
+	pkE, err := curve25519.X25519(skE, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	dh, err := curve25519.X25519(skE, pkR)
+	if err != nil {
+		return nil, nil, err
+	}
+	sharedSecret := hpkeExtractAndExpand(dh, append(append([]byte{}, pkE...), pkR...))
+	ctx, err := hpkeKeySchedule(sharedSecret, info)
+	return pkE, ctx, err
+}
+
+func (c *hpkeContext) seal(aad, plaintext []byte) []byte {
+	return c.aead.Seal(nil, c.baseNonce, plaintext, aad)
+}
+
+func (c *hpkeContext) export(exporterContext []byte, length int) []byte {
+	return hpkeLabeledExpand(hpkeSuiteID(), c.exporterSecret, "sec", exporterContext, length)
+}
+
+// DHKEM shared secret derivation from the Diffie-Hellman result.
+func hpkeExtractAndExpand(dh, kemContext []byte) []byte {
+	suiteID := []byte{'K', 'E', 'M', 0, 0}
+	binary.BigEndian.PutUint16(suiteID[3:], hpkeKEMX25519)
+	prk := hpkeLabeledExtract(suiteID, nil, "eae_prk", dh)
+	return hpkeLabeledExpand(suiteID, prk, "shared_secret", kemContext, hpkeNsecret)
+}
+
+// Key schedule for base mode, without PSK.
+func hpkeKeySchedule(sharedSecret, info []byte) (*hpkeContext, error) {
+	suiteID := hpkeSuiteID()
+	pskIDHash := hpkeLabeledExtract(suiteID, nil, "psk_id_hash", nil)
+	infoHash := hpkeLabeledExtract(suiteID, nil, "info_hash", info)
+	keyScheduleContext := append([]byte{0x00}, pskIDHash...) // mode_base
+	keyScheduleContext = append(keyScheduleContext, infoHash...)
+
+	secret := hpkeLabeledExtract(suiteID, sharedSecret, "secret", nil)
+	key := hpkeLabeledExpand(suiteID, secret, "key", keyScheduleContext, hpkeNk)
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &hpkeContext{
+		aead:           aead,
+		baseNonce:      hpkeLabeledExpand(suiteID, secret, "base_nonce", keyScheduleContext, hpkeNn),
+		exporterSecret: hpkeLabeledExpand(suiteID, secret, "exp", keyScheduleContext, hpkeNh),
+	}, nil
+}
+
+func hpkeSuiteID() []byte {
+	id := []byte{'H', 'P', 'K', 'E', 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(id[4:], hpkeKEMX25519)
+	binary.BigEndian.PutUint16(id[6:], hpkeKDFHKDFSHA256)
+	binary.BigEndian.PutUint16(id[8:], hpkeAEADAES128GCM)
+	return id
+}
+
+func hpkeLabeledExtract(suiteID, salt []byte, label string, ikm []byte) []byte {
+	labeledIKM := append([]byte("HPKE-v1"), suiteID...)
+	labeledIKM = append(labeledIKM, label...)
+	labeledIKM = append(labeledIKM, ikm...)
+	return hkdf.Extract(sha256.New, labeledIKM, salt)
+}
+
+func hpkeLabeledExpand(suiteID, prk []byte, label string, info []byte, length int) []byte {
+	labeledInfo := make([]byte, 2, 2+7+len(suiteID)+len(label)+len(info))
+	binary.BigEndian.PutUint16(labeledInfo, uint16(length))
+	labeledInfo = append(labeledInfo, "HPKE-v1"...)
+	labeledInfo = append(labeledInfo, suiteID...)
+	labeledInfo = append(labeledInfo, label...)
+	labeledInfo = append(labeledInfo, info...)
+	return hkdfExpand(prk, labeledInfo, length)
+}
+
+func hkdfExpand(prk, info []byte, length int) []byte {
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, info), out); err != nil {
+		panic(err) // Only fails if the length exceeds the HKDF limit
+	}
+	return out
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Reads a byte string with a 2-byte length prefix.
+func readVector16(r *bytes.Reader) ([]byte, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Appends a byte string with a 2-byte length prefix.
+func appendVector16(b, v []byte) []byte {
+	b = append(b, byte(len(v)>>8), byte(len(v)))
+	return append(b, v...)
+}
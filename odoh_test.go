@@ -0,0 +1,254 @@
+package rdns
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jtacoma/uritemplates"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// odohTestTarget is a minimal ODoH target that answers every query with NXDOMAIN.
+type odohTestTarget struct {
+	t       *testing.T
+	sk      []byte
+	configs []byte
+	keyID   []byte
+	queries int
+}
+
+func newODoHTestTarget(t *testing.T) *odohTestTarget {
+	sk := make([]byte, curve25519.ScalarSize)
+	_, err := rand.Read(sk)
+	require.NoError(t, err)
+	pk, err := curve25519.X25519(sk, curve25519.Basepoint)
+	require.NoError(t, err)
+
+	contents := []byte{0x00, 0x20, 0x00, 0x01, 0x00, 0x01}
+	contents = appendVector16(contents, pk)
+	config := appendVector16([]byte{0x00, 0x01}, contents)
+	c, err := parseODoHConfigContents(contents)
+	require.NoError(t, err)
+	return &odohTestTarget{t: t, sk: sk, configs: appendVector16(nil, config), keyID: c.keyID()}
+}
+
+func (s *odohTestTarget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := s.t
+	if r.URL.Path == "/.well-known/odohconfigs" {
+		w.Write(s.configs)
+		return
+	}
+	require.Equal(t, odohContentType, r.Header.Get("content-type"))
+	s.queries++
+	b, err := ioutil.ReadAll(r.Body)
+	require.NoError(t, err)
+
+	// Decrypt the query
+	rd := bytes.NewReader(b)
+	msgType, _ := rd.ReadByte()
+	require.Equal(t, byte(odohMessageQuery), msgType)
+	keyID, err := readVector16(rd)
+	require.NoError(t, err)
+	require.Equal(t, s.keyID, keyID)
+	encrypted, err := readVector16(rd)
+	require.NoError(t, err)
+	enc, ct := encrypted[:curve25519.PointSize], encrypted[curve25519.PointSize:]
+	pk, _ := curve25519.X25519(s.sk, curve25519.Basepoint)
+	dh, err := curve25519.X25519(s.sk, enc)
+	require.NoError(t, err)
+	ctx, err := hpkeKeySchedule(hpkeExtractAndExpand(dh, append(append([]byte{}, enc...), pk...)), []byte("odoh query"))
+	require.NoError(t, err)
+	plaintext, err := ctx.aead.Open(nil, ctx.baseNonce, ct, appendVector16([]byte{odohMessageQuery}, keyID))
+	require.NoError(t, err)
+	qb, err := readVector16(bytes.NewReader(plaintext))
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	require.NoError(t, q.Unpack(qb))
+
+	// Encrypt the response
+	a := new(dns.Msg)
+	a.SetRcode(q, dns.RcodeNameError)
+	ab, err := a.Pack()
+	require.NoError(t, err)
+	nonce := make([]byte, hpkeNk)
+	rand.Read(nonce)
+	secret := ctx.export([]byte("odoh response"), hpkeNk)
+	prk := hkdf.Extract(sha256.New, secret, appendVector16(append([]byte{}, plaintext...), nonce))
+	aead, err := newAESGCM(hkdfExpand(prk, []byte("odoh key"), hpkeNk))
+	require.NoError(t, err)
+	rp := appendVector16(nil, ab)
+	rp = appendVector16(rp, make([]byte, 8))
+	rct := aead.Seal(nil, hkdfExpand(prk, []byte("odoh nonce"), hpkeNn), rp, appendVector16([]byte{odohMessageResponse}, nonce))
+	resp := appendVector16([]byte{odohMessageResponse}, nonce)
+	resp = appendVector16(resp, rct)
+
+	w.Header().Set("content-type", odohContentType)
+	w.Write(resp)
+}
+
+func TestDoHClientODoH(t *testing.T) {
+	target := newODoHTestTarget(t)
+	srv := httptest.NewTLSServer(target)
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	// Config fetched from the target
+	d, err := NewDoHClient("test-odoh", srv.URL+"/dns-query", DoHClientOptions{
+		TLSConfig: &tls.Config{RootCAs: pool},
+		ODoH:      &ODoHOptions{},
+	})
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	a, err := d.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeNameError, a.Rcode)
+	require.Equal(t, 1, target.queries)
+
+	// Static config
+	d, err = NewDoHClient("test-odoh", srv.URL+"/dns-query", DoHClientOptions{
+		TLSConfig: &tls.Config{RootCAs: pool},
+		ODoH:      &ODoHOptions{Config: base64.StdEncoding.EncodeToString(target.configs)},
+	})
+	require.NoError(t, err)
+	a, err = d.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeNameError, a.Rcode)
+	require.Equal(t, 2, target.queries)
+}
+
+func TestDoHClientODoHFallback(t *testing.T) {
+	// Regular DoH server without ODoH support
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dns-query" {
+			http.NotFound(w, r)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		q := new(dns.Msg)
+		require.NoError(t, q.Unpack(b))
+		a := new(dns.Msg)
+		a.SetRcode(q, dns.RcodeRefused)
+		ab, _ := a.Pack()
+		w.Header().Set("content-type", "application/dns-message")
+		w.Write(ab)
+	}))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	// Queries fail by default if the target doesn't support ODoH
+	d, err := NewDoHClient("test-odoh-no-fallback", srv.URL+"/dns-query", DoHClientOptions{
+		TLSConfig: &tls.Config{RootCAs: pool},
+		ODoH:      &ODoHOptions{},
+	})
+	require.NoError(t, err)
+	_, err = d.Resolve(q, ClientInfo{})
+	require.Error(t, err)
+
+	// With fallback enabled, they're sent as regular DoH
+	d, err = NewDoHClient("test-odoh-fallback", srv.URL+"/dns-query", DoHClientOptions{
+		TLSConfig: &tls.Config{RootCAs: pool},
+		ODoH:      &ODoHOptions{Fallback: true},
+	})
+	require.NoError(t, err)
+	a, err := d.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeRefused, a.Rcode)
+	require.Equal(t, int64(1), d.odoh.fallbackCount.Value())
+
+	// Fallback would bypass the relay
+	_, err = NewDoHClient("test-odoh-fallback-relay", srv.URL+"/dns-query", DoHClientOptions{
+		ODoH: &ODoHOptions{Fallback: true, Relay: "https://relay.example.com/proxy"},
+	})
+	require.Error(t, err)
+}
+
+func TestODoHRelayURL(t *testing.T) {
+	template, err := uritemplates.Parse("https://odoh.example.com/dns-query")
+	require.NoError(t, err)
+	c, err := newODoHClient("test-odoh-relay", template, ODoHOptions{Relay: "https://relay.example.com/proxy"})
+	require.NoError(t, err)
+	require.Equal(t, "https://relay.example.com/proxy?targethost=odoh.example.com&targetpath=%2Fdns-query", c.relayURL)
+	require.Equal(t, "https://odoh.example.com/.well-known/odohconfigs", c.configURL)
+}
+
+func TestParseODoHConfigs(t *testing.T) {
+	// Unsupported version and suite are skipped
+	pk := make([]byte, curve25519.PointSize)
+	unsupported := appendVector16([]byte{0x00, 0x02}, []byte{0x00})
+	otherSuite := appendVector16([]byte{0x00, 0x01}, appendVector16([]byte{0x00, 0x10, 0x00, 0x01, 0x00, 0x01}, pk))
+	supported := appendVector16([]byte{0x00, 0x01}, appendVector16([]byte{0x00, 0x20, 0x00, 0x01, 0x00, 0x01}, pk))
+
+	list := append(append(append([]byte{}, unsupported...), otherSuite...), supported...)
+	c, err := parseODoHConfigs(appendVector16(nil, list))
+	require.NoError(t, err)
+	require.Equal(t, uint16(hpkeKEMX25519), c.kemID)
+
+	_, err = parseODoHConfigs(appendVector16(nil, unsupported))
+	require.Error(t, err)
+	_, err = parseODoHConfigs([]byte{0x00})
+	require.Error(t, err)
+}
+
+// Test vector A.1.1 of RFC9180, DHKEM(X25519, HKDF-SHA256), HKDF-SHA256,
+// AES-128-GCM in base mode.
+func TestHPKEVector(t *testing.T) {
+	h := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		require.NoError(t, err)
+		return b
+	}
+	info := h("4f6465206f6e2061204772656369616e2055726e")
+	ikmE := h("7268600d403fce431561aef583ee1613527cff655c1343f29812e66706df3234")
+	skRm := h("4612c550263fc8ad58375df3f557aac531d26850903e55a9f23f21d8534e8ac8")
+	pkRm := h("3948cfe0ad1ddb695d780e59077195da6c56506b027329794ab02bca80815c4d")
+
+	// DeriveKeyPair of the ephemeral key
+	kemSuiteID := []byte{'K', 'E', 'M', 0x00, 0x20}
+	dkpPRK := hpkeLabeledExtract(kemSuiteID, nil, "dkp_prk", ikmE)
+	skEm := hpkeLabeledExpand(kemSuiteID, dkpPRK, "sk", nil, curve25519.ScalarSize)
+	require.Equal(t, h("52c4a758a802cd8b936eceea314432798d5baf2d7e9235dc084ab1b9cfa2f736"), skEm)
+
+	pkR, err := curve25519.X25519(skRm, curve25519.Basepoint)
+	require.NoError(t, err)
+	require.Equal(t, pkRm, pkR)
+
+	enc, ctx, err := hpkeSetupBaseSWithKey(skEm, pkRm, info)
+	require.NoError(t, err)
+	require.Equal(t, h("37fda3567bdbd628e88668c3c8d7e97d1d1253b6d4ea6d44c150f741f1bf4431"), enc)
+	require.Equal(t, h("56d890e5accaaf011cff4b7d"), ctx.baseNonce)
+	require.Equal(t, h("45ff1c2e220db587171952c0592d5f5ebe103f1561a2614e38f2ffd47e99e3f8"), ctx.exporterSecret)
+
+	// First encryption, sequence number 0
+	ct := ctx.seal(h("436f756e742d30"), h("4265617574792069732074727574682c20747275746820626561757479"))
+	require.Equal(t, h("f938558b5d72f1a23810b4be2ab4f84331acc02fc97babc53a52ae8218a355a96d8770ac83d07bea87e13c512a"), ct)
+
+	// Exported values
+	for _, e := range []struct {
+		context, value string
+	}{
+		{"", "3853fe2b4035195a573ffc53856e77058e15d9ea064de3e59f4961d0095250ee"},
+		{"00", "2e8f0b54673c7029649d4eb9d5e33bf1872cf76d623ff164ac185da9e88c21a5"},
+		{"54657374436f6e74657874", "e9e43065102c3836401bed8c3c3c75ae46be1639869391d62c61f1ec7af54931"},
+	} {
+		require.Equal(t, h(e.value), ctx.export(h(e.context), 32))
+	}
+}
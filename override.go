@@ -0,0 +1,123 @@
+package rdns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Override is a resolver that answers queries for a small set of names with fixed
+// records and forwards all other queries upstream. It's a lightweight alternative
+// to a local zone for overriding a handful of names. Names that are overridden
+// with records of other types are answered with an empty response, and queries
+// for names that are configured as NXDOMAIN are answered with NXDOMAIN.
+type Override struct {
+	id       string
+	resolver Resolver
+	records  map[string]map[uint16][]dns.RR // By lowercase FQDN and type
+	nxdomain map[string]struct{}
+}
+
+var _ Resolver = &Override{}
+
+// OverrideOptions contain settings for the Override resolver.
+type OverrideOptions struct {
+	// Values of records by name and type, like
+	// {"router.local": {"A": ["192.168.1.1"]}}. Names are case-insensitive
+	// and don't require a trailing dot.
+	Records map[string]map[string][]string
+
+	// Names that are answered with NXDOMAIN.
+	NXDomain []string
+
+	// TTL of the records in the response. Default 3600.
+	TTL uint32
+}
+
+// NewOverride returns a new instance of an Override resolver.
+func NewOverride(id string, resolver Resolver, opt OverrideOptions) (*Override, error) {
+	if opt.TTL == 0 {
+		opt.TTL = 3600
+	}
+	r := &Override{
+		id:       id,
+		resolver: resolver,
+		records:  make(map[string]map[uint16][]dns.RR),
+		nxdomain: make(map[string]struct{}),
+	}
+	for name, types := range opt.Records {
+		name = overrideName(name)
+		if _, ok := r.records[name]; !ok {
+			r.records[name] = make(map[uint16][]dns.RR)
+		}
+		for typ, values := range types {
+			qtype, ok := dns.StringToType[strings.ToUpper(typ)]
+			if !ok {
+				return nil, fmt.Errorf("unsupported record type '%s' for '%s'", typ, name)
+			}
+			for _, value := range values {
+				rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, opt.TTL, dns.TypeToString[qtype], value))
+				if err != nil {
+					return nil, err
+				}
+				if rr == nil {
+					return nil, fmt.Errorf("invalid %s record '%s' for '%s'", typ, value, name)
+				}
+				r.records[name][qtype] = append(r.records[name][qtype], rr)
+			}
+		}
+	}
+	for _, name := range opt.NXDomain {
+		name = overrideName(name)
+		if _, ok := r.records[name]; ok {
+			return nil, fmt.Errorf("'%s' can't have records and be nxdomain", name)
+		}
+		r.nxdomain[name] = struct{}{}
+	}
+	return r, nil
+}
+
+// Resolve a DNS query by answering it from the overrides if the name matches, or
+// forwarding it to the upstream resolver otherwise.
+func (r *Override) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return r.resolver.Resolve(q, ci)
+	}
+	log := logger(r.id, q, ci)
+	question := q.Question[0]
+	name := overrideName(question.Name)
+	if _, ok := r.nxdomain[name]; ok {
+		log.Debug("responding with nxdomain override")
+		return nxdomain(q), nil
+	}
+	types, ok := r.records[name]
+	if !ok {
+		log.WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+		return r.resolver.Resolve(q, ci)
+	}
+	records, ok := types[question.Qtype]
+	if !ok && question.Qtype != dns.TypeCNAME {
+		// CNAMEs are returned for all types
+		records = types[dns.TypeCNAME]
+	}
+	a := new(dns.Msg)
+	a.SetReply(q)
+	for _, rr := range records {
+		rr = dns.Copy(rr)
+		// Use the name as it was in the query
+		rr.Header().Name = question.Name
+		a.Answer = append(a.Answer, rr)
+	}
+	log.Debug("responding with override")
+	return a, nil
+}
+
+func (r *Override) String() string {
+	return r.id
+}
+
+// Returns the name in lowercase and fully qualified.
+func overrideName(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
@@ -0,0 +1,67 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverride(t *testing.T) {
+	upstream := new(TestResolver)
+	opt := OverrideOptions{
+		Records: map[string]map[string][]string{
+			"router.local": {"A": {"192.168.1.1"}, "aaaa": {"fd00::1"}},
+			"nas.local.":   {"A": {"192.168.1.2", "192.168.1.3"}},
+			"alias.local":  {"CNAME": {"router.local."}},
+		},
+		NXDomain: []string{"Ads.Example.com"},
+		TTL:      60,
+	}
+	r, err := NewOverride("test-override", upstream, opt)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		qtype   uint16
+		rcode   int
+		answers int
+	}{
+		{"router.local.", dns.TypeA, dns.RcodeSuccess, 1},
+		{"ROUTER.Local.", dns.TypeAAAA, dns.RcodeSuccess, 1},
+		{"router.local.", dns.TypeMX, dns.RcodeSuccess, 0},
+		{"nas.local.", dns.TypeA, dns.RcodeSuccess, 2},
+		{"alias.local.", dns.TypeA, dns.RcodeSuccess, 1},
+		{"ads.example.com.", dns.TypeA, dns.RcodeNameError, 0},
+	}
+	for _, test := range tests {
+		q := new(dns.Msg)
+		q.SetQuestion(test.name, test.qtype)
+		a, err := r.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+		require.Equal(t, test.rcode, a.Rcode, test.name)
+		require.Len(t, a.Answer, test.answers, test.name)
+		for _, rr := range a.Answer {
+			require.Equal(t, test.name, rr.Header().Name)
+			require.Equal(t, uint32(60), rr.Header().Ttl)
+		}
+	}
+	require.Equal(t, 0, upstream.HitCount())
+
+	// Other names are forwarded
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	_, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 1, upstream.HitCount())
+
+	// Invalid records
+	_, err = NewOverride("test-override", upstream, OverrideOptions{
+		Records: map[string]map[string][]string{"router.local": {"A": {"invalid"}}},
+	})
+	require.Error(t, err)
+	_, err = NewOverride("test-override", upstream, OverrideOptions{
+		Records: map[string]map[string][]string{"router.local": {"INVALID": {"192.168.1.1"}}},
+	})
+	require.Error(t, err)
+}
@@ -8,6 +8,21 @@ const QueryPaddingBlockSize = 128
 //  ResponsePaddingBlockSize is used to pad responses over DoT and DoH according to rfc8467
 const ResponsePaddingBlockSize = 468
 
+// PaddingPolicy controls how queries are padded before they're sent over an
+// encrypted transport.
+type PaddingPolicy struct {
+	// Add padding to queries. Padding hides the length of queries from observers,
+	// but is wasteful if the transport already does something similar.
+	Enabled bool
+
+	// Queries are padded to a multiple of this size. Defaults to
+	// QueryPaddingBlockSize (128) as recommended in rfc8467.
+	BlockSize int
+}
+
+// DefaultQueryPadding is the padding policy used by clients when none is configured.
+var DefaultQueryPadding = PaddingPolicy{Enabled: true, BlockSize: QueryPaddingBlockSize}
+
 // Fixed buffers to draw on for padding (rather than allocate every time)
 var respPadBuf [ResponsePaddingBlockSize]byte
 var queryPadBuf [QueryPaddingBlockSize]byte
@@ -57,9 +72,16 @@ func padAnswer(q, a *dns.Msg) {
 	paddingOpt.Padding = respPadBuf[0:padLen]
 }
 
-// Adds padding to a query that is to be sent over DoH or DoT. Padding length is according to rfc8467.
-// This should not be used for plain (unencrypted) DNS.
-func padQuery(q *dns.Msg) {
+// Adds padding to a query that is to be sent over DoH or DoT. Padding length is according to rfc8467
+// unless the policy defines a different block size. This should not be used for plain (unencrypted) DNS.
+func padQuery(q *dns.Msg, policy PaddingPolicy) {
+	if !policy.Enabled {
+		return
+	}
+	blockSize := policy.BlockSize
+	if blockSize <= 0 {
+		blockSize = QueryPaddingBlockSize
+	}
 	edns0q := q.IsEdns0()
 	if edns0q == nil { // Don't pad if the client does not support EDNS0
 		return
@@ -82,7 +104,11 @@ func padQuery(q *dns.Msg) {
 
 	// Calculate the desired padding length
 	len := q.Len()
-	padLen := QueryPaddingBlockSize - len%QueryPaddingBlockSize
+	padLen := blockSize - len%blockSize
+	if padLen > QueryPaddingBlockSize { // Only allocate if the fixed buffer is too small
+		paddingOpt.Padding = make([]byte, padLen)
+		return
+	}
 	paddingOpt.Padding = queryPadBuf[0:padLen]
 }
 
@@ -42,16 +42,28 @@ func TestQueryPadding(t *testing.T) {
 	q.SetQuestion("google.com.", dns.TypeA)
 
 	// No padding should be added when there's no EDNS0 in the query
-	padQuery(q)
+	padQuery(q, DefaultQueryPadding)
 	edns0 := q.IsEdns0()
 	require.Nil(t, edns0, "unexpected EDNS0 option in query")
 
 	// Now with EDNS0, the query should be padded to the right size
 	q.SetEdns0(4096, false)
-	padQuery(q)
+	padQuery(q, DefaultQueryPadding)
 	edns0 = q.IsEdns0()
 	require.NotNil(t, edns0, "missing EDNS0 in query")
 	require.Zero(t, q.Len()%QueryPaddingBlockSize, "query not padded to the correct length")
+
+	// Larger block size
+	padQuery(q, PaddingPolicy{Enabled: true, BlockSize: 256})
+	require.Zero(t, q.Len()%256, "query not padded to the correct length")
+
+	// No padding if disabled
+	q = new(dns.Msg)
+	q.SetQuestion("google.com.", dns.TypeA)
+	q.SetEdns0(4096, false)
+	len1 := q.Len()
+	padQuery(q, PaddingPolicy{})
+	require.Equal(t, len1, q.Len(), "unexpected padding")
 }
 
 func TestStripPadding(t *testing.T) {
@@ -59,7 +71,7 @@ func TestStripPadding(t *testing.T) {
 	q.SetQuestion("google.com.", dns.TypeA)
 	q.SetEdns0(4096, false)
 	len1 := q.Len()
-	padQuery(q)
+	padQuery(q, DefaultQueryPadding)
 	stripPadding(q)
 	len2 := q.Len()
 	require.Equal(t, len1, len2, "padding not stripped off correctly")
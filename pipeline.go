@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,18 +14,29 @@ import (
 // Defines how long to wait for a response from the resolver.
 const queryTimeout = time.Second
 
-// Tear down an upstream connection if nothing has been received for this long.
+// Default time after which an upstream connection is torn down if nothing has been received.
 const idleTimeout = 10 * time.Second
 
 // Pipeline is a DNS client that is able to use pipelining for multiple requests over
-// one connection, handle out-of-order responses and deals with disconnects
-// gracefully. It opens a single connection on demand and uses it for all queries.
-// It can manage UDP, TCP, DNS-over-TLS, and DNS-over-DTLS connections.
+// one or more connections, handle out-of-order responses and deals with disconnects
+// gracefully. Connections are opened on demand, up to the configured pool size, and
+// queries are multiplexed over them by message ID as per RFC7766. It can manage UDP,
+// TCP, DNS-over-TLS, and DNS-over-DTLS connections.
 type Pipeline struct {
-	addr     string
-	client   DNSDialer
-	requests chan *request
-	metrics  *ListenerMetrics
+	addr        string
+	client      DNSDialer
+	requests    chan *request
+	metrics     *ListenerMetrics
+	idleTimeout time.Duration
+}
+
+// PipelineOptions contain settings for the connection pool of a pipeline.
+type PipelineOptions struct {
+	// Max number of connections to open to the upstream. Default 1.
+	PoolSize int
+
+	// Time after which an idle connection is closed. Default 10 seconds.
+	IdleTimeout time.Duration
 }
 
 // DNSDialer is an abstraction for a dns.Client that returns a *dns.Conn.
@@ -33,20 +45,32 @@ type DNSDialer interface {
 }
 
 // NewPipeline returns an initialized (and running) DNS connection manager.
-func NewPipeline(id string, addr string, client DNSDialer) *Pipeline {
+func NewPipeline(id string, addr string, client DNSDialer, opt PipelineOptions) *Pipeline {
+	if opt.PoolSize <= 0 {
+		opt.PoolSize = 1
+	}
+	if opt.IdleTimeout <= 0 {
+		opt.IdleTimeout = idleTimeout
+	}
 	c := &Pipeline{
-		addr:     addr,
-		client:   client,
-		requests: make(chan *request),
-		metrics:  NewListenerMetrics("client", id),
+		addr:        addr,
+		client:      client,
+		requests:    make(chan *request),
+		metrics:     NewListenerMetrics("client", id),
+		idleTimeout: opt.IdleTimeout,
+	}
+	// Each connection in the pool is managed by its own loop, all of them
+	// taking requests from the same channel.
+	for i := 0; i < opt.PoolSize; i++ {
+		go c.start()
 	}
-	go c.start()
 	return c
 }
 
 // Resolve a single query using this connection.
 func (c *Pipeline) Resolve(q *dns.Msg) (*dns.Msg, error) {
 	r := newRequest(q)
+	start := time.Now()
 
 	timeout := time.NewTimer(queryTimeout)
 	defer timeout.Stop()
@@ -67,7 +91,11 @@ func (c *Pipeline) Resolve(q *dns.Msg) (*dns.Msg, error) {
 		return nil, QueryTimeoutError{q}
 	}
 
-	return r.waitFor()
+	a, err := r.waitFor()
+	if err == nil {
+		c.metrics.observeLatency(start)
+	}
+	return a, err
 }
 
 // Starts a loop that will wait for queries and open an upstream connection on-demand, writing queries
@@ -121,7 +149,7 @@ func (c *Pipeline) start() {
 				// a network topology change wouldn't be noticed. Putting the idle timeout here ensures
 				// a reconnect in that case as well. This does create a very slight race however if the
 				// sender is using the connection right at the time of the timeout in the receiver.
-				_ = conn.SetReadDeadline(time.Now().Add(idleTimeout))
+				_ = conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
 				a, err := conn.ReadMsg()
 				if err != nil {
 					switch e := err.(type) {
@@ -197,11 +225,12 @@ func (r *request) waitFor() (*dns.Msg, error) {
 
 	if r.err == nil {
 		// As per https://tools.ietf.org/html/rfc7858#section-3.3, we need to double check this
-		// really is the correct response.
+		// really is the correct response. Names are compared case-insensitively, the case
+		// is checked by the Case0x20 resolver if needed.
 		if len(r.a.Question) > 0 && len(r.q.Question) > 0 {
 			q := r.q.Question[0]
 			a := r.a.Question[0]
-			if a.Name != q.Name || a.Qclass != q.Qclass || a.Qtype != q.Qtype {
+			if !strings.EqualFold(a.Name, q.Name) || a.Qclass != q.Qclass || a.Qtype != q.Qtype {
 				return nil, fmt.Errorf("expected answer for %s, got %s", q.String(), a.String())
 			}
 		}
@@ -2,6 +2,10 @@ package rdns
 
 import (
 	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -20,7 +24,7 @@ func TestPipelineQueryTimeout(t *testing.T) {
 		time.Sleep(2 * time.Second)
 		return nil, errors.New("failed")
 	}
-	p := NewPipeline("test", "localhost:53", testDialer(df))
+	p := NewPipeline("test", "localhost:53", testDialer(df), PipelineOptions{})
 
 	q := new(dns.Msg)
 	q.SetQuestion("example.com.", dns.TypeA)
@@ -37,3 +41,73 @@ func TestPipelineQueryTimeout(t *testing.T) {
 	require.ErrorAs(t, err, &QueryTimeoutError{})
 	require.WithinDuration(t, start.Add(queryTimeout), time.Now(), 10*time.Millisecond)
 }
+
+func TestPipelinePool(t *testing.T) {
+	// Simulated upstream that answers queries out-of-order, responding to each
+	// with the name in a TXT record.
+	var (
+		mu    sync.Mutex
+		dials int
+	)
+	df := func(address string) (*dns.Conn, error) {
+		mu.Lock()
+		dials++
+		mu.Unlock()
+		client, server := net.Pipe()
+		go func() {
+			var wmu sync.Mutex
+			conn := &dns.Conn{Conn: server}
+			for {
+				q, err := conn.ReadMsg()
+				if err != nil {
+					return
+				}
+				go func() {
+					time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+					a := new(dns.Msg)
+					a.SetReply(q)
+					a.Answer = []dns.RR{&dns.TXT{
+						Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+						Txt: []string{q.Question[0].Name},
+					}}
+					wmu.Lock()
+					_ = conn.WriteMsg(a)
+					wmu.Unlock()
+				}()
+			}
+		}()
+		return &dns.Conn{Conn: client}, nil
+	}
+	p := NewPipeline("test-pool", "localhost:53", testDialer(df), PipelineOptions{PoolSize: 4})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 1000)
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("test%d.example.com.", i)
+			q := new(dns.Msg)
+			q.SetQuestion(name, dns.TypeTXT)
+			a, err := p.Resolve(q)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if a.Id != q.Id || len(a.Answer) != 1 || a.Answer[0].(*dns.TXT).Txt[0] != name {
+				errs <- fmt.Errorf("unexpected answer for %s: %s", name, a)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	// All queries should have been sent over the connections in the pool
+	mu.Lock()
+	defer mu.Unlock()
+	require.LessOrEqual(t, dials, 4)
+	require.Greater(t, dials, 1)
+}
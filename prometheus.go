@@ -0,0 +1,161 @@
+package rdns
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Integer metrics that represent a current value rather than a count.
+var prometheusGauges = map[string]bool{
+	"entries":       true,
+	"available":     true,
+	"maxqueue":      true,
+	"breaker_state": true,
+}
+
+// PrometheusHandler returns an HTTP handler that serves all routedns metrics in the
+// Prometheus text format. It reads the registered expvar variables, so metrics don't
+// need to be recorded separately. A variable "routedns.<base>.<id>.<name>" becomes
+// the metric "routedns_<base>_<name>" with a "resolver_id" label, and counters get
+// the "_total" suffix. Response code maps use an "rcode" label, other maps a "type"
+// label. Floating point values in maps, like the effective weights of a weighted
+// group, are exported as gauges. Latency maps are exported as histograms.
+func PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		writePrometheusMetrics(bw)
+		_ = bw.Flush()
+	})
+}
+
+type prometheusMetric struct {
+	typ     string
+	samples []string
+}
+
+func writePrometheusMetrics(w *bufio.Writer) {
+	metrics := make(map[string]*prometheusMetric)
+	add := func(name, typ, sample string) {
+		m, ok := metrics[name]
+		if !ok {
+			m = &prometheusMetric{typ: typ}
+			metrics[name] = m
+		}
+		m.samples = append(m.samples, sample)
+	}
+
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !strings.HasPrefix(kv.Key, "routedns.") {
+			return
+		}
+		// The ID can contain dots, the base and name can not
+		parts := strings.Split(strings.TrimPrefix(kv.Key, "routedns."), ".")
+		if len(parts) < 3 {
+			return
+		}
+		base := parts[0]
+		name := parts[len(parts)-1]
+		id := strings.Join(parts[1:len(parts)-1], ".")
+		metric := prometheusName("routedns_" + base + "_" + name)
+		idLabel := fmt.Sprintf("resolver_id=%q", prometheusEscape(id))
+
+		switch v := kv.Value.(type) {
+		case *expvar.Int:
+			if prometheusGauges[name] {
+				add(metric, "gauge", fmt.Sprintf("%s{%s} %d", metric, idLabel, v.Value()))
+				return
+			}
+			counter := metric + "_total"
+			add(counter, "counter", fmt.Sprintf("%s{%s} %d", counter, idLabel, v.Value()))
+		case *expvar.Map:
+			if name == "latency" {
+				for _, s := range prometheusHistogram(metric, idLabel, v) {
+					add(metric, "histogram", s)
+				}
+				return
+			}
+			label := "type"
+			if name == "response" {
+				label = "rcode"
+			}
+			v.Do(func(kv expvar.KeyValue) {
+				switch i := kv.Value.(type) {
+				case *expvar.Int:
+					counter := metric + "_total"
+					add(counter, "counter", fmt.Sprintf("%s{%s,%s=%q} %d", counter, idLabel, label, prometheusEscape(kv.Key), i.Value()))
+				case *expvar.Float:
+					add(metric, "gauge", fmt.Sprintf("%s{%s,%s=%q} %g", metric, idLabel, label, prometheusEscape(kv.Key), i.Value()))
+				}
+			})
+		}
+	})
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := metrics[name]
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, m.typ)
+		for _, s := range m.samples {
+			fmt.Fprintln(w, s)
+		}
+	}
+}
+
+// Converts a latency map with counts per bucket into cumulative Prometheus
+// histogram samples, with bucket bounds in seconds.
+func prometheusHistogram(metric, idLabel string, m *expvar.Map) []string {
+	count := func(key string) int64 {
+		if i, ok := m.Get(key).(*expvar.Int); ok {
+			return i.Value()
+		}
+		return 0
+	}
+	var (
+		samples []string
+		total   int64
+	)
+	for _, b := range latencyBuckets {
+		total += count(b.String())
+		samples = append(samples, fmt.Sprintf("%s_bucket{%s,le=\"%g\"} %d", metric, idLabel, b.Seconds(), total))
+	}
+	total += count("inf")
+	var sum float64
+	if f, ok := m.Get("sum").(*expvar.Float); ok {
+		sum = f.Value()
+	}
+	samples = append(samples,
+		fmt.Sprintf("%s_bucket{%s,le=\"+Inf\"} %d", metric, idLabel, total),
+		fmt.Sprintf("%s_sum{%s} %g", metric, idLabel, sum),
+		fmt.Sprintf("%s_count{%s} %d", metric, idLabel, total),
+	)
+	return samples
+}
+
+// Replaces any characters that aren't valid in Prometheus metric names.
+func prometheusName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// Escapes a label value. Quotes are escaped by the %q verb already, but that also
+// produces Go-specific escapes for non-printable characters which are replaced here.
+func prometheusEscape(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, s)
+}
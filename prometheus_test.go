@@ -0,0 +1,74 @@
+package rdns
+
+import (
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	getVarInt("client", "prom.test", "query").Add(3)
+	getVarMap("client", "prom.test", "response").Add("NOERROR", 2)
+	getVarMap("client", "prom.test", "error").Add("timeout", 1)
+	latency := getVarMap("client", "prom.test", "latency")
+	addLatency(latency, time.Millisecond)
+	addLatency(latency, 3*time.Millisecond)
+	addLatency(latency, time.Minute)
+	getVarInt("cache", "prom-cache", "entries").Set(5)
+	weight := new(expvar.Float)
+	weight.Set(2.5)
+	getVarMap("router", "prom-weighted", "weight").Set("upstream", weight)
+
+	w := httptest.NewRecorder()
+	PrometheusHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := ioutil.ReadAll(w.Body)
+	require.NoError(t, err)
+	out := string(body)
+
+	require.Contains(t, out, "# TYPE routedns_client_query_total counter\n")
+	require.Contains(t, out, `routedns_client_query_total{resolver_id="prom.test"} 3`+"\n")
+	require.Contains(t, out, `routedns_client_response_total{resolver_id="prom.test",rcode="NOERROR"} 2`+"\n")
+	require.Contains(t, out, `routedns_client_error_total{resolver_id="prom.test",type="timeout"} 1`+"\n")
+
+	// Latency buckets are cumulative
+	require.Contains(t, out, "# TYPE routedns_client_latency histogram\n")
+	require.Contains(t, out, `routedns_client_latency_bucket{resolver_id="prom.test",le="0.001"} 1`+"\n")
+	require.Contains(t, out, `routedns_client_latency_bucket{resolver_id="prom.test",le="0.005"} 2`+"\n")
+	require.Contains(t, out, `routedns_client_latency_bucket{resolver_id="prom.test",le="10"} 2`+"\n")
+	require.Contains(t, out, `routedns_client_latency_bucket{resolver_id="prom.test",le="+Inf"} 3`+"\n")
+	require.Contains(t, out, `routedns_client_latency_count{resolver_id="prom.test"} 3`+"\n")
+	require.Contains(t, out, fmt.Sprintf(`routedns_client_latency_sum{resolver_id="prom.test"} %g`+"\n", 0.001+0.003+60.0))
+
+	require.Contains(t, out, "# TYPE routedns_cache_entries gauge\n")
+	require.Contains(t, out, `routedns_cache_entries{resolver_id="prom-cache"} 5`+"\n")
+
+	// Floating point values in maps are gauges
+	require.Contains(t, out, "# TYPE routedns_router_weight gauge\n")
+	require.Contains(t, out, `routedns_router_weight{resolver_id="prom-weighted",type="upstream"} 2.5`+"\n")
+}
+
+func TestPrometheusListenerAllowedNet(t *testing.T) {
+	addr, err := getLnAddress()
+	require.NoError(t, err)
+	_, allowed, err := net.ParseCIDR("192.0.2.0/24")
+	require.NoError(t, err)
+	s := NewPrometheusListener("test-prom", addr, PrometheusListenerOptions{
+		ListenOptions: ListenOptions{AllowedNet: []*net.IPNet{allowed}},
+	})
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	// Clients outside the allowed networks are rejected
+	resp, err := http.Get("http://" + addr + "/metrics")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
@@ -0,0 +1,85 @@
+package rdns
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PrometheusListener is an HTTP server that serves metrics in the Prometheus text
+// format under /metrics. Unlike the admin listener, TLS is optional.
+type PrometheusListener struct {
+	httpServer *http.Server
+
+	id   string
+	addr string
+	opt  PrometheusListenerOptions
+}
+
+var _ Listener = &PrometheusListener{}
+
+// PrometheusListenerOptions contains options used by the Prometheus listener.
+type PrometheusListenerOptions struct {
+	ListenOptions
+
+	// Serve metrics over HTTPS if set, plain HTTP otherwise.
+	TLSConfig *tls.Config
+}
+
+// NewPrometheusListener returns an instance of a Prometheus metrics listener.
+func NewPrometheusListener(id, addr string, opt PrometheusListenerOptions) *PrometheusListener {
+	return &PrometheusListener{
+		id:   id,
+		addr: addr,
+		opt:  opt,
+	}
+}
+
+// Start the metrics server.
+func (s *PrometheusListener) Start() error {
+	Log.WithFields(logrus.Fields{"id": s.id, "protocol": "prometheus", "addr": s.addr}).Info("starting listener")
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.allowed(PrometheusHandler()))
+	s.httpServer = &http.Server{
+		Addr:         s.addr,
+		TLSConfig:    s.opt.TLSConfig,
+		Handler:      mux,
+		ReadTimeout:  adminServerTimeout,
+		WriteTimeout: adminServerTimeout,
+	}
+
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	if s.opt.TLSConfig != nil {
+		return s.httpServer.ServeTLS(ln, "", "")
+	}
+	return s.httpServer.Serve(ln)
+}
+
+// Rejects requests from clients outside the allowed networks.
+func (s *PrometheusListener) allowed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil || !isAllowed(s.opt.AllowedNet, net.ParseIP(host)) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Stop the server.
+func (s *PrometheusListener) Stop() error {
+	Log.WithFields(logrus.Fields{"id": s.id, "protocol": "prometheus", "addr": s.addr}).Info("stopping listener")
+	return s.httpServer.Shutdown(context.Background())
+}
+
+func (s *PrometheusListener) String() string {
+	return s.id
+}
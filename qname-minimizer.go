@@ -0,0 +1,110 @@
+package rdns
+
+import (
+	"errors"
+	"expvar"
+	"strings"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// QNameMinimizer is a resolver that applies query name minimization, as described
+// in RFC7816, to reduce how much of a query name is disclosed to the upstream
+// resolver. Before sending the full query, it sends an NS query for the
+// registrable domain of the query name, like "example.co.uk." for
+// "www.example.co.uk.", and optionally for every additional label after that.
+// If one of these names doesn't exist, neither does the full name (RFC8020), and
+// the query is answered with NXDOMAIN without sending the full name upstream.
+type QNameMinimizer struct {
+	id       string
+	resolver Resolver
+	opt      QNameMinimizerOptions
+	metrics  *qnameMinimizerMetrics
+}
+
+var _ Resolver = &QNameMinimizer{}
+
+// QNameMinimizerOptions contain settings for the QNameMinimizer resolver.
+type QNameMinimizerOptions struct {
+	// Send probes for every label between the registrable domain and the full
+	// query name, not just for the registrable domain.
+	WalkLabels bool
+}
+
+type qnameMinimizerMetrics struct {
+	// Number of probe queries sent.
+	probe *expvar.Int
+	// Number of queries answered with NXDOMAIN from a probe.
+	nxdomain *expvar.Int
+}
+
+// NewQNameMinimizer returns a new instance of a query name minimizer.
+func NewQNameMinimizer(id string, resolver Resolver, opt QNameMinimizerOptions) *QNameMinimizer {
+	return &QNameMinimizer{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		metrics: &qnameMinimizerMetrics{
+			probe:    getVarInt("router", id, "probe"),
+			nxdomain: getVarInt("router", id, "nxdomain"),
+		},
+	}
+}
+
+// Resolve a DNS query by probing the parent names before sending the full query.
+func (r *QNameMinimizer) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return nil, errors.New("no question in query")
+	}
+	log := logger(r.id, q, ci)
+	for _, name := range minimizedNames(q.Question[0].Name, r.opt.WalkLabels) {
+		probe := new(dns.Msg)
+		probe.SetQuestion(name, dns.TypeNS)
+		probe.RecursionDesired = q.RecursionDesired
+		r.metrics.probe.Add(1)
+		log.WithField("probe", name).Debug("sending minimized query")
+		a, err := r.resolver.Resolve(probe, ci)
+		if err != nil || a == nil {
+			// Probes are best-effort, the full query could still work
+			break
+		}
+		if a.Rcode == dns.RcodeNameError {
+			log.WithField("probe", name).Debug("minimized name doesn't exist, responding with nxdomain")
+			r.metrics.nxdomain.Add(1)
+			answer := nxdomain(q)
+			answer.Ns = a.Ns
+			return answer, nil
+		}
+		if a.Rcode != dns.RcodeSuccess {
+			break
+		}
+	}
+	log.WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+	return r.resolver.Resolve(q, ci)
+}
+
+func (r *QNameMinimizer) String() string {
+	return r.id
+}
+
+// Returns the names to probe before querying a name, starting with the registrable
+// domain. If walk is true, every name between that and the full name follows.
+// The full name itself is not included. Returns nothing for names that are a
+// registrable domain or public suffix themselves.
+func minimizedNames(name string, walk bool) []string {
+	name = strings.TrimSuffix(dns.CanonicalName(name), ".")
+	domain, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil || domain == name {
+		return nil
+	}
+	names := []string{dns.Fqdn(domain)}
+	if !walk {
+		return names
+	}
+	labels := dns.SplitDomainName(name)
+	for i := len(labels) - dns.CountLabel(domain) - 1; i > 0; i-- {
+		names = append(names, dns.Fqdn(strings.Join(labels[i:], ".")))
+	}
+	return names
+}
@@ -0,0 +1,61 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinimizedNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		walk     bool
+		expected []string
+	}{
+		{"www.example.co.uk.", false, []string{"example.co.uk."}},
+		{"a.b.www.example.co.uk.", false, []string{"example.co.uk."}},
+		{"a.b.www.example.co.uk.", true, []string{"example.co.uk.", "www.example.co.uk.", "b.www.example.co.uk."}},
+		{"WWW.Example.COM.", true, []string{"example.com."}},
+		{"example.co.uk.", true, nil},
+		{"co.uk.", true, nil},
+		{"uk.", true, nil},
+		{".", true, nil},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.expected, minimizedNames(test.name, test.walk), test.name)
+	}
+}
+
+func TestQNameMinimizer(t *testing.T) {
+	var queries []string
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			queries = append(queries, q.Question[0].Name+" "+dns.TypeToString[q.Question[0].Qtype])
+			if dns.IsSubDomain("missing.example.co.uk.", q.Question[0].Name) {
+				return nxdomain(q), nil
+			}
+			a := new(dns.Msg)
+			a.SetReply(q)
+			return a, nil
+		},
+	}
+	r := NewQNameMinimizer("test-qmin", upstream, QNameMinimizerOptions{WalkLabels: true})
+
+	// Existing names are probed first, then the full query is sent
+	q := new(dns.Msg)
+	q.SetQuestion("a.www.example.co.uk.", dns.TypeA)
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.Equal(t, []string{"example.co.uk. NS", "www.example.co.uk. NS", "a.www.example.co.uk. A"}, queries)
+
+	// The full name isn't sent if a parent doesn't exist
+	queries = nil
+	q.SetQuestion("a.b.missing.example.co.uk.", dns.TypeA)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeNameError, a.Rcode)
+	require.Equal(t, "a.b.missing.example.co.uk.", a.Question[0].Name)
+	require.Equal(t, []string{"example.co.uk. NS", "missing.example.co.uk. NS"}, queries)
+}
@@ -0,0 +1,198 @@
+package rdns
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// QPSLimiter is a resolver that limits the query rate with token buckets, one per
+// client IP and one shared by all clients. Unlike the RateLimiter, which counts
+// queries in fixed windows, it allows short bursts while enforcing an average rate.
+// Queries exceeding either limit are refused or dropped.
+type QPSLimiter struct {
+	id       string
+	resolver Resolver
+	opt      QPSLimiterOptions
+
+	mu      sync.Mutex
+	clients map[string]*tokenBucket
+	global  *tokenBucket
+	metrics *QPSLimiterMetrics
+}
+
+var _ Resolver = &QPSLimiter{}
+
+// QPSLimiterOptions contain settings for the QPSLimiter resolver.
+type QPSLimiterOptions struct {
+	// Queries per second allowed per client IP. Disabled if 0.
+	PerClientQPS float64
+
+	// Max number of queries a client can make in a burst. Defaults to the
+	// per-client QPS, or 1 if that is below 1.
+	PerClientBurst int
+
+	// Queries per second allowed across all clients. Disabled if 0.
+	GlobalQPS float64
+
+	// Max number of queries all clients can make in a burst. Defaults to the
+	// global QPS, or 1 if that is below 1.
+	GlobalBurst int
+
+	// What to do with queries that exceed a limit. "refuse" (default) responds
+	// with REFUSED, "drop" drops the query without response.
+	Action string
+
+	// How often buckets of idle clients are removed. Default 1 minute.
+	GCPeriod time.Duration
+}
+
+type QPSLimiterMetrics struct {
+	// Count of queries.
+	query *expvar.Int
+	// Count of rejected queries by reason, "client" or "global".
+	reject *expvar.Map
+}
+
+// NewQPSLimiter returns a new instance of a token bucket rate limiter.
+func NewQPSLimiter(id string, resolver Resolver, opt QPSLimiterOptions) (*QPSLimiter, error) {
+	switch opt.Action {
+	case "":
+		opt.Action = "refuse"
+	case "refuse", "drop":
+	default:
+		return nil, fmt.Errorf("unsupported action '%s'", opt.Action)
+	}
+	if opt.PerClientQPS < 0 || opt.GlobalQPS < 0 {
+		return nil, errors.New("qps limits can not be negative")
+	}
+	opt.PerClientBurst = defaultBurst(opt.PerClientBurst, opt.PerClientQPS)
+	opt.GlobalBurst = defaultBurst(opt.GlobalBurst, opt.GlobalQPS)
+	if opt.GCPeriod == 0 {
+		opt.GCPeriod = time.Minute
+	}
+	r := &QPSLimiter{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		clients:  make(map[string]*tokenBucket),
+		metrics: &QPSLimiterMetrics{
+			query:  getVarInt("router", id, "query"),
+			reject: getVarMap("router", id, "reject"),
+		},
+	}
+	if opt.GlobalQPS > 0 {
+		r.global = newTokenBucket(opt.GlobalBurst)
+	}
+	if opt.PerClientQPS > 0 {
+		go r.startGC(opt.GCPeriod)
+	}
+	return r, nil
+}
+
+// Resolve a DNS query if the client and global rate limits allow it.
+func (r *QPSLimiter) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	log := logger(r.id, q, ci)
+	r.metrics.query.Add(1)
+
+	// Check the client limit first so that queries from a client that exceeds
+	// its own limit don't use up the global allowance.
+	now := time.Now()
+	var reason string
+	r.mu.Lock()
+	if r.opt.PerClientQPS > 0 {
+		key := ci.SourceIP.String()
+		b, ok := r.clients[key]
+		if !ok {
+			b = newTokenBucket(r.opt.PerClientBurst)
+			r.clients[key] = b
+		}
+		if !b.take(now, r.opt.PerClientQPS, r.opt.PerClientBurst) {
+			reason = "client"
+		}
+	}
+	if reason == "" && r.global != nil && !r.global.take(now, r.opt.GlobalQPS, r.opt.GlobalBurst) {
+		reason = "global"
+	}
+	r.mu.Unlock()
+
+	if reason != "" {
+		r.metrics.reject.Add(reason, 1)
+		log = log.WithField("limit", reason)
+		if r.opt.Action == "drop" {
+			log.Debug("rate-limit reached, dropping")
+			return nil, nil
+		}
+		log.Debug("rate-limit reached, refusing")
+		answer := new(dns.Msg)
+		answer.SetRcode(q, dns.RcodeRefused)
+		return answer, nil
+	}
+	log.WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+	return r.resolver.Resolve(q, ci)
+}
+
+func (r *QPSLimiter) String() string {
+	return r.id
+}
+
+// Periodically remove the buckets of clients that have been idle long enough
+// for their bucket to be full again. They're equivalent to a new bucket.
+func (r *QPSLimiter) startGC(period time.Duration) {
+	refill := time.Duration(float64(r.opt.PerClientBurst) / r.opt.PerClientQPS * float64(time.Second))
+	for {
+		time.Sleep(period)
+		now := time.Now()
+		var total, removed int
+		r.mu.Lock()
+		for key, b := range r.clients {
+			if now.Sub(b.last) >= refill {
+				delete(r.clients, key)
+				removed++
+			}
+		}
+		total = len(r.clients)
+		r.mu.Unlock()
+		Log.WithFields(logrus.Fields{"id": r.id, "total": total, "removed": removed}).Trace("rate-limiter garbage collection")
+	}
+}
+
+// Returns the burst size to use if none was configured.
+func defaultBurst(burst int, qps float64) int {
+	if burst > 0 {
+		return burst
+	}
+	return int(math.Max(1, math.Ceil(qps)))
+}
+
+// tokenBucket holds a number of tokens that are refilled at a fixed rate up to
+// a maximum. Every query takes a token, queries are rejected if there are none.
+// Not thread-safe.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(burst int) *tokenBucket {
+	return &tokenBucket{tokens: float64(burst), last: time.Now()}
+}
+
+// Refill the bucket according to the time passed since the last call and take
+// a token. Returns false if no token was available.
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) bool {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(burst), b.tokens+elapsed.Seconds()*rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,98 @@
+package rdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQPSLimiterClient(t *testing.T) {
+	r := new(TestResolver)
+	l, err := NewQPSLimiter("test-qps", r, QPSLimiterOptions{
+		PerClientQPS:   10,
+		PerClientBurst: 2,
+	})
+	require.NoError(t, err)
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	ci1 := ClientInfo{SourceIP: net.ParseIP("192.168.1.1")}
+	ci2 := ClientInfo{SourceIP: net.ParseIP("192.168.1.2")}
+
+	// The burst should be allowed, the next query refused
+	for i := 0; i < 2; i++ {
+		a, err := l.Resolve(q, ci1)
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	}
+	a, err := l.Resolve(q, ci1)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeRefused, a.Rcode)
+	require.Equal(t, 2, r.HitCount())
+
+	// Other clients have their own bucket
+	a, err = l.Resolve(q, ci2)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.Equal(t, 3, r.HitCount())
+
+	// Tokens are refilled over time
+	time.Sleep(150 * time.Millisecond)
+	a, err = l.Resolve(q, ci1)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.Equal(t, 4, r.HitCount())
+}
+
+func TestQPSLimiterGlobal(t *testing.T) {
+	r := new(TestResolver)
+	l, err := NewQPSLimiter("test-qps", r, QPSLimiterOptions{
+		GlobalQPS:   1,
+		GlobalBurst: 2,
+		Action:      "drop",
+	})
+	require.NoError(t, err)
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	// All clients share the global limit, excess queries are dropped
+	for i := 1; i <= 3; i++ {
+		ci := ClientInfo{SourceIP: net.IPv4(192, 168, 1, byte(i))}
+		a, err := l.Resolve(q, ci)
+		require.NoError(t, err)
+		if i <= 2 {
+			require.NotNil(t, a)
+		} else {
+			require.Nil(t, a)
+		}
+	}
+	require.Equal(t, 2, r.HitCount())
+
+	_, err = NewQPSLimiter("test-qps", r, QPSLimiterOptions{Action: "invalid"})
+	require.Error(t, err)
+}
+
+func TestQPSLimiterGC(t *testing.T) {
+	r := new(TestResolver)
+	l, err := NewQPSLimiter("test-qps", r, QPSLimiterOptions{
+		PerClientQPS:   100,
+		PerClientBurst: 1,
+		GCPeriod:       50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	_, err = l.Resolve(q, ClientInfo{SourceIP: net.ParseIP("192.168.1.1")})
+	require.NoError(t, err)
+
+	// Idle client buckets should be removed
+	time.Sleep(200 * time.Millisecond)
+	l.mu.Lock()
+	require.Len(t, l.clients, 0)
+	l.mu.Unlock()
+}
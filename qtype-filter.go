@@ -0,0 +1,101 @@
+package rdns
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// QTypeFilter is a resolver that blocks queries for a set of query types, like
+// ANY or HINFO, and forwards all other queries. Blocked queries are refused,
+// dropped, or answered with an empty response.
+type QTypeFilter struct {
+	id       string
+	resolver Resolver
+	types    map[uint16]bool
+	action   string
+	metrics  *QTypeFilterMetrics
+}
+
+var _ Resolver = &QTypeFilter{}
+
+// QTypeFilterOptions contain settings for the QTypeFilter resolver.
+type QTypeFilterOptions struct {
+	// Query types to block, like "ANY" or "HINFO".
+	Types []string
+
+	// What to do with blocked queries. "refuse" (default) responds with REFUSED,
+	// "empty" with an empty NOERROR response, and "drop" drops the query without
+	// response.
+	Action string
+}
+
+type QTypeFilterMetrics struct {
+	// Count of queries.
+	query *expvar.Int
+	// Count of blocked queries by type.
+	blocked *expvar.Map
+}
+
+// NewQTypeFilter returns a new instance of a query type filter.
+func NewQTypeFilter(id string, resolver Resolver, opt QTypeFilterOptions) (*QTypeFilter, error) {
+	switch opt.Action {
+	case "":
+		opt.Action = "refuse"
+	case "refuse", "empty", "drop":
+	default:
+		return nil, fmt.Errorf("unsupported action '%s'", opt.Action)
+	}
+	types, err := stringToType(opt.Types)
+	if err != nil {
+		return nil, err
+	}
+	r := &QTypeFilter{
+		id:       id,
+		resolver: resolver,
+		types:    make(map[uint16]bool),
+		action:   opt.Action,
+		metrics: &QTypeFilterMetrics{
+			query:   getVarInt("router", id, "query"),
+			blocked: getVarMap("router", id, "blocked"),
+		},
+	}
+	for _, t := range types {
+		r.types[t] = true
+	}
+	return r, nil
+}
+
+// Resolve a DNS query unless its type is blocked.
+func (r *QTypeFilter) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return nil, errors.New("no question in query")
+	}
+	log := logger(r.id, q, ci)
+	r.metrics.query.Add(1)
+	qtype := q.Question[0].Qtype
+	if !r.types[qtype] {
+		log.WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+		return r.resolver.Resolve(q, ci)
+	}
+	r.metrics.blocked.Add(dns.Type(qtype).String(), 1)
+	switch r.action {
+	case "drop":
+		log.Debug("query type blocked, dropping")
+		return nil, nil
+	case "empty":
+		log.Debug("query type blocked, responding with empty answer")
+		answer := new(dns.Msg)
+		answer.SetReply(q)
+		return answer, nil
+	default:
+		log.Debug("query type blocked, refusing")
+		return refused(q), nil
+	}
+}
+
+func (r *QTypeFilter) String() string {
+	return r.id
+}
@@ -0,0 +1,58 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQTypeFilter(t *testing.T) {
+	r := new(TestResolver)
+	f, err := NewQTypeFilter("test-qtype", r, QTypeFilterOptions{Types: []string{"ANY", "hinfo"}})
+	require.NoError(t, err)
+
+	q := new(dns.Msg)
+
+	// Blocked types are refused
+	q.SetQuestion("example.com.", dns.TypeANY)
+	a, err := f.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeRefused, a.Rcode)
+	q.SetQuestion("example.com.", dns.TypeHINFO)
+	a, err = f.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeRefused, a.Rcode)
+	require.Equal(t, 0, r.HitCount())
+
+	// Other types are forwarded
+	q.SetQuestion("example.com.", dns.TypeA)
+	_, err = f.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 1, r.HitCount())
+}
+
+func TestQTypeFilterAction(t *testing.T) {
+	r := new(TestResolver)
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeANY)
+
+	f, err := NewQTypeFilter("test-qtype", r, QTypeFilterOptions{Types: []string{"ANY"}, Action: "empty"})
+	require.NoError(t, err)
+	a, err := f.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.Empty(t, a.Answer)
+
+	f, err = NewQTypeFilter("test-qtype", r, QTypeFilterOptions{Types: []string{"ANY"}, Action: "drop"})
+	require.NoError(t, err)
+	a, err = f.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Nil(t, a)
+	require.Equal(t, 0, r.HitCount())
+
+	_, err = NewQTypeFilter("test-qtype", r, QTypeFilterOptions{Action: "invalid"})
+	require.Error(t, err)
+	_, err = NewQTypeFilter("test-qtype", r, QTypeFilterOptions{Types: []string{"invalid"}})
+	require.Error(t, err)
+}
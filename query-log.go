@@ -0,0 +1,185 @@
+package rdns
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// QueryLog is a resolver that writes one JSON object per query to a log, with
+// the client, query, response code, latency, the upstream resolver that answered
+// it and whether it came from a cache. Only a sample of the queries can be
+// logged to reduce the volume under load. Entries are written in the background,
+// and dropped if the writer can't keep up.
+type QueryLog struct {
+	id       string
+	resolver Resolver
+	opt      QueryLogOptions
+	fields   map[string]bool
+	entries  chan map[string]interface{}
+	dropped  *expvar.Int
+}
+
+var _ Resolver = &QueryLog{}
+
+// QueryLogOptions contain settings for the QueryLog resolver.
+type QueryLogOptions struct {
+	// Writer for the log entries. Defaults to STDOUT.
+	Output io.Writer
+
+	// Fraction of queries to log, between 0 and 1. Default 1 (all queries).
+	SampleRate float64
+
+	// Names of the fields to include in the log entries. Defaults to all of
+	// QueryLogFields.
+	Fields []string
+
+	// Max number of entries waiting to be written. Entries are dropped when the
+	// buffer is full. Default 1000.
+	BufferSize int
+}
+
+// QueryLogFields are the names of the fields a query log entry can have.
+var QueryLogFields = []string{"time", "client", "name", "type", "rcode", "latency", "upstream", "cache-hit", "error"}
+
+// Details about how a query was answered, collected by the resolvers the query
+// passes through. Methods can be called on a nil trace.
+type queryTrace struct {
+	mu       sync.Mutex
+	upstream string
+	cacheHit bool
+}
+
+// Record the id of the upstream resolver the query is sent to.
+func (t *queryTrace) setUpstream(id string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.upstream = id
+	t.mu.Unlock()
+}
+
+// Record that the response came from a cache.
+func (t *queryTrace) setCacheHit() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.cacheHit = true
+	t.mu.Unlock()
+}
+
+// NewQueryLog returns a new instance of a query log resolver.
+func NewQueryLog(id string, resolver Resolver, opt QueryLogOptions) (*QueryLog, error) {
+	if opt.Output == nil {
+		opt.Output = os.Stdout
+	}
+	if opt.SampleRate < 0 || opt.SampleRate > 1 {
+		return nil, fmt.Errorf("invalid sample rate %v, must be between 0 and 1", opt.SampleRate)
+	}
+	if opt.SampleRate == 0 {
+		opt.SampleRate = 1
+	}
+	if opt.BufferSize <= 0 {
+		opt.BufferSize = 1000
+	}
+	if len(opt.Fields) == 0 {
+		opt.Fields = QueryLogFields
+	}
+	supported := make(map[string]bool)
+	for _, f := range QueryLogFields {
+		supported[f] = true
+	}
+	fields := make(map[string]bool)
+	for _, f := range opt.Fields {
+		if !supported[f] {
+			return nil, fmt.Errorf("unsupported query log field '%s'", f)
+		}
+		fields[f] = true
+	}
+	r := &QueryLog{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		fields:   fields,
+		entries:  make(chan map[string]interface{}, opt.BufferSize),
+		dropped:  getVarInt("router", id, "dropped"),
+	}
+	go r.write()
+	return r, nil
+}
+
+// Resolve a DNS query with the upstream resolver and log the result.
+func (r *QueryLog) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if r.opt.SampleRate < 1 && rand.Float64() >= r.opt.SampleRate {
+		return r.resolver.Resolve(q, ci)
+	}
+	logger(r.id, q, ci).WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+	trace := new(queryTrace)
+	ci.trace = trace
+	start := time.Now()
+	a, err := r.resolver.Resolve(q, ci)
+	latency := time.Since(start)
+
+	trace.mu.Lock()
+	upstream, cacheHit := trace.upstream, trace.cacheHit
+	trace.mu.Unlock()
+
+	e := make(map[string]interface{}, len(r.fields))
+	r.set(e, "time", start.Format(time.RFC3339Nano))
+	if ci.SourceIP != nil {
+		r.set(e, "client", ci.SourceIP.String())
+	}
+	if len(q.Question) > 0 {
+		r.set(e, "name", q.Question[0].Name)
+		r.set(e, "type", dns.Type(q.Question[0].Qtype).String())
+	}
+	if a != nil {
+		r.set(e, "rcode", dns.RcodeToString[a.Rcode])
+	}
+	r.set(e, "latency", latency.String())
+	if upstream != "" {
+		r.set(e, "upstream", upstream)
+	}
+	r.set(e, "cache-hit", cacheHit)
+	if err != nil {
+		r.set(e, "error", err.Error())
+	}
+
+	// Don't block the query if the writer is falling behind
+	select {
+	case r.entries <- e:
+	default:
+		r.dropped.Add(1)
+	}
+	return a, err
+}
+
+func (r *QueryLog) String() string {
+	return r.id
+}
+
+// Add a field to the entry if it was selected in the options.
+func (r *QueryLog) set(e map[string]interface{}, field string, value interface{}) {
+	if r.fields[field] {
+		e[field] = value
+	}
+}
+
+// Writes the log entries as they come in, one JSON object per line.
+func (r *QueryLog) write() {
+	enc := json.NewEncoder(r.opt.Output)
+	for e := range r.entries {
+		if err := enc.Encode(e); err != nil {
+			Log.WithField("id", r.id).WithError(err).Error("failed to write query log")
+		}
+	}
+}
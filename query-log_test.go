@@ -0,0 +1,88 @@
+package rdns
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryLog(t *testing.T) {
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			ci.trace.setUpstream("test-upstream")
+			a := new(dns.Msg)
+			a.SetRcode(q, dns.RcodeNameError)
+			return a, nil
+		},
+	}
+	cache := NewCache("test-cache", upstream, CacheOptions{})
+	pr, pw := io.Pipe()
+	r, err := NewQueryLog("test-query-log", cache, QueryLogOptions{
+		Output: pw,
+		Fields: []string{"client", "name", "type", "rcode", "upstream", "cache-hit"},
+	})
+	require.NoError(t, err)
+	lines := bufio.NewScanner(pr)
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	ci := ClientInfo{SourceIP: net.ParseIP("192.0.2.1")}
+
+	// First query goes upstream
+	_, err = r.Resolve(q, ci)
+	require.NoError(t, err)
+	require.True(t, lines.Scan())
+	var e map[string]interface{}
+	require.NoError(t, json.Unmarshal(lines.Bytes(), &e))
+	require.Equal(t, map[string]interface{}{
+		"client":    "192.0.2.1",
+		"name":      "example.com.",
+		"type":      "A",
+		"rcode":     "NXDOMAIN",
+		"upstream":  "test-upstream",
+		"cache-hit": false,
+	}, e)
+
+	// Second one is answered from cache
+	_, err = r.Resolve(q, ci)
+	require.NoError(t, err)
+	require.True(t, lines.Scan())
+	e = nil
+	require.NoError(t, json.Unmarshal(lines.Bytes(), &e))
+	require.Equal(t, true, e["cache-hit"])
+	require.NotContains(t, e, "upstream")
+	require.Equal(t, 1, upstream.HitCount())
+
+	// Unsupported fields and sample rates fail
+	_, err = NewQueryLog("test-query-log", upstream, QueryLogOptions{Fields: []string{"qname"}})
+	require.Error(t, err)
+	_, err = NewQueryLog("test-query-log", upstream, QueryLogOptions{SampleRate: 2})
+	require.Error(t, err)
+}
+
+func TestQueryLogDrop(t *testing.T) {
+	// Nothing reads from the pipe, so the writer is blocked after the first entry
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	r, err := NewQueryLog("test-query-log-drop", new(TestResolver), QueryLogOptions{
+		Output:     pw,
+		BufferSize: 1,
+	})
+	require.NoError(t, err)
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	for i := 0; i < 5; i++ {
+		_, err = r.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+	}
+	// One is blocked in the writer, one is in the buffer, the rest is dropped
+	require.Equal(t, int64(3), r.dropped.Value())
+}
@@ -0,0 +1,142 @@
+package rdns
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// RebindProtect is a resolver that protects against DNS rebinding attacks by
+// blocking responses from upstream resolvers that contain private, loopback or
+// link-local addresses. Names that are expected to resolve to private addresses,
+// like those of internal services, can be allowed explicitly.
+type RebindProtect struct {
+	id       string
+	resolver Resolver
+	action   string
+	cidrs    IPBlocklistDB
+	allow    BlocklistDB
+	blocked  *expvar.Int
+}
+
+var _ Resolver = &RebindProtect{}
+
+// RebindProtectOptions contain settings for the RebindProtect resolver.
+type RebindProtectOptions struct {
+	// Networks that are not allowed in responses. Defaults to DefaultRebindCIDRs.
+	CIDRs []string
+
+	// What to do with responses containing addresses in the networks. "strip"
+	// (default) removes the matching records from the answer, "nxdomain" responds
+	// with NXDOMAIN, and "refuse" with REFUSED.
+	Action string
+
+	// Names that are allowed to resolve to addresses in the networks, in the
+	// format of domain blocklists, like "internal.example.com" or ".lan".
+	AllowNames []string
+}
+
+// DefaultRebindCIDRs are the private, loopback, link-local, and other non-routable
+// networks blocked by default.
+var DefaultRebindCIDRs = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// NewRebindProtect returns a new instance of a DNS rebinding protection resolver.
+func NewRebindProtect(id string, resolver Resolver, opt RebindProtectOptions) (*RebindProtect, error) {
+	switch opt.Action {
+	case "":
+		opt.Action = "strip"
+	case "strip", "nxdomain", "refuse":
+	default:
+		return nil, fmt.Errorf("unsupported action '%s'", opt.Action)
+	}
+	if len(opt.CIDRs) == 0 {
+		opt.CIDRs = DefaultRebindCIDRs
+	}
+	cidrs, err := NewCidrDB(NewStaticLoader(opt.CIDRs))
+	if err != nil {
+		return nil, err
+	}
+	allow, err := NewDomainDB(NewStaticLoader(opt.AllowNames))
+	if err != nil {
+		return nil, err
+	}
+	return &RebindProtect{
+		id:       id,
+		resolver: resolver,
+		action:   opt.Action,
+		cidrs:    cidrs,
+		allow:    allow,
+		blocked:  getVarInt("router", id, "blocked_rebind"),
+	}, nil
+}
+
+// Resolve a DNS query with the upstream resolver and block the response if it
+// contains addresses in one of the protected networks.
+func (r *RebindProtect) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	answer, err := r.resolver.Resolve(q, ci)
+	if err != nil || answer == nil || answer.Rcode != dns.RcodeSuccess || len(q.Question) < 1 {
+		return answer, err
+	}
+	if _, _, _, ok := r.allow.Match(q.Question[0]); ok {
+		return answer, nil
+	}
+	log := logger(r.id, q, ci)
+
+	var (
+		rrs     []dns.RR
+		blocked bool
+	)
+	for _, rr := range answer.Answer {
+		var ip net.IP
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip = rr.A
+		case *dns.AAAA:
+			ip = rr.AAAA
+		}
+		if ip != nil {
+			if rule, ok := r.cidrs.Match(ip); ok {
+				log.WithFields(logrus.Fields{"rule": rule, "ip": ip}).Debug("private address in response")
+				blocked = true
+				continue
+			}
+		}
+		rrs = append(rrs, rr)
+	}
+	if !blocked {
+		return answer, nil
+	}
+	r.blocked.Add(1)
+
+	switch r.action {
+	case "nxdomain":
+		log.Debug("blocking response, responding with nxdomain")
+		return nxdomain(q), nil
+	case "refuse":
+		log.Debug("blocking response, refusing")
+		return refused(q), nil
+	default:
+		log.Debug("stripping private addresses from response")
+		answer.Answer = rrs
+		return answer, nil
+	}
+}
+
+func (r *RebindProtect) String() string {
+	return r.id
+}
@@ -0,0 +1,67 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebindProtect(t *testing.T) {
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			for _, s := range []string{
+				q.Question[0].Name + " IN A 192.168.1.1",
+				q.Question[0].Name + " IN A 1.2.3.4",
+				q.Question[0].Name + " IN AAAA ::1",
+			} {
+				rr, err := dns.NewRR(s)
+				require.NoError(t, err)
+				a.Answer = append(a.Answer, rr)
+			}
+			return a, nil
+		},
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("attacker.example.com.", dns.TypeA)
+
+	// Private addresses are removed by default
+	r, err := NewRebindProtect("test-rebind", upstream, RebindProtectOptions{AllowNames: []string{".lan"}})
+	require.NoError(t, err)
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 1)
+	require.Equal(t, "1.2.3.4", a.Answer[0].(*dns.A).A.String())
+
+	// Allowed names are not modified
+	q.SetQuestion("router.lan.", dns.TypeA)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 3)
+
+	// Block the response entirely
+	q.SetQuestion("attacker.example.com.", dns.TypeA)
+	r, err = NewRebindProtect("test-rebind", upstream, RebindProtectOptions{Action: "nxdomain"})
+	require.NoError(t, err)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeNameError, a.Rcode)
+
+	r, err = NewRebindProtect("test-rebind", upstream, RebindProtectOptions{Action: "refuse"})
+	require.NoError(t, err)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeRefused, a.Rcode)
+
+	// Custom networks
+	r, err = NewRebindProtect("test-rebind", upstream, RebindProtectOptions{CIDRs: []string{"1.2.3.0/24"}})
+	require.NoError(t, err)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 2)
+
+	_, err = NewRebindProtect("test-rebind", upstream, RebindProtectOptions{Action: "invalid"})
+	require.Error(t, err)
+}
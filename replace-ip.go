@@ -0,0 +1,117 @@
+package rdns
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ReplaceIP is a resolver that rewrites A and AAAA records in the answer section of
+// responses according to a list of rules. Each rule matches addresses in a network
+// or a single IP and replaces them with another IP, which can be used to
+// transparently redirect clients to a different host. Only the address is changed,
+// TTLs and the number of records remain the same.
+type ReplaceIP struct {
+	id       string
+	resolver Resolver
+	rules    []replaceIPRule
+	replaced *expvar.Int
+}
+
+var _ Resolver = &ReplaceIP{}
+
+// ReplaceIPRule defines which addresses are replaced and what they're replaced with.
+type ReplaceIPRule struct {
+	// Network in CIDR notation or a single IP to match addresses against.
+	Match string
+
+	// IP that matching addresses are replaced with. IPv4 addresses only replace
+	// A records, IPv6 addresses only AAAA records.
+	Replace string
+}
+
+type replaceIPRule struct {
+	match   *net.IPNet
+	replace net.IP
+}
+
+// NewReplaceIP returns a new instance of a ReplaceIP resolver. Rules are applied in
+// order, the first one that matches an address is used.
+func NewReplaceIP(id string, resolver Resolver, list ...ReplaceIPRule) (*ReplaceIP, error) {
+	var rules []replaceIPRule
+	for _, o := range list {
+		match := o.Match
+		if !strings.Contains(match, "/") {
+			if ip := net.ParseIP(match); ip != nil && ip.To4() != nil {
+				match += "/32"
+			} else {
+				match += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(match)
+		if err != nil {
+			return nil, err
+		}
+		ip := net.ParseIP(o.Replace)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid replacement ip '%s'", o.Replace)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		rules = append(rules, replaceIPRule{match: n, replace: ip})
+	}
+	return &ReplaceIP{
+		id:       id,
+		resolver: resolver,
+		rules:    rules,
+		replaced: getVarInt("router", id, "replaced"),
+	}, nil
+}
+
+// Resolve a DNS query and replace any matching addresses in the response.
+func (r *ReplaceIP) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	a, err := r.resolver.Resolve(q, ci)
+	if err != nil || a == nil {
+		return a, err
+	}
+	log := logger(r.id, q, ci)
+	for _, rr := range a.Answer {
+		switch record := rr.(type) {
+		case *dns.A:
+			if ip := r.lookup(record.A, true); ip != nil {
+				log.WithField("old", record.A).WithField("new", ip).Debug("replacing address")
+				record.A = ip
+				r.replaced.Add(1)
+			}
+		case *dns.AAAA:
+			if ip := r.lookup(record.AAAA, false); ip != nil {
+				log.WithField("old", record.AAAA).WithField("new", ip).Debug("replacing address")
+				record.AAAA = ip
+				r.replaced.Add(1)
+			}
+		}
+	}
+	return a, nil
+}
+
+func (r *ReplaceIP) String() string {
+	return r.id
+}
+
+// Returns the replacement for an address, or nil if no rule matches. Only rules
+// with a replacement of the same address family are considered.
+func (r *ReplaceIP) lookup(ip net.IP, v4 bool) net.IP {
+	for _, rule := range r.rules {
+		if (len(rule.replace) == net.IPv4len) != v4 {
+			continue
+		}
+		if rule.match.Contains(ip) {
+			return rule.replace
+		}
+	}
+	return nil
+}
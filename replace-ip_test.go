@@ -0,0 +1,55 @@
+package rdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceIP(t *testing.T) {
+	r := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			for _, ip := range []string{"203.0.113.1", "203.0.113.200", "192.0.2.1"} {
+				a.Answer = append(a.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+					A:   net.ParseIP(ip),
+				})
+			}
+			a.Answer = append(a.Answer, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 300},
+				AAAA: net.ParseIP("2001:db8::1"),
+			})
+			return a, nil
+		},
+	}
+
+	rules := []ReplaceIPRule{
+		{Match: "203.0.113.0/24", Replace: "10.0.0.5"},
+		{Match: "2001:db8::1", Replace: "fd00::5"},
+	}
+	b, err := NewReplaceIP("test-replace-ip", r, rules...)
+	require.NoError(t, err)
+
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeA)
+	a, err := b.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 4)
+	require.Equal(t, "10.0.0.5", a.Answer[0].(*dns.A).A.String())
+	require.Equal(t, "10.0.0.5", a.Answer[1].(*dns.A).A.String())
+	require.Equal(t, "192.0.2.1", a.Answer[2].(*dns.A).A.String())
+	require.Equal(t, "fd00::5", a.Answer[3].(*dns.AAAA).AAAA.String())
+	for _, rr := range a.Answer {
+		require.Equal(t, uint32(300), rr.Header().Ttl)
+	}
+
+	// Invalid rules
+	_, err = NewReplaceIP("test-replace-ip", r, ReplaceIPRule{Match: "203.0.113.0/33", Replace: "10.0.0.5"})
+	require.Error(t, err)
+	_, err = NewReplaceIP("test-replace-ip", r, ReplaceIPRule{Match: "203.0.113.0/24", Replace: "invalid"})
+	require.Error(t, err)
+}
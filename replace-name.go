@@ -0,0 +1,98 @@
+package rdns
+
+import (
+	"errors"
+	"expvar"
+	"regexp"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// ReplaceName is a resolver that rewrites names in responses with regular
+// expressions, for example to turn internal names returned by an upstream into
+// public ones. Owner names of answer records and CNAME targets are rewritten. The
+// question, and any record owned by the query name, is left as it was sent by the
+// client, since clients reject responses for a different question. Names are
+// compared and matched in lower case, so they are rewritten the same way
+// regardless of their case in the response. Any DNSSEC signatures in modified
+// responses won't validate anymore, they are not re-signed.
+type ReplaceName struct {
+	id       string
+	resolver Resolver
+	exp      replaceExpressions
+	replaced *expvar.Int
+}
+
+var _ Resolver = &ReplaceName{}
+
+// ReplaceNameRule is a regular expression that is applied to names and the string
+// to replace matches with.
+type ReplaceNameRule struct {
+	// Regular expression applied to names in the response, in lower case.
+	Pattern string
+
+	// Replacement for matches of Pattern. Can reference groups with ${1}.
+	Replacement string
+}
+
+// NewReplaceName returns a new instance of a ReplaceName resolver. Rules are
+// applied in order, each to the result of the previous one.
+func NewReplaceName(id string, resolver Resolver, list ...ReplaceNameRule) (*ReplaceName, error) {
+	var exp replaceExpressions
+	for _, o := range list {
+		re, err := regexp.Compile(o.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		exp = append(exp, replaceExp{re, o.Replacement})
+	}
+	return &ReplaceName{
+		id:       id,
+		resolver: resolver,
+		exp:      exp,
+		replaced: getVarInt("router", id, "replaced"),
+	}, nil
+}
+
+// Resolve a DNS query and rewrite the names in the response.
+func (r *ReplaceName) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return nil, errors.New("no question in query")
+	}
+	a, err := r.resolver.Resolve(q, ci)
+	if err != nil || a == nil {
+		return a, err
+	}
+	log := logger(r.id, q, ci)
+	qName := q.Question[0].Name
+	for _, rr := range a.Answer {
+		h := rr.Header()
+		if !equalName(h.Name, qName) {
+			h.Name = r.apply(log, h.Name)
+		}
+		if cname, ok := rr.(*dns.CNAME); ok && !equalName(cname.Target, qName) {
+			cname.Target = r.apply(log, cname.Target)
+		}
+	}
+	return a, nil
+}
+
+func (r *ReplaceName) String() string {
+	return r.id
+}
+
+// Returns the name with all rules applied. Results are turned into FQDNs to keep
+// the response valid if a rule dropped the trailing dot. Names that don't match
+// any rule are returned with their original case.
+func (r *ReplaceName) apply(log *logrus.Entry, name string) string {
+	canonical := dns.CanonicalName(name)
+	newName := r.exp.apply(canonical)
+	if newName == canonical {
+		return name
+	}
+	newName = dns.Fqdn(newName)
+	log.WithField("old", name).WithField("new", newName).Debug("replacing name")
+	r.replaced.Add(1)
+	return newName
+}
@@ -0,0 +1,78 @@
+package rdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceName(t *testing.T) {
+	r := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.Answer = []dns.RR{
+				&dns.CNAME{
+					Hdr:    dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 300},
+					Target: "app1.eu.internal.corp.",
+				},
+				&dns.A{
+					Hdr: dns.RR_Header{Name: "app1.eu.internal.corp.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+					A:   net.ParseIP("192.0.2.1"),
+				},
+			}
+			return a, nil
+		},
+	}
+
+	rules := []ReplaceNameRule{
+		{Pattern: `^(.+)\.(eu|us)\.internal\.corp\.$`, Replacement: `${1}-${2}.corp.example.com.`},
+	}
+	b, err := NewReplaceName("test-replace-name", r, rules...)
+	require.NoError(t, err)
+
+	// CNAME target and the owner of the record it points to are rewritten
+	// consistently, the question is unchanged
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.com.", dns.TypeA)
+	a, err := b.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, "www.example.com.", a.Question[0].Name)
+	require.Equal(t, "www.example.com.", a.Answer[0].Header().Name)
+	require.Equal(t, "app1-eu.corp.example.com.", a.Answer[0].(*dns.CNAME).Target)
+	require.Equal(t, "app1-eu.corp.example.com.", a.Answer[1].Header().Name)
+
+	// Names matching the query are never rewritten
+	q.SetQuestion("app1.eu.internal.corp.", dns.TypeA)
+	a, err = b.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, "app1.eu.internal.corp.", a.Question[0].Name)
+	require.Equal(t, "app1.eu.internal.corp.", a.Answer[1].Header().Name)
+
+	// Names are compared and matched regardless of case
+	q.SetQuestion("APP1.EU.Internal.Corp.", dns.TypeA)
+	a, err = b.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, "app1.eu.internal.corp.", a.Answer[1].Header().Name)
+	mixed := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a, err := r.Resolve(q, ci)
+			a.Answer[0].(*dns.CNAME).Target = "App1.EU.internal.corp."
+			a.Answer[1].Header().Name = "APP1.eu.INTERNAL.corp."
+			return a, err
+		},
+	}
+	b, err = NewReplaceName("test-replace-name", mixed, rules...)
+	require.NoError(t, err)
+	q.SetQuestion("www.example.com.", dns.TypeA)
+	a, err = b.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, "app1-eu.corp.example.com.", a.Answer[0].(*dns.CNAME).Target)
+	require.Equal(t, "app1-eu.corp.example.com.", a.Answer[1].Header().Name)
+
+	// Invalid expression
+	_, err = NewReplaceName("test-replace-name", r, ReplaceNameRule{Pattern: `(`})
+	require.Error(t, err)
+}
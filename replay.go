@@ -0,0 +1,203 @@
+package rdns
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// Replay is a resolver that records queries and the responses of its upstream
+// resolver to a file, and later serves responses from that file instead of
+// sending queries upstream. This allows deterministic tests of a configuration
+// without network access. Responses are matched by query name and type.
+type Replay struct {
+	id       string
+	resolver Resolver
+	opt      ReplayOptions
+	metrics  *replayMetrics
+
+	mu        sync.Mutex
+	file      *os.File            // Recording file, in "record" mode
+	responses map[string]*dns.Msg // Recorded responses by name and type, in "replay" mode
+}
+
+var _ Resolver = &Replay{}
+
+// ReplayOptions contain settings for the Replay resolver.
+type ReplayOptions struct {
+	// File to write recorded responses to, or read them from.
+	Path string
+
+	// "record" forwards queries upstream and appends the responses to the file,
+	// "replay" answers queries from the file, and "passthrough" only forwards
+	// queries upstream. Default "replay".
+	Mode string
+
+	// What to do in "replay" mode if there is no recorded response for a query.
+	// "error" (default) fails the query, "forward" sends it upstream.
+	MissAction string
+}
+
+type replayMetrics struct {
+	// Number of queries answered from the recording.
+	hit *expvar.Int
+	// Number of queries without recorded response.
+	miss *expvar.Int
+	// Number of responses recorded.
+	recorded *expvar.Int
+}
+
+// Recorded response, one per line in the file.
+type replayEntry struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Response []byte `json:"response"` // Wire format
+}
+
+// NewReplay returns a new instance of a Replay resolver.
+func NewReplay(id string, resolver Resolver, opt ReplayOptions) (*Replay, error) {
+	switch opt.Mode {
+	case "":
+		opt.Mode = "replay"
+	case "record", "replay", "passthrough":
+	default:
+		return nil, fmt.Errorf("unsupported replay mode '%s'", opt.Mode)
+	}
+	switch opt.MissAction {
+	case "":
+		opt.MissAction = "error"
+	case "error", "forward":
+	default:
+		return nil, fmt.Errorf("unsupported replay miss action '%s'", opt.MissAction)
+	}
+	if opt.Path == "" && opt.Mode != "passthrough" {
+		return nil, errors.New("replay requires a path")
+	}
+	r := &Replay{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		metrics: &replayMetrics{
+			hit:      getVarInt("router", id, "hit"),
+			miss:     getVarInt("router", id, "miss"),
+			recorded: getVarInt("router", id, "recorded"),
+		},
+	}
+	var err error
+	switch opt.Mode {
+	case "record":
+		r.file, err = os.OpenFile(opt.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	case "replay":
+		r.responses, err = loadReplayFile(opt.Path)
+	}
+	return r, err
+}
+
+// Resolve a DNS query according to the mode of the resolver.
+func (r *Replay) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return nil, errors.New("no question in query")
+	}
+	log := logger(r.id, q, ci)
+	switch r.opt.Mode {
+	case "record":
+		log.WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+		a, err := r.resolver.Resolve(q, ci)
+		if err != nil || a == nil {
+			return a, err
+		}
+		if err := r.record(q, a); err != nil {
+			log.WithError(err).Error("failed to record response")
+		}
+		return a, nil
+	case "replay":
+		if a, ok := r.responses[replayKey(q.Question[0].Name, q.Question[0].Qtype)]; ok {
+			log.Debug("answering from recording")
+			r.metrics.hit.Add(1)
+			a = a.Copy()
+			a.Id = q.Id
+			a.Question = q.Question
+			return a, nil
+		}
+		r.metrics.miss.Add(1)
+		if r.opt.MissAction == "error" {
+			log.Debug("no recorded response")
+			return nil, fmt.Errorf("no recorded response for %s %s", q.Question[0].Name, dns.TypeToString[q.Question[0].Qtype])
+		}
+	}
+	log.WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+	return r.resolver.Resolve(q, ci)
+}
+
+func (r *Replay) String() string {
+	return r.id
+}
+
+// Append a response to the recording.
+func (r *Replay) record(q, a *dns.Msg) error {
+	b, err := a.Pack()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(replayEntry{
+		Name:     q.Question[0].Name,
+		Type:     dns.TypeToString[q.Question[0].Qtype],
+		Response: b,
+	})
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	r.metrics.recorded.Add(1)
+	return nil
+}
+
+// Reads recorded responses from a file. If a query was recorded more than once,
+// the last response is used.
+func loadReplayFile(path string) (map[string]*dns.Msg, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	responses := make(map[string]*dns.Msg)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var n int
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var e replayEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		qtype, ok := dns.StringToType[e.Type]
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: invalid type '%s'", path, n, e.Type)
+		}
+		a := new(dns.Msg)
+		if err := a.Unpack(e.Response); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		responses[replayKey(e.Name, qtype)] = a
+	}
+	return responses, scanner.Err()
+}
+
+func replayKey(name string, qtype uint16) string {
+	return dns.CanonicalName(name) + " " + dns.TypeToString[qtype]
+}
@@ -0,0 +1,70 @@
+package rdns
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routedns")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "recording")
+
+	var ci ClientInfo
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.Answer = []dns.RR{&dns.A{
+				Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600},
+				A:   []byte{192, 0, 2, 1},
+			}}
+			return a, nil
+		},
+	}
+
+	// Record a response
+	r, err := NewReplay("test-replay", upstream, ReplayOptions{Path: path, Mode: "record"})
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	_, err = r.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 1, upstream.HitCount())
+
+	// Replay it without sending the query upstream
+	r, err = NewReplay("test-replay", upstream, ReplayOptions{Path: path, Mode: "replay"})
+	require.NoError(t, err)
+	q = new(dns.Msg)
+	q.SetQuestion("Example.com.", dns.TypeA)
+	a, err := r.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 1, upstream.HitCount())
+	require.Equal(t, q.Id, a.Id)
+	require.Equal(t, "192.0.2.1", a.Answer[0].(*dns.A).A.String())
+
+	// Queries that weren't recorded fail
+	q.SetQuestion("example.com.", dns.TypeAAAA)
+	_, err = r.Resolve(q, ci)
+	require.Error(t, err)
+	require.Equal(t, 1, upstream.HitCount())
+
+	// Or are forwarded upstream
+	r, err = NewReplay("test-replay", upstream, ReplayOptions{Path: path, Mode: "replay", MissAction: "forward"})
+	require.NoError(t, err)
+	_, err = r.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, 2, upstream.HitCount())
+
+	// Replaying requires an existing recording
+	_, err = NewReplay("test-replay", upstream, ReplayOptions{Path: filepath.Join(dir, "missing")})
+	require.Error(t, err)
+	_, err = NewReplay("test-replay", upstream, ReplayOptions{Path: path, Mode: "invalid"})
+	require.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package rdns
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/miekg/dns"
@@ -11,3 +12,21 @@ type Resolver interface {
 	Resolve(*dns.Msg, ClientInfo) (*dns.Msg, error)
 	fmt.Stringer
 }
+
+// ContextResolver is implemented by resolvers that can cancel queries, for
+// example when the client disconnected or a deadline has passed.
+type ContextResolver interface {
+	Resolver
+	ResolveContext(context.Context, *dns.Msg, ClientInfo) (*dns.Msg, error)
+}
+
+// Resolves a query with the given context. The context is passed on to the
+// resolver if it supports it, and along with the client info otherwise so it
+// reaches resolvers further down the chain.
+func resolveContext(ctx context.Context, r Resolver, q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	ci.ctx = ctx
+	if cr, ok := r.(ContextResolver); ok {
+		return cr.ResolveContext(ctx, q, ci)
+	}
+	return r.Resolve(q, ci)
+}
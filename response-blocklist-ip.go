@@ -41,6 +41,10 @@ type ResponseBlocklistIPOptions struct {
 	// If true, removes matching records from the response rather than replying with NXDOMAIN. Can
 	// not be combined with alternative blockist-resolver
 	Filter bool
+
+	// Extended DNS Error added to blocked responses, unless they come from the
+	// BlocklistResolver. Optional.
+	EDE *ExtendedError
 }
 
 // NewResponseBlocklistIP returns a new instance of a response blocklist resolver.
@@ -81,7 +85,7 @@ func (r *ResponseBlocklistIP) refreshLoopBlocklist(refresh time.Duration) {
 		log.Debug("reloading blocklist")
 		db, err := r.BlocklistDB.Reload()
 		if err != nil {
-			Log.WithError(err).Error("failed to load rules")
+			log.WithError(err).Warn("failed to load rules, keeping the previous ones")
 			continue
 		}
 		r.mu.Lock()
@@ -110,7 +114,7 @@ func (r *ResponseBlocklistIP) blockIfMatch(query, answer *dns.Msg, ci ClientInfo
 					return r.BlocklistResolver.Resolve(query, ci)
 				}
 				log.Debug("blocking response")
-				return nxdomain(query), nil
+				return r.EDE.addTo(query, nxdomain(query)), nil
 			}
 		}
 	}
@@ -127,7 +131,7 @@ func (r *ResponseBlocklistIP) filterMatch(query, answer *dns.Msg, ci ClientInfo)
 			return r.BlocklistResolver.Resolve(query, ci)
 		}
 		log.Debug("no answers after filtering, blocking response")
-		return nxdomain(query), nil
+		return r.EDE.addTo(query, nxdomain(query)), nil
 	}
 	answer.Ns = r.filterRR(query, ci, answer.Ns)
 	answer.Extra = r.filterRR(query, ci, answer.Extra)
@@ -26,6 +26,10 @@ type ResponseBlocklistNameOptions struct {
 
 	// Refresh period for the blocklist. Disabled if 0.
 	BlocklistRefresh time.Duration
+
+	// Extended DNS Error added to blocked responses, unless they come from the
+	// BlocklistResolver. Optional.
+	EDE *ExtendedError
 }
 
 // NewResponseBlocklistName returns a new instance of a response blocklist resolver.
@@ -60,12 +64,16 @@ func (r *ResponseBlocklistName) refreshLoopBlocklist(refresh time.Duration) {
 		log.Debug("reloading blocklist")
 		db, err := r.BlocklistDB.Reload()
 		if err != nil {
-			Log.WithError(err).Error("failed to load rules")
+			log.WithError(err).Warn("failed to load rules, keeping the previous ones")
 			continue
 		}
 		r.mu.Lock()
+		old := r.BlocklistDB
 		r.BlocklistDB = db
 		r.mu.Unlock()
+		if err := closeBlocklistDB(old); err != nil {
+			log.WithError(err).Warn("failed to close previous rules")
+		}
 	}
 }
 
@@ -94,7 +102,7 @@ func (r *ResponseBlocklistName) blockIfMatch(query, answer *dns.Msg, ci ClientIn
 					return r.BlocklistResolver.Resolve(query, ci)
 				}
 				log.Debug("blocking response")
-				return nxdomain(query), nil
+				return r.EDE.addTo(query, nxdomain(query)), nil
 			}
 		}
 	}
@@ -0,0 +1,49 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCollapse(t *testing.T) {
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			for _, s := range []string{
+				"www.example.com. 2964 IN CNAME www.glb.example.com.",
+				"www.glb.example.com. 251 IN CNAME e1.example.net.",
+				"e1.example.net. 7199 IN A 95.100.196.60",
+				"e1.example.net. 7199 IN A 95.100.196.61",
+			} {
+				rr, err := dns.NewRR(s)
+				require.NoError(t, err)
+				a.Answer = append(a.Answer, rr)
+			}
+			return a, nil
+		},
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.com.", dns.TypeA)
+
+	// Only the records of the query type are kept, under the query name
+	r := NewResponseCollapse("test-collapse", upstream, ResponseCollapsOptions{})
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 2)
+	for _, rr := range a.Answer {
+		require.Equal(t, "www.example.com.", rr.Header().Name)
+		require.Equal(t, dns.TypeA, rr.Header().Rrtype)
+		require.Equal(t, uint32(7199), rr.Header().Ttl)
+	}
+
+	// Nothing left after collapsing
+	r = NewResponseCollapse("test-collapse", upstream, ResponseCollapsOptions{NullRCode: dns.RcodeNameError})
+	q.SetQuestion("www.example.com.", dns.TypeAAAA)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeNameError, a.Rcode)
+	require.Empty(t, a.Answer)
+}
@@ -0,0 +1,74 @@
+package rdns
+
+import (
+	"expvar"
+
+	"github.com/miekg/dns"
+)
+
+// ResponseLimiter is a resolver that limits the number of answer records and the
+// size of responses from its upstream resolver, to protect clients from very large
+// responses. Responses that exceed the limits are truncated, and optionally
+// marked with the TC bit so clients can retry over TCP.
+type ResponseLimiter struct {
+	id       string
+	resolver Resolver
+	opt      ResponseLimiterOptions
+	truncate *expvar.Map
+}
+
+var _ Resolver = &ResponseLimiter{}
+
+// ResponseLimiterOptions contain settings for the ResponseLimiter resolver.
+type ResponseLimiterOptions struct {
+	// Max number of records in the answer section. No limit if 0.
+	MaxAnswers int
+
+	// Max size of the response in bytes. Records are removed from the end of
+	// the response until it fits. No limit if 0, values below 512 are treated
+	// as 512.
+	MaxSize int
+
+	// Set the TC bit in truncated responses.
+	SetTC bool
+}
+
+// NewResponseLimiter returns a new instance of a response limiter.
+func NewResponseLimiter(id string, resolver Resolver, opt ResponseLimiterOptions) *ResponseLimiter {
+	return &ResponseLimiter{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		truncate: getVarMap("router", id, "truncate"),
+	}
+}
+
+// Resolve a DNS query with the upstream resolver and truncate the response if
+// it exceeds the limits.
+func (r *ResponseLimiter) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	answer, err := r.resolver.Resolve(q, ci)
+	if err != nil || answer == nil {
+		return answer, err
+	}
+	log := logger(r.id, q, ci)
+	truncated := answer.Truncated
+
+	if r.opt.MaxAnswers > 0 && len(answer.Answer) > r.opt.MaxAnswers {
+		log.WithField("answers", len(answer.Answer)).Debug("too many answers, truncating response")
+		r.truncate.Add("answers", 1)
+		answer.Answer = answer.Answer[:r.opt.MaxAnswers]
+		truncated = truncated || r.opt.SetTC
+	}
+	if r.opt.MaxSize > 0 && answer.Len() > r.opt.MaxSize {
+		log.WithField("size", answer.Len()).Debug("response too large, truncating")
+		r.truncate.Add("size", 1)
+		answer.Truncate(r.opt.MaxSize)
+		truncated = truncated || r.opt.SetTC
+	}
+	answer.Truncated = truncated
+	return answer, nil
+}
+
+func (r *ResponseLimiter) String() string {
+	return r.id
+}
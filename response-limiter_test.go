@@ -0,0 +1,47 @@
+package rdns
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseLimiter(t *testing.T) {
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			for i := 0; i < 100; i++ {
+				rr, _ := dns.NewRR(fmt.Sprintf("%s IN TXT \"record %d with some padding to make it larger\"", q.Question[0].Name, i))
+				a.Answer = append(a.Answer, rr)
+			}
+			return a, nil
+		},
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeTXT)
+
+	// Limit the number of answers without setting TC
+	r := NewResponseLimiter("test-limit", upstream, ResponseLimiterOptions{MaxAnswers: 10})
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 10)
+	require.False(t, a.Truncated)
+
+	// Limit the size and set TC
+	r = NewResponseLimiter("test-limit", upstream, ResponseLimiterOptions{MaxSize: 1232, SetTC: true})
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.True(t, a.Len() <= 1232)
+	require.NotEmpty(t, a.Answer)
+	require.True(t, a.Truncated)
+
+	// Small responses are not modified
+	r = NewResponseLimiter("test-limit", upstream, ResponseLimiterOptions{MaxAnswers: 100, MaxSize: 65535, SetTC: true})
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 100)
+	require.False(t, a.Truncated)
+}
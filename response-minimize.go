@@ -5,32 +5,90 @@ import (
 )
 
 // ResponseMinimize is a resolver that strips Extra and Authority records
-// from responses, leaving just the answer records.
+// from responses, leaving just the answer records. Only responses with an
+// answer to the query are minimized, referrals and negative responses are
+// passed on unmodified. The OPT record is always kept, as are DNSSEC records
+// if the client asked for them.
 type ResponseMinimize struct {
-	id       string
-	resolver Resolver
+	id        string
+	resolver  Resolver
+	keepExtra []uint16
 }
 
 var _ Resolver = &ResponseMinimize{}
 
+// ResponseMinimizeOptions contain settings for a response minimizer.
+type ResponseMinimizeOptions struct {
+	// Query types for which the Extra records are kept, like "MX" or "SRV"
+	// where they typically contain the addresses of the targets.
+	KeepExtraTypes []string
+}
+
 // NewResponseMinimize returns a new instance of a response minimizer.
-func NewResponseMinimize(id string, resolver Resolver) *ResponseMinimize {
-	return &ResponseMinimize{id: id, resolver: resolver}
+func NewResponseMinimize(id string, resolver Resolver, opt ResponseMinimizeOptions) (*ResponseMinimize, error) {
+	keepExtra, err := stringToType(opt.KeepExtraTypes)
+	if err != nil {
+		return nil, err
+	}
+	return &ResponseMinimize{id: id, resolver: resolver, keepExtra: keepExtra}, nil
 }
 
 // Resolve a DNS query with the upstream resolver and strip out any extra or NS
 // records in the response.
 func (r *ResponseMinimize) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
 	answer, err := r.resolver.Resolve(q, ci)
-	if err != nil || answer == nil || answer.Rcode != dns.RcodeSuccess {
+	if err != nil || answer == nil || answer.Rcode != dns.RcodeSuccess || len(q.Question) < 1 {
 		return answer, err
 	}
+	qtype := q.Question[0].Qtype
+	if !answersQuery(answer, qtype) {
+		return answer, nil
+	}
 	logger(r.id, q, ci).Debug("stripping response")
-	answer.Extra = nil
-	answer.Ns = nil
+
+	var dnssec bool
+	if edns0 := q.IsEdns0(); edns0 != nil {
+		dnssec = edns0.Do()
+	}
+	answer.Ns = minimizeRecords(answer.Ns, dnssec)
+	for _, t := range r.keepExtra {
+		if t == qtype {
+			return answer, nil
+		}
+	}
+	answer.Extra = minimizeRecords(answer.Extra, dnssec)
 	return answer, nil
 }
 
 func (r *ResponseMinimize) String() string {
 	return r.id
 }
+
+// Returns true if the answer section has records of the queried type.
+func answersQuery(a *dns.Msg, qtype uint16) bool {
+	for _, rr := range a.Answer {
+		if qtype == dns.TypeANY || rr.Header().Rrtype == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns only the records that need to be kept in a minimized response, that
+// is OPT and, if requested, DNSSEC records.
+func minimizeRecords(rrs []dns.RR, dnssec bool) []dns.RR {
+	var out []dns.RR
+	for _, rr := range rrs {
+		switch rr.Header().Rrtype {
+		case dns.TypeOPT:
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+			if !dnssec {
+				continue
+			}
+		default:
+			continue
+		}
+		out = append(out, rr)
+	}
+	return out
+}
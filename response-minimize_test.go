@@ -0,0 +1,81 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseMinimize(t *testing.T) {
+	var answer, ns, extra []string
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			for _, s := range answer {
+				rr, _ := dns.NewRR(s)
+				a.Answer = append(a.Answer, rr)
+			}
+			for _, s := range ns {
+				rr, _ := dns.NewRR(s)
+				a.Ns = append(a.Ns, rr)
+			}
+			for _, s := range extra {
+				rr, _ := dns.NewRR(s)
+				a.Extra = append(a.Extra, rr)
+			}
+			a.SetEdns0(4096, true)
+			return a, nil
+		},
+	}
+	r, err := NewResponseMinimize("test-minimize", upstream, ResponseMinimizeOptions{KeepExtraTypes: []string{"mx"}})
+	require.NoError(t, err)
+
+	// Answer satisfies the query, NS and Extra are stripped except for OPT
+	answer = []string{"example.com. IN A 1.2.3.4"}
+	ns = []string{"example.com. IN NS ns1.example.com."}
+	extra = []string{"ns1.example.com. IN A 1.1.1.1"}
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 1)
+	require.Empty(t, a.Ns)
+	require.Len(t, a.Extra, 1)
+	require.NotNil(t, a.IsEdns0())
+
+	// Extra records are kept for MX queries
+	answer = []string{"example.com. IN MX 10 mail.example.com."}
+	extra = []string{"mail.example.com. IN A 1.1.1.1"}
+	q.SetQuestion("example.com.", dns.TypeMX)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Empty(t, a.Ns)
+	require.Len(t, a.Extra, 2)
+
+	// Referrals are not modified
+	answer = nil
+	extra = []string{"ns1.example.com. IN A 1.1.1.1"}
+	q.SetQuestion("www.example.com.", dns.TypeA)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Ns, 1)
+	require.Len(t, a.Extra, 2)
+
+	// DNSSEC records are kept if the DO bit is set
+	answer = []string{"example.com. IN A 1.2.3.4"}
+	ns = []string{
+		"example.com. IN NS ns1.example.com.",
+		"example.com. IN NSEC www.example.com. A NS RRSIG NSEC",
+	}
+	q.SetQuestion("example.com.", dns.TypeA)
+	q.SetEdns0(4096, true)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Len(t, a.Ns, 1)
+	require.Equal(t, dns.TypeNSEC, a.Ns[0].Header().Rrtype)
+
+	_, err = NewResponseMinimize("test-minimize", upstream, ResponseMinimizeOptions{KeepExtraTypes: []string{"invalid"}})
+	require.Error(t, err)
+}
@@ -0,0 +1,158 @@
+package rdns
+
+import (
+	"context"
+	"expvar"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Retry is a resolver that sends a query to the same upstream resolver again if
+// it fails or returns one of a set of response codes (SERVFAIL by default). This
+// is useful for flaky upstreams that typically succeed on the second try. Unlike
+// the failover groups, only a single resolver is used.
+type Retry struct {
+	id       string
+	resolver Resolver
+	opt      RetryOptions
+	metrics  *RetryMetrics
+}
+
+var _ Resolver = &Retry{}
+
+// RetryOptions contain settings for the Retry resolver.
+type RetryOptions struct {
+	// Maximum number of attempts, including the first query. Default 3.
+	Attempts int
+
+	// Time to wait before the first retry. Default 100ms.
+	Delay time.Duration
+
+	// Factor by which the delay is multiplied after every retry. Values below
+	// 1 are treated as 1, meaning a constant delay.
+	Backoff float64
+
+	// Response codes that trigger a retry. Default is SERVFAIL only.
+	RetryRcodes []int
+
+	// Overall time limit for a query including all retries. No more attempts
+	// are made once the limit would be exceeded, and the limit is passed on to
+	// upstream resolvers that support cancellation. A shorter deadline of the
+	// query itself, like the query timeout of the listener, applies as well.
+	// Default 0, no limit.
+	Timeout time.Duration
+}
+
+type RetryMetrics struct {
+	// Count of retries.
+	retry *expvar.Int
+	// Count of queries that still failed after the last attempt.
+	exhausted *expvar.Int
+}
+
+// NewRetry returns a new instance of a retry resolver.
+func NewRetry(id string, resolver Resolver, opt RetryOptions) *Retry {
+	if opt.Attempts < 1 {
+		opt.Attempts = 3
+	}
+	if opt.Delay == 0 {
+		opt.Delay = 100 * time.Millisecond
+	}
+	if opt.Backoff < 1 {
+		opt.Backoff = 1
+	}
+	if len(opt.RetryRcodes) == 0 {
+		opt.RetryRcodes = []int{dns.RcodeServerFailure}
+	}
+	return &Retry{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		metrics: &RetryMetrics{
+			retry:     getVarInt("router", id, "retry"),
+			exhausted: getVarInt("router", id, "exhausted"),
+		},
+	}
+}
+
+// Resolve a DNS query, retrying with the same upstream resolver on failure.
+func (r *Retry) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	log := logger(r.id, q, ci)
+	ctx := ci.queryContext()
+	if r.opt.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.opt.Timeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	delay := r.opt.Delay
+
+	var (
+		a   *dns.Msg
+		err error
+	)
+	for attempt := 1; ; attempt++ {
+		// Upstream resolvers may modify the query (ID, padding, etc), so send
+		// a copy every time
+		a, err = resolveContext(ctx, r.resolver, q.Copy(), ci)
+		if !r.shouldRetry(a, err) {
+			break
+		}
+		if attempt >= r.opt.Attempts {
+			r.metrics.exhausted.Add(1)
+			break
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			log.Debug("retry deadline reached")
+			r.metrics.exhausted.Add(1)
+			break
+		}
+		log.WithField("attempt", attempt).WithError(err).Debug("retrying query")
+		r.metrics.retry.Add(1)
+		if !sleepContext(ctx, delay) {
+			log.Debug("query cancelled while waiting to retry")
+			r.metrics.exhausted.Add(1)
+			break
+		}
+		delay = time.Duration(float64(delay) * r.opt.Backoff)
+	}
+	if a != nil {
+		a.Id = q.Id
+	}
+	return a, err
+}
+
+func (r *Retry) String() string {
+	return r.id
+}
+
+// Waits for the given duration. Returns false if the context is done first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Returns true if the result of a query should cause a retry.
+func (r *Retry) shouldRetry(a *dns.Msg, err error) bool {
+	if err != nil {
+		return true
+	}
+	if a == nil { // Dropped queries are passed through
+		return false
+	}
+	for _, rcode := range r.opt.RetryRcodes {
+		if a.Rcode == rcode {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package rdns
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetry(t *testing.T) {
+	var ci ClientInfo
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeA)
+
+	// Resolver that returns SERVFAIL for the first two queries and modifies the ID
+	var count int
+	r := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			count++
+			q.Id = 0
+			a := new(dns.Msg)
+			a.SetReply(q)
+			if count < 3 {
+				a.Rcode = dns.RcodeServerFailure
+			}
+			return a, nil
+		},
+	}
+	g := NewRetry("test-retry", r, RetryOptions{Delay: time.Millisecond, Backoff: 2})
+	a, err := g.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	require.Equal(t, q.Id, a.Id)
+	require.Equal(t, 3, r.HitCount())
+
+	// Not enough attempts, the last failure should be returned
+	count = 0
+	g = NewRetry("test-retry", r, RetryOptions{Attempts: 2, Delay: time.Millisecond})
+	a, err = g.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeServerFailure, a.Rcode)
+	require.Equal(t, 5, r.HitCount())
+}
+
+func TestRetryError(t *testing.T) {
+	var ci ClientInfo
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeA)
+
+	r := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			return nil, errors.New("failed")
+		},
+	}
+
+	// Errors are retried until the attempts are exhausted
+	g := NewRetry("test-retry", r, RetryOptions{Attempts: 4, Delay: time.Millisecond})
+	_, err := g.Resolve(q, ci)
+	require.Error(t, err)
+	require.Equal(t, 4, r.HitCount())
+
+	// Stop early if the overall timeout would be exceeded
+	g = NewRetry("test-retry", r, RetryOptions{Attempts: 4, Delay: 20 * time.Millisecond, Timeout: 30 * time.Millisecond})
+	_, err = g.Resolve(q, ci)
+	require.Error(t, err)
+	require.Equal(t, 6, r.HitCount())
+
+	// Stop waiting for the next attempt once the query is cancelled
+	g = NewRetry("test-retry", r, RetryOptions{Attempts: 4, Delay: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = resolveContext(ctx, g, q, ci)
+	require.Error(t, err)
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+	require.Equal(t, 7, r.HitCount())
+
+	// No attempt is made if it's already cancelled
+	_, err = resolveContext(ctx, g, q, ci)
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, 7, r.HitCount())
+}
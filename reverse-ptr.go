@@ -0,0 +1,102 @@
+package rdns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ReversePTR is a resolver that answers PTR queries for in-addr.arpa and ip6.arpa
+// names from a table of forward mappings, like "server1.local" to "10.0.0.5".
+// This avoids maintaining a reverse zone in addition to the forward records.
+// All other queries, and PTR queries for addresses that aren't in the table,
+// are forwarded to the upstream resolver.
+type ReversePTR struct {
+	id       string
+	resolver Resolver
+	ttl      uint32
+	names    map[string][]string // Names by lowercase reverse name
+}
+
+var _ Resolver = &ReversePTR{}
+
+// ReversePTROptions contain settings for the ReversePTR resolver.
+type ReversePTROptions struct {
+	// IPv4 and IPv6 addresses by name, like {"server1.local": ["10.0.0.5"]}.
+	// Names don't require a trailing dot. An address can belong to more than
+	// one name, in which case all of them are returned.
+	Mappings map[string][]string
+
+	// TTL of the PTR records in the response. Default 3600.
+	TTL uint32
+}
+
+// NewReversePTR returns a new instance of a ReversePTR resolver.
+func NewReversePTR(id string, resolver Resolver, opt ReversePTROptions) (*ReversePTR, error) {
+	if opt.TTL == 0 {
+		opt.TTL = 3600
+	}
+	r := &ReversePTR{
+		id:       id,
+		resolver: resolver,
+		ttl:      opt.TTL,
+		names:    make(map[string][]string),
+	}
+	for name, ips := range opt.Mappings {
+		name = dns.Fqdn(name)
+		for _, s := range ips {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address '%s' for '%s'", s, name)
+			}
+			reverse, err := dns.ReverseAddr(ip.String())
+			if err != nil {
+				return nil, err
+			}
+			r.names[reverse] = append(r.names[reverse], name)
+		}
+	}
+	for _, names := range r.names {
+		sort.Strings(names)
+	}
+	return r, nil
+}
+
+// Resolve a DNS query by answering PTR queries from the mappings, forwarding
+// everything else upstream.
+func (r *ReversePTR) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return r.resolver.Resolve(q, ci)
+	}
+	log := logger(r.id, q, ci)
+	question := q.Question[0]
+	if question.Qtype == dns.TypePTR && question.Qclass == dns.ClassINET {
+		if names, ok := r.names[strings.ToLower(question.Name)]; ok {
+			log.Debug("answering from reverse mappings")
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.Authoritative = true
+			for _, name := range names {
+				a.Answer = append(a.Answer, &dns.PTR{
+					Hdr: dns.RR_Header{
+						Name:   question.Name,
+						Rrtype: dns.TypePTR,
+						Class:  dns.ClassINET,
+						Ttl:    r.ttl,
+					},
+					Ptr: name,
+				})
+			}
+			return a, nil
+		}
+	}
+	log.WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+	return r.resolver.Resolve(q, ci)
+}
+
+func (r *ReversePTR) String() string {
+	return r.id
+}
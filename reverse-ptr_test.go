@@ -0,0 +1,53 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReversePTR(t *testing.T) {
+	var ci ClientInfo
+	upstream := new(TestResolver)
+	r, err := NewReversePTR("test-ptr", upstream, ReversePTROptions{
+		Mappings: map[string][]string{
+			"server1.local":  {"10.0.0.5", "2001:db8::5"},
+			"server1-alias.": {"10.0.0.5"},
+		},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		ptr   []string
+	}{
+		{"5.0.0.10.in-addr.arpa.", dns.TypePTR, []string{"server1-alias.", "server1.local."}},
+		{"5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.B.D.0.1.0.0.2.IP6.ARPA.", dns.TypePTR, []string{"server1.local."}},
+		{"6.0.0.10.in-addr.arpa.", dns.TypePTR, nil},
+		{"5.0.0.10.in-addr.arpa.", dns.TypeA, nil},
+	}
+	var forwarded int
+	for _, test := range tests {
+		q := new(dns.Msg)
+		q.SetQuestion(test.name, test.qtype)
+		a, err := r.Resolve(q, ci)
+		require.NoError(t, err)
+		if test.ptr == nil {
+			forwarded++
+			require.Equal(t, forwarded, upstream.HitCount(), test.name)
+			continue
+		}
+		var ptr []string
+		for _, rr := range a.Answer {
+			require.Equal(t, test.name, rr.Header().Name)
+			ptr = append(ptr, rr.(*dns.PTR).Ptr)
+		}
+		require.Equal(t, test.ptr, ptr, test.name)
+	}
+	require.Equal(t, 2, upstream.HitCount())
+
+	_, err = NewReversePTR("test-ptr", upstream, ReversePTROptions{Mappings: map[string][]string{"bad": {"10.0.0"}}})
+	require.Error(t, err)
+}
@@ -15,6 +15,7 @@ type route struct {
 	class    uint16
 	name     *regexp.Regexp
 	source   *net.IPNet
+	identity *regexp.Regexp
 	inverted bool // invert the matching behavior
 	resolver Resolver
 }
@@ -66,6 +67,9 @@ func (r *route) match(q *dns.Msg, ci ClientInfo) bool {
 	if r.source != nil && !r.source.Contains(ci.SourceIP) {
 		return r.inverted
 	}
+	if r.identity != nil && (ci.TLSIdentity == "" || !r.identity.MatchString(ci.TLSIdentity)) {
+		return r.inverted
+	}
 	return !r.inverted
 }
 
@@ -73,6 +77,22 @@ func (r *route) Invert(value bool) {
 	r.inverted = value
 }
 
+// ClientIdentity limits the route to clients with a TLS certificate identity
+// that matches the regular expression. Only clients that authenticated with
+// mutual TLS have an identity.
+func (r *route) ClientIdentity(pattern string) error {
+	if pattern == "" {
+		r.identity = nil
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	r.identity = re
+	return nil
+}
+
 func (r *route) String() string {
 	if r.isDefault() {
 		return fmt.Sprintf("default->%s", r.resolver)
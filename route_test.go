@@ -91,3 +91,21 @@ func TestRoute(t *testing.T) {
 		require.Equal(t, test.match, match)
 	}
 }
+
+func TestRouteClientIdentity(t *testing.T) {
+	r, err := NewRoute("", "", nil, "", &TestResolver{})
+	require.NoError(t, err)
+	require.NoError(t, r.ClientIdentity(`^client-[0-9]+\.example\.com$`))
+
+	q := new(dns.Msg)
+	q.SetQuestion("google.com.", dns.TypeA)
+	require.True(t, r.match(q, ClientInfo{TLSIdentity: "client-1.example.com"}))
+	require.False(t, r.match(q, ClientInfo{TLSIdentity: "other.example.com"}))
+	require.False(t, r.match(q, ClientInfo{}))
+
+	// Clients without identity match the inverted route
+	r.Invert(true)
+	require.True(t, r.match(q, ClientInfo{}))
+
+	require.Error(t, r.ClientIdentity("("))
+}
@@ -0,0 +1,188 @@
+package rdns
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ResponseRateLimiter is a resolver that implements Response Rate Limiting (RRL),
+// similar to BIND, to protect against reflection and amplification attacks. The
+// rate of identical responses, by query name, type and response code, to a client
+// network is tracked over a sliding window. Responses above the limit are dropped,
+// or "slipped", that is replaced with an empty truncated response which makes
+// legitimate clients retry over TCP. It should only be used for UDP listeners.
+type ResponseRateLimiter struct {
+	id       string
+	resolver Resolver
+	opt      ResponseRateLimiterOptions
+
+	mu       sync.Mutex
+	windowID int64
+	previous map[string]*rrlEntry
+	current  map[string]*rrlEntry
+	metrics  *ResponseRateLimiterMetrics
+}
+
+var _ Resolver = &ResponseRateLimiter{}
+
+// ResponseRateLimiterOptions contain settings for the response rate limiter.
+type ResponseRateLimiterOptions struct {
+	// Number of identical responses per second allowed for a client network,
+	// averaged over the window.
+	ResponsesPerSecond uint
+
+	// Time period over which the response rate is measured. Defaults to 15
+	// seconds.
+	Window time.Duration
+
+	// Every Nth response over the limit is slipped instead of dropped, like 2
+	// for every other response. 1 slips all of them, 0 (default) drops all.
+	SlipRatio int
+
+	// Prefix lengths to identify client networks. Default to 24 and 56.
+	IPv4PrefixLen uint8
+	IPv6PrefixLen uint8
+}
+
+// ResponseRateLimiterMetrics contain the counters of a response rate limiter.
+type ResponseRateLimiterMetrics struct {
+	// Count of responses that were dropped.
+	drop *expvar.Int
+	// Count of responses replaced with a truncated response.
+	slip *expvar.Int
+}
+
+type rrlEntry struct {
+	responses uint
+	limited   int
+}
+
+// NewResponseRateLimiter returns a new instance of a response rate limiter.
+func NewResponseRateLimiter(id string, resolver Resolver, opt ResponseRateLimiterOptions) (*ResponseRateLimiter, error) {
+	if opt.ResponsesPerSecond == 0 {
+		return nil, errors.New("responses per second must be greater than 0")
+	}
+	if opt.Window <= 0 {
+		opt.Window = 15 * time.Second
+	}
+	if opt.SlipRatio < 0 {
+		return nil, fmt.Errorf("invalid slip ratio %d", opt.SlipRatio)
+	}
+	if opt.IPv4PrefixLen == 0 {
+		opt.IPv4PrefixLen = 24
+	}
+	if opt.IPv4PrefixLen > 32 {
+		return nil, fmt.Errorf("invalid ipv4 prefix length %d", opt.IPv4PrefixLen)
+	}
+	if opt.IPv6PrefixLen == 0 {
+		opt.IPv6PrefixLen = 56
+	}
+	if opt.IPv6PrefixLen > 128 {
+		return nil, fmt.Errorf("invalid ipv6 prefix length %d", opt.IPv6PrefixLen)
+	}
+	return &ResponseRateLimiter{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		previous: make(map[string]*rrlEntry),
+		current:  make(map[string]*rrlEntry),
+		metrics: &ResponseRateLimiterMetrics{
+			drop: getVarInt("router", id, "drop"),
+			slip: getVarInt("router", id, "slip"),
+		},
+	}, nil
+}
+
+// Resolve a DNS query with the upstream resolver and limit the rate of identical
+// responses to a client network.
+func (r *ResponseRateLimiter) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	a, err := r.resolver.Resolve(q, ci)
+	if err != nil || a == nil || len(q.Question) < 1 {
+		return a, err
+	}
+	log := logger(r.id, q, ci)
+
+	switch r.account(r.key(q, a, ci), time.Now()) {
+	case "drop":
+		r.metrics.drop.Add(1)
+		log.Debug("response rate limit exceeded, dropping")
+		return nil, nil
+	case "slip":
+		r.metrics.slip.Add(1)
+		log.Debug("response rate limit exceeded, responding with truncated response")
+		tc := new(dns.Msg)
+		tc.SetReply(q)
+		tc.Truncated = true
+		return tc, nil
+	}
+	return a, nil
+}
+
+func (r *ResponseRateLimiter) String() string {
+	return r.id
+}
+
+// Returns the key identifying the client network and the response.
+func (r *ResponseRateLimiter) key(q, a *dns.Msg, ci ClientInfo) string {
+	source := ci.SourceIP
+	if ip4 := source.To4(); len(ip4) == net.IPv4len {
+		source = ip4.Mask(net.CIDRMask(int(r.opt.IPv4PrefixLen), 32))
+	} else {
+		source = source.Mask(net.CIDRMask(int(r.opt.IPv6PrefixLen), 128))
+	}
+	question := q.Question[0]
+	return source.String() + " " + dns.CanonicalName(question.Name) + " " + strconv.Itoa(int(question.Qtype)) + " " + strconv.Itoa(a.Rcode)
+}
+
+// Records a response and returns "drop" or "slip" if the response is over the
+// limit, or an empty string if it can be sent. The rate is calculated with a
+// sliding window counter, weighing the count of the previous fixed window by
+// how much of it overlaps with the sliding window.
+func (r *ResponseRateLimiter) account(key string, now time.Time) string {
+	window := r.opt.Window.Nanoseconds()
+	windowID := now.UnixNano() / window
+	elapsed := float64(now.UnixNano()%window) / float64(window)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Move on to the next window if needed
+	switch windowID - r.windowID {
+	case 0:
+	case 1:
+		r.previous = r.current
+		r.current = make(map[string]*rrlEntry)
+	default:
+		r.previous = make(map[string]*rrlEntry)
+		r.current = make(map[string]*rrlEntry)
+	}
+	r.windowID = windowID
+
+	e, ok := r.current[key]
+	if !ok {
+		e = new(rrlEntry)
+		r.current[key] = e
+	}
+	count := float64(e.responses)
+	if prev, ok := r.previous[key]; ok {
+		count += float64(prev.responses) * (1 - elapsed)
+	}
+	e.responses++
+
+	limit := float64(r.opt.ResponsesPerSecond) * r.opt.Window.Seconds()
+	if count < limit {
+		return ""
+	}
+	e.limited++
+	if r.opt.SlipRatio > 0 && e.limited%r.opt.SlipRatio == 0 {
+		return "slip"
+	}
+	return "drop"
+}
@@ -0,0 +1,105 @@
+package rdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseRateLimiter(t *testing.T) {
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			rr, err := dns.NewRR(q.Question[0].Name + " 60 IN A 192.0.2.1")
+			require.NoError(t, err)
+			a.Answer = append(a.Answer, rr)
+			return a, nil
+		},
+	}
+	opt := ResponseRateLimiterOptions{
+		ResponsesPerSecond: 1,
+		Window:             time.Hour,
+		SlipRatio:          2,
+	}
+	r, err := NewResponseRateLimiter("test-rrl", upstream, opt)
+	require.NoError(t, err)
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	ci := ClientInfo{SourceIP: net.ParseIP("192.0.2.10")}
+
+	// The limit is 3600 responses in the window, no need to wait for the
+	// sliding window
+	r.mu.Lock()
+	r.current[r.key(q, &dns.Msg{}, ci)] = &rrlEntry{responses: 3599}
+	r.windowID = time.Now().UnixNano() / opt.Window.Nanoseconds()
+	r.mu.Unlock()
+
+	// The last response within the limit
+	a, err := r.Resolve(q, ci)
+	require.NoError(t, err)
+	require.NotNil(t, a)
+	require.Len(t, a.Answer, 1)
+
+	// Over the limit, every other response is dropped or truncated
+	a, err = r.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Nil(t, a)
+	a, err = r.Resolve(q, ci)
+	require.NoError(t, err)
+	require.NotNil(t, a)
+	require.True(t, a.Truncated)
+	require.Empty(t, a.Answer)
+
+	// Clients in the same network share the limit
+	a, err = r.Resolve(q, ClientInfo{SourceIP: net.ParseIP("192.0.2.20")})
+	require.NoError(t, err)
+	require.Nil(t, a)
+
+	// Other clients and other responses are not limited
+	a, err = r.Resolve(q, ClientInfo{SourceIP: net.ParseIP("198.51.100.1")})
+	require.NoError(t, err)
+	require.NotNil(t, a)
+	require.False(t, a.Truncated)
+	q.SetQuestion("example.net.", dns.TypeA)
+	a, err = r.Resolve(q, ci)
+	require.NoError(t, err)
+	require.NotNil(t, a)
+	require.False(t, a.Truncated)
+
+	_, err = NewResponseRateLimiter("test-rrl", upstream, ResponseRateLimiterOptions{})
+	require.Error(t, err)
+	_, err = NewResponseRateLimiter("test-rrl", upstream, ResponseRateLimiterOptions{ResponsesPerSecond: 1, IPv4PrefixLen: 33})
+	require.Error(t, err)
+	_, err = NewResponseRateLimiter("test-rrl", upstream, ResponseRateLimiterOptions{ResponsesPerSecond: 1, IPv6PrefixLen: 129})
+	require.Error(t, err)
+}
+
+func TestResponseRateLimiterSlidingWindow(t *testing.T) {
+	r, err := NewResponseRateLimiter("test-rrl", new(TestResolver), ResponseRateLimiterOptions{
+		ResponsesPerSecond: 1,
+		Window:             10 * time.Second,
+	})
+	require.NoError(t, err)
+
+	// 10 responses in the first window are allowed
+	start := time.Unix(1000, 0)
+	for i := 0; i < 10; i++ {
+		require.Equal(t, "", r.account("key", start))
+	}
+	require.Equal(t, "drop", r.account("key", start))
+
+	// Halfway through the next window, half of the previous window counts
+	now := start.Add(15 * time.Second)
+	for i := 0; i < 5; i++ {
+		require.Equal(t, "", r.account("key", now))
+	}
+	require.Equal(t, "drop", r.account("key", now))
+
+	// After two windows, the counts are reset
+	require.Equal(t, "", r.account("key", start.Add(30*time.Second)))
+}
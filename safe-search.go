@@ -0,0 +1,189 @@
+package rdns
+
+import (
+	"expvar"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// SafeSearch is a resolver that enforces the safe search or restricted modes of
+// search engines and video sites. Queries for their hostnames are answered with
+// a CNAME to the restricted host, like forcesafesearch.google.com, followed by
+// the records of that host from the upstream resolver. Only A, AAAA, HTTPS and
+// CNAME queries are rewritten, queries for other types and for all other names
+// are forwarded unchanged.
+type SafeSearch struct {
+	id       string
+	resolver Resolver
+	opt      SafeSearchOptions
+	targets  map[string]string
+	rewrites *expvar.Int
+}
+
+var _ Resolver = &SafeSearch{}
+
+// Query types that are answered with the safe search host.
+var safeSearchTypes = map[uint16]struct{}{
+	dns.TypeA:     {},
+	dns.TypeAAAA:  {},
+	dns.TypeHTTPS: {},
+	dns.TypeCNAME: {},
+}
+
+// SafeSearchOptions contain settings for the SafeSearch resolver.
+type SafeSearchOptions struct {
+	// Additional hostnames and the host they're rewritten to, like
+	// {"www.google.ch": "forcesafesearch.google.com"}. These take precedence
+	// over the built-in ones, an empty target removes a built-in mapping.
+	Mappings map[string]string
+
+	// Don't use the built-in mappings, only the ones in Mappings.
+	NoDefaults bool
+
+	// TTL of the CNAME record. Default 300.
+	TTL uint32
+}
+
+// Top-level domains of Google search.
+var googleSearchDomains = []string{
+	"com", "ad", "ae", "com.af", "com.ag", "al", "am", "co.ao", "com.ar", "as", "at", "com.au", "az", "ba",
+	"com.bd", "be", "bf", "bg", "com.bh", "bi", "bj", "com.bn", "com.bo", "com.br", "bs", "bt", "co.bw",
+	"by", "com.bz", "ca", "cd", "cf", "cg", "ch", "ci", "co.ck", "cl", "cm", "cn", "com.co", "co.cr",
+	"com.cu", "cv", "com.cy", "cz", "de", "dj", "dk", "dm", "com.do", "dz", "com.ec", "ee", "com.eg",
+	"es", "com.et", "fi", "com.fj", "fm", "fr", "ga", "ge", "gg", "com.gh", "com.gi", "gl", "gm", "gr",
+	"com.gt", "gy", "com.hk", "hn", "hr", "ht", "hu", "co.id", "ie", "co.il", "im", "co.in", "iq", "is",
+	"it", "je", "com.jm", "jo", "co.jp", "co.ke", "com.kh", "ki", "kg", "co.kr", "com.kw", "kz", "la",
+	"com.lb", "li", "lk", "co.ls", "lt", "lu", "lv", "com.ly", "co.ma", "md", "me", "mg", "mk", "ml",
+	"com.mm", "mn", "com.mt", "mu", "mv", "mw", "com.mx", "com.my", "co.mz", "com.na", "com.ng",
+	"com.ni", "ne", "nl", "no", "com.np", "nr", "nu", "co.nz", "com.om", "com.pa", "com.pe", "com.pg",
+	"com.ph", "com.pk", "pl", "pn", "com.pr", "ps", "pt", "com.py", "com.qa", "ro", "ru", "rw",
+	"com.sa", "com.sb", "sc", "se", "com.sg", "sh", "si", "sk", "com.sl", "sn", "so", "sm", "sr", "st",
+	"com.sv", "td", "tg", "co.th", "com.tj", "tl", "tm", "tn", "to", "com.tr", "tt", "com.tw", "co.tz",
+	"com.ua", "co.ug", "co.uk", "com.uy", "co.uz", "com.vc", "co.ve", "co.vi", "com.vn", "vu", "ws",
+	"rs", "co.za", "co.zm", "co.zw", "cat",
+}
+
+// DefaultSafeSearchMappings returns the built-in mappings of hostnames to their
+// safe search or restricted hosts, as documented by the operators.
+func DefaultSafeSearchMappings() map[string]string {
+	m := map[string]string{
+		// Bing
+		"www.bing.com": "strict.bing.com",
+		"bing.com":     "strict.bing.com",
+
+		// DuckDuckGo
+		"duckduckgo.com":       "safe.duckduckgo.com",
+		"www.duckduckgo.com":   "safe.duckduckgo.com",
+		"start.duckduckgo.com": "safe.duckduckgo.com",
+
+		// YouTube, use restrictmoderate.youtube.com for the moderate mode
+		"www.youtube.com":          "restrict.youtube.com",
+		"m.youtube.com":            "restrict.youtube.com",
+		"youtubei.googleapis.com":  "restrict.youtube.com",
+		"youtube.googleapis.com":   "restrict.youtube.com",
+		"www.youtube-nocookie.com": "restrict.youtube.com",
+
+		// Yandex
+		"yandex.com":     "familysearch.yandex.ru",
+		"www.yandex.com": "familysearch.yandex.ru",
+		"yandex.ru":      "familysearch.yandex.ru",
+		"www.yandex.ru":  "familysearch.yandex.ru",
+
+		// Pixabay
+		"pixabay.com":     "safesearch.pixabay.com",
+		"www.pixabay.com": "safesearch.pixabay.com",
+	}
+	for _, tld := range googleSearchDomains {
+		m["google."+tld] = "forcesafesearch.google.com"
+		m["www.google."+tld] = "forcesafesearch.google.com"
+	}
+	return m
+}
+
+// NewSafeSearch returns a new instance of a safe search resolver.
+func NewSafeSearch(id string, resolver Resolver, opt SafeSearchOptions) (*SafeSearch, error) {
+	if opt.TTL == 0 {
+		opt.TTL = 300
+	}
+	targets := make(map[string]string)
+	if !opt.NoDefaults {
+		for name, target := range DefaultSafeSearchMappings() {
+			targets[dns.CanonicalName(name)] = dns.CanonicalName(target)
+		}
+	}
+	for name, target := range opt.Mappings {
+		name = dns.CanonicalName(name)
+		if _, ok := dns.IsDomainName(name); !ok {
+			return nil, fmt.Errorf("invalid safe search name '%s'", name)
+		}
+		if target == "" {
+			delete(targets, name)
+			continue
+		}
+		target = dns.CanonicalName(target)
+		if _, ok := dns.IsDomainName(target); !ok {
+			return nil, fmt.Errorf("invalid safe search target '%s'", target)
+		}
+		targets[name] = target
+	}
+	return &SafeSearch{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		targets:  targets,
+		rewrites: getVarInt("router", id, "rewrite"),
+	}, nil
+}
+
+// Resolve a DNS query, answering queries for search engines with their safe
+// search hosts.
+func (r *SafeSearch) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 || q.Question[0].Qclass != dns.ClassINET {
+		return r.resolver.Resolve(q, ci)
+	}
+	question := q.Question[0]
+	if _, ok := safeSearchTypes[question.Qtype]; !ok {
+		return r.resolver.Resolve(q, ci)
+	}
+	target, ok := r.targets[dns.CanonicalName(question.Name)]
+	if !ok {
+		return r.resolver.Resolve(q, ci)
+	}
+	log := logger(r.id, q, ci).WithField("target", target)
+	r.rewrites.Add(1)
+	cname := &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   question.Name,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    r.opt.TTL,
+		},
+		Target: target,
+	}
+
+	// No need to resolve the target if the CNAME is all that was asked for
+	if question.Qtype == dns.TypeCNAME {
+		log.Debug("responding with safe search cname")
+		a := new(dns.Msg)
+		a.SetReply(q)
+		a.Answer = []dns.RR{cname}
+		return a, nil
+	}
+
+	log.Debug("resolving safe search target")
+	tq := q.Copy()
+	tq.Question[0].Name = target
+	a, err := r.resolver.Resolve(tq, ci)
+	if err != nil || a == nil {
+		return a, err
+	}
+	a.Id = q.Id
+	a.Question = []dns.Question{question}
+	a.Answer = append([]dns.RR{cname}, a.Answer...)
+	return a, nil
+}
+
+func (r *SafeSearch) String() string {
+	return r.id
+}
@@ -0,0 +1,80 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSafeSearch(t *testing.T) {
+	var queried []string
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			queried = append(queried, q.Question[0].Name)
+			a := new(dns.Msg)
+			a.SetReply(q)
+			rr, err := dns.NewRR(q.Question[0].Name + " 60 IN A 192.0.2.1")
+			require.NoError(t, err)
+			a.Answer = []dns.RR{rr}
+			return a, nil
+		},
+	}
+	r, err := NewSafeSearch("test-safe-search", upstream, SafeSearchOptions{
+		Mappings: map[string]string{
+			"www.youtube.com": "restrictmoderate.youtube.com", // Override a built-in mapping
+			"www.bing.com":    "",                             // Remove a built-in mapping
+			"search.example":  "safe.search.example.",         // Add a new one
+		},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"www.google.com.", "forcesafesearch.google.com."},
+		{"WWW.Google.CO.UK.", "forcesafesearch.google.com."},
+		{"duckduckgo.com.", "safe.duckduckgo.com."},
+		{"www.youtube.com.", "restrictmoderate.youtube.com."},
+		{"search.example.", "safe.search.example."},
+		{"www.bing.com.", ""},
+		{"mail.google.com.", ""},
+	}
+	for _, test := range tests {
+		queried = nil
+		q := new(dns.Msg)
+		q.SetQuestion(test.name, dns.TypeA)
+		a, err := r.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+		require.Equal(t, q.Id, a.Id)
+		require.Equal(t, test.name, a.Question[0].Name)
+		if test.target == "" {
+			// Forwarded unchanged
+			require.Equal(t, []string{test.name}, queried)
+			require.Len(t, a.Answer, 1)
+			continue
+		}
+		require.Equal(t, []string{test.target}, queried)
+		require.Len(t, a.Answer, 2)
+		cname, ok := a.Answer[0].(*dns.CNAME)
+		require.True(t, ok)
+		require.Equal(t, test.name, cname.Hdr.Name)
+		require.Equal(t, test.target, cname.Target)
+		require.Equal(t, test.target, a.Answer[1].Header().Name)
+	}
+
+	// Only address, HTTPS and CNAME queries are rewritten
+	for _, qtype := range []uint16{dns.TypeAAAA, dns.TypeHTTPS, dns.TypeMX, dns.TypeTXT} {
+		queried = nil
+		q := new(dns.Msg)
+		q.SetQuestion("www.google.com.", qtype)
+		_, err := r.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+		if qtype == dns.TypeMX || qtype == dns.TypeTXT {
+			require.Equal(t, []string{"www.google.com."}, queried)
+			continue
+		}
+		require.Equal(t, []string{"forcesafesearch.google.com."}, queried)
+	}
+}
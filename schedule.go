@@ -0,0 +1,200 @@
+package rdns
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Schedule is a resolver that blocks queries for a set of names during configured
+// time windows, like social media sites at night. Outside the time windows, or
+// for other names, queries are forwarded to the upstream resolver.
+type Schedule struct {
+	id       string
+	resolver Resolver
+	rules    []scheduleRule
+	location *time.Location
+	blocked  *expvar.Map
+
+	// Returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+var _ Resolver = &Schedule{}
+
+// ScheduleOptions contain settings for the Schedule resolver.
+type ScheduleOptions struct {
+	// Rules are evaluated in order, the first one that is active and matches
+	// the query name is applied.
+	Rules []ScheduleRule
+
+	// Timezone of the time windows. Uses the local timezone of the system if nil.
+	Location *time.Location
+}
+
+// ScheduleRule defines names that are blocked during a time window.
+type ScheduleRule struct {
+	// Names to block, including all their subdomains.
+	Names []string
+
+	// Days on which the time window starts, like "mon" or "sat". Every day if empty.
+	Days []string
+
+	// Start and end of the time window as "15:04". If the end is before the
+	// start, the window ends on the following day. Times default to the
+	// beginning and end of the day.
+	StartTime string
+	EndTime   string
+
+	// How to respond to blocked queries. "nxdomain" (default), "refuse", or
+	// "drop" to not respond at all.
+	Action string
+}
+
+type scheduleRule struct {
+	names      map[string]struct{}
+	days       map[time.Weekday]bool // nil for every day
+	start, end time.Duration         // Offset from midnight
+	action     string
+}
+
+var scheduleDays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// NewSchedule returns a new instance of a Schedule resolver.
+func NewSchedule(id string, resolver Resolver, opt ScheduleOptions) (*Schedule, error) {
+	if opt.Location == nil {
+		opt.Location = time.Local
+	}
+	var rules []scheduleRule
+	for _, o := range opt.Rules {
+		rule := scheduleRule{
+			names:  make(map[string]struct{}),
+			end:    24 * time.Hour,
+			action: o.Action,
+		}
+		switch rule.action {
+		case "":
+			rule.action = "nxdomain"
+		case "nxdomain", "refuse", "drop":
+		default:
+			return nil, fmt.Errorf("unsupported schedule action '%s'", o.Action)
+		}
+		for _, name := range o.Names {
+			rule.names[strings.ToLower(dns.Fqdn(name))] = struct{}{}
+		}
+		if len(o.Days) > 0 {
+			rule.days = make(map[time.Weekday]bool)
+			for _, d := range o.Days {
+				day, ok := scheduleDays[strings.ToLower(d)]
+				if !ok {
+					return nil, fmt.Errorf("invalid day '%s' in schedule", d)
+				}
+				rule.days[day] = true
+			}
+		}
+		var err error
+		if o.StartTime != "" {
+			if rule.start, err = parseTimeOfDay(o.StartTime); err != nil {
+				return nil, err
+			}
+		}
+		if o.EndTime != "" {
+			if rule.end, err = parseTimeOfDay(o.EndTime); err != nil {
+				return nil, err
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return &Schedule{
+		id:       id,
+		resolver: resolver,
+		rules:    rules,
+		location: opt.Location,
+		blocked:  getVarMap("router", id, "blocked"),
+		now:      time.Now,
+	}, nil
+}
+
+// Resolve a DNS query, blocking it if it matches a rule that is currently active.
+func (r *Schedule) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return nil, errors.New("no question in query")
+	}
+	log := logger(r.id, q, ci)
+	name := strings.ToLower(q.Question[0].Name)
+	now := r.now().In(r.location)
+	for i, rule := range r.rules {
+		if !rule.matchName(name) || !rule.active(now) {
+			continue
+		}
+		log.WithField("rule", i).WithField("action", rule.action).Debug("blocking query during scheduled time")
+		r.blocked.Add(rule.action, 1)
+		switch rule.action {
+		case "refuse":
+			return refused(q), nil
+		case "drop":
+			return nil, nil
+		default:
+			return nxdomain(q), nil
+		}
+	}
+	log.WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+	return r.resolver.Resolve(q, ci)
+}
+
+func (r *Schedule) String() string {
+	return r.id
+}
+
+// Returns true if the name or one of its parents is in the rule.
+func (r scheduleRule) matchName(name string) bool {
+	for {
+		if _, ok := r.names[name]; ok {
+			return true
+		}
+		i := strings.Index(name, ".")
+		if i < 0 || i == len(name)-1 {
+			return false
+		}
+		name = name[i+1:]
+	}
+}
+
+// Returns true if the time is within the time window of the rule.
+func (r scheduleRule) active(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if r.start <= r.end {
+		return r.onDay(t.Weekday()) && offset >= r.start && offset < r.end
+	}
+	// The window wraps around midnight, it could have started today or yesterday
+	if offset >= r.start && r.onDay(t.Weekday()) {
+		return true
+	}
+	return offset < r.end && r.onDay((t.Weekday()+6)%7)
+}
+
+func (r scheduleRule) onDay(day time.Weekday) bool {
+	return r.days == nil || r.days[day]
+}
+
+// Parses a time of day like "15:04" and returns the offset from midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day '%s', expected format is hh:mm", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,76 @@
+package rdns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedule(t *testing.T) {
+	var ci ClientInfo
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			return a, nil
+		},
+	}
+	loc := time.FixedZone("test", 2*3600)
+	r, err := NewSchedule("test-schedule", upstream, ScheduleOptions{
+		Rules: []ScheduleRule{
+			{Names: []string{"social.example"}, StartTime: "22:00", EndTime: "06:00"},
+			{Names: []string{"games.example."}, Days: []string{"mon", "tue"}, StartTime: "08:00", EndTime: "15:00", Action: "refuse"},
+		},
+		Location: loc,
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		now   time.Time
+		rcode int
+	}{
+		// Window wrapping around midnight, every day
+		{"www.social.example.", time.Date(2021, 3, 1, 23, 0, 0, 0, loc), dns.RcodeNameError},
+		{"social.example.", time.Date(2021, 3, 2, 5, 59, 0, 0, loc), dns.RcodeNameError},
+		{"social.example.", time.Date(2021, 3, 2, 6, 0, 0, 0, loc), dns.RcodeSuccess},
+		{"social.example.", time.Date(2021, 3, 2, 21, 59, 0, 0, loc), dns.RcodeSuccess},
+		// Times are converted to the configured timezone
+		{"social.example.", time.Date(2021, 3, 1, 20, 30, 0, 0, time.UTC), dns.RcodeNameError},
+		{"other.example.", time.Date(2021, 3, 1, 23, 0, 0, 0, loc), dns.RcodeSuccess},
+		{"notsocial.example.", time.Date(2021, 3, 1, 23, 0, 0, 0, loc), dns.RcodeSuccess},
+		// Only on Monday and Tuesday (2021-03-01 is a Monday)
+		{"games.example.", time.Date(2021, 3, 1, 10, 0, 0, 0, loc), dns.RcodeRefused},
+		{"games.example.", time.Date(2021, 3, 3, 10, 0, 0, 0, loc), dns.RcodeSuccess},
+		{"games.example.", time.Date(2021, 3, 2, 15, 0, 0, 0, loc), dns.RcodeSuccess},
+	}
+	for _, test := range tests {
+		now := test.now
+		r.now = func() time.Time { return now }
+		q := new(dns.Msg)
+		q.SetQuestion(test.name, dns.TypeA)
+		a, err := r.Resolve(q, ci)
+		require.NoError(t, err)
+		require.Equal(t, test.rcode, a.Rcode, "%s at %s", test.name, test.now)
+	}
+	require.Equal(t, 6, upstream.HitCount())
+}
+
+func TestScheduleWrapDays(t *testing.T) {
+	rule := ScheduleRule{Names: []string{"example.com"}, Days: []string{"fri"}, StartTime: "22:00", EndTime: "06:00"}
+	r, err := NewSchedule("test-schedule", &TestResolver{}, ScheduleOptions{Rules: []ScheduleRule{rule}, Location: time.UTC})
+	require.NoError(t, err)
+
+	// 2021-03-05 is a Friday, the window continues into Saturday morning
+	require.True(t, r.rules[0].active(time.Date(2021, 3, 5, 22, 0, 0, 0, time.UTC)))
+	require.True(t, r.rules[0].active(time.Date(2021, 3, 6, 5, 0, 0, 0, time.UTC)))
+	require.False(t, r.rules[0].active(time.Date(2021, 3, 6, 22, 0, 0, 0, time.UTC)))
+	require.False(t, r.rules[0].active(time.Date(2021, 3, 5, 5, 0, 0, 0, time.UTC)))
+
+	_, err = NewSchedule("test-schedule", &TestResolver{}, ScheduleOptions{Rules: []ScheduleRule{{StartTime: "25:00"}}})
+	require.Error(t, err)
+	_, err = NewSchedule("test-schedule", &TestResolver{}, ScheduleOptions{Rules: []ScheduleRule{{Days: []string{"someday"}}}})
+	require.Error(t, err)
+}
@@ -0,0 +1,107 @@
+package rdns
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+
+	"github.com/miekg/dns"
+	"golang.org/x/sync/singleflight"
+)
+
+// SingleFlight is a resolver that combines identical queries that arrive while one
+// is already in flight, so that only one of them is sent upstream and all receive
+// a copy of its response. This protects upstreams from bursts of queries for the
+// same name, for example when a popular record expires from the cache.
+type SingleFlight struct {
+	id        string
+	resolver  Resolver
+	opt       SingleFlightOptions
+	group     singleflight.Group
+	coalesced *expvar.Int
+}
+
+var _ Resolver = &SingleFlight{}
+
+// SingleFlightOptions contain settings for the SingleFlight resolver.
+type SingleFlightOptions struct {
+	// Only combine queries with the same EDNS0 Client Subnet, for upstreams that
+	// give different answers depending on the subnet.
+	ECS bool
+}
+
+// NewSingleFlight returns a new instance of a SingleFlight resolver.
+func NewSingleFlight(id string, resolver Resolver, opt SingleFlightOptions) *SingleFlight {
+	return &SingleFlight{
+		id:        id,
+		resolver:  resolver,
+		opt:       opt,
+		coalesced: getVarInt("router", id, "coalesced"),
+	}
+}
+
+// Resolve a DNS query, or wait for the response of an identical query that is
+// already in flight.
+func (r *SingleFlight) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return nil, errors.New("no question in query")
+	}
+	log := logger(r.id, q, ci)
+
+	// The shared query is sent without the context of the query that started
+	// it, so it isn't cancelled for everyone when that one client goes away.
+	// Each query only waits as long as its own context allows.
+	ctx := ci.queryContext()
+	shared := ci.detached()
+	var leader bool
+	ch := r.group.DoChan(r.key(q), func() (interface{}, error) {
+		leader = true
+		log.WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+		return r.resolver.Resolve(q, shared)
+	})
+	var res singleflight.Result
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if !leader {
+		log.Debug("using response of identical query in flight")
+		r.coalesced.Add(1)
+	}
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	a, _ := res.Val.(*dns.Msg)
+	if a == nil {
+		return nil, nil
+	}
+	// The response is shared by all waiters, everyone gets their own copy
+	a = a.Copy()
+	a.Id = q.Id
+	return a, nil
+}
+
+func (r *SingleFlight) String() string {
+	return r.id
+}
+
+// Returns the key identifying identical queries. The DO and CD bits are part of it
+// since they change the response.
+func (r *SingleFlight) key(q *dns.Msg) string {
+	question := q.Question[0]
+	key := fmt.Sprintf("%s %d %d %t", question.Name, question.Qtype, question.Qclass, q.CheckingDisabled)
+	edns0 := q.IsEdns0()
+	if edns0 == nil {
+		return key
+	}
+	key += fmt.Sprintf(" %t", edns0.Do())
+	if r.opt.ECS {
+		for _, opt := range edns0.Option {
+			if subnet, ok := opt.(*dns.EDNS0_SUBNET); ok {
+				key += fmt.Sprintf(" %s/%d", subnet.Address, subnet.SourceNetmask)
+			}
+		}
+	}
+	return key
+}
@@ -0,0 +1,129 @@
+package rdns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSingleFlight(t *testing.T) {
+	block := make(chan struct{})
+	var (
+		mu    sync.Mutex
+		count int
+	)
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			mu.Lock()
+			count++
+			mu.Unlock()
+			<-block
+			a := new(dns.Msg)
+			a.SetReply(q)
+			return a, nil
+		},
+	}
+	r := NewSingleFlight("test-single-flight", upstream, SingleFlightOptions{})
+
+	// Send identical queries with different IDs while the first is in flight
+	const n = 10
+	answers := make([]*dns.Msg, n)
+	ids := make([]uint16, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		q := new(dns.Msg)
+		q.SetQuestion("example.com.", dns.TypeA)
+		ids[i] = q.Id
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			a, err := r.Resolve(q, ClientInfo{})
+			require.NoError(t, err)
+			answers[i] = a
+		}(i)
+	}
+	// Give the queries time to reach the resolver, all but the first should
+	// then be waiting for it
+	time.Sleep(100 * time.Millisecond)
+	close(block)
+	wg.Wait()
+
+	require.Equal(t, 1, count)
+	require.Equal(t, int64(n-1), r.coalesced.Value())
+	for i, a := range answers {
+		require.Equal(t, ids[i], a.Id)
+		for j := range answers[:i] {
+			require.False(t, a == answers[j], "answers need to be copies")
+		}
+	}
+}
+
+func TestSingleFlightLeaderCancelled(t *testing.T) {
+	block := make(chan struct{})
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			<-block
+			if err := ci.queryContext().Err(); err != nil {
+				return nil, err
+			}
+			a := new(dns.Msg)
+			a.SetReply(q)
+			return a, nil
+		},
+	}
+	r := NewSingleFlight("test-single-flight-cancel", upstream, SingleFlightOptions{})
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	// The first query starts the upstream query, then its client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := resolveContext(ctx, r, q, ClientInfo{})
+		leaderErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	waiter := make(chan *dns.Msg, 1)
+	go func() {
+		a, err := r.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+		waiter <- a
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	require.Equal(t, context.Canceled, <-leaderErr)
+
+	// The other query still gets the response
+	close(block)
+	a := <-waiter
+	require.NotNil(t, a)
+	require.Equal(t, 1, upstream.HitCount())
+}
+
+func TestSingleFlightKey(t *testing.T) {
+	r := NewSingleFlight("test-single-flight", new(TestResolver), SingleFlightOptions{ECS: true})
+	q1 := new(dns.Msg)
+	q1.SetQuestion("example.com.", dns.TypeA)
+	q2 := new(dns.Msg)
+	q2.SetQuestion("example.com.", dns.TypeAAAA)
+	require.NotEqual(t, r.key(q1), r.key(q2))
+
+	// Different DO bit
+	q2 = q1.Copy()
+	q2.SetEdns0(4096, true)
+	require.NotEqual(t, r.key(q1), r.key(q2))
+
+	// Different ECS subnets, only part of the key if enabled
+	q1.SetEdns0(4096, false)
+	q2 = q1.Copy()
+	ECSModifierAdd(net.ParseIP("192.0.2.0"), 24, 56)(q1, ClientInfo{})
+	ECSModifierAdd(net.ParseIP("198.51.100.0"), 24, 56)(q2, ClientInfo{})
+	require.NotEqual(t, r.key(q1), r.key(q2))
+	r.opt.ECS = false
+	require.Equal(t, r.key(q1), r.key(q2))
+}
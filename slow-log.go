@@ -0,0 +1,63 @@
+package rdns
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// SlowLog is a resolver that measures how long the upstream resolver takes to
+// respond and logs queries that take longer than a threshold. Queries are passed
+// through unmodified, this only surfaces tail latency without logging every query.
+type SlowLog struct {
+	id       string
+	resolver Resolver
+	opt      SlowLogOptions
+	slow     *expvar.Map
+}
+
+var _ Resolver = &SlowLog{}
+
+// SlowLogOptions contain settings for the SlowLog resolver.
+type SlowLogOptions struct {
+	// Queries that take longer than this are logged. Defaults to 1 second.
+	Threshold time.Duration
+}
+
+// NewSlowLog returns a new instance of a slow query logger.
+func NewSlowLog(id string, resolver Resolver, opt SlowLogOptions) *SlowLog {
+	if opt.Threshold <= 0 {
+		opt.Threshold = time.Second
+	}
+	return &SlowLog{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		slow:     getVarMap("router", id, "slow"),
+	}
+}
+
+// Resolve a DNS query with the upstream resolver and log it if it's slow.
+func (r *SlowLog) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	start := time.Now()
+	a, err := r.resolver.Resolve(q, ci)
+	latency := time.Since(start)
+	if latency > r.opt.Threshold && len(q.Question) > 0 {
+		r.slow.Add(r.resolver.String(), 1)
+		log := logger(r.id, q, ci).WithFields(logrus.Fields{
+			"resolver": r.resolver.String(),
+			"latency":  latency,
+		})
+		if err != nil {
+			log = log.WithError(err)
+		}
+		log.Warn("slow query")
+	}
+	return a, err
+}
+
+func (r *SlowLog) String() string {
+	return r.id
+}
@@ -0,0 +1,57 @@
+package rdns
+
+import (
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlowLog(t *testing.T) {
+	var (
+		delay time.Duration
+		fail  bool
+	)
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			time.Sleep(delay)
+			if fail {
+				return nil, errors.New("failed")
+			}
+			a := new(dns.Msg)
+			a.SetReply(q)
+			return a, nil
+		},
+	}
+	r := NewSlowLog("test-slow-log", upstream, SlowLogOptions{Threshold: 50 * time.Millisecond})
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	slow := func() int64 {
+		if v, ok := r.slow.Get(upstream.String()).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+
+	// Fast queries aren't counted
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.NotNil(t, a)
+	require.Equal(t, int64(0), slow())
+
+	// Slow queries are counted
+	delay = 100 * time.Millisecond
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.NotNil(t, a)
+	require.Equal(t, int64(1), slow())
+
+	// Slow failures are counted too, and the error passed on
+	fail = true
+	_, err = r.Resolve(q, ClientInfo{})
+	require.Error(t, err)
+	require.Equal(t, int64(2), slow())
+}
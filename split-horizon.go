@@ -0,0 +1,73 @@
+package rdns
+
+import (
+	"errors"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// SplitHorizon is a resolver that picks an upstream resolver based on the
+// network of the client. This allows serving different answers to internal
+// and external clients. Queries from clients that don't match any of the
+// horizons are sent to the default resolver.
+type SplitHorizon struct {
+	id       string
+	resolver Resolver
+	horizons []Horizon
+}
+
+var _ Resolver = &SplitHorizon{}
+
+// Horizon maps a set of client networks to a resolver.
+type Horizon struct {
+	Networks []*net.IPNet
+	Resolver Resolver
+}
+
+// NewSplitHorizon returns a new instance of a split-horizon resolver. Horizons
+// are evaluated in order, the first one with a matching network is used.
+func NewSplitHorizon(id string, resolver Resolver, horizons ...Horizon) *SplitHorizon {
+	return &SplitHorizon{
+		id:       id,
+		resolver: resolver,
+		horizons: horizons,
+	}
+}
+
+// Resolve a DNS query using the resolver of the horizon the client belongs to.
+func (r *SplitHorizon) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return nil, errors.New("no question in query")
+	}
+	log := logger(r.id, q, ci)
+	resolver := r.resolver
+	for _, h := range r.horizons {
+		if h.match(ci.SourceIP) {
+			resolver = h.Resolver
+			break
+		}
+	}
+	log.WithField("resolver", resolver).Debug("forwarding query to resolver")
+	a, err := resolver.Resolve(q, ci)
+	if a != nil {
+		a.Id = q.Id
+	}
+	return a, err
+}
+
+func (r *SplitHorizon) String() string {
+	return r.id
+}
+
+func (h Horizon) match(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range h.Networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
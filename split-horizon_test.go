@@ -0,0 +1,42 @@
+package rdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitHorizon(t *testing.T) {
+	internal := new(TestResolver)
+	external := new(TestResolver)
+
+	_, lan, err := net.ParseCIDR("192.168.0.0/16")
+	require.NoError(t, err)
+	_, ula, err := net.ParseCIDR("fd00::/8")
+	require.NoError(t, err)
+
+	g := NewSplitHorizon("test-horizon", external, Horizon{
+		Networks: []*net.IPNet{lan, ula},
+		Resolver: internal,
+	})
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeA)
+
+	// Clients in the internal networks should use the internal resolver
+	_, err = g.Resolve(q, ClientInfo{SourceIP: net.ParseIP("192.168.1.1")})
+	require.NoError(t, err)
+	_, err = g.Resolve(q, ClientInfo{SourceIP: net.ParseIP("fd00::1")})
+	require.NoError(t, err)
+	require.Equal(t, 2, internal.HitCount())
+	require.Equal(t, 0, external.HitCount())
+
+	// Everything else goes to the default
+	_, err = g.Resolve(q, ClientInfo{SourceIP: net.ParseIP("10.0.0.1")})
+	require.NoError(t, err)
+	_, err = g.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 2, internal.HitCount())
+	require.Equal(t, 2, external.HitCount())
+}
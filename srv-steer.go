@@ -0,0 +1,258 @@
+package rdns
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// SRVSteer is a resolver that probes the targets of SRV records in responses
+// and adjusts the records so clients prefer targets that are reachable and
+// respond fastest. Targets are probed on their SRV port with the same probes
+// as the FastestTCP resolver. Their addresses are taken from the additional
+// section of the response, or resolved with the upstream resolver. This
+// should be used in combination with a Cache to avoid the probe overhead on
+// every query.
+type SRVSteer struct {
+	id       string
+	resolver Resolver
+	opt      SRVSteerOptions
+	metrics  *srvSteerMetrics
+}
+
+var _ Resolver = &SRVSteer{}
+
+// SRVSteerOptions contain settings for the SRVSteer resolver.
+type SRVSteerOptions struct {
+	// Network used for the probes, "tcp" (default) or "udp".
+	Network string
+
+	// Maximum time to wait for the probes to complete. Default 2 seconds.
+	ProbeTimeout time.Duration
+
+	// How the records are adjusted. "priority" (default) sets the priority of
+	// unreachable targets to the lowest possible value and sorts the records
+	// by priority and latency. "weight" keeps the priorities and sets the
+	// weights by latency, with 0 for unreachable targets. "filter" removes
+	// the records of unreachable targets.
+	Mode string
+}
+
+type srvSteerMetrics struct {
+	// Number of targets that failed the probe.
+	unreachable *expvar.Int
+	// Number of responses where all probes failed.
+	probeFailure *expvar.Int
+}
+
+// Weight of the fastest target in "weight" mode, slower targets get less.
+const srvSteerMaxWeight = 100
+
+// NewSRVSteer returns a new instance of an SRVSteer resolver.
+func NewSRVSteer(id string, resolver Resolver, opt SRVSteerOptions) (*SRVSteer, error) {
+	switch opt.Mode {
+	case "":
+		opt.Mode = "priority"
+	case "priority", "weight", "filter":
+	default:
+		return nil, fmt.Errorf("unsupported srv-steer mode '%s'", opt.Mode)
+	}
+	switch opt.Network {
+	case "":
+		opt.Network = "tcp"
+	case "tcp", "udp":
+	default:
+		return nil, fmt.Errorf("unsupported srv-steer network '%s'", opt.Network)
+	}
+	if opt.ProbeTimeout == 0 {
+		opt.ProbeTimeout = 2 * time.Second
+	}
+	return &SRVSteer{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		metrics: &srvSteerMetrics{
+			unreachable:  getVarInt("router", id, "unreachable"),
+			probeFailure: getVarInt("router", id, "probe_failure"),
+		},
+	}, nil
+}
+
+// Resolve a DNS query with the upstream resolver, then probe the targets of SRV
+// records in the response and adjust the records by the results.
+func (r *SRVSteer) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	a, err := r.resolver.Resolve(q, ci)
+	if err != nil || a == nil || len(q.Question) < 1 || q.Question[0].Qtype != dns.TypeSRV {
+		return a, err
+	}
+	log := logger(r.id, q, ci)
+
+	var srvs []*dns.SRV
+	for _, rr := range a.Answer {
+		if srv, ok := rr.(*dns.SRV); ok && srv.Target != "." {
+			srvs = append(srvs, srv)
+		}
+	}
+	if len(srvs) == 0 {
+		return a, nil
+	}
+
+	latency := r.probe(a, srvs, ci)
+	if len(latency) == 0 {
+		r.metrics.probeFailure.Add(1)
+		log.Debug("all srv target probes failed, returning response unmodified")
+		return a, nil
+	}
+	r.metrics.unreachable.Add(int64(len(srvs) - len(latency)))
+
+	// Records are modified, make copies so the upstream response isn't changed
+	var (
+		other    = make([]dns.RR, 0, len(a.Answer))
+		steered  []*dns.SRV
+		fastest  time.Duration = -1
+		srvCount int
+	)
+	for _, rr := range a.Answer {
+		srv, ok := rr.(*dns.SRV)
+		if !ok || srv.Target == "." {
+			other = append(other, rr)
+			continue
+		}
+		srvCount++
+		d, reachable := latency[srvTargetKey(srv)]
+		if !reachable && r.opt.Mode == "filter" {
+			continue
+		}
+		if reachable && (fastest < 0 || d < fastest) {
+			fastest = d
+		}
+		steered = append(steered, dns.Copy(srv).(*dns.SRV))
+	}
+
+	switch r.opt.Mode {
+	case "priority":
+		for _, srv := range steered {
+			if _, ok := latency[srvTargetKey(srv)]; !ok {
+				srv.Priority = 65535
+			}
+		}
+		sort.SliceStable(steered, func(i, j int) bool {
+			if steered[i].Priority != steered[j].Priority {
+				return steered[i].Priority < steered[j].Priority
+			}
+			di, iok := latency[srvTargetKey(steered[i])]
+			dj, jok := latency[srvTargetKey(steered[j])]
+			return iok && (!jok || di < dj)
+		})
+	case "weight":
+		for _, srv := range steered {
+			d, ok := latency[srvTargetKey(srv)]
+			if !ok {
+				srv.Weight = 0
+				continue
+			}
+			srv.Weight = srvSteerWeight(fastest, d)
+		}
+	}
+	for _, srv := range steered {
+		other = append(other, srv)
+	}
+	a.Answer = other
+	log.WithField("reachable", len(latency)).WithField("targets", srvCount).Debug("steered srv response")
+	return a, nil
+}
+
+func (r *SRVSteer) String() string {
+	return r.id
+}
+
+// Probes the targets of the SRV records and returns the latency of those that
+// responded, keyed by target and port.
+func (r *SRVSteer) probe(a *dns.Msg, srvs []*dns.SRV, ci ClientInfo) map[string]time.Duration {
+	ctx, cancel := context.WithTimeout(context.Background(), r.opt.ProbeTimeout)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		latency = make(map[string]time.Duration)
+		probed  = make(map[string]bool)
+	)
+	for _, srv := range srvs {
+		key := srvTargetKey(srv)
+		if probed[key] {
+			continue
+		}
+		probed[key] = true
+		wg.Add(1)
+		go func(target string, port uint16) {
+			defer wg.Done()
+			// A target is reachable if one of its addresses responds
+			for _, ip := range r.targetAddrs(a, target, ci) {
+				start := time.Now()
+				if err := probeAddress(ctx, r.opt.Network, ip, strconv.Itoa(int(port))); err != nil {
+					continue
+				}
+				mu.Lock()
+				latency[srvTargetKey(&dns.SRV{Target: target, Port: port})] = time.Since(start)
+				mu.Unlock()
+				return
+			}
+		}(srv.Target, srv.Port)
+	}
+	wg.Wait()
+	return latency
+}
+
+// Returns the addresses of an SRV target from the additional section of the
+// response. If there are none, they're resolved with the upstream resolver.
+func (r *SRVSteer) targetAddrs(a *dns.Msg, target string, ci ClientInfo) []net.IP {
+	var ips []net.IP
+	for _, rr := range a.Extra {
+		if ip := rrIP(rr); ip != nil && strings.EqualFold(rr.Header().Name, target) {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) > 0 {
+		return ips
+	}
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		q := new(dns.Msg)
+		q.SetQuestion(target, qtype)
+		resp, err := r.resolver.Resolve(q, ci)
+		if err != nil || resp == nil {
+			continue
+		}
+		for _, rr := range resp.Answer {
+			if ip := rrIP(rr); ip != nil && rr.Header().Rrtype == qtype {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+// Returns the weight of a target relative to the fastest one, at least 1 so
+// reachable targets are still preferred over unreachable ones.
+func srvSteerWeight(fastest, latency time.Duration) uint16 {
+	if latency <= 0 {
+		return srvSteerMaxWeight
+	}
+	w := int64(srvSteerMaxWeight) * int64(fastest) / int64(latency)
+	if w < 1 {
+		w = 1
+	}
+	return uint16(w)
+}
+
+func srvTargetKey(srv *dns.SRV) string {
+	return strings.ToLower(srv.Target) + ":" + strconv.Itoa(int(srv.Port))
+}
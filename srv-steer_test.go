@@ -0,0 +1,104 @@
+package rdns
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSRVSteer(t *testing.T) {
+	var ci ClientInfo
+
+	// One target with a listening port, and one with a closed port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, up, _ := net.SplitHostPort(ln.Addr().String())
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, down, _ := net.SplitHostPort(closed.Addr().String())
+	closed.Close()
+
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			switch q.Question[0].Qtype {
+			case dns.TypeSRV:
+				for _, s := range []string{
+					fmt.Sprintf("_svc._tcp.example.com. 300 IN SRV 10 50 %s down.example.com.", down),
+					fmt.Sprintf("_svc._tcp.example.com. 300 IN SRV 10 50 %s up.example.com.", up),
+				} {
+					rr, _ := dns.NewRR(s)
+					a.Answer = append(a.Answer, rr)
+				}
+				// Only the address of one target is in the additional section
+				rr, _ := dns.NewRR("down.example.com. 300 IN A 127.0.0.1")
+				a.Extra = append(a.Extra, rr)
+			case dns.TypeA:
+				rr, _ := dns.NewRR(q.Question[0].Name + " 300 IN A 127.0.0.1")
+				a.Answer = append(a.Answer, rr)
+			}
+			return a, nil
+		},
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("_svc._tcp.example.com.", dns.TypeSRV)
+
+	// Unreachable targets get the lowest priority and are moved to the end
+	r, err := NewSRVSteer("test-srv", upstream, SRVSteerOptions{})
+	require.NoError(t, err)
+	a, err := r.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 2)
+	require.Equal(t, "up.example.com.", a.Answer[0].(*dns.SRV).Target)
+	require.Equal(t, uint16(10), a.Answer[0].(*dns.SRV).Priority)
+	require.Equal(t, "down.example.com.", a.Answer[1].(*dns.SRV).Target)
+	require.Equal(t, uint16(65535), a.Answer[1].(*dns.SRV).Priority)
+
+	// Weights are rewritten
+	r, err = NewSRVSteer("test-srv", upstream, SRVSteerOptions{Mode: "weight"})
+	require.NoError(t, err)
+	a, err = r.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 2)
+	for _, rr := range a.Answer {
+		srv := rr.(*dns.SRV)
+		require.Equal(t, uint16(10), srv.Priority)
+		if srv.Target == "up.example.com." {
+			require.Equal(t, uint16(srvSteerMaxWeight), srv.Weight)
+		} else {
+			require.Equal(t, uint16(0), srv.Weight)
+		}
+	}
+
+	// Unreachable targets are removed
+	r, err = NewSRVSteer("test-srv", upstream, SRVSteerOptions{Mode: "filter"})
+	require.NoError(t, err)
+	a, err = r.Resolve(q, ci)
+	require.NoError(t, err)
+	require.Len(t, a.Answer, 1)
+	require.Equal(t, up, strconv.Itoa(int(a.Answer[0].(*dns.SRV).Port)))
+
+	_, err = NewSRVSteer("test-srv", upstream, SRVSteerOptions{Mode: "invalid"})
+	require.Error(t, err)
+}
+
+func TestSRVSteerWeight(t *testing.T) {
+	require.Equal(t, uint16(100), srvSteerWeight(10, 10))
+	require.Equal(t, uint16(50), srvSteerWeight(10, 20))
+	require.Equal(t, uint16(1), srvSteerWeight(1, 1000))
+}
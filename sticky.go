@@ -0,0 +1,199 @@
+package rdns
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// StickyGroup is a resolver group that sends all queries from a client to the same
+// resolver, for upstreams that give different answers depending on which instance
+// is queried, like GSLB services. Clients are identified by their network, and
+// hashed to pick a resolver. If a resolver fails, it's taken out of the group for
+// the client network for a period of time and the query is retried with the next
+// resolver for the client. Other clients keep using it.
+type StickyGroup struct {
+	id        string
+	resolvers []Resolver
+	opt       StickyGroupOptions
+	metrics   *FailRouterMetrics
+
+	mu        sync.Mutex
+	downUntil map[stickyDownKey]time.Time // Time failed resolvers become active again for a client network
+	lastSweep time.Time
+}
+
+type stickyDownKey struct {
+	resolver int
+	client   string
+}
+
+var _ Resolver = &StickyGroup{}
+
+// StickyGroupOptions contain settings for the sticky resolver group.
+type StickyGroupOptions struct {
+	// Prefix bits to identify IPv4 clients. Default 24.
+	Prefix4 uint8
+
+	// Prefix bits to identify IPv6 clients. Default 56.
+	Prefix6 uint8
+
+	// How clients are assigned when resolvers fail for them or become active
+	// again. "consistent" (default) moves clients to the resolver with the next
+	// highest score for them. "rebalance" picks from the resolvers active for
+	// the client by hash, which can move it to any other resolver.
+	Mode string
+
+	// Re-enable resolvers after this time after a failure. Default 1 minute.
+	ResetAfter time.Duration
+}
+
+// NewStickyGroup returns a new instance of a sticky resolver group.
+func NewStickyGroup(id string, opt StickyGroupOptions, resolvers ...Resolver) (*StickyGroup, error) {
+	switch opt.Mode {
+	case "":
+		opt.Mode = "consistent"
+	case "consistent", "rebalance":
+	default:
+		return nil, fmt.Errorf("unsupported sticky mode '%s'", opt.Mode)
+	}
+	if opt.Prefix4 == 0 {
+		opt.Prefix4 = 24
+	}
+	if opt.Prefix4 > 32 {
+		return nil, fmt.Errorf("invalid ipv4 prefix length %d", opt.Prefix4)
+	}
+	if opt.Prefix6 == 0 {
+		opt.Prefix6 = 56
+	}
+	if opt.Prefix6 > 128 {
+		return nil, fmt.Errorf("invalid ipv6 prefix length %d", opt.Prefix6)
+	}
+	if opt.ResetAfter == 0 {
+		opt.ResetAfter = time.Minute
+	}
+	return &StickyGroup{
+		id:        id,
+		resolvers: resolvers,
+		opt:       opt,
+		metrics:   NewFailRouterMetrics(id, len(resolvers)),
+		downUntil: make(map[stickyDownKey]time.Time),
+		lastSweep: time.Now(),
+	}, nil
+}
+
+// Resolve a DNS query using the resolver assigned to the client.
+func (r *StickyGroup) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	log := logger(r.id, q, ci)
+	key := r.clientKey(ci.SourceIP)
+	tried := make(map[int]bool)
+	for {
+		i, ok := r.pick(key, tried)
+		if !ok {
+			log.Warn("no active resolvers left")
+			return nil, errors.New("no active resolvers left")
+		}
+		tried[i] = true
+		resolver := r.resolvers[i]
+
+		r.metrics.route.Add(resolver.String(), 1)
+		log.WithField("resolver", resolver.String()).Debug("forwarding query to resolver")
+		a, err := resolver.Resolve(q, ci)
+		if err == nil {
+			return a, err
+		}
+		log.WithField("resolver", resolver.String()).WithError(err).Debug("resolver returned failure")
+		r.metrics.failure.Add(resolver.String(), 1)
+		r.metrics.failover.Add(1)
+		r.deactivate(i, key)
+	}
+}
+
+func (r *StickyGroup) String() string {
+	return r.id
+}
+
+// Returns the network of the client, used to assign it to a resolver.
+func (r *StickyGroup) clientKey(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); len(ip4) == net.IPv4len {
+		return ip4.Mask(net.CIDRMask(int(r.opt.Prefix4), 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(int(r.opt.Prefix6), 128)).String()
+}
+
+// Returns the index of the resolver for a client, ignoring resolvers that failed
+// for it recently or were already tried. Returns false if there are none left.
+func (r *StickyGroup) pick(key string, tried map[int]bool) (int, bool) {
+	now := time.Now()
+	r.mu.Lock()
+	var active []int
+	for i := range r.resolvers {
+		if now.After(r.downUntil[stickyDownKey{i, key}]) {
+			active = append(active, i)
+		}
+	}
+	r.mu.Unlock()
+	r.metrics.available.Set(int64(len(active)))
+
+	var order []int
+	switch r.opt.Mode {
+	case "consistent":
+		// Rendezvous hashing, the resolver with the highest score for the client wins
+		scores := make(map[int]uint64, len(active))
+		for _, i := range active {
+			scores[i] = stickyHash(key + "|" + r.resolvers[i].String())
+		}
+		sort.Slice(active, func(a, b int) bool { return scores[active[a]] > scores[active[b]] })
+		order = active
+	case "rebalance":
+		if len(active) > 0 {
+			start := int(stickyHash(key) % uint64(len(active)))
+			order = append(order, active[start:]...)
+			order = append(order, active[:start]...)
+		}
+	}
+	for _, i := range order {
+		if !tried[i] {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Take a resolver out of the group for a client network for a while.
+func (r *StickyGroup) deactivate(i int, key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.sweep(now)
+	r.downUntil[stickyDownKey{i, key}] = now.Add(r.opt.ResetAfter)
+}
+
+// Removes resolvers that are active again, at most once per reset period. Must
+// be called with the lock held.
+func (r *StickyGroup) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < r.opt.ResetAfter {
+		return
+	}
+	r.lastSweep = now
+	for key, t := range r.downUntil {
+		if now.After(t) {
+			delete(r.downUntil, key)
+		}
+	}
+}
+
+func stickyHash(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum64()
+}
@@ -0,0 +1,98 @@
+package rdns
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStickyGroup(t *testing.T) {
+	var resolvers []Resolver
+	var upstreams []*TestResolver
+	for i := 0; i < 4; i++ {
+		r := new(TestResolver)
+		upstreams = append(upstreams, r)
+		resolvers = append(resolvers, &testNamedResolver{TestResolver: r, name: fmt.Sprintf("upstream-%d", i)})
+	}
+	for _, mode := range []string{"consistent", "rebalance"} {
+		g, err := NewStickyGroup("test-sticky", StickyGroupOptions{Mode: mode}, resolvers...)
+		require.NoError(t, err)
+
+		// Map 1000 client networks to resolvers
+		assigned := make(map[string]int)
+		q := new(dns.Msg)
+		q.SetQuestion("example.com.", dns.TypeA)
+		for i := 0; i < 1000; i++ {
+			ci := ClientInfo{SourceIP: net.IPv4(10, byte(i>>8), byte(i), 1)}
+			idx, ok := g.pick(g.clientKey(ci.SourceIP), nil)
+			require.True(t, ok)
+			assigned[ci.SourceIP.String()] = idx
+
+			// Other clients in the same network use the same resolver
+			other := ClientInfo{SourceIP: net.IPv4(10, byte(i>>8), byte(i), 2)}
+			otherIdx, _ := g.pick(g.clientKey(other.SourceIP), nil)
+			require.Equal(t, idx, otherIdx)
+		}
+
+		// The distribution should be roughly even
+		counts := make([]int, len(resolvers))
+		for _, idx := range assigned {
+			counts[idx]++
+		}
+		for _, c := range counts {
+			require.InDelta(t, 250, c, 75, mode)
+		}
+
+		// A failed resolver is only taken out of the group for the client
+		// network it failed for
+		var keys []string
+		for ip, idx := range assigned {
+			if idx == 0 {
+				keys = append(keys, g.clientKey(net.ParseIP(ip)))
+			}
+		}
+		g.deactivate(0, keys[0])
+		newIdx, ok := g.pick(keys[0], nil)
+		require.True(t, ok)
+		require.NotEqual(t, 0, newIdx)
+		for _, key := range keys[1:] {
+			idx, _ := g.pick(key, nil)
+			require.Equal(t, 0, idx)
+		}
+	}
+
+	// Queries fail over to the next resolver
+	upstreams[0].shouldFail = true
+	upstreams[1].shouldFail = true
+	upstreams[2].shouldFail = true
+	upstreams[3].shouldFail = true
+	g, err := NewStickyGroup("test-sticky", StickyGroupOptions{}, resolvers...)
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	_, err = g.Resolve(q, ClientInfo{SourceIP: net.ParseIP("192.0.2.1")})
+	require.Error(t, err)
+	for _, u := range upstreams {
+		require.Equal(t, 1, u.HitCount())
+		u.shouldFail = false
+	}
+	_, err = NewStickyGroup("test-sticky", StickyGroupOptions{Mode: "random"}, resolvers...)
+	require.Error(t, err)
+	_, err = NewStickyGroup("test-sticky", StickyGroupOptions{Prefix4: 33}, resolvers...)
+	require.Error(t, err)
+	_, err = NewStickyGroup("test-sticky", StickyGroupOptions{Prefix6: 129}, resolvers...)
+	require.Error(t, err)
+}
+
+// Test resolver with a name, to distinguish them in a group.
+type testNamedResolver struct {
+	*TestResolver
+	name string
+}
+
+func (r *testNamedResolver) String() string {
+	return r.name
+}
@@ -1,8 +1,10 @@
 package rdns
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 )
@@ -39,6 +41,27 @@ func TLSServerConfig(caFile, crtFile, keyFile string, mutualTLS bool) (*tls.Conf
 	return tlsConfig, nil
 }
 
+// Returns the identity of a client from the certificate it presented, if it was
+// verified. That's the common name of the subject, or the first DNS name, email
+// address or URI in the SAN if there's no common name.
+func tlsClientIdentity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := state.VerifiedChains[0][0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
+
 // TLSClientConfig is a convenience function that builds a tls.Config instance for TLS clients
 // based on common options and certificate+key files.
 func TLSClientConfig(caFile, crtFile, keyFile string) (*tls.Config, error) {
@@ -69,3 +92,44 @@ func TLSClientConfig(caFile, crtFile, keyFile string) (*tls.Config, error) {
 	}
 	return tlsConfig, nil
 }
+
+// tlsConfigWithPinnedSPKI returns a copy of the TLS config that only accepts server
+// certificates with one of the given public keys. Pins are base64 encoded SHA-256
+// hashes of the SubjectPublicKeyInfo. The regular certificate verification still
+// applies. The config is returned unchanged if there are no pins.
+func tlsConfigWithPinnedSPKI(tlsConfig *tls.Config, pins []string) (*tls.Config, error) {
+	if len(pins) == 0 {
+		return tlsConfig, nil
+	}
+	hashes := make(map[[sha256.Size]byte]struct{})
+	for _, pin := range pins {
+		b, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid spki pin '%s', expected base64 encoded sha256 hash", pin)
+		}
+		var h [sha256.Size]byte
+		copy(h[:], b)
+		hashes[h] = struct{}{}
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
+	tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("no server certificate to verify pinned spki")
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		if _, ok := hashes[h]; !ok {
+			return fmt.Errorf("spki '%s' of server certificate for '%s' doesn't match any pinned keys",
+				base64.StdEncoding.EncodeToString(h[:]), cert.Subject.CommonName)
+		}
+		return nil
+	}
+	return tlsConfig, nil
+}
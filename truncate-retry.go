@@ -0,0 +1,70 @@
+package rdns
+
+import (
+	"expvar"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// TruncateRetry is a resolver that sends queries to a UDP resolver and repeats them
+// with a TCP (or DoT etc) resolver if the response is truncated. Clients receive the
+// full response rather than having to retry over TCP themselves.
+type TruncateRetry struct {
+	id       string
+	resolver Resolver
+	opt      TruncateRetryOptions
+	retry    *expvar.Int
+}
+
+var _ Resolver = &TruncateRetry{}
+
+// TruncateRetryOptions contain settings for the TruncateRetry resolver.
+type TruncateRetryOptions struct {
+	// Resolver to send queries to if the response is truncated. If nil, a TCP
+	// resolver is derived from the UDP resolver, which has to be a plain DNS client.
+	TCPResolver Resolver
+
+	// Return truncated responses to the client unchanged instead of retrying,
+	// for clients that handle truncation themselves.
+	KeepTC bool
+}
+
+// NewTruncateRetry returns a new instance of a truncate-retry resolver.
+func NewTruncateRetry(id string, resolver Resolver, opt TruncateRetryOptions) (*TruncateRetry, error) {
+	if opt.TCPResolver == nil {
+		d, ok := resolver.(*DNSClient)
+		if !ok || d.net != "udp" {
+			return nil, fmt.Errorf("no tcp resolver for '%s' and unable to derive one from '%s'", id, resolver)
+		}
+		var err error
+		opt.TCPResolver, err = NewDNSClient(d.id+"-tcp", d.endpoint, "tcp", d.opt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &TruncateRetry{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		retry:    getVarInt("router", id, "retry"),
+	}, nil
+}
+
+// Resolve a DNS query, repeating it with the TCP resolver if the response is truncated.
+func (r *TruncateRetry) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	log := logger(r.id, q, ci)
+	log.WithField("resolver", r.resolver).Debug("forwarding query to resolver")
+	// Upstream resolvers may modify the query, so keep the original for the retry
+	a, err := r.resolver.Resolve(q.Copy(), ci)
+	if err != nil || a == nil || !a.Truncated || r.opt.KeepTC {
+		return a, err
+	}
+	log.WithField("resolver", r.opt.TCPResolver).Debug("response truncated, retrying with tcp resolver")
+	r.retry.Add(1)
+	return r.opt.TCPResolver.Resolve(q, ci)
+}
+
+func (r *TruncateRetry) String() string {
+	return r.id
+}
@@ -0,0 +1,65 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateRetry(t *testing.T) {
+	truncated := true
+	udp := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			a.Truncated = truncated
+			return a, nil
+		},
+	}
+	tcp := new(TestResolver)
+
+	r, err := NewTruncateRetry("test-truncate-retry", udp, TruncateRetryOptions{TCPResolver: tcp})
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	// Truncated response, should be retried with the TCP resolver
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.False(t, a.Truncated)
+	require.Equal(t, 1, udp.HitCount())
+	require.Equal(t, 1, tcp.HitCount())
+
+	// Complete response is returned directly
+	truncated = false
+	_, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 2, udp.HitCount())
+	require.Equal(t, 1, tcp.HitCount())
+
+	// Truncated responses are passed through if configured
+	truncated = true
+	r, err = NewTruncateRetry("test-truncate-retry", udp, TruncateRetryOptions{TCPResolver: tcp, KeepTC: true})
+	require.NoError(t, err)
+	a, err = r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+	require.True(t, a.Truncated)
+	require.Equal(t, 1, tcp.HitCount())
+}
+
+func TestTruncateRetryDerived(t *testing.T) {
+	// A TCP resolver is derived from a plain UDP client
+	udp, err := NewDNSClient("test-udp", "127.0.0.1:53", "udp", DNSClientOptions{})
+	require.NoError(t, err)
+	r, err := NewTruncateRetry("test-truncate-retry", udp, TruncateRetryOptions{})
+	require.NoError(t, err)
+	tcp, ok := r.opt.TCPResolver.(*DNSClient)
+	require.True(t, ok)
+	require.Equal(t, "tcp", tcp.net)
+	require.Equal(t, "127.0.0.1:53", tcp.endpoint)
+
+	// Not possible with other resolvers
+	_, err = NewTruncateRetry("test-truncate-retry", new(TestResolver), TruncateRetryOptions{})
+	require.Error(t, err)
+}
@@ -5,7 +5,8 @@ import (
 )
 
 // TTLModifier passes queries to upstream resolvers and then modifies
-// the TTL in response RRs according to limits.
+// the TTL in response RRs according to limits. OPT records are skipped since
+// their TTL field holds flags. The minimum field of SOA records is not changed.
 type TTLModifier struct {
 	id string
 	TTLModifierOptions
@@ -40,29 +41,35 @@ func (r *TTLModifier) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
 		return a, err
 	}
 
+	if limitTTL(a, r.MinTTL, r.MaxTTL) {
+		logger(r.id, q, ci).Debug("modified response ttl")
+	}
+	return a, nil
+}
+
+func (r *TTLModifier) String() string {
+	return r.id
+}
+
+// Updates the TTL of all resource records (except OPT) to be within min and
+// max. A value of 0 disables the limit. Returns true if any TTL was changed.
+func limitTTL(answer *dns.Msg, min, max uint32) bool {
 	var modified bool
-	for _, rrs := range [][]dns.RR{a.Answer, a.Ns, a.Extra} {
+	for _, rrs := range [][]dns.RR{answer.Answer, answer.Ns, answer.Extra} {
 		for _, rr := range rrs {
 			if _, ok := rr.(*dns.OPT); ok {
 				continue
 			}
 			h := rr.Header()
-			if h.Ttl < r.MinTTL {
-				h.Ttl = r.MinTTL
+			if h.Ttl < min {
+				h.Ttl = min
 				modified = true
 			}
-			if r.MaxTTL > 0 && h.Ttl > r.MaxTTL {
-				h.Ttl = r.MaxTTL
+			if max > 0 && h.Ttl > max {
+				h.Ttl = max
 				modified = true
 			}
 		}
 	}
-	if modified {
-		logger(r.id, q, ci).Debug("modified response ttl")
-	}
-	return a, nil
-}
-
-func (r *TTLModifier) String() string {
-	return r.id
+	return modified
 }
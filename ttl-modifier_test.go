@@ -0,0 +1,45 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTLModifier(t *testing.T) {
+	upstream := &TestResolver{
+		ResolveFunc: func(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+			a := new(dns.Msg)
+			a.SetReply(q)
+			low, _ := dns.NewRR("example.com. 0 IN A 192.0.2.1")
+			high, _ := dns.NewRR("example.com. 604800 IN A 192.0.2.2")
+			soa, _ := dns.NewRR("example.com. 30 IN SOA ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 10")
+			a.Answer = []dns.RR{low, high}
+			a.Ns = []dns.RR{soa}
+			a.SetEdns0(4096, true)
+			return a, nil
+		},
+	}
+	r := NewTTLModifier("test-ttl", upstream, TTLModifierOptions{MinTTL: 60, MaxTTL: 86400})
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	a, err := r.Resolve(q, ClientInfo{})
+	require.NoError(t, err)
+
+	// TTLs should be brought into range
+	require.Equal(t, uint32(60), a.Answer[0].Header().Ttl)
+	require.Equal(t, uint32(86400), a.Answer[1].Header().Ttl)
+
+	// The SOA record TTL is updated, but not the minimum field used for negative caching
+	soa := a.Ns[0].(*dns.SOA)
+	require.Equal(t, uint32(60), soa.Hdr.Ttl)
+	require.Equal(t, uint32(10), soa.Minttl)
+
+	// OPT records use the TTL field for flags and must not be changed
+	opt := a.IsEdns0()
+	require.NotNil(t, opt)
+	require.True(t, opt.Do())
+	require.Equal(t, uint32(0x8000), opt.Hdr.Ttl)
+}
@@ -0,0 +1,213 @@
+package rdns
+
+import (
+	"expvar"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Labels shorter than this are not checked for entropy. Short labels can't have
+// a high entropy and the measure isn't meaningful for them.
+const tunnelMinEntropyLabelLen = 20
+
+// TunnelGuard is a resolver that detects likely DNS tunneling with a number of
+// heuristics. Tunneling tools encode data in query names, which makes them
+// unusually long and random, and often use TXT or NULL queries for the return
+// channel. Queries are flagged if the name is too long, if a label has a high
+// entropy, if a client sends too many TXT or NULL queries, or if a client sends
+// too many queries for names under the same registrable domain. Flagged queries
+// are refused, rate-limited, or only logged.
+type TunnelGuard struct {
+	id       string
+	resolver Resolver
+	opt      TunnelGuardOptions
+
+	mu        sync.Mutex
+	currWinID int64
+	txt       map[string]uint
+	domain    map[string]uint
+	flagged   map[string]uint
+	metrics   *TunnelGuardMetrics
+}
+
+var _ Resolver = &TunnelGuard{}
+
+// TunnelGuardOptions contain settings for the tunnel guard.
+type TunnelGuardOptions struct {
+	// Max length of a query name. Defaults to 100.
+	MaxNameLength int
+
+	// Max Shannon entropy, in bits per character, of a label. Defaults to 4.0.
+	// Only labels with at least 20 characters are checked.
+	EntropyThreshold float64
+
+	// Number of TXT and NULL queries a client can make per window. Disabled if 0.
+	TXTBudget uint
+
+	// Number of queries a client can make per window for names under the same
+	// registrable domain, like "example.com". Disabled if 0.
+	DomainBudget uint
+
+	// Time period for the budgets. Defaults to 1 minute.
+	Window time.Duration
+
+	// What to do with flagged queries. "refuse" (default) responds with REFUSED,
+	// "rate-limit" allows RateLimit flagged queries per client and window before
+	// refusing them, "log" only logs them.
+	Action string
+
+	// Flagged queries allowed per client and window with the "rate-limit" action.
+	// Defaults to 10.
+	RateLimit uint
+}
+
+// TunnelGuardMetrics contain the counters of a tunnel guard.
+type TunnelGuardMetrics struct {
+	// Count of flagged queries by reason.
+	flagged *expvar.Map
+	// Count of refused queries.
+	refused *expvar.Int
+}
+
+// NewTunnelGuard returns a new instance of a DNS tunneling detector.
+func NewTunnelGuard(id string, resolver Resolver, opt TunnelGuardOptions) (*TunnelGuard, error) {
+	switch opt.Action {
+	case "":
+		opt.Action = "refuse"
+	case "refuse", "rate-limit", "log":
+	default:
+		return nil, fmt.Errorf("unsupported action '%s'", opt.Action)
+	}
+	if opt.MaxNameLength <= 0 {
+		opt.MaxNameLength = 100
+	}
+	if opt.EntropyThreshold <= 0 {
+		opt.EntropyThreshold = 4.0
+	}
+	if opt.Window <= 0 {
+		opt.Window = time.Minute
+	}
+	if opt.RateLimit == 0 {
+		opt.RateLimit = 10
+	}
+	return &TunnelGuard{
+		id:       id,
+		resolver: resolver,
+		opt:      opt,
+		txt:      make(map[string]uint),
+		domain:   make(map[string]uint),
+		flagged:  make(map[string]uint),
+		metrics: &TunnelGuardMetrics{
+			flagged: getVarMap("router", id, "flagged"),
+			refused: getVarInt("router", id, "refused"),
+		},
+	}, nil
+}
+
+// Resolve a DNS query unless it looks like DNS tunneling.
+func (r *TunnelGuard) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(q.Question) < 1 {
+		return r.resolver.Resolve(q, ci)
+	}
+	reason, refuse := r.check(q.Question[0], ci.SourceIP.String(), time.Now())
+	if reason == "" {
+		return r.resolver.Resolve(q, ci)
+	}
+	r.metrics.flagged.Add(reason, 1)
+	log := logger(r.id, q, ci).WithField("reason", reason)
+	if r.opt.Action == "log" {
+		log.Warn("possible dns tunneling")
+		return r.resolver.Resolve(q, ci)
+	}
+	if refuse {
+		r.metrics.refused.Add(1)
+		log.Debug("possible dns tunneling, refusing")
+		return refused(q), nil
+	}
+	log.Debug("possible dns tunneling, within rate-limit")
+	return r.resolver.Resolve(q, ci)
+}
+
+func (r *TunnelGuard) String() string {
+	return r.id
+}
+
+// Applies the heuristics to a query and returns the reason if it was flagged,
+// and whether it should be refused.
+func (r *TunnelGuard) check(question dns.Question, client string, now time.Time) (string, bool) {
+	name := strings.TrimSuffix(dns.CanonicalName(question.Name), ".")
+	var reason string
+	if len(name) > r.opt.MaxNameLength {
+		reason = "length"
+	} else {
+		for _, label := range dns.SplitDomainName(name) {
+			if len(label) >= tunnelMinEntropyLabelLen && labelEntropy(label) > r.opt.EntropyThreshold {
+				reason = "entropy"
+				break
+			}
+		}
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		domain = name
+	}
+
+	windowID := now.UnixNano() / r.opt.Window.Nanoseconds()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// If we have moved on to the next window, re-initialize the counters
+	if windowID != r.currWinID {
+		r.currWinID = windowID
+		r.txt = make(map[string]uint)
+		r.domain = make(map[string]uint)
+		r.flagged = make(map[string]uint)
+	}
+
+	if question.Qtype == dns.TypeTXT || question.Qtype == dns.TypeNULL {
+		r.txt[client]++
+		if reason == "" && r.opt.TXTBudget > 0 && r.txt[client] > r.opt.TXTBudget {
+			reason = "txt-rate"
+		}
+	}
+	key := client + " " + domain
+	r.domain[key]++
+	if reason == "" && r.opt.DomainBudget > 0 && r.domain[key] > r.opt.DomainBudget {
+		reason = "domain-rate"
+	}
+	if reason == "" {
+		return "", false
+	}
+	switch r.opt.Action {
+	case "refuse":
+		return reason, true
+	case "rate-limit":
+		r.flagged[client]++
+		return reason, r.flagged[client] > r.opt.RateLimit
+	}
+	return reason, false
+}
+
+// Returns the Shannon entropy of a label in bits per character.
+func labelEntropy(label string) float64 {
+	var counts [256]int
+	for i := 0; i < len(label); i++ {
+		counts[label[i]]++
+	}
+	var entropy float64
+	n := float64(len(label))
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
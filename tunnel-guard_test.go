@@ -0,0 +1,101 @@
+package rdns
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTunnelGuard(t *testing.T) {
+	var ci ClientInfo
+	upstream := new(TestResolver)
+	r, err := NewTunnelGuard("test-guard", upstream, TunnelGuardOptions{
+		TXTBudget:    2,
+		DomainBudget: 5,
+	})
+	require.NoError(t, err)
+
+	resolve := func(name string, qtype uint16) int {
+		q := new(dns.Msg)
+		q.SetQuestion(name, qtype)
+		a, err := r.Resolve(q, ci)
+		require.NoError(t, err)
+		return a.Rcode
+	}
+
+	// Regular queries pass
+	require.Equal(t, dns.RcodeSuccess, resolve("www.example.com.", dns.TypeA))
+	require.Equal(t, dns.RcodeSuccess, resolve("thisisaverylongsubdomainname.example.com.", dns.TypeA))
+
+	// Long names and random labels are refused
+	require.Equal(t, dns.RcodeRefused, resolve(strings.Repeat("a.", 60)+"example.org.", dns.TypeA))
+	require.Equal(t, dns.RcodeRefused, resolve("mfzwizltoq2gc3tbnvsxiylonrqxi.tunnel.example.net.", dns.TypeA))
+
+	// TXT queries over the budget are refused
+	require.Equal(t, dns.RcodeSuccess, resolve("a.example.info.", dns.TypeTXT))
+	require.Equal(t, dns.RcodeSuccess, resolve("b.example.info.", dns.TypeNULL))
+	require.Equal(t, dns.RcodeRefused, resolve("c.example.info.", dns.TypeTXT))
+
+	// Queries for the same registrable domain over the budget are refused
+	for i := 0; i < 3; i++ {
+		require.Equal(t, dns.RcodeSuccess, resolve("host.example.co.uk.", dns.TypeA))
+	}
+	require.Equal(t, dns.RcodeSuccess, resolve("other.example.co.uk.", dns.TypeAAAA))
+	require.Equal(t, dns.RcodeSuccess, resolve("example.co.uk.", dns.TypeA))
+	require.Equal(t, dns.RcodeRefused, resolve("host.example.co.uk.", dns.TypeA))
+
+	// The budget is per client
+	ci.SourceIP = net.ParseIP("192.168.1.1")
+	require.Equal(t, dns.RcodeSuccess, resolve("host.example.co.uk.", dns.TypeA))
+
+	require.Equal(t, 10, upstream.HitCount())
+}
+
+func TestTunnelGuardActions(t *testing.T) {
+	name := "mfzwizltoq2gc3tbnvsxiylonrqxi.tunnel.example.net."
+	q := new(dns.Msg)
+	q.SetQuestion(name, dns.TypeA)
+
+	// Flagged queries are only logged
+	upstream := new(TestResolver)
+	r, err := NewTunnelGuard("test-guard", upstream, TunnelGuardOptions{Action: "log"})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		a, err := r.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeSuccess, a.Rcode)
+	}
+	require.Equal(t, 5, upstream.HitCount())
+
+	// Flagged queries are refused once over the rate-limit
+	upstream = new(TestResolver)
+	r, err = NewTunnelGuard("test-guard", upstream, TunnelGuardOptions{Action: "rate-limit", RateLimit: 3})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err := r.Resolve(q, ClientInfo{})
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, upstream.HitCount())
+
+	_, err = NewTunnelGuard("test-guard", upstream, TunnelGuardOptions{Action: "invalid"})
+	require.Error(t, err)
+}
+
+func TestLabelEntropy(t *testing.T) {
+	require.Equal(t, 0.0, labelEntropy("aaaa"))
+	require.Equal(t, 1.0, labelEntropy("abab"))
+	require.Equal(t, 2.0, labelEntropy("abcd"))
+}
+
+func BenchmarkLabelEntropy(b *testing.B) {
+	for _, label := range []string{"www", "thisisaverylongsubdomainname", "mfzwizltoq2gc3tbnvsxiylonrqxi4tfmvzgk3tfoq"} {
+		b.Run(label, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				labelEntropy(label)
+			}
+		})
+	}
+}
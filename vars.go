@@ -3,6 +3,7 @@ package rdns
 import (
 	"expvar"
 	"fmt"
+	"time"
 )
 
 // Get an *expvar.Int with the given path.
@@ -14,6 +15,41 @@ func getVarInt(base string, id string, name string) *expvar.Int {
 	return expvar.NewInt(fullname)
 }
 
+// Upper bounds of the buckets in latency histograms. Durations above the last
+// bucket are counted under "inf".
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Adds a duration to a latency histogram. The histogram is an *expvar.Map
+// holding the count for each bucket, keyed by the upper bound of the bucket
+// (like "250ms"), and the total of all durations in seconds under "sum".
+func addLatency(m *expvar.Map, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.AddFloat("sum", d.Seconds())
+	for _, b := range latencyBuckets {
+		if d <= b {
+			m.Add(b.String(), 1)
+			return
+		}
+	}
+	m.Add("inf", 1)
+}
+
 // Get an *expvar.Map with the given path.
 func getVarMap(base string, id string, name string) *expvar.Map {
 	fullname := fmt.Sprintf("routedns.%s.%s.%s", base, id, name)
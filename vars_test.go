@@ -0,0 +1,26 @@
+package rdns
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddLatency(t *testing.T) {
+	m := new(expvar.Map).Init()
+	addLatency(m, 500*time.Microsecond)
+	addLatency(m, time.Millisecond)
+	addLatency(m, 30*time.Millisecond)
+	addLatency(m, time.Minute)
+
+	require.Equal(t, int64(2), m.Get("1ms").(*expvar.Int).Value())
+	require.Equal(t, int64(1), m.Get("50ms").(*expvar.Int).Value())
+	require.Equal(t, int64(1), m.Get("inf").(*expvar.Int).Value())
+	require.Nil(t, m.Get("25ms"))
+	require.InDelta(t, 60.0315, m.Get("sum").(*expvar.Float).Value(), 1e-9)
+
+	// Should not panic on a nil histogram
+	addLatency(nil, time.Second)
+}
@@ -0,0 +1,204 @@
+package rdns
+
+import (
+	"errors"
+	"expvar"
+	"sort"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// WeightedGroup is a group of resolvers that receive queries in proportion to
+// their weight. It uses smooth weighted round-robin to spread queries evenly
+// over time rather than sending bursts to the same resolver. Resolvers with a
+// weight of 0 don't receive any queries. Optionally, the effective weight of a
+// resolver is reduced after every failure and restored on success, so degrading
+// resolvers gradually receive less traffic without being removed entirely.
+type WeightedGroup struct {
+	id        string
+	resolvers []WeightedResolver
+	opt       WeightedGroupOptions
+	mu        sync.Mutex
+	effective []float64
+	current   []float64
+	metrics   *RouterMetrics
+	weights   []*expvar.Float
+}
+
+var _ Resolver = &WeightedGroup{}
+
+// WeightedResolver is a resolver with a weight in a WeightedGroup.
+type WeightedResolver struct {
+	Resolver Resolver
+	Weight   int
+}
+
+// WeightedGroupOptions contain settings for the weighted group.
+type WeightedGroupOptions struct {
+	// Return errors from the selected resolver rather than trying the
+	// other resolvers in order of their weight.
+	FailFast bool
+
+	// Factor the effective weight of a resolver is multiplied with after
+	// every failure, at least 0 and less than 1. Defaults to 0 which disables
+	// the decay.
+	DecayFactor float64
+
+	// Fraction of the difference between the effective and the configured
+	// weight that is restored after every success, between 0 and 1. Defaults
+	// to 0.1.
+	RecoveryFactor float64
+
+	// Lowest effective weight a resolver can decay to. Defaults to 0.1.
+	MinWeight float64
+}
+
+// NewWeightedGroup returns a new instance of a weighted round-robin resolver group.
+func NewWeightedGroup(id string, opt WeightedGroupOptions, resolvers ...WeightedResolver) (*WeightedGroup, error) {
+	if opt.DecayFactor < 0 || opt.DecayFactor >= 1 {
+		return nil, errors.New("decay factor must be at least 0 and less than 1")
+	}
+	if opt.RecoveryFactor < 0 || opt.RecoveryFactor > 1 {
+		return nil, errors.New("recovery factor must be between 0 and 1")
+	}
+	if opt.RecoveryFactor == 0 {
+		opt.RecoveryFactor = 0.1
+	}
+	if opt.MinWeight <= 0 {
+		opt.MinWeight = 0.1
+	}
+	var (
+		active    []WeightedResolver
+		effective []float64
+		weights   []*expvar.Float
+	)
+	metric := getVarMap("router", id, "weight")
+	for _, r := range resolvers {
+		if r.Weight <= 0 {
+			continue
+		}
+		active = append(active, r)
+		effective = append(effective, float64(r.Weight))
+		w := new(expvar.Float)
+		w.Set(float64(r.Weight))
+		metric.Set(r.Resolver.String(), w)
+		weights = append(weights, w)
+	}
+	return &WeightedGroup{
+		id:        id,
+		resolvers: active,
+		opt:       opt,
+		effective: effective,
+		current:   make([]float64, len(active)),
+		metrics:   NewRouterMetrics(id, len(active)),
+		weights:   weights,
+	}, nil
+}
+
+// Resolve a DNS query using a weighted round-robin resolver group.
+func (r *WeightedGroup) Resolve(q *dns.Msg, ci ClientInfo) (*dns.Msg, error) {
+	if len(r.resolvers) == 0 {
+		return nil, errors.New("no resolvers with a weight above 0")
+	}
+	log := logger(r.id, q, ci)
+	var (
+		a   *dns.Msg
+		err error
+	)
+	for _, i := range r.order(r.pick()) {
+		resolver := r.resolvers[i].Resolver
+		log.WithField("resolver", resolver).Debug("forwarding query to resolver")
+		r.metrics.route.Add(resolver.String(), 1)
+		a, err = resolver.Resolve(q, ci)
+		if err == nil {
+			r.restore(i)
+			return a, nil
+		}
+		log.WithField("resolver", resolver).WithError(err).Debug("resolver returned failure")
+		r.metrics.failure.Add(resolver.String(), 1)
+		r.decay(i)
+		if r.opt.FailFast {
+			break
+		}
+	}
+	return a, err
+}
+
+func (r *WeightedGroup) String() string {
+	return r.id
+}
+
+// Pick the index of the next resolver using the smooth weighted round-robin
+// algorithm. Every resolver's current weight is increased by its effective
+// weight, the one with the highest current weight is selected and the total
+// of all weights subtracted from it.
+func (r *WeightedGroup) pick() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var total float64
+	best := 0
+	for i, w := range r.effective {
+		r.current[i] += w
+		total += w
+		if r.current[i] > r.current[best] {
+			best = i
+		}
+	}
+	r.current[best] -= total
+	return best
+}
+
+// Returns the index of the selected resolver followed by the remaining ones in
+// order of their effective weight, to be tried in case of failure.
+func (r *WeightedGroup) order(selected int) []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]int, 0, len(r.resolvers))
+	for i := range r.resolvers {
+		if i != selected {
+			out = append(out, i)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return r.effective[out[i]] > r.effective[out[j]]
+	})
+	return append([]int{selected}, out...)
+}
+
+// Reduce the effective weight of a resolver after a failure, down to the
+// minimum weight.
+func (r *WeightedGroup) decay(i int) {
+	if r.opt.DecayFactor == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w := r.effective[i] * r.opt.DecayFactor
+	lowest := r.opt.MinWeight
+	if configured := float64(r.resolvers[i].Weight); lowest > configured {
+		lowest = configured
+	}
+	if w < lowest {
+		w = lowest
+	}
+	r.effective[i] = w
+	r.weights[i].Set(w)
+}
+
+// Move the effective weight of a resolver back towards its configured weight
+// after a success.
+func (r *WeightedGroup) restore(i int) {
+	if r.opt.DecayFactor == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	configured := float64(r.resolvers[i].Weight)
+	w := r.effective[i] + (configured-r.effective[i])*r.opt.RecoveryFactor
+	if configured-w < 0.01 {
+		w = configured
+	}
+	r.effective[i] = w
+	r.weights[i].Set(w)
+}
@@ -0,0 +1,131 @@
+package rdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWeightedGroup(t *testing.T) {
+	var ci ClientInfo
+	r1 := new(TestResolver)
+	r2 := new(TestResolver)
+	r3 := new(TestResolver)
+
+	g, err := NewWeightedGroup("test-weighted", WeightedGroupOptions{},
+		WeightedResolver{Resolver: r1, Weight: 4},
+		WeightedResolver{Resolver: r2, Weight: 1},
+		WeightedResolver{Resolver: r3, Weight: 0},
+	)
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeA)
+
+	// Queries should be distributed by weight, the 3rd resolver gets none
+	for i := 0; i < 10; i++ {
+		_, err := g.Resolve(q, ci)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 8, r1.HitCount())
+	require.Equal(t, 2, r2.HitCount())
+	require.Equal(t, 0, r3.HitCount())
+
+	// Smooth distribution, the lower weight resolver isn't skipped for long
+	for i := 0; i < 5; i++ {
+		_, err := g.Resolve(q, ci)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, r2.HitCount())
+
+	// Failed queries should be retried with the other resolver
+	r1.SetFail(true)
+	for i := 0; i < 5; i++ {
+		_, err := g.Resolve(q, ci)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 16, r1.HitCount())
+	require.Equal(t, 8, r2.HitCount())
+}
+
+func TestWeightedGroupFailFast(t *testing.T) {
+	var ci ClientInfo
+	r1 := new(TestResolver)
+	r2 := new(TestResolver)
+
+	g, err := NewWeightedGroup("test-weighted", WeightedGroupOptions{FailFast: true},
+		WeightedResolver{Resolver: r1, Weight: 1},
+		WeightedResolver{Resolver: r2, Weight: 1},
+	)
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeA)
+
+	// The error should be returned without trying the other resolver
+	r1.SetFail(true)
+	_, err = g.Resolve(q, ci)
+	require.Error(t, err)
+	require.Equal(t, 1, r1.HitCount())
+	require.Equal(t, 0, r2.HitCount())
+
+	// No resolvers with weight
+	g, err = NewWeightedGroup("test-weighted", WeightedGroupOptions{}, WeightedResolver{Resolver: r1, Weight: 0})
+	require.NoError(t, err)
+	_, err = g.Resolve(q, ci)
+	require.Error(t, err)
+}
+
+func TestWeightedGroupDecay(t *testing.T) {
+	var ci ClientInfo
+	r1 := new(TestResolver)
+	r2 := new(TestResolver)
+
+	opt := WeightedGroupOptions{DecayFactor: 0.5, RecoveryFactor: 0.5, MinWeight: 1}
+	g, err := NewWeightedGroup("test-weighted-decay", opt,
+		WeightedResolver{Resolver: r1, Weight: 8},
+		WeightedResolver{Resolver: r2, Weight: 8},
+	)
+	require.NoError(t, err)
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeA)
+
+	// Every failure halves the effective weight, down to the minimum
+	r1.SetFail(true)
+	for i := 0; i < 20; i++ {
+		_, err := g.Resolve(q, ci)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1.0, g.effective[0])
+	require.Equal(t, 8.0, g.effective[1])
+	require.Equal(t, 1.0, g.weights[0].Value())
+
+	// The degraded resolver still receives a share of the queries
+	require.Equal(t, 4, r1.HitCount())
+
+	// Successes restore the effective weight gradually
+	r1.SetFail(false)
+	for i := 0; i < 9; i++ {
+		_, err := g.Resolve(q, ci)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 5, r1.HitCount())
+	require.Equal(t, 4.5, g.effective[0])
+	for i := 0; i < 100; i++ {
+		_, err := g.Resolve(q, ci)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 8.0, g.effective[0])
+}
+
+func TestWeightedGroupInvalidOptions(t *testing.T) {
+	r := WeightedResolver{Resolver: new(TestResolver), Weight: 1}
+	for _, opt := range []WeightedGroupOptions{
+		{DecayFactor: -0.1},
+		{DecayFactor: 1},
+		{RecoveryFactor: -0.1},
+		{RecoveryFactor: 1.5},
+	} {
+		_, err := NewWeightedGroup("test-weighted", opt, r)
+		require.Error(t, err)
+	}
+}